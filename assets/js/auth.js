@@ -35,7 +35,7 @@ function signIn(form) {
     let password = form[1].value;
     signInRequest(login, password)
         // TODO Put uuid into redirect body
-        .then(() => window.location.href = "http://127.0.0.1:4201/tasks")
+        .then(() => window.location.href = "http://127.0.0.1:4201/ui/tasks")
         .catch((e) => alert(`Failed to sign in ${e}`));
 }
 
@@ -59,7 +59,7 @@ function signUp(form) {
     let password = form[1].value;
     signUpRequest(login, password)
         // TODO Put uuid into redirect body
-        .then(() => window.location.href = "http://127.0.0.1:4201/tasks")
+        .then(() => window.location.href = "http://127.0.0.1:4201/ui/tasks")
         .catch((e) => alert(`Failed to sign up ${e}`));
 }
 
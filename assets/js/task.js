@@ -2,9 +2,10 @@
 
 async function changeStatusRequest(id, new_value) {
     let resp = await fetch(
-    `http://127.0.0.1:4201/tasks/${id}`,
+    `http://127.0.0.1:4201/api/v1/tasks/${id}`,
     {
-        method: 'PUT',
+        method: 'PATCH',
+        headers: { 'Content-Type': 'application/json' },
         body: JSON.stringify({
             is_resolved: new_value
         })
@@ -37,4 +38,117 @@ $('#form').on('submit', e => {
     let old_value = form[1] ? form[1].value === 'on' : false;
     changeStatus(form[0].value, old_value);
     e.preventDefault();
-});
\ No newline at end of file
+});
+
+async function insertCommentRequest(id, content) {
+    let resp = await fetch(
+    `http://127.0.0.1:4201/api/v1/tasks/${id}/comments`,
+    {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({
+            value: content
+        })
+    });
+    if (!resp.ok) {
+        throw `Failed to insert comment ${resp.status} ${resp.statusText}`;
+    }
+}
+
+$('#comment-form').on('submit', e => {
+    let id = $('#form').serializeArray()[0].value;
+    let content = $('#comment-form').serializeArray()[0].value;
+    insertCommentRequest(id, content)
+        .then(() => window.location.reload())
+        .catch((err) => alert(`Failed to insert comment ${err}`));
+    e.preventDefault();
+});
+
+async function loadSharesRequest(id) {
+    let resp = await fetch(`http://127.0.0.1:4201/api/v1/tasks/${id}/shares`);
+    if (!resp.ok) {
+        throw `Failed to get shares ${resp.status} ${resp.statusText}`;
+    }
+    return resp.json();
+}
+
+async function shareRequest(id, login, role) {
+    let resp = await fetch(
+    `http://127.0.0.1:4201/api/v1/tasks/${id}/shares`,
+    {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({
+            login: login,
+            role: role
+        })
+    });
+    if (!resp.ok) {
+        let body = await resp.json();
+        throw body.error.message;
+    }
+}
+
+async function removeShareRequest(shareId) {
+    let resp = await fetch(`http://127.0.0.1:4201/api/v1/shares/${shareId}`, { method: 'DELETE' });
+    if (!resp.ok) {
+        throw `Failed to remove share ${resp.status} ${resp.statusText}`;
+    }
+}
+
+function renderShares(shares) {
+    let list = $('#task-shares-list').empty();
+    shares.forEach(share => {
+        let item = $('<li class="task-share"></li>').text(`${share.login} (${share.role})`);
+        $('<button class="task-share-remove-button">Revoke</button>')
+            .on('click', () => {
+                removeShareRequest(share.uuid)
+                    .then(() => item.remove())
+                    .catch((err) => alert(`Failed to revoke share ${err}`));
+            })
+            .appendTo(item);
+        list.append(item);
+    });
+}
+
+if ($('#share-form').length) {
+    let id = $('#form').serializeArray()[0].value;
+    loadSharesRequest(id)
+        .then(renderShares)
+        .catch((err) => alert(`Failed to get shares ${err}`));
+
+    $('#share-form').on('submit', e => {
+        let form = $('#share-form').serializeArray();
+        shareRequest(id, form[0].value, form[1].value)
+            .then(() => loadSharesRequest(id))
+            .then(renderShares)
+            .catch((err) => alert(`Failed to share task ${err}`));
+        e.preventDefault();
+    });
+}
+
+async function assignRequest(id, login, method) {
+    let resp = await fetch(
+    `http://127.0.0.1:4201/api/v1/tasks/${id}/assignees/${encodeURIComponent(login)}`,
+    { method: method });
+    if (!resp.ok) {
+        let body = await resp.json();
+        throw body.error.message;
+    }
+}
+
+$('#assign-form').on('submit', e => {
+    let id = $('#form').serializeArray()[0].value;
+    let login = $('#assign-form').serializeArray()[0].value;
+    assignRequest(id, login, 'PUT')
+        .then(() => window.location.reload())
+        .catch((err) => alert(`Failed to assign task ${err}`));
+    e.preventDefault();
+});
+
+$('.task-unassign-button').on('click', e => {
+    let id = $('#form').serializeArray()[0].value;
+    assignRequest(id, $(e.target).attr('data-login'), 'DELETE')
+        .then(() => window.location.reload())
+        .catch((err) => alert(`Failed to unassign task ${err}`));
+});
@@ -5,6 +5,7 @@
 function createTaskContainer(task) {
     $('.tasks-ul').prepend(`
         <li class="task" id="task_${task.uuid}">
+            <input class="task-select" type="checkbox" value="${task.uuid}">
             <p class="task-content">${task.value}</p>
             <button class="task-view-button">View</button>
             <button class="task-remove-button">Remove</button>
@@ -20,30 +21,73 @@ function createTaskContainer(task) {
     });
 }
 
-async function insertTaskRequest(content) {
+// Value of quick task is parsed by server, dates like "tomorrow 5pm" are in time zone of the browser
+async function quickTaskRequest(path, content) {
     let resp = await fetch(
-    `http://127.0.0.1:4201/tasks`,
+    `http://127.0.0.1:4201${path}`,
     {
         method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
         body: JSON.stringify({
             value: content,
-            is_resolved: false
+            time_zone: Intl.DateTimeFormat().resolvedOptions().timeZone
         })
     });
     if (resp.ok) {
         return await resp.json();
     } else {
-        throw `Failed to insert task ${resp.status} ${resp.statusText}`
+        throw `Failed to send task ${resp.status} ${resp.statusText}`
     }
 }
 
+async function insertTaskRequest(content) {
+    return quickTaskRequest('/tasks', content);
+}
+
+function previewText(parsed) {
+    let parts = [];
+    if (parsed.due_date) {
+        parts.push(`due ${new Date(parsed.due_date).toLocaleString()}`);
+    }
+    if (parsed.priority) {
+        parts.push(`${parsed.priority} priority`);
+    }
+    parsed.labels.forEach(label => parts.push(`#${label}`));
+    if (parsed.project) {
+        parts.push(`in ${parsed.project}`);
+    }
+    return parts.length === 0 ? '' : `"${parsed.value}" ${parts.join(', ')}`;
+}
+
+let previewTimer = null;
+
+// Show what will be parsed out of the value while it is typed
+function previewTask() {
+    clearTimeout(previewTimer);
+    previewTimer = setTimeout(() => {
+        let content = $('#tasks-add-form input[name="task_content"]').val();
+        if (content.trim() === '') {
+            $('#tasks-add-preview').text('');
+            return;
+        }
+        quickTaskRequest('/tasks/parse', content)
+            .then(parsed => $('#tasks-add-preview').text(previewText(parsed)))
+            .catch(err => console.error(`Failed to preview task`, err));
+    }, 300);
+}
+
 function insertTask() {
     let form = $('#tasks-add-form').serializeArray();
     let content = form[0].value;
     insertTaskRequest(content)
         .then((task) => {
-            createTaskContainer(task);
+            // Event of the new task may come before the response
+            if ($(`#task_${task.uuid}`).length === 0) {
+                createTaskContainer(task);
+            }
             $('#placeholder').remove();
+            $('#tasks-add-form input[name="task_content"]').val('');
+            $('#tasks-add-preview').text('');
         })
         .catch((err) => {
             console.error(`Failed to insert task`, err)
@@ -52,7 +96,7 @@ function insertTask() {
 
 async function viewTaskRequest(id) {
     let resp = await fetch(
-        `http://127.0.0.1:4201/tasks/${id}`,
+        `http://127.0.0.1:4201/api/v1/tasks/${id}`,
         { method: 'GET' });
     if (resp.ok) {
         return await resp.text();
@@ -62,16 +106,16 @@ async function viewTaskRequest(id) {
 }
 
 function viewTask(id) {
-    viewTaskRequest()
+    viewTaskRequest(id)
         .then(result => {
-            document.location.href = `http://127.0.0.1:4201/tasks/${id}`
+            document.location.href = `http://127.0.0.1:4201/ui/tasks/${id}`
         })
         .catch(err => console.error(`Failed to view task with id: ${id}`, err));
 }
 
 async function removeTaskRequest(id) {
     let resp = await fetch(
-    `http://127.0.0.1:4201/tasks/${id}`,
+    `http://127.0.0.1:4201/api/v1/tasks/${id}`,
     {
         method: 'DELETE'
     });
@@ -88,11 +132,251 @@ function removeTask(id) {
         .catch((err) => console.error(`Failed to remove task with id: ${id}`, err));
 }
 
+async function smartListRequest(method, path, body) {
+    let resp = await fetch(
+    `http://127.0.0.1:4201/api/v1/lists${path}`,
+    {
+        method: method,
+        headers: { 'Content-Type': 'application/json' },
+        body: body === undefined ? undefined : JSON.stringify(body)
+    });
+    if (!resp.ok) {
+        throw `Failed to change smart list ${resp.status} ${resp.statusText}`
+    }
+    return resp.status === 204 ? null : await resp.json();
+}
+
+function saveSmartList() {
+    let form = $('#tasks-save-list-form');
+    let name = form.serializeArray()[0].value;
+    smartListRequest('POST', '', { name: name, query: form.data('query'), pinned: true })
+        .then(list => {
+            document.location.href = `http://127.0.0.1:4201/ui/lists/${list.uuid}`
+        })
+        .catch(err => console.error(`Failed to save smart list`, err));
+}
+
+function pinSmartList(id, pinned) {
+    smartListRequest('PATCH', `/${id}`, { pinned: pinned })
+        .then(() => document.location.reload())
+        .catch(err => console.error(`Failed to pin smart list with id: ${id}`, err));
+}
+
+function removeSmartList(id) {
+    smartListRequest('DELETE', `/${id}`)
+        .then(() => {
+            document.location.href = `http://127.0.0.1:4201/ui/tasks`
+        })
+        .catch(err => console.error(`Failed to remove smart list with id: ${id}`, err));
+}
+
+async function bulkRequest(operations) {
+    let resp = await fetch(
+    `http://127.0.0.1:4201/api/v1/tasks/bulk`,
+    {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ operations: operations })
+    });
+    if (resp.ok) {
+        return await resp.json();
+    } else {
+        throw `Failed to execute bulk operations ${resp.status} ${resp.statusText}`
+    }
+}
+
+// Apply action of bulk form to selected tasks
+function bulkTasks(action) {
+    let form = $('#tasks-bulk-form');
+    let label = form.find('[name=label]').val();
+    let project = form.find('[name=project]').val() || null;
+    let ids = $('.task-select:checked').map((i, e) => e.value).get();
+    if (ids.length === 0) {
+        return;
+    }
+    let operations = ids.map(id => {
+        switch (action) {
+            case 'add_label':
+                return { op: 'relabel', task_uuid: id, add_labels: [label] };
+            case 'remove_label':
+                return { op: 'relabel', task_uuid: id, remove_labels: [label] };
+            case 'move':
+                return { op: 'move', task_uuid: id, project_uuid: project };
+            default:
+                return { op: action, task_uuid: id };
+        }
+    });
+    bulkRequest(operations)
+        .then(response => {
+            response.results
+                .filter(result => result.status !== 'ok')
+                .forEach(result => console.error(`Failed to ${result.op} task with id: ${result.task_uuid}`, result.message));
+            document.location.reload();
+        })
+        .catch(err => console.error(`Failed to execute bulk operations`, err));
+}
+
+async function insertProjectRequest(name, workspace) {
+    let resp = await fetch(
+    `http://127.0.0.1:4201/api/v1/projects`,
+    {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ name: name, workspace_uuid: workspace || null })
+    });
+    if (!resp.ok) {
+        throw `Failed to insert project ${resp.status} ${resp.statusText}`
+    }
+}
+
+function insertProject() {
+    let form = $('#tasks-add-project-form').serializeArray();
+    insertProjectRequest(form[0].value, form[1].value)
+        .then(() => document.location.reload())
+        .catch(err => console.error(`Failed to insert project`, err));
+}
+
+async function insertWorkspaceRequest(name) {
+    let resp = await fetch(
+    `http://127.0.0.1:4201/api/v1/workspaces`,
+    {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ name: name })
+    });
+    if (!resp.ok) {
+        throw `Failed to insert workspace ${resp.status} ${resp.statusText}`
+    }
+}
+
+function insertWorkspace() {
+    let name = $('#tasks-add-workspace-form').serializeArray()[0].value;
+    insertWorkspaceRequest(name)
+        .then(() => document.location.reload())
+        .catch(err => console.error(`Failed to insert workspace`, err));
+}
+
+async function notificationsRequest(method, path) {
+    let resp = await fetch(`http://127.0.0.1:4201/api/v1/notifications${path}`, { method: method });
+    if (!resp.ok) {
+        throw `Failed to ${method} notifications ${resp.status} ${resp.statusText}`
+    }
+    return resp.status === 204 ? null : resp.json();
+}
+
+function notificationText(n) {
+    let about = n.task || n.project || 'removed item';
+    switch (n.kind) {
+        case 'mention': return `${n.actor} mentioned you on ${about}`;
+        case 'comment': return `${n.actor} commented on ${about}`;
+        case 'assigned': return `${n.actor} assigned you to ${about}`;
+        case 'share': return `${n.actor} shared ${about} with you`;
+        case 'reminder': return `Reminder: ${about}`;
+        default: return about;
+    }
+}
+
+function renderNotifications(notifications) {
+    let list = $('#header-inbox-list').empty();
+    notifications.forEach(n => {
+        let item = $('<li class="header-inbox-item"></li>').text(notificationText(n));
+        if (!n.read_at) {
+            item.addClass('header-inbox-unread');
+        }
+        item.on('click', () => {
+            notificationsRequest('POST', `/${n.uuid}/read`)
+                .then(() => {
+                    if (n.task_uuid && n.task) {
+                        viewTask(n.task_uuid);
+                    } else {
+                        document.location.reload();
+                    }
+                })
+                .catch(err => console.error(`Failed to read notification`, err));
+        });
+        list.append(item);
+    });
+    if (notifications.length === 0) {
+        list.append($('<li class="header-inbox-item"></li>').text('Nothing new'));
+    }
+}
+
+function toggleInbox() {
+    let inbox = $('#header-inbox');
+    if (!inbox.prop('hidden')) {
+        inbox.prop('hidden', true);
+        return;
+    }
+    notificationsRequest('GET', '')
+        .then(notifications => {
+            renderNotifications(notifications);
+            inbox.prop('hidden', false);
+        })
+        .catch(err => console.error(`Failed to get notifications`, err));
+}
+
+function readAllNotifications() {
+    notificationsRequest('POST', '/read')
+        .then(() => {
+            $('#header-inbox-badge').remove();
+            $('.header-inbox-unread').removeClass('header-inbox-unread');
+        })
+        .catch(err => console.error(`Failed to read notifications`, err));
+}
+
+async function getTaskRequest(id) {
+    let resp = await fetch(`http://127.0.0.1:4201/api/v1/tasks/${id}`, { method: 'GET' });
+    if (resp.ok) {
+        return await resp.json();
+    } else {
+        throw `Failed to get task ${resp.status} ${resp.statusText}`;
+    }
+}
+
+// New tasks are added only to the first page of all tasks, other pages may not match them
+function showsNewTasks() {
+    return document.location.pathname === '/ui/tasks' && document.location.search === '';
+}
+
+// Keep the list in sync with changes made in other tabs and by other users
+function subscribeEvents() {
+    let source = new EventSource('http://127.0.0.1:4201/events', { withCredentials: true });
+    source.addEventListener('task.created', e => {
+        let event = JSON.parse(e.data);
+        if (!showsNewTasks() || $(`#task_${event.task_uuid}`).length > 0) {
+            return;
+        }
+        getTaskRequest(event.task_uuid)
+            .then(task => {
+                if ($(`#task_${task.uuid}`).length === 0) {
+                    createTaskContainer(task);
+                    $('#placeholder').remove();
+                }
+            })
+            .catch(err => console.error(`Failed to get created task with id: ${event.task_uuid}`, err));
+    });
+    source.addEventListener('task.updated', e => {
+        let event = JSON.parse(e.data);
+        let item = $(`#task_${event.task_uuid}`);
+        if (item.length === 0) {
+            return;
+        }
+        getTaskRequest(event.task_uuid)
+            .then(task => item.find('.task-content').text(task.value))
+            .catch(err => console.error(`Failed to get updated task with id: ${event.task_uuid}`, err));
+    });
+    source.addEventListener('task.deleted', e => {
+        $(`#task_${JSON.parse(e.data).task_uuid}`).remove();
+    });
+    source.onerror = err => console.error(`Events stream failed, reconnecting`, err);
+}
+
 // Handlers
 $('.tasks-add-form').on('submit', e => {
     insertTask();
     e.preventDefault();
 });
+$('#tasks-add-form input[name="task_content"]').on('input', previewTask);
 $('.task-view-button').on('click', e => {
     viewTask(e.target.parentElement.id.slice(5));
     e.preventDefault();
@@ -101,3 +385,38 @@ $('.task-remove-button').on('click', e => {
     removeTask(e.target.parentElement.id.slice(5));
     e.preventDefault();
 });
+$('.tasks-save-list-form').on('submit', e => {
+    saveSmartList();
+    e.preventDefault();
+});
+$('.tasks-list-pin-button').on('click', e => {
+    let header = $(e.target.parentElement);
+    pinSmartList(header.attr('id').slice(5), header.data('pinned') !== true);
+    e.preventDefault();
+});
+$('.tasks-list-remove-button').on('click', e => {
+    removeSmartList(e.target.parentElement.id.slice(5));
+    e.preventDefault();
+});
+$('#tasks-bulk-form button').on('click', e => {
+    bulkTasks($(e.target).data('op'));
+    e.preventDefault();
+});
+$('.tasks-add-project-form').on('submit', e => {
+    insertProject();
+    e.preventDefault();
+});
+$('.tasks-add-workspace-form').on('submit', e => {
+    insertWorkspace();
+    e.preventDefault();
+});
+$('#header-inbox-button').on('click', e => {
+    toggleInbox();
+    e.preventDefault();
+});
+$('#header-inbox-read-all').on('click', e => {
+    readAllNotifications();
+    e.preventDefault();
+});
+
+subscribeEvents();
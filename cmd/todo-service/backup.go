@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Kolya59/todo-service/pkg/backup"
+	"github.com/Kolya59/todo-service/pkg/blob"
+)
+
+type backupCommand struct {
+	Output        string `long:"output" description:"Archive path" required:"true"`
+	Passphrase    string `long:"passphrase" env:"BACKUP_PASSPHRASE" description:"Archive passphrase" required:"true"`
+	NoAttachments bool   `long:"no_attachments" description:"Do not include attachment blobs"`
+}
+
+type restoreCommand struct {
+	Input      string `long:"input" description:"Archive path" required:"true"`
+	Passphrase string `long:"passphrase" env:"BACKUP_PASSPHRASE" description:"Archive passphrase" required:"true"`
+	DryRun     bool   `long:"dry_run" description:"Verify archive and report what would be restored without changing anything"`
+}
+
+func (c *backupCommand) run(store blob.Store) {
+	if c.NoAttachments {
+		store = nil
+	}
+	f, err := os.OpenFile(c.Output, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create archive")
+	}
+	manifest, err := backup.Write(f, c.Passphrase, store)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(c.Output)
+		log.Fatal().Err(err).Msg("Failed to back up")
+	}
+	log.Info().Msgf("Backup with %v entries is written to %v", len(manifest.Entries), c.Output)
+}
+
+func (c *restoreCommand) run(store blob.Store) {
+	// Archive is verified while it is read, nothing is committed unless it matches its manifest
+	f, err := os.Open(c.Input)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to open archive")
+	}
+	defer f.Close()
+	res, err := backup.Restore(f, c.Passphrase, store, c.DryRun)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to restore")
+	}
+	log.Info().Msgf("Archive created at %v is verified", res.Manifest.CreatedAt)
+	if c.DryRun {
+		log.Info().Msgf("Dry run: would restore %v, skip existing %v, write %v blobs", res.Restored, res.Skipped, res.Blobs)
+		return
+	}
+	log.Info().Msgf("Restored %v, skipped existing %v, wrote %v blobs", res.Restored, res.Skipped, res.Blobs)
+
+	// Search index is not a part of backup
+	reindex()
+}
@@ -7,13 +7,16 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
+	"github.com/Kolya59/todo-service/pkg/blob"
+	"github.com/Kolya59/todo-service/pkg/crypt"
+	"github.com/Kolya59/todo-service/pkg/notifier"
 	"github.com/Kolya59/todo-service/pkg/postgres"
 	"github.com/Kolya59/todo-service/pkg/server"
 )
 
 var opts struct {
-	ServerHost   string `long:"server_host" env:"SERVER_HOST" description:"Server host" required:"true"`
-	ServerPort   string `long:"server_port" env:"SERVER_PORT" description:"Server port" required:"true"`
+	ServerHost   string `long:"server_host" env:"SERVER_HOST" description:"Server host" required:"false"`
+	ServerPort   string `long:"server_port" env:"SERVER_PORT" description:"Server port" required:"false"`
 	DbHost       string `long:"database_host" env:"DB_HOST" description:"Database host" required:"true"`
 	DbPort       string `long:"database_port" env:"DB_PORT" description:"Database port" required:"true"`
 	DbName       string `long:"database_name" env:"DB_NAME" description:"Database name" required:"true"`
@@ -21,6 +24,24 @@ var opts struct {
 	DbPassword   string `long:"database_password" env:"DB_PASSWORD" description:"Database password" required:"true"`
 	ProfilerPort string `long:"prof_port" env:"PROF_PORT" description:"Profiler port" required:"false"`
 	LogLevel     string `long:"log_level" env:"LOG_LEVEL" description:"Log level for zerolog" required:"false"`
+	Notifier     string `long:"notifier" env:"NOTIFIER" description:"Reminder notifier: log, file, webhook or smtp" required:"false"`
+	NotifyTarget string `long:"notify_target" env:"NOTIFY_TARGET" description:"File path, webhook url or smtp address for notifier" required:"false"`
+	SMTPFrom     string `long:"smtp_from" env:"SMTP_FROM" description:"Sender address for smtp notifier" required:"false"`
+	BlobStore    string `long:"blob_store" env:"BLOB_STORE" description:"Attachment storage: file or s3" required:"false"`
+	BlobTarget   string `long:"blob_target" env:"BLOB_TARGET" description:"Directory or s3 endpoint for attachments" required:"false"`
+	S3Bucket     string `long:"s3_bucket" env:"S3_BUCKET" description:"S3 bucket for attachments" required:"false"`
+	S3Region     string `long:"s3_region" env:"S3_REGION" description:"S3 region" required:"false"`
+	S3AccessKey  string `long:"s3_access_key" env:"S3_ACCESS_KEY" description:"S3 access key" required:"false"`
+	S3SecretKey  string `long:"s3_secret_key" env:"S3_SECRET_KEY" description:"S3 secret key" required:"false"`
+	MasterKey    string `long:"master_key" env:"MASTER_KEY" description:"Legacy single master key for at-rest encryption" required:"false"`
+	MasterKeys   string `long:"master_keys" env:"MASTER_KEYS" description:"Comma separated id:key master keys, encryption is disabled when empty" required:"false"`
+	PrimaryKey   string `long:"primary_key" env:"PRIMARY_KEY" description:"Id of master key used for new data keys" required:"false"`
+	SearchLang   string `long:"search_language" env:"SEARCH_LANGUAGE" description:"Text search configuration, english by default" required:"false"`
+
+	Rekey   rekeyCommand   `command:"rekey" description:"Re-wrap data keys with the primary master key"`
+	Backup  backupCommand  `command:"backup" description:"Write encrypted backup of database and attachments"`
+	Restore restoreCommand `command:"restore" description:"Restore encrypted backup"`
+	Reindex reindexCommand `command:"reindex" description:"Rebuild full text search index"`
 }
 
 func main() {
@@ -33,7 +54,9 @@ func main() {
 	log.Logger = log.Output(os.Stderr).With().Str("PROGRAM", "todo-service").Caller().Logger()
 
 	// Parse flags
-	_, err := flags.ParseArgs(&opts, os.Args)
+	parser := flags.NewParser(&opts, flags.Default)
+	parser.SubcommandsOptional = true
+	_, err := parser.ParseArgs(os.Args[1:])
 	if err != nil {
 		log.Fatal().Msgf("Could not parse flags: %v", err)
 	}
@@ -50,5 +73,43 @@ func main() {
 	}
 	defer postgres.CloseConnection()
 
-	server.StartServer(opts.ServerHost, opts.ServerPort, opts.ProfilerPort)
+	ring, err := crypt.NewKeyRing(opts.MasterKeys, opts.PrimaryKey, opts.MasterKey)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load master keys")
+	}
+	postgres.SetKeyRing(ring)
+
+	if err = postgres.SetSearchLanguage(opts.SearchLang); err != nil {
+		log.Fatal().Err(err).Msg("Failed to set search language")
+	}
+
+	store, err := blob.New(opts.BlobStore, opts.BlobTarget, opts.S3Bucket, opts.S3Region, opts.S3AccessKey, opts.S3SecretKey)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create blob store")
+	}
+
+	if parser.Active != nil {
+		switch parser.Active.Name {
+		case "rekey":
+			opts.Rekey.run()
+		case "backup":
+			opts.Backup.run(store)
+		case "restore":
+			opts.Restore.run(store)
+		case "reindex":
+			opts.Reindex.run()
+		}
+		return
+	}
+
+	if opts.ServerHost == "" || opts.ServerPort == "" {
+		log.Fatal().Msg("Server host and port are required")
+	}
+
+	n, err := notifier.New(opts.Notifier, opts.NotifyTarget, opts.SMTPFrom)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create notifier")
+	}
+
+	server.StartServer(opts.ServerHost, opts.ServerPort, opts.ProfilerPort, n, store)
 }
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"github.com/rs/zerolog/log"
+
+	"github.com/Kolya59/todo-service/pkg/postgres"
+)
+
+type reindexCommand struct{}
+
+func (c *reindexCommand) run() {
+	reindex()
+}
+
+// Rebuild search index, needed after restore or change of search language
+func reindex() {
+	tasks, comments, err := postgres.ReindexSearch()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to rebuild search index")
+	}
+	log.Info().Msgf("Search index is rebuilt for %v tasks and %v comments", tasks, comments)
+}
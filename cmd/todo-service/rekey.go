@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/rs/zerolog/log"
+
+	"github.com/Kolya59/todo-service/pkg/postgres"
+)
+
+type rekeyCommand struct {
+	BatchSize int `long:"batch_size" description:"Data keys re-wrapped per transaction" default:"100"`
+}
+
+func (c *rekeyCommand) run() {
+	if !postgres.EncryptionEnabled() {
+		log.Fatal().Msg("Master keys are not configured")
+	}
+	if c.BatchSize <= 0 {
+		log.Fatal().Msg("Batch size must be positive")
+	}
+	res, err := postgres.RekeyDataKeys(c.BatchSize, func(p postgres.RekeyProgress) {
+		log.Info().Msgf("Re-wrapped %v of %v data keys, skipped %v", p.Done, p.Total, p.Skipped)
+	})
+	if err != nil {
+		// Already re-wrapped keys are committed, running the command again resumes the rotation
+		log.Fatal().Err(err).Msgf("Failed to re-wrap data keys after %v of %v", res.Done, res.Total)
+	}
+	if res.Skipped > 0 {
+		log.Fatal().Msgf("%v data keys are wrapped by unknown master keys", res.Skipped)
+	}
+	log.Info().Msgf("All %v data keys are wrapped by the primary master key", res.Done)
+}
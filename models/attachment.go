@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+type Attachment struct {
+	UUID        string    `json:"uuid"`
+	TaskUUID    string    `json:"task_uuid"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"created_at"`
+	Encrypted   bool      `json:"-"`
+}
@@ -0,0 +1,37 @@
+package models
+
+// Operations of bulk request
+const (
+	BulkResolve = "resolve"
+	BulkReopen  = "reopen"
+	BulkDelete  = "delete"
+	BulkRelabel = "relabel"
+	BulkMove    = "move"
+)
+
+// Statuses of bulk operation results
+const (
+	BulkOK         = "ok"
+	BulkNotFound   = "not_found"
+	BulkForbidden  = "forbidden"
+	BulkRolledBack = "rolled_back"
+)
+
+// BulkOperation is a single operation on a task
+type BulkOperation struct {
+	Op       string `json:"op"`
+	TaskUUID string `json:"task_uuid"`
+	// Labels added and removed by relabel
+	AddLabels    []string `json:"add_labels,omitempty"`
+	RemoveLabels []string `json:"remove_labels,omitempty"`
+	// Target of move, null moves task out of any project
+	ProjectUUID *string `json:"project_uuid,omitempty"`
+}
+
+// BulkResult is a result of operation with the same index
+type BulkResult struct {
+	Op       string `json:"op"`
+	TaskUUID string `json:"task_uuid"`
+	Status   string `json:"status"`
+	Message  string `json:"message,omitempty"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// CaptureToken authorizes posting tasks to secret capture URL of its user without signing in
+type CaptureToken struct {
+	UUID string `json:"uuid"`
+	Name string `json:"name"`
+	// Token and its URL are shown only when the token is created
+	Token      string     `json:"token,omitempty"`
+	URL        string     `json:"url,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
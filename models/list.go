@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// SmartList is a saved filter query shown as a virtual list of tasks
+type SmartList struct {
+	UUID      string    `json:"uuid"`
+	Name      string    `json:"name"`
+	Query     string    `json:"query"`
+	Pinned    bool      `json:"pinned"`
+	Count     int       `json:"count"`
+	CreatedAt time.Time `json:"created_at"`
+}
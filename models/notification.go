@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Kinds of notifications
+const (
+	NotificationMention  = "mention"
+	NotificationComment  = "comment"
+	NotificationAssigned = "assigned"
+	NotificationShare    = "share"
+	NotificationReminder = "reminder"
+)
+
+// Notification tells user about a change made by another user or about a due reminder.
+// Task, comment and project are set while the user sees them.
+type Notification struct {
+	UUID        string     `json:"uuid"`
+	UserUUID    string     `json:"-"`
+	Kind        string     `json:"kind"`
+	Actor       string     `json:"actor,omitempty"`
+	TaskUUID    *string    `json:"task_uuid,omitempty"`
+	Task        *string    `json:"task,omitempty"`
+	CommentUUID *string    `json:"comment_uuid,omitempty"`
+	Comment     *string    `json:"comment,omitempty"`
+	ProjectUUID *string    `json:"project_uuid,omitempty"`
+	Project     *string    `json:"project,omitempty"`
+	ReadAt      *time.Time `json:"read_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Attempts    int        `json:"-"`
+}
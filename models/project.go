@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// Project groups tasks of its owner or of a workspace, every task belongs to at most one project
+type Project struct {
+	UUID          string    `json:"uuid"`
+	Name          string    `json:"name"`
+	WorkspaceUUID *string   `json:"workspace_uuid,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
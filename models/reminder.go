@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Reminder fires either at RemindAt or Offset seconds before the task due date
+type Reminder struct {
+	UUID     string     `json:"uuid"`
+	TaskUUID string     `json:"task_uuid"`
+	UserUUID string     `json:"-"`
+	RemindAt *time.Time `json:"remind_at,omitempty"`
+	Offset   *int64     `json:"offset,omitempty"`
+	FireAt   *time.Time `json:"fire_at,omitempty"`
+	SentAt   *time.Time `json:"sent_at,omitempty"`
+	FailedAt *time.Time `json:"failed_at,omitempty"`
+	Attempts int        `json:"attempts"`
+}
@@ -0,0 +1,15 @@
+package models
+
+// SearchResult is a task found by text, snippet is taken from the task or from its best comment
+type SearchResult struct {
+	Task    Task       `json:"task"`
+	Rank    float64    `json:"rank"`
+	Source  string     `json:"source"`
+	Snippet []Fragment `json:"snippet"`
+}
+
+// Fragment is a part of snippet, matched parts are highlighted
+type Fragment struct {
+	Text  string `json:"text"`
+	Match bool   `json:"match,omitempty"`
+}
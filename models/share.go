@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Roles of user on a task, editors change tasks and comment them, viewers only read
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// Share grants role on a task or on all tasks of a project to another user
+type Share struct {
+	UUID        string    `json:"uuid"`
+	TaskUUID    *string   `json:"task_uuid,omitempty"`
+	ProjectUUID *string   `json:"project_uuid,omitempty"`
+	Owner       string    `json:"owner"`
+	Login       string    `json:"login"`
+	Role        string    `json:"role"`
+	CreatedAt   time.Time `json:"created_at"`
+}
@@ -1,9 +1,40 @@
 package models
 
+import "time"
+
+// Priorities of tasks, tasks without priority have empty one
+const (
+	PriorityLow    = "low"
+	PriorityMedium = "medium"
+	PriorityHigh   = "high"
+)
+
 type Task struct {
-	UUID       string    `json:"uuid"`
-	Author     string    `json:"author"`
-	Value      string    `json:"value"`
-	IsResolved bool      `json:"is_resolved"`
-	Comments   []Comment `json:"comments"`
+	UUID string `json:"uuid"`
+	// Login of the creator, who owns the task
+	Author     string     `json:"author"`
+	Value      string     `json:"value"`
+	IsResolved bool       `json:"is_resolved"`
+	DueDate    *time.Time `json:"due_date,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Labels     []string   `json:"labels"`
+	Priority   string     `json:"priority,omitempty"`
+	// Project of the task, nil for tasks out of any project
+	ProjectUUID *string `json:"project_uuid,omitempty"`
+	// Role of the requesting user, tasks of other users are shared with the user
+	Role string `json:"role,omitempty"`
+	// Logins of users responsible for the task, separate from its author
+	Assignees []string `json:"assignees,omitempty"`
+	// Number of published changes, clients order updates of the task by it
+	Version  int64     `json:"version,omitempty"`
+	Comments []Comment `json:"comments"`
+}
+
+// TaskPage is a part of tasks list ordered from the newest task, cursor is empty at the end of list
+type TaskPage struct {
+	Tasks []Task `json:"tasks"`
+	Total int    `json:"total"`
+	Limit int    `json:"limit"`
+	Next  string `json:"next_cursor,omitempty"`
+	Prev  string `json:"prev_cursor,omitempty"`
 }
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Webhook posts events of tasks its user sees to URL, bodies are signed with its secret
+type Webhook struct {
+	UUID     string   `json:"uuid"`
+	UserUUID string   `json:"-"`
+	URL      string   `json:"url"`
+	Events   []string `json:"events"`
+	// Secret is shown only when the webhook is created
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Statuses of webhook deliveries
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is an event posted to webhook, it is retried with backoff until it succeeds or attempts run out.
+// Payload and history are set for a single delivery.
+type WebhookDelivery struct {
+	UUID          string           `json:"uuid"`
+	WebhookUUID   string           `json:"webhook_uuid"`
+	EventType     string           `json:"event_type"`
+	Status        string           `json:"status"`
+	Attempts      int              `json:"attempts"`
+	NextAttemptAt *time.Time       `json:"next_attempt_at,omitempty"`
+	DeliveredAt   *time.Time       `json:"delivered_at,omitempty"`
+	CreatedAt     time.Time        `json:"created_at"`
+	Payload       json.RawMessage  `json:"payload,omitempty"`
+	History       []WebhookAttempt `json:"history,omitempty"`
+	// Target of claimed delivery
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
+
+// WebhookAttempt records one post of delivery, error is set when it failed
+type WebhookAttempt struct {
+	AttemptedAt time.Time `json:"attempted_at"`
+	StatusCode  int       `json:"status_code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	DurationMs  int64     `json:"duration_ms"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// Roles of members of a workspace from the most privileged
+const (
+	WorkspaceOwner  = "owner"
+	WorkspaceAdmin  = "admin"
+	WorkspaceMember = "member"
+	WorkspaceGuest  = "guest"
+)
+
+// Workspace holds projects shared by its members, Role is the role of requesting user
+type Workspace struct {
+	UUID      string    `json:"uuid"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Member struct {
+	UserUUID  string    `json:"user_uuid"`
+	Login     string    `json:"login"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Invitation becomes membership with the role once the invited user accepts it
+type Invitation struct {
+	UUID          string    `json:"uuid"`
+	WorkspaceUUID string    `json:"workspace_uuid"`
+	Workspace     string    `json:"workspace"`
+	Login         string    `json:"login"`
+	Role          string    `json:"role"`
+	InvitedBy     string    `json:"invited_by"`
+	CreatedAt     time.Time `json:"created_at"`
+}
@@ -0,0 +1,94 @@
+package authz
+
+import "github.com/Kolya59/todo-service/models"
+
+// Action of a member in a workspace
+type Action string
+
+const (
+	// Read workspace, its members, projects and tasks
+	View Action = "view"
+	// Create, change and comment tasks of workspace projects
+	EditTasks Action = "edit_tasks"
+	// Delete tasks created by other members
+	DeleteTasks Action = "delete_tasks"
+	// Create, rename and delete workspace projects
+	ManageProjects Action = "manage_projects"
+	// Invite users, remove members and change their roles
+	ManageMembers Action = "manage_members"
+	Rename        Action = "rename"
+	Delete        Action = "delete"
+)
+
+// Roles allowed to perform every action. Handlers check it before calling the store,
+// the store builds access conditions over tasks and projects of workspaces from it.
+var policy = map[Action][]string{
+	View:           {models.WorkspaceOwner, models.WorkspaceAdmin, models.WorkspaceMember, models.WorkspaceGuest},
+	EditTasks:      {models.WorkspaceOwner, models.WorkspaceAdmin, models.WorkspaceMember},
+	DeleteTasks:    {models.WorkspaceOwner, models.WorkspaceAdmin},
+	ManageProjects: {models.WorkspaceOwner, models.WorkspaceAdmin, models.WorkspaceMember},
+	ManageMembers:  {models.WorkspaceOwner, models.WorkspaceAdmin},
+	Rename:         {models.WorkspaceOwner, models.WorkspaceAdmin},
+	Delete:         {models.WorkspaceOwner},
+}
+
+// IsRole reports whether role is a known role of workspace member
+func IsRole(role string) bool {
+	for _, r := range policy[View] {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Roles allowed to perform the action
+func Roles(action Action) []string {
+	return policy[action]
+}
+
+// Can reports whether member with the role may perform the action
+func Can(role string, action Action) bool {
+	for _, r := range policy[action] {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// TaskAccess describes how user reaches a task: as its author, by shares of the task or its project with the best
+// of their roles and by membership in workspace of its project. Missing roles are empty.
+type TaskAccess struct {
+	Author        bool
+	ShareRole     string
+	WorkspaceRole string
+}
+
+// CanTask reports whether user with the access may perform the action on a task. Authors may do anything,
+// viewers of shares may view and editors also edit, workspace roles follow the policy.
+func CanTask(access TaskAccess, action Action) bool {
+	if access.Author || Can(access.WorkspaceRole, action) {
+		return true
+	}
+	switch action {
+	case View:
+		return access.ShareRole == models.RoleViewer || access.ShareRole == models.RoleEditor
+	case EditTasks:
+		return access.ShareRole == models.RoleEditor
+	default:
+		return false
+	}
+}
+
+// CanAssign reports whether actor may give role to a member having current role, current is empty
+// for invited user and role is empty for removed member. Only owners grant ownership and touch other owners.
+func CanAssign(actor string, current string, role string) bool {
+	if !Can(actor, ManageMembers) {
+		return false
+	}
+	if current == models.WorkspaceOwner || role == models.WorkspaceOwner {
+		return actor == models.WorkspaceOwner
+	}
+	return true
+}
@@ -0,0 +1,34 @@
+package authz
+
+import (
+	"testing"
+
+	"github.com/Kolya59/todo-service/models"
+)
+
+func TestCanTask(t *testing.T) {
+	tests := []struct {
+		name   string
+		access TaskAccess
+		view   bool
+		edit   bool
+		delete bool
+	}{
+		{"stranger", TaskAccess{}, false, false, false},
+		{"author", TaskAccess{Author: true}, true, true, true},
+		{"viewer share", TaskAccess{ShareRole: models.RoleViewer}, true, false, false},
+		{"editor share", TaskAccess{ShareRole: models.RoleEditor}, true, true, false},
+		{"workspace guest", TaskAccess{WorkspaceRole: models.WorkspaceGuest}, true, false, false},
+		{"workspace member", TaskAccess{WorkspaceRole: models.WorkspaceMember}, true, true, false},
+		{"workspace admin", TaskAccess{WorkspaceRole: models.WorkspaceAdmin}, true, true, true},
+		{"workspace owner", TaskAccess{WorkspaceRole: models.WorkspaceOwner}, true, true, true},
+		{"guest with editor share", TaskAccess{ShareRole: models.RoleEditor, WorkspaceRole: models.WorkspaceGuest}, true, true, false},
+	}
+	for _, test := range tests {
+		for action, want := range map[Action]bool{View: test.view, EditTasks: test.edit, DeleteTasks: test.delete} {
+			if got := CanTask(test.access, action); got != want {
+				t.Errorf("%v: CanTask(%v) = %v, want %v", test.name, action, got, want)
+			}
+		}
+	}
+}
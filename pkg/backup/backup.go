@@ -0,0 +1,430 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Kolya59/todo-service/pkg/blob"
+	"github.com/Kolya59/todo-service/pkg/crypt"
+	"github.com/Kolya59/todo-service/pkg/postgres"
+)
+
+// Archive is a tar.gz encrypted as a pkg/crypt passphrase stream. It holds JSON lines sections,
+// attachment blobs under blobPrefix and manifest with counts and SHA-256 of every entry, written last.
+// Rows are dumped as stored, so encrypted data can only be read with the same master keys.
+const (
+	formatVersion     = 1
+	manifestName      = "manifest.json"
+	usersName         = "users.jsonl"
+	tasksName         = "tasks.jsonl"
+	remindersName     = "reminders.jsonl"
+	commentsName      = "comments.jsonl"
+	mentionsName      = "comment_mentions.jsonl"
+	notificationsName = "notifications.jsonl"
+	attachmentsName   = "attachments.jsonl"
+	smartListsName    = "smart_lists.jsonl"
+	projectsName      = "projects.jsonl"
+	sharesName        = "shares.jsonl"
+	assigneesName     = "task_assignees.jsonl"
+	workspacesName    = "workspaces.jsonl"
+	membersName       = "workspace_members.jsonl"
+	invitationsName   = "workspace_invitations.jsonl"
+	webhooksName      = "webhooks.jsonl"
+	capturesName      = "capture_tokens.jsonl"
+	blobPrefix        = "blobs/"
+)
+
+type Entry struct {
+	Name   string `json:"name"`
+	Count  int    `json:"count,omitempty"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type Manifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	Entries   []Entry   `json:"entries"`
+}
+
+type archiveWriter struct {
+	cw       io.WriteCloser
+	gz       *gzip.Writer
+	tw       *tar.Writer
+	manifest Manifest
+}
+
+func newArchiveWriter(w io.Writer, passphrase string) (*archiveWriter, error) {
+	cw, err := crypt.NewPassphraseWriter(w, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("could not start encryption: %v", err)
+	}
+	gz := gzip.NewWriter(cw)
+	return &archiveWriter{
+		cw:       cw,
+		gz:       gz,
+		tw:       tar.NewWriter(gz),
+		manifest: Manifest{Version: formatVersion, CreatedAt: time.Now().UTC()},
+	}, nil
+}
+
+// Add entry from reader, content is spooled to temporary file since tar needs size up front
+func (a *archiveWriter) add(name string, count int, r io.Reader) error {
+	tmp, err := ioutil.TempFile("", "backup-")
+	if err != nil {
+		return fmt.Errorf("could not create temporary file: %v", err)
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err != nil {
+		return fmt.Errorf("could not read %v: %v", name, err)
+	}
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	err = a.tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: size, ModTime: a.manifest.CreatedAt, Typeflag: tar.TypeReg})
+	if err != nil {
+		return fmt.Errorf("could not write %v: %v", name, err)
+	}
+	if _, err = io.Copy(a.tw, tmp); err != nil {
+		return fmt.Errorf("could not write %v: %v", name, err)
+	}
+	a.manifest.Entries = append(a.manifest.Entries, Entry{Name: name, Count: count, Size: size, SHA256: hex.EncodeToString(h.Sum(nil))})
+	return nil
+}
+
+// Add JSON lines section produced by export
+func (a *archiveWriter) addSection(name string, export func(enc *json.Encoder) (int, error)) error {
+	pr, pw := io.Pipe()
+	count := 0
+	go func() {
+		var err error
+		count, err = export(json.NewEncoder(pw))
+		pw.CloseWithError(err)
+	}()
+	err := a.add(name, 0, pr)
+	pr.Close()
+	if err != nil {
+		return err
+	}
+	a.manifest.Entries[len(a.manifest.Entries)-1].Count = count
+	log.Info().Msgf("Backed up %v rows of %v", count, name)
+	return nil
+}
+
+// Write backup of database and attachment blobs into w, blobs are skipped when store is nil
+func Write(w io.Writer, passphrase string, store blob.Store) (Manifest, error) {
+	a, err := newArchiveWriter(w, passphrase)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	err = a.addSection(usersName, func(enc *json.Encoder) (n int, err error) {
+		err = postgres.ExportUsers(func(r postgres.UserRecord) error { n++; return enc.Encode(r) })
+		return n, err
+	})
+	if err != nil {
+		return Manifest{}, err
+	}
+	// Workspaces are referenced by projects and projects by tasks, so they are restored first
+	err = a.addSection(workspacesName, func(enc *json.Encoder) (n int, err error) {
+		err = postgres.ExportWorkspaces(func(r postgres.WorkspaceRecord) error { n++; return enc.Encode(r) })
+		return n, err
+	})
+	if err != nil {
+		return Manifest{}, err
+	}
+	err = a.addSection(membersName, func(enc *json.Encoder) (n int, err error) {
+		err = postgres.ExportMembers(func(r postgres.MemberRecord) error { n++; return enc.Encode(r) })
+		return n, err
+	})
+	if err != nil {
+		return Manifest{}, err
+	}
+	err = a.addSection(invitationsName, func(enc *json.Encoder) (n int, err error) {
+		err = postgres.ExportInvitations(func(r postgres.InvitationRecord) error { n++; return enc.Encode(r) })
+		return n, err
+	})
+	if err != nil {
+		return Manifest{}, err
+	}
+	err = a.addSection(projectsName, func(enc *json.Encoder) (n int, err error) {
+		err = postgres.ExportProjects(func(r postgres.ProjectRecord) error { n++; return enc.Encode(r) })
+		return n, err
+	})
+	if err != nil {
+		return Manifest{}, err
+	}
+	err = a.addSection(tasksName, func(enc *json.Encoder) (n int, err error) {
+		err = postgres.ExportTasks(func(r postgres.TaskRecord) error { n++; return enc.Encode(r) })
+		return n, err
+	})
+	if err != nil {
+		return Manifest{}, err
+	}
+	err = a.addSection(sharesName, func(enc *json.Encoder) (n int, err error) {
+		err = postgres.ExportShares(func(r postgres.ShareRecord) error { n++; return enc.Encode(r) })
+		return n, err
+	})
+	if err != nil {
+		return Manifest{}, err
+	}
+	err = a.addSection(assigneesName, func(enc *json.Encoder) (n int, err error) {
+		err = postgres.ExportAssignees(func(r postgres.AssigneeRecord) error { n++; return enc.Encode(r) })
+		return n, err
+	})
+	if err != nil {
+		return Manifest{}, err
+	}
+	err = a.addSection(remindersName, func(enc *json.Encoder) (n int, err error) {
+		err = postgres.ExportReminders(func(r postgres.ReminderRecord) error { n++; return enc.Encode(r) })
+		return n, err
+	})
+	if err != nil {
+		return Manifest{}, err
+	}
+	err = a.addSection(commentsName, func(enc *json.Encoder) (n int, err error) {
+		err = postgres.ExportComments(func(r postgres.CommentRecord) error { n++; return enc.Encode(r) })
+		return n, err
+	})
+	if err != nil {
+		return Manifest{}, err
+	}
+	err = a.addSection(mentionsName, func(enc *json.Encoder) (n int, err error) {
+		err = postgres.ExportMentions(func(r postgres.MentionRecord) error { n++; return enc.Encode(r) })
+		return n, err
+	})
+	if err != nil {
+		return Manifest{}, err
+	}
+	err = a.addSection(notificationsName, func(enc *json.Encoder) (n int, err error) {
+		err = postgres.ExportNotifications(func(r postgres.NotificationRecord) error { n++; return enc.Encode(r) })
+		return n, err
+	})
+	if err != nil {
+		return Manifest{}, err
+	}
+	err = a.addSection(smartListsName, func(enc *json.Encoder) (n int, err error) {
+		err = postgres.ExportSmartLists(func(r postgres.SmartListRecord) error { n++; return enc.Encode(r) })
+		return n, err
+	})
+	if err != nil {
+		return Manifest{}, err
+	}
+	err = a.addSection(webhooksName, func(enc *json.Encoder) (n int, err error) {
+		err = postgres.ExportWebhooks(func(r postgres.WebhookRecord) error { n++; return enc.Encode(r) })
+		return n, err
+	})
+	if err != nil {
+		return Manifest{}, err
+	}
+	err = a.addSection(capturesName, func(enc *json.Encoder) (n int, err error) {
+		err = postgres.ExportCaptures(func(r postgres.CaptureRecord) error { n++; return enc.Encode(r) })
+		return n, err
+	})
+	if err != nil {
+		return Manifest{}, err
+	}
+	var attachments []postgres.AttachmentRecord
+	err = a.addSection(attachmentsName, func(enc *json.Encoder) (n int, err error) {
+		err = postgres.ExportAttachments(func(r postgres.AttachmentRecord) error {
+			n++
+			attachments = append(attachments, postgres.AttachmentRecord{UUID: r.UUID, TaskUUID: r.TaskUUID})
+			return enc.Encode(r)
+		})
+		return n, err
+	})
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	if store != nil {
+		if err = a.addBlobs(store, attachments); err != nil {
+			return Manifest{}, err
+		}
+	}
+	if err = a.close(); err != nil {
+		return Manifest{}, err
+	}
+	return a.manifest, nil
+}
+
+// Add blobs of attachments, missing ones are skipped
+func (a *archiveWriter) addBlobs(store blob.Store, attachments []postgres.AttachmentRecord) error {
+	for _, attachment := range attachments {
+		key := blob.AttachmentKey(attachment.TaskUUID, attachment.UUID)
+		data, err := store.Get(key)
+		if err == blob.ErrNotFound {
+			log.Warn().Msgf("Blob %v is missing, skipping it", key)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("could not read blob %v: %v", key, err)
+		}
+		err = a.add(blobPrefix+key, 0, data)
+		data.Close()
+		if err != nil {
+			return err
+		}
+	}
+	log.Info().Msgf("Backed up %v attachment blobs", len(attachments))
+	return nil
+}
+
+// Write manifest of added entries and finish archive
+func (a *archiveWriter) close() error {
+	manifest, err := json.MarshalIndent(a.manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not marshal manifest: %v", err)
+	}
+	err = a.tw.WriteHeader(&tar.Header{Name: manifestName, Mode: 0600, Size: int64(len(manifest)), ModTime: a.manifest.CreatedAt, Typeflag: tar.TypeReg})
+	if err != nil {
+		return fmt.Errorf("could not write manifest: %v", err)
+	}
+	if _, err = a.tw.Write(manifest); err != nil {
+		return fmt.Errorf("could not write manifest: %v", err)
+	}
+	if err = a.tw.Close(); err != nil {
+		return fmt.Errorf("could not finish archive: %v", err)
+	}
+	if err = a.gz.Close(); err != nil {
+		return fmt.Errorf("could not finish archive: %v", err)
+	}
+	if err = a.cw.Close(); err != nil {
+		return fmt.Errorf("could not finish encryption: %v", err)
+	}
+	return nil
+}
+
+// Open archive for reading entries
+func open(r io.Reader, passphrase string) (*tar.Reader, error) {
+	cr, err := crypt.NewPassphraseReader(r, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt archive: %v", err)
+	}
+	gz, err := gzip.NewReader(cr)
+	if err != nil {
+		return nil, fmt.Errorf("could not decompress archive: %v", err)
+	}
+	return tar.NewReader(gz), nil
+}
+
+type countingHash struct {
+	hash.Hash
+	lines int
+	size  int64
+}
+
+func (c *countingHash) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if b == '\n' {
+			c.lines++
+		}
+	}
+	c.size += int64(len(p))
+	return c.Hash.Write(p)
+}
+
+// Checks entries against manifest while they are read, so that archive is read once
+type verifier struct {
+	seen     map[string]Entry
+	manifest *Manifest
+}
+
+func newVerifier() *verifier {
+	return &verifier{seen: make(map[string]Entry)}
+}
+
+// Read entry through verifier, read gets reader of the entry which hashes everything read from it.
+// Manifest is decoded by verifier itself and read is not called for it.
+func (v *verifier) entry(hdr *tar.Header, r io.Reader, read func(io.Reader) error) error {
+	if v.manifest != nil {
+		return fmt.Errorf("unexpected entry %v after manifest", hdr.Name)
+	}
+	if hdr.Name == manifestName {
+		v.manifest = &Manifest{}
+		if err := json.NewDecoder(r).Decode(v.manifest); err != nil {
+			return fmt.Errorf("could not read manifest: %v", err)
+		}
+		return nil
+	}
+	h := &countingHash{Hash: sha256.New()}
+	tee := io.TeeReader(r, h)
+	if err := read(tee); err != nil {
+		return err
+	}
+	// Part of entry left by read is hashed as well
+	if _, err := io.Copy(ioutil.Discard, tee); err != nil {
+		return fmt.Errorf("could not read %v: %v", hdr.Name, err)
+	}
+	v.seen[hdr.Name] = Entry{Name: hdr.Name, Count: h.lines, Size: h.size, SHA256: hex.EncodeToString(h.Sum(nil))}
+	return nil
+}
+
+// Check entries read so far against manifest, it is called after the last entry
+func (v *verifier) check() (Manifest, error) {
+	if v.manifest == nil {
+		return Manifest{}, fmt.Errorf("archive has no manifest, it is probably truncated")
+	}
+	if v.manifest.Version != formatVersion {
+		return Manifest{}, fmt.Errorf("unsupported archive version %v", v.manifest.Version)
+	}
+	seen := make(map[string]Entry, len(v.seen))
+	for name, entry := range v.seen {
+		seen[name] = entry
+	}
+	for _, expected := range v.manifest.Entries {
+		actual, ok := seen[expected.Name]
+		if !ok {
+			return Manifest{}, fmt.Errorf("entry %v is missing", expected.Name)
+		}
+		if actual.Size != expected.Size || actual.SHA256 != expected.SHA256 {
+			return Manifest{}, fmt.Errorf("entry %v is corrupted", expected.Name)
+		}
+		if expected.Count > 0 && actual.Count != expected.Count {
+			return Manifest{}, fmt.Errorf("entry %v has %v rows, expected %v", expected.Name, actual.Count, expected.Count)
+		}
+		delete(seen, expected.Name)
+	}
+	for name := range seen {
+		return Manifest{}, fmt.Errorf("entry %v is not in manifest", name)
+	}
+	return *v.manifest, nil
+}
+
+// Verify decrypts whole archive and checks every entry against manifest
+func Verify(r io.Reader, passphrase string) (Manifest, error) {
+	tr, err := open(r, passphrase)
+	if err != nil {
+		return Manifest{}, err
+	}
+	v := newVerifier()
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Manifest{}, fmt.Errorf("could not read archive: %v", err)
+		}
+		if err = v.entry(hdr, tr, func(io.Reader) error { return nil }); err != nil {
+			return Manifest{}, err
+		}
+	}
+	return v.check()
+}
@@ -0,0 +1,282 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/Kolya59/todo-service/pkg/blob"
+	"github.com/Kolya59/todo-service/pkg/postgres"
+)
+
+const testPassphrase = "backup passphrase"
+
+// Blob store in memory
+type memStore map[string][]byte
+
+func (s memStore) Put(key string, r io.Reader, size int64, contentType string) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s[key] = data
+	return nil
+}
+
+func (s memStore) Get(key string) (io.ReadCloser, error) {
+	data, ok := s[key]
+	if !ok {
+		return nil, blob.ErrNotFound
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s memStore) Delete(key string) error {
+	delete(s, key)
+	return nil
+}
+
+// Restore transaction in memory, rows of existing uuids are skipped
+type memRestore struct {
+	existing  map[string]bool
+	rows      []string
+	committed bool
+}
+
+func (r *memRestore) row(kind string, uuid string) (bool, error) {
+	if r.existing[uuid] {
+		return false, nil
+	}
+	r.rows = append(r.rows, kind+":"+uuid)
+	return true, nil
+}
+
+func (r *memRestore) InsertUser(u postgres.UserRecord) (bool, error)   { return r.row("user", u.UUID) }
+func (r *memRestore) InsertTask(t postgres.TaskRecord) (bool, error)   { return r.row("task", t.UUID) }
+func (r *memRestore) InsertShare(s postgres.ShareRecord) (bool, error) { return r.row("share", s.UUID) }
+func (r *memRestore) InsertAttachment(a postgres.AttachmentRecord) (bool, error) {
+	return r.row("attachment", a.UUID)
+}
+func (r *memRestore) InsertWorkspace(w postgres.WorkspaceRecord) (bool, error) {
+	return r.row("workspace", w.UUID)
+}
+func (r *memRestore) InsertMember(m postgres.MemberRecord) (bool, error) {
+	return r.row("member", m.WorkspaceUUID+"/"+m.UserUUID)
+}
+func (r *memRestore) InsertInvitation(i postgres.InvitationRecord) (bool, error) {
+	return r.row("invitation", i.UUID)
+}
+func (r *memRestore) InsertProject(p postgres.ProjectRecord) (bool, error) {
+	return r.row("project", p.UUID)
+}
+func (r *memRestore) InsertAssignee(a postgres.AssigneeRecord) (bool, error) {
+	return r.row("assignee", a.TaskUUID+"/"+a.UserUUID)
+}
+func (r *memRestore) InsertReminder(rem postgres.ReminderRecord) (bool, error) {
+	return r.row("reminder", rem.UUID)
+}
+func (r *memRestore) InsertComment(c postgres.CommentRecord) (bool, error) {
+	return r.row("comment", c.UUID)
+}
+func (r *memRestore) InsertMention(m postgres.MentionRecord) (bool, error) {
+	return r.row("mention", m.CommentUUID+"/"+m.UserUUID)
+}
+func (r *memRestore) InsertNotification(n postgres.NotificationRecord) (bool, error) {
+	return r.row("notification", n.UUID)
+}
+func (r *memRestore) InsertSmartList(l postgres.SmartListRecord) (bool, error) {
+	return r.row("smart_list", l.UUID)
+}
+func (r *memRestore) InsertWebhook(w postgres.WebhookRecord) (bool, error) {
+	return r.row("webhook", w.UUID)
+}
+func (r *memRestore) InsertCapture(c postgres.CaptureRecord) (bool, error) {
+	return r.row("capture", c.UUID)
+}
+
+func (r *memRestore) Commit() error {
+	r.committed = true
+	return nil
+}
+
+func (r *memRestore) Rollback() {
+	if !r.committed {
+		r.rows = nil
+	}
+}
+
+// Restore into returned transaction in memory
+func restoreInMemory(t *testing.T, existing ...string) *memRestore {
+	r := &memRestore{existing: map[string]bool{}}
+	for _, uuid := range existing {
+		r.existing[uuid] = true
+	}
+	begin := beginRestore
+	beginRestore = func() (restoreTx, error) { return r, nil }
+	t.Cleanup(func() { beginRestore = begin })
+	return r
+}
+
+var testAttachment = postgres.AttachmentRecord{UUID: "a1", TaskUUID: "t1", Name: "notes.txt", Size: 5}
+
+// Archive of two users, a task and its attachment with blob of the store. Manifest can be changed before it is written.
+func writeArchive(t *testing.T, store blob.Store, modify func(m *Manifest)) []byte {
+	buf := &bytes.Buffer{}
+	a, err := newArchiveWriter(buf, testPassphrase)
+	if err != nil {
+		t.Fatalf("could not create archive: %v", err)
+	}
+	sections := []struct {
+		name    string
+		records []interface{}
+	}{
+		{usersName, []interface{}{postgres.UserRecord{UUID: "u1", Login: "alice"}, postgres.UserRecord{UUID: "u2", Login: "bob"}}},
+		{tasksName, []interface{}{postgres.TaskRecord{UUID: "t1", Value: "encrypted", AuthorUUID: "u1", Labels: []string{"home"}}}},
+		{attachmentsName, []interface{}{testAttachment}},
+	}
+	for _, section := range sections {
+		err = a.addSection(section.name, func(enc *json.Encoder) (int, error) {
+			for _, record := range section.records {
+				if err := enc.Encode(record); err != nil {
+					return 0, err
+				}
+			}
+			return len(section.records), nil
+		})
+		if err != nil {
+			t.Fatalf("could not add %v: %v", section.name, err)
+		}
+	}
+	if err = a.addBlobs(store, []postgres.AttachmentRecord{testAttachment}); err != nil {
+		t.Fatalf("could not add blobs: %v", err)
+	}
+	if modify != nil {
+		modify(&a.manifest)
+	}
+	if err = a.close(); err != nil {
+		t.Fatalf("could not close archive: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func sourceStore() memStore {
+	return memStore{blob.AttachmentKey("t1", "a1"): []byte("hello")}
+}
+
+func TestRoundTrip(t *testing.T) {
+	archive := writeArchive(t, sourceStore(), nil)
+
+	manifest, err := Verify(bytes.NewReader(archive), testPassphrase)
+	if err != nil {
+		t.Fatalf("could not verify: %v", err)
+	}
+	if len(manifest.Entries) != 4 || manifest.Entries[0].Name != usersName || manifest.Entries[0].Count != 2 {
+		t.Errorf("unexpected manifest entries %+v", manifest.Entries)
+	}
+
+	restore := restoreInMemory(t, "u2")
+	target := memStore{}
+	res, err := Restore(bytes.NewReader(archive), testPassphrase, target, false)
+	if err != nil {
+		t.Fatalf("could not restore: %v", err)
+	}
+	if !restore.committed {
+		t.Errorf("restore is not committed")
+	}
+	want := []string{"user:u1", "task:t1", "attachment:a1"}
+	if len(restore.rows) != len(want) {
+		t.Fatalf("restored %v, want %v", restore.rows, want)
+	}
+	for i := range want {
+		if restore.rows[i] != want[i] {
+			t.Errorf("restored %v, want %v", restore.rows, want)
+		}
+	}
+	if res.Restored[usersName] != 1 || res.Skipped[usersName] != 1 || res.Restored[tasksName] != 1 || res.Blobs != 1 {
+		t.Errorf("unexpected result %+v", res)
+	}
+	if !res.Manifest.CreatedAt.Equal(manifest.CreatedAt) {
+		t.Errorf("result has manifest of %v, want %v", res.Manifest.CreatedAt, manifest.CreatedAt)
+	}
+	if data := target[blob.AttachmentKey("t1", "a1")]; string(data) != "hello" {
+		t.Errorf("restored blob %q, want %q", data, "hello")
+	}
+}
+
+func TestRestoreDryRun(t *testing.T) {
+	archive := writeArchive(t, sourceStore(), nil)
+	restore := restoreInMemory(t)
+	target := memStore{}
+	res, err := Restore(bytes.NewReader(archive), testPassphrase, target, true)
+	if err != nil {
+		t.Fatalf("could not restore: %v", err)
+	}
+	if restore.committed || len(restore.rows) != 0 {
+		t.Errorf("dry run committed %v", restore.rows)
+	}
+	if len(target) != 0 {
+		t.Errorf("dry run wrote %v blobs", len(target))
+	}
+	if res.Restored[usersName] != 2 || res.Blobs != 1 {
+		t.Errorf("unexpected result %+v", res)
+	}
+}
+
+func TestBackupWithoutBlob(t *testing.T) {
+	archive := writeArchive(t, memStore{}, nil)
+	manifest, err := Verify(bytes.NewReader(archive), testPassphrase)
+	if err != nil {
+		t.Fatalf("could not verify: %v", err)
+	}
+	if len(manifest.Entries) != 3 {
+		t.Errorf("missing blob is in manifest %+v", manifest.Entries)
+	}
+}
+
+// Every rejected archive leaves database and blob store untouched
+func assertRejected(t *testing.T, archive []byte, passphrase string) {
+	t.Helper()
+	if _, err := Verify(bytes.NewReader(archive), passphrase); err == nil {
+		t.Errorf("archive is verified")
+	}
+	restore := restoreInMemory(t)
+	target := memStore{}
+	if _, err := Restore(bytes.NewReader(archive), passphrase, target, false); err == nil {
+		t.Errorf("archive is restored")
+	}
+	if restore.committed || len(restore.rows) != 0 {
+		t.Errorf("rejected archive committed %v", restore.rows)
+	}
+	if len(target) != 0 {
+		t.Errorf("rejected archive wrote %v blobs", len(target))
+	}
+}
+
+func TestRejectedArchive(t *testing.T) {
+	archive := writeArchive(t, sourceStore(), nil)
+	tampered := append([]byte{}, archive...)
+	tampered[len(tampered)/2] ^= 1
+
+	tests := []struct {
+		name       string
+		archive    []byte
+		passphrase string
+	}{
+		{"tampered", tampered, testPassphrase},
+		{"truncated", archive[:len(archive)-10], testPassphrase},
+		{"wrong passphrase", archive, "wrong"},
+		{"count mismatch", writeArchive(t, sourceStore(), func(m *Manifest) { m.Entries[0].Count++ }), testPassphrase},
+		{"sha256 mismatch", writeArchive(t, sourceStore(), func(m *Manifest) { m.Entries[1].SHA256 = m.Entries[0].SHA256 }), testPassphrase},
+		{"size mismatch", writeArchive(t, sourceStore(), func(m *Manifest) { m.Entries[3].Size++ }), testPassphrase},
+		{"missing entry", writeArchive(t, sourceStore(), func(m *Manifest) { m.Entries = append(m.Entries, Entry{Name: "extra.jsonl"}) }), testPassphrase},
+		{"unlisted entry", writeArchive(t, sourceStore(), func(m *Manifest) { m.Entries = m.Entries[1:] }), testPassphrase},
+		{"unknown version", writeArchive(t, sourceStore(), func(m *Manifest) { m.Version++ }), testPassphrase},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assertRejected(t, test.archive, test.passphrase)
+		})
+	}
+}
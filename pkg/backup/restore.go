@@ -0,0 +1,312 @@
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Kolya59/todo-service/pkg/blob"
+	"github.com/Kolya59/todo-service/pkg/postgres"
+)
+
+// Maximal length of a single JSON line
+const maxLineSize = 64 << 20
+
+// Result counts restored and already existing rows per entry
+type Result struct {
+	Manifest Manifest
+	Restored map[string]int
+	Skipped  map[string]int
+	Blobs    int
+}
+
+// Transaction rows are restored in, it is *postgres.Restore
+type restoreTx interface {
+	InsertUser(u postgres.UserRecord) (bool, error)
+	InsertWorkspace(w postgres.WorkspaceRecord) (bool, error)
+	InsertMember(m postgres.MemberRecord) (bool, error)
+	InsertInvitation(i postgres.InvitationRecord) (bool, error)
+	InsertProject(p postgres.ProjectRecord) (bool, error)
+	InsertTask(t postgres.TaskRecord) (bool, error)
+	InsertShare(s postgres.ShareRecord) (bool, error)
+	InsertAssignee(a postgres.AssigneeRecord) (bool, error)
+	InsertReminder(rem postgres.ReminderRecord) (bool, error)
+	InsertComment(c postgres.CommentRecord) (bool, error)
+	InsertMention(m postgres.MentionRecord) (bool, error)
+	InsertNotification(n postgres.NotificationRecord) (bool, error)
+	InsertSmartList(l postgres.SmartListRecord) (bool, error)
+	InsertWebhook(w postgres.WebhookRecord) (bool, error)
+	InsertCapture(c postgres.CaptureRecord) (bool, error)
+	InsertAttachment(a postgres.AttachmentRecord) (bool, error)
+	Commit() error
+	Rollback()
+}
+
+// Tests restore into memory
+var beginRestore = func() (restoreTx, error) {
+	return postgres.BeginRestore()
+}
+
+// Blob read from archive, it is kept in temporary file until rows are committed
+type stagedBlob struct {
+	key  string
+	path string
+	size int64
+}
+
+// Restore rows and blobs from archive, it is read once and checked against its manifest on the way.
+// Rows are inserted in a single transaction committed only for verified archive, existing rows are kept.
+// Blobs are written after commit, so failed restore leaves no blobs. In dry run transaction is rolled back
+// and blobs are not written, so the result shows what would be restored.
+func Restore(r io.Reader, passphrase string, store blob.Store, dryRun bool) (Result, error) {
+	res := Result{Restored: make(map[string]int), Skipped: make(map[string]int)}
+	tr, err := open(r, passphrase)
+	if err != nil {
+		return res, err
+	}
+	restore, err := beginRestore()
+	if err != nil {
+		return res, err
+	}
+	defer restore.Rollback()
+	staging, err := ioutil.TempDir("", "restore-")
+	if err != nil {
+		return res, fmt.Errorf("could not create staging directory: %v", err)
+	}
+	defer os.RemoveAll(staging)
+
+	var blobs []stagedBlob
+	v := newVerifier()
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return res, fmt.Errorf("could not read archive: %v", err)
+		}
+		err = v.entry(hdr, tr, func(r io.Reader) error {
+			if !strings.HasPrefix(hdr.Name, blobPrefix) {
+				return restoreEntry(restore, hdr, r, &res)
+			}
+			res.Blobs++
+			if dryRun || store == nil {
+				return nil
+			}
+			staged, err := stageBlob(staging, len(blobs), hdr, r)
+			if err != nil {
+				return err
+			}
+			blobs = append(blobs, staged)
+			return nil
+		})
+		if err != nil {
+			return res, err
+		}
+	}
+	if res.Manifest, err = v.check(); err != nil {
+		return res, err
+	}
+
+	if dryRun {
+		return res, nil
+	}
+	if err = restore.Commit(); err != nil {
+		return res, err
+	}
+	for _, b := range blobs {
+		if err = putBlob(store, b); err != nil {
+			return res, fmt.Errorf("rows are restored, but blob %v is not: %v", b.key, err)
+		}
+	}
+	return res, nil
+}
+
+// Copy blob entry into staging directory, files are numbered since keys have slashes
+func stageBlob(dir string, n int, hdr *tar.Header, r io.Reader) (stagedBlob, error) {
+	b := stagedBlob{key: strings.TrimPrefix(hdr.Name, blobPrefix), path: filepath.Join(dir, strconv.Itoa(n)), size: hdr.Size}
+	f, err := os.Create(b.path)
+	if err != nil {
+		return b, fmt.Errorf("could not stage blob %v: %v", b.key, err)
+	}
+	defer f.Close()
+	if _, err = io.Copy(f, r); err != nil {
+		return b, fmt.Errorf("could not stage blob %v: %v", b.key, err)
+	}
+	return b, nil
+}
+
+func putBlob(store blob.Store, b stagedBlob) error {
+	f, err := os.Open(b.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return store.Put(b.key, f, b.size, "application/octet-stream")
+}
+
+// Insert rows of a section entry into restore transaction
+func restoreEntry(restore restoreTx, hdr *tar.Header, r io.Reader, res *Result) error {
+	switch {
+	case hdr.Name == usersName:
+		return restoreSection(r, hdr.Name, res, func(line []byte) (bool, error) {
+			u := postgres.UserRecord{}
+			if err := json.Unmarshal(line, &u); err != nil {
+				return false, err
+			}
+			return restore.InsertUser(u)
+		})
+	case hdr.Name == workspacesName:
+		return restoreSection(r, hdr.Name, res, func(line []byte) (bool, error) {
+			w := postgres.WorkspaceRecord{}
+			if err := json.Unmarshal(line, &w); err != nil {
+				return false, err
+			}
+			return restore.InsertWorkspace(w)
+		})
+	case hdr.Name == membersName:
+		return restoreSection(r, hdr.Name, res, func(line []byte) (bool, error) {
+			m := postgres.MemberRecord{}
+			if err := json.Unmarshal(line, &m); err != nil {
+				return false, err
+			}
+			return restore.InsertMember(m)
+		})
+	case hdr.Name == invitationsName:
+		return restoreSection(r, hdr.Name, res, func(line []byte) (bool, error) {
+			i := postgres.InvitationRecord{}
+			if err := json.Unmarshal(line, &i); err != nil {
+				return false, err
+			}
+			return restore.InsertInvitation(i)
+		})
+	case hdr.Name == projectsName:
+		return restoreSection(r, hdr.Name, res, func(line []byte) (bool, error) {
+			p := postgres.ProjectRecord{}
+			if err := json.Unmarshal(line, &p); err != nil {
+				return false, err
+			}
+			return restore.InsertProject(p)
+		})
+	case hdr.Name == tasksName:
+		return restoreSection(r, hdr.Name, res, func(line []byte) (bool, error) {
+			t := postgres.TaskRecord{}
+			if err := json.Unmarshal(line, &t); err != nil {
+				return false, err
+			}
+			return restore.InsertTask(t)
+		})
+	case hdr.Name == sharesName:
+		return restoreSection(r, hdr.Name, res, func(line []byte) (bool, error) {
+			s := postgres.ShareRecord{}
+			if err := json.Unmarshal(line, &s); err != nil {
+				return false, err
+			}
+			return restore.InsertShare(s)
+		})
+	case hdr.Name == assigneesName:
+		return restoreSection(r, hdr.Name, res, func(line []byte) (bool, error) {
+			a := postgres.AssigneeRecord{}
+			if err := json.Unmarshal(line, &a); err != nil {
+				return false, err
+			}
+			return restore.InsertAssignee(a)
+		})
+	case hdr.Name == remindersName:
+		return restoreSection(r, hdr.Name, res, func(line []byte) (bool, error) {
+			rem := postgres.ReminderRecord{}
+			if err := json.Unmarshal(line, &rem); err != nil {
+				return false, err
+			}
+			return restore.InsertReminder(rem)
+		})
+	case hdr.Name == commentsName:
+		return restoreSection(r, hdr.Name, res, func(line []byte) (bool, error) {
+			c := postgres.CommentRecord{}
+			if err := json.Unmarshal(line, &c); err != nil {
+				return false, err
+			}
+			return restore.InsertComment(c)
+		})
+	case hdr.Name == mentionsName:
+		return restoreSection(r, hdr.Name, res, func(line []byte) (bool, error) {
+			m := postgres.MentionRecord{}
+			if err := json.Unmarshal(line, &m); err != nil {
+				return false, err
+			}
+			return restore.InsertMention(m)
+		})
+	case hdr.Name == notificationsName:
+		return restoreSection(r, hdr.Name, res, func(line []byte) (bool, error) {
+			n := postgres.NotificationRecord{}
+			if err := json.Unmarshal(line, &n); err != nil {
+				return false, err
+			}
+			return restore.InsertNotification(n)
+		})
+	case hdr.Name == smartListsName:
+		return restoreSection(r, hdr.Name, res, func(line []byte) (bool, error) {
+			l := postgres.SmartListRecord{}
+			if err := json.Unmarshal(line, &l); err != nil {
+				return false, err
+			}
+			return restore.InsertSmartList(l)
+		})
+	case hdr.Name == webhooksName:
+		return restoreSection(r, hdr.Name, res, func(line []byte) (bool, error) {
+			w := postgres.WebhookRecord{}
+			if err := json.Unmarshal(line, &w); err != nil {
+				return false, err
+			}
+			return restore.InsertWebhook(w)
+		})
+	case hdr.Name == capturesName:
+		return restoreSection(r, hdr.Name, res, func(line []byte) (bool, error) {
+			c := postgres.CaptureRecord{}
+			if err := json.Unmarshal(line, &c); err != nil {
+				return false, err
+			}
+			return restore.InsertCapture(c)
+		})
+	case hdr.Name == attachmentsName:
+		return restoreSection(r, hdr.Name, res, func(line []byte) (bool, error) {
+			a := postgres.AttachmentRecord{}
+			if err := json.Unmarshal(line, &a); err != nil {
+				return false, err
+			}
+			return restore.InsertAttachment(a)
+		})
+	default:
+		return fmt.Errorf("unknown entry %v", hdr.Name)
+	}
+}
+
+func restoreSection(r io.Reader, name string, res *Result, insert func([]byte) (bool, error)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		inserted, err := insert(scanner.Bytes())
+		if err != nil {
+			return fmt.Errorf("could not restore %v line %v: %v", name, line, err)
+		}
+		if inserted {
+			res.Restored[name]++
+		} else {
+			res.Skipped[name]++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("could not read %v: %v", name, err)
+	}
+	log.Info().Msgf("Restored %v rows of %v, %v already exist", res.Restored[name], name, res.Skipped[name])
+	return nil
+}
@@ -0,0 +1,53 @@
+package blob
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNotFound is returned when there is no blob with the key
+var ErrNotFound = errors.New("blob is not found")
+
+// Store keeps opaque blobs by key
+type Store interface {
+	// Put writes size bytes from r under the key, replacing an existing blob
+	Put(key string, r io.Reader, size int64, contentType string) error
+	// Get opens blob for reading, caller must close it
+	Get(key string) (io.ReadCloser, error)
+	// Delete removes blob, missing blob is not an error
+	Delete(key string) error
+}
+
+// AttachmentKey returns key of task attachment blob
+func AttachmentKey(taskUUID string, attachmentUUID string) string {
+	return fmt.Sprintf("attachments/%s/%s", taskUUID, attachmentUUID)
+}
+
+// New creates store by kind: file or s3
+func New(kind string, target string, bucket string, region string, accessKey string, secretKey string) (Store, error) {
+	switch kind {
+	case "", "file":
+		if target == "" {
+			target = "./data/blobs"
+		}
+		return &FileStore{Root: target}, nil
+	case "s3":
+		if target == "" || bucket == "" {
+			return nil, fmt.Errorf("s3 store requires an endpoint and a bucket")
+		}
+		if region == "" {
+			region = "us-east-1"
+		}
+		return &S3Store{
+			Endpoint:  target,
+			Bucket:    bucket,
+			Region:    region,
+			AccessKey: accessKey,
+			SecretKey: secretKey,
+			Client:    defaultClient,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown blob store %q", kind)
+	}
+}
@@ -0,0 +1,80 @@
+package blob
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileStore keeps blobs in files under Root
+type FileStore struct {
+	Root string
+}
+
+func (s *FileStore) path(key string) (string, error) {
+	p := filepath.Join(s.Root, filepath.FromSlash(key))
+	if !strings.HasPrefix(p, filepath.Clean(s.Root)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return p, nil
+}
+
+func (s *FileStore) Put(key string, r io.Reader, size int64, contentType string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return fmt.Errorf("could not create blob directory: %v", err)
+	}
+	// Write into temporary file first so readers never see a partial blob
+	f, err := ioutil.TempFile(filepath.Dir(p), ".upload-")
+	if err != nil {
+		return fmt.Errorf("could not create blob file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	n, err := io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("could not write blob: %v", err)
+	}
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("could not write blob: %v", err)
+	}
+	if size >= 0 && n != size {
+		return fmt.Errorf("blob size mismatch: expected %v, got %v", size, n)
+	}
+	if err = os.Rename(f.Name(), p); err != nil {
+		return fmt.Errorf("could not save blob: %v", err)
+	}
+	return nil
+}
+
+func (s *FileStore) Get(key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not open blob: %v", err)
+	}
+	return f, nil
+}
+
+func (s *FileStore) Delete(key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not delete blob: %v", err)
+	}
+	return nil
+}
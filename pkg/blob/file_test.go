@@ -0,0 +1,72 @@
+package blob
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	s := &FileStore{Root: filepath.Join(t.TempDir(), "blobs")}
+	if err := s.Put("attachments/t1/a1", strings.NewReader("hello"), 5, "text/plain"); err != nil {
+		t.Fatalf("could not put: %v", err)
+	}
+	r, err := s.Get("attachments/t1/a1")
+	if err != nil {
+		t.Fatalf("could not get: %v", err)
+	}
+	data, _ := ioutil.ReadAll(r)
+	r.Close()
+	if string(data) != "hello" {
+		t.Errorf("got %q, want %q", data, "hello")
+	}
+
+	if err = s.Delete("attachments/t1/a1"); err != nil {
+		t.Fatalf("could not delete: %v", err)
+	}
+	if _, err = s.Get("attachments/t1/a1"); err != ErrNotFound {
+		t.Errorf("got %v for deleted blob, want %v", err, ErrNotFound)
+	}
+	if err = s.Delete("attachments/t1/a1"); err != nil {
+		t.Errorf("got %v deleting missing blob", err)
+	}
+}
+
+func TestFileStoreSizeMismatch(t *testing.T) {
+	s := &FileStore{Root: t.TempDir()}
+	if err := s.Put("key", strings.NewReader("hello"), 4, ""); err == nil {
+		t.Errorf("put blob of wrong size")
+	}
+	if _, err := s.Get("key"); err != ErrNotFound {
+		t.Errorf("got %v for blob of wrong size, want %v", err, ErrNotFound)
+	}
+}
+
+func TestFileStoreTraversal(t *testing.T) {
+	dir := t.TempDir()
+	s := &FileStore{Root: filepath.Join(dir, "blobs")}
+	secret := filepath.Join(dir, "secret")
+	if err := ioutil.WriteFile(secret, []byte("secret"), 0600); err != nil {
+		t.Fatalf("could not write file: %v", err)
+	}
+
+	for _, key := range []string{"../secret", "attachments/../../secret", "..", "", "a/../../blobs-other/x"} {
+		if err := s.Put(key, strings.NewReader("x"), 1, ""); err == nil {
+			t.Errorf("put blob with key %q", key)
+		}
+		if _, err := s.Get(key); err == nil || err == ErrNotFound {
+			t.Errorf("got %v reading key %q, want invalid key", err, key)
+		}
+		if err := s.Delete(key); err == nil {
+			t.Errorf("deleted blob with key %q", key)
+		}
+	}
+	if data, err := ioutil.ReadFile(secret); err != nil || string(data) != "secret" {
+		t.Errorf("file out of root is changed: %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "blobs-other")); !os.IsNotExist(err) {
+		t.Errorf("directory out of root is created")
+	}
+}
@@ -1,67 +1,279 @@
 package crypt
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/md5"
 	"crypto/rand"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+
+	"golang.org/x/crypto/argon2"
 )
 
-func createHash(key string) string {
-	hasher := md5.New()
-	hasher.Write([]byte(key))
-	return hex.EncodeToString(hasher.Sum(nil))
+// Ciphertext layout:
+//
+//	magic | version | algorithm | kdf | kdf params | nonce | sealed data
+//
+// Argon2id params are time (uint32), memory in KiB (uint32), threads (uint8), salt length (uint8) and salt.
+// The header is authenticated as additional data. Data without magic is decrypted as the legacy
+// format: nonce | sealed data with AES key derived by unsalted MD5.
+const (
+	formatVersion = 1
+
+	algAES256GCM = 1
+
+	kdfNone     = 0
+	kdfArgon2id = 1
+
+	keySize  = 32
+	saltSize = 16
+
+	argonTime    = 1
+	argonMemory  = 64 * 1024
+	argonThreads = 4
+)
+
+var (
+	magic       = []byte("TDCR")
+	streamMagic = []byte("TDCS")
+)
+
+var (
+	// ErrInvalidCiphertext is returned for truncated or malformed input
+	ErrInvalidCiphertext = errors.New("invalid ciphertext")
+	// ErrUnsupported is returned for ciphertext produced by an unknown version
+	ErrUnsupported = errors.New("unsupported ciphertext format")
+	// ErrInvalidKey is returned for raw keys of wrong size
+	ErrInvalidKey = errors.New("invalid key size")
+)
+
+type header struct {
+	magic     []byte
+	algorithm byte
+	kdf       byte
+	time      uint32
+	memory    uint32
+	threads   uint8
+	salt      []byte
 }
 
-func Encrypt(data []byte, passphrase string) ([]byte, error) {
-	block, _ := aes.NewCipher([]byte(createHash(passphrase)))
-	gcm, err := cipher.NewGCM(block)
+func (h header) marshal() []byte {
+	buf := &bytes.Buffer{}
+	buf.Write(h.magic)
+	buf.WriteByte(formatVersion)
+	buf.WriteByte(h.algorithm)
+	buf.WriteByte(h.kdf)
+	if h.kdf == kdfArgon2id {
+		_ = binary.Write(buf, binary.BigEndian, h.time)
+		_ = binary.Write(buf, binary.BigEndian, h.memory)
+		buf.WriteByte(h.threads)
+		buf.WriteByte(byte(len(h.salt)))
+		buf.Write(h.salt)
+	}
+	return buf.Bytes()
+}
+
+// Parse header, returns header and its length
+func parseHeader(data []byte, magic []byte) (header, int, error) {
+	h := header{magic: magic}
+	if len(data) < len(magic)+3 || !bytes.Equal(data[:len(magic)], magic) {
+		return h, 0, ErrInvalidCiphertext
+	}
+	p := len(magic)
+	if data[p] != formatVersion {
+		return h, 0, ErrUnsupported
+	}
+	h.algorithm, h.kdf = data[p+1], data[p+2]
+	p += 3
+	if h.algorithm != algAES256GCM {
+		return h, 0, ErrUnsupported
+	}
+	switch h.kdf {
+	case kdfNone:
+	case kdfArgon2id:
+		if len(data) < p+10 {
+			return h, 0, ErrInvalidCiphertext
+		}
+		h.time = binary.BigEndian.Uint32(data[p:])
+		h.memory = binary.BigEndian.Uint32(data[p+4:])
+		h.threads = data[p+8]
+		saltLen := int(data[p+9])
+		p += 10
+		if len(data) < p+saltLen {
+			return h, 0, ErrInvalidCiphertext
+		}
+		h.salt = data[p : p+saltLen]
+		p += saltLen
+		// Params above the ones the service writes are rejected, so that crafted input cannot exhaust memory
+		if h.time == 0 || h.time > argonTime || h.memory == 0 || h.memory > argonMemory ||
+			h.threads == 0 || h.threads > argonThreads {
+			return h, 0, ErrInvalidCiphertext
+		}
+	default:
+		return h, 0, ErrUnsupported
+	}
+	return h, p, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(h header, key []byte, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
 	if err != nil {
 		return nil, err
 	}
+	head := h.marshal()
 	nonce := make([]byte, gcm.NonceSize())
 	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
 		return nil, err
 	}
-	ciphertext := gcm.Seal(nonce, nonce, data, nil)
-	return ciphertext, nil
+	out := make([]byte, 0, len(head)+len(nonce)+len(data)+gcm.Overhead())
+	out = append(append(out, head...), nonce...)
+	return gcm.Seal(out, nonce, data, head), nil
+}
+
+func open(head []byte, key []byte, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize()+gcm.Overhead() {
+		return nil, ErrInvalidCiphertext
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, head)
+}
+
+func argonHeader(magic []byte, salt []byte) header {
+	return header{
+		magic:     magic,
+		algorithm: algAES256GCM,
+		kdf:       kdfArgon2id,
+		time:      argonTime,
+		memory:    argonMemory,
+		threads:   argonThreads,
+		salt:      salt,
+	}
+}
+
+func deriveKey(passphrase string, h header) []byte {
+	return argon2.IDKey([]byte(passphrase), h.salt, h.time, h.memory, h.threads, keySize)
+}
+
+// Encrypt data with key derived from passphrase by Argon2id
+func Encrypt(data []byte, passphrase string) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	h := argonHeader(magic, salt)
+	return seal(h, deriveKey(passphrase, h), data)
 }
 
+// Decrypt data produced by Encrypt, including the legacy format
 func Decrypt(data []byte, passphrase string) ([]byte, error) {
-	key := []byte(createHash(passphrase))
-	block, err := aes.NewCipher(key)
+	h, n, err := parseHeader(data, magic)
+	if err == ErrInvalidCiphertext {
+		return decryptLegacy(data, passphrase)
+	}
 	if err != nil {
 		return nil, err
 	}
-	gcm, err := cipher.NewGCM(block)
+	if h.kdf != kdfArgon2id {
+		return nil, fmt.Errorf("ciphertext requires a raw key: %w", ErrUnsupported)
+	}
+	plain, err := open(data[:n], deriveKey(passphrase, h), data[n:])
+	if err != nil {
+		// Legacy ciphertext may start with magic by chance
+		if legacy, legacyErr := decryptLegacy(data, passphrase); legacyErr == nil {
+			return legacy, nil
+		}
+		return nil, err
+	}
+	return plain, nil
+}
+
+// EncryptWithKey encrypts data with raw 32 bytes key, no key derivation is done
+func EncryptWithKey(data []byte, key []byte) ([]byte, error) {
+	if len(key) != keySize {
+		return nil, ErrInvalidKey
+	}
+	return seal(header{magic: magic, algorithm: algAES256GCM, kdf: kdfNone}, key, data)
+}
+
+// DecryptWithKey decrypts data produced by EncryptWithKey
+func DecryptWithKey(data []byte, key []byte) ([]byte, error) {
+	if len(key) != keySize {
+		return nil, ErrInvalidKey
+	}
+	h, n, err := parseHeader(data, magic)
+	if err == ErrInvalidCiphertext {
+		// Raw keys were used as hex passphrase before versioned format
+		return decryptLegacy(data, hex.EncodeToString(key))
+	}
 	if err != nil {
 		return nil, err
 	}
-	nonceSize := gcm.NonceSize()
-	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if h.kdf != kdfNone {
+		return nil, fmt.Errorf("ciphertext requires a passphrase: %w", ErrUnsupported)
+	}
+	plain, err := open(data[:n], key, data[n:])
 	if err != nil {
+		if legacy, legacyErr := decryptLegacy(data, hex.EncodeToString(key)); legacyErr == nil {
+			return legacy, nil
+		}
 		return nil, err
 	}
-	return plaintext, nil
+	return plain, nil
+}
+
+func legacyHash(key string) string {
+	hasher := md5.New()
+	hasher.Write([]byte(key))
+	return hex.EncodeToString(hasher.Sum(nil))
 }
 
-func EncryptFile(filename string, data []byte, passphrase string) error {
-	f, _ := os.Create(filename)
-	defer f.Close()
+func decryptLegacy(data []byte, passphrase string) ([]byte, error) {
+	return open(nil, []byte(legacyHash(passphrase)), data)
+}
+
+func EncryptFile(filename string, data []byte, passphrase string) (err error) {
 	encrypted, err := Encrypt(data, passphrase)
 	if err != nil {
 		return err
 	}
-	f.Write(encrypted)
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("could not create file: %v", err)
+	}
+	defer func() {
+		if closeErr := f.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("could not close file: %v", closeErr)
+		}
+	}()
+	if _, err = f.Write(encrypted); err != nil {
+		return fmt.Errorf("could not write file: %v", err)
+	}
 	return nil
 }
 
 func DecryptFile(filename string, passphrase string) ([]byte, error) {
-	data, _ := ioutil.ReadFile(filename)
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("could not read file: %v", err)
+	}
 	return Decrypt(data, passphrase)
 }
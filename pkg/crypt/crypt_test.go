@@ -0,0 +1,170 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"testing"
+)
+
+const testPassphrase = "correct horse battery staple"
+
+func testKey(t *testing.T) []byte {
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+	return key
+}
+
+// Ciphertext in the format written before the versioned header
+func encryptLegacy(t *testing.T, data []byte, passphrase string) []byte {
+	gcm, err := newGCM([]byte(legacyHash(passphrase)))
+	if err != nil {
+		t.Fatalf("could not create cipher: %v", err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		t.Fatalf("could not generate nonce: %v", err)
+	}
+	return gcm.Seal(nonce, nonce, data, nil)
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	for _, data := range [][]byte{{}, []byte("secret task"), bytes.Repeat([]byte{0xab}, 100000)} {
+		encrypted, err := Encrypt(data, testPassphrase)
+		if err != nil {
+			t.Fatalf("could not encrypt: %v", err)
+		}
+		if !bytes.HasPrefix(encrypted, magic) {
+			t.Errorf("ciphertext does not start with magic")
+		}
+		plain, err := Decrypt(encrypted, testPassphrase)
+		if err != nil {
+			t.Fatalf("could not decrypt: %v", err)
+		}
+		if !bytes.Equal(plain, data) {
+			t.Errorf("decrypted %v bytes do not match %v bytes", len(plain), len(data))
+		}
+		if _, err = Decrypt(encrypted, "wrong"); err == nil {
+			t.Errorf("decrypted with wrong passphrase")
+		}
+	}
+}
+
+func TestEncryptWithKeyRoundTrip(t *testing.T) {
+	key := testKey(t)
+	data := []byte("secret task")
+	encrypted, err := EncryptWithKey(data, key)
+	if err != nil {
+		t.Fatalf("could not encrypt: %v", err)
+	}
+	plain, err := DecryptWithKey(encrypted, key)
+	if err != nil {
+		t.Fatalf("could not decrypt: %v", err)
+	}
+	if !bytes.Equal(plain, data) {
+		t.Errorf("got %q, want %q", plain, data)
+	}
+	if _, err = DecryptWithKey(encrypted, testKey(t)); err == nil {
+		t.Errorf("decrypted with wrong key")
+	}
+	if _, err = EncryptWithKey(data, key[:16]); err != ErrInvalidKey {
+		t.Errorf("got %v for short key, want %v", err, ErrInvalidKey)
+	}
+	// Ciphertext of raw key needs the key, not a passphrase
+	if _, err = Decrypt(encrypted, testPassphrase); err == nil {
+		t.Errorf("decrypted raw key ciphertext with passphrase")
+	}
+}
+
+func TestDecryptLegacy(t *testing.T) {
+	data := []byte("task of the old format")
+	plain, err := Decrypt(encryptLegacy(t, data, testPassphrase), testPassphrase)
+	if err != nil {
+		t.Fatalf("could not decrypt: %v", err)
+	}
+	if !bytes.Equal(plain, data) {
+		t.Errorf("got %q, want %q", plain, data)
+	}
+	if _, err = Decrypt(encryptLegacy(t, data, testPassphrase), "wrong"); err == nil {
+		t.Errorf("decrypted with wrong passphrase")
+	}
+
+	// Raw keys were hex passphrases of the legacy format
+	key := testKey(t)
+	plain, err = DecryptWithKey(encryptLegacy(t, data, hex.EncodeToString(key)), key)
+	if err != nil {
+		t.Fatalf("could not decrypt with key: %v", err)
+	}
+	if !bytes.Equal(plain, data) {
+		t.Errorf("got %q, want %q", plain, data)
+	}
+}
+
+func TestDecryptInvalid(t *testing.T) {
+	encrypted, err := Encrypt([]byte("secret task"), testPassphrase)
+	if err != nil {
+		t.Fatalf("could not encrypt: %v", err)
+	}
+	corrupt := append([]byte{}, encrypted...)
+	corrupt[len(corrupt)-1] ^= 1
+	headerSize := len(argonHeader(magic, make([]byte, saltSize)).marshal())
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short", []byte{1, 2, 3}},
+		{"magic only", magic},
+		{"header only", encrypted[:headerSize]},
+		{"truncated", encrypted[:len(encrypted)-1]},
+		{"corrupt", corrupt},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Decrypt(test.data, testPassphrase); err == nil {
+				t.Errorf("decrypted invalid ciphertext")
+			}
+		})
+	}
+}
+
+func TestParseHeaderLimits(t *testing.T) {
+	encrypted, err := Encrypt([]byte("secret task"), testPassphrase)
+	if err != nil {
+		t.Fatalf("could not encrypt: %v", err)
+	}
+	// Argon2id params follow magic, version, algorithm and kdf
+	p := len(magic) + 3
+	tests := []struct {
+		name   string
+		modify func(data []byte)
+	}{
+		{"time over limit", func(data []byte) { binary.BigEndian.PutUint32(data[p:], argonTime+1) }},
+		{"zero time", func(data []byte) { binary.BigEndian.PutUint32(data[p:], 0) }},
+		{"memory over limit", func(data []byte) { binary.BigEndian.PutUint32(data[p+4:], argonMemory+1) }},
+		{"huge memory", func(data []byte) { binary.BigEndian.PutUint32(data[p+4:], ^uint32(0)) }},
+		{"zero memory", func(data []byte) { binary.BigEndian.PutUint32(data[p+4:], 0) }},
+		{"threads over limit", func(data []byte) { data[p+8] = argonThreads + 1 }},
+		{"unknown version", func(data []byte) { data[len(magic)] = formatVersion + 1 }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := append([]byte{}, encrypted...)
+			test.modify(data)
+			if _, _, err := parseHeader(data, magic); err == nil {
+				t.Errorf("parsed header")
+			}
+			if _, err := Decrypt(data, testPassphrase); err == nil {
+				t.Errorf("decrypted modified ciphertext")
+			}
+		})
+	}
+	if _, _, err := parseHeader(encrypted, magic); err != nil {
+		t.Errorf("could not parse header written by Encrypt: %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package crypt
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	dataKeySize = 32
+	// Prefix marks encrypted values stored in text columns
+	valuePrefix = "enc:v1:"
+)
+
+// ErrNoKey is returned when encrypted data is met but no key is configured
+var ErrNoKey = errors.New("encryption key is not configured")
+
+// GenerateDataKey creates random data key and returns it wrapped by primary master key
+func GenerateDataKey(ring *KeyRing) (key []byte, wrapped []byte, err error) {
+	if ring.Empty() {
+		return nil, nil, ErrNoKey
+	}
+	key = make([]byte, dataKeySize)
+	if _, err = rand.Read(key); err != nil {
+		return nil, nil, fmt.Errorf("could not generate data key: %v", err)
+	}
+	wrapped, err = ring.Wrap(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not wrap data key: %v", err)
+	}
+	return key, wrapped, nil
+}
+
+// UnwrapDataKey decrypts data key with master key from the ring
+func UnwrapDataKey(wrapped []byte, ring *KeyRing) ([]byte, error) {
+	key, err := ring.Unwrap(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("could not unwrap data key: %v", err)
+	}
+	return key, nil
+}
+
+// EncryptWithDataKey encrypts data with unwrapped data key
+func EncryptWithDataKey(data []byte, key []byte) ([]byte, error) {
+	return EncryptWithKey(data, key)
+}
+
+// DecryptWithDataKey decrypts data with unwrapped data key
+func DecryptWithDataKey(data []byte, key []byte) ([]byte, error) {
+	return DecryptWithKey(data, key)
+}
+
+// IsEncryptedValue reports whether value was produced by EncryptValue
+func IsEncryptedValue(value string) bool {
+	return strings.HasPrefix(value, valuePrefix)
+}
+
+// EncryptValue encrypts string to be stored in text column
+func EncryptValue(value string, key []byte) (string, error) {
+	data, err := EncryptWithDataKey([]byte(value), key)
+	if err != nil {
+		return "", err
+	}
+	return valuePrefix + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// DecryptValue decrypts value produced by EncryptValue, plain values are returned as is
+func DecryptValue(value string, key []byte) (string, error) {
+	if !IsEncryptedValue(value) {
+		return value, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, valuePrefix))
+	if err != nil {
+		return "", fmt.Errorf("could not decode value: %v", err)
+	}
+	plain, err := DecryptWithDataKey(data, key)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
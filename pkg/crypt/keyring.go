@@ -0,0 +1,128 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Wrapped key layout: wrapMagic | key id length | key id | EncryptWithKey output.
+// Keys wrapped before key ring have no magic and are unwrapped with the legacy key.
+var wrapMagic = []byte("TDKW")
+
+// ErrUnknownKey is returned when data is wrapped by a key missing in the ring
+var ErrUnknownKey = errors.New("unknown master key")
+
+// KeyRing holds master keys by id, new data keys are wrapped by the primary one
+type KeyRing struct {
+	keys    map[string][]byte
+	legacy  string
+	primary string
+}
+
+// NewKeyRing parses comma separated id:key pairs, primary must be one of ids.
+// Legacy key is the single master key used before key ring, it is only used for unwrapping.
+func NewKeyRing(spec string, primary string, legacy string) (*KeyRing, error) {
+	ring := &KeyRing{keys: make(map[string][]byte), legacy: legacy}
+	for i, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" || len(parts[0]) > 255 {
+			// Entry without colon is likely the key itself, so it is never echoed
+			return nil, fmt.Errorf("invalid master key entry %d, expected id:key", i+1)
+		}
+		if _, ok := ring.keys[parts[0]]; ok {
+			return nil, fmt.Errorf("duplicate master key id %q", parts[0])
+		}
+		ring.keys[parts[0]] = deriveRingKey(parts[0], parts[1])
+	}
+	if primary == "" && len(ring.keys) == 1 {
+		for id := range ring.keys {
+			primary = id
+		}
+	}
+	if len(ring.keys) > 0 {
+		if _, ok := ring.keys[primary]; !ok {
+			return nil, fmt.Errorf("primary master key %q is not in key ring", primary)
+		}
+		ring.primary = primary
+	}
+	return ring, nil
+}
+
+// Master keys are high entropy secrets, so fixed per-id salt is enough and lets us derive them once
+func deriveRingKey(id string, secret string) []byte {
+	return argon2.IDKey([]byte(secret), []byte("todo-service key ring "+id), argonTime, argonMemory, argonThreads, keySize)
+}
+
+// Empty reports whether ring can neither wrap nor unwrap keys
+func (k *KeyRing) Empty() bool {
+	return k == nil || (len(k.keys) == 0 && k.legacy == "")
+}
+
+// Primary returns id of the key used for wrapping, empty for the legacy key
+func (k *KeyRing) Primary() string {
+	return k.primary
+}
+
+// Wrap encrypts data key with the primary master key
+func (k *KeyRing) Wrap(data []byte) ([]byte, error) {
+	if k.Empty() {
+		return nil, ErrNoKey
+	}
+	if len(k.keys) == 0 {
+		return Encrypt(data, k.legacy)
+	}
+	sealed, err := EncryptWithKey(data, k.keys[k.primary])
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(wrapMagic)+1+len(k.primary)+len(sealed))
+	out = append(out, wrapMagic...)
+	out = append(out, byte(len(k.primary)))
+	out = append(out, k.primary...)
+	return append(out, sealed...), nil
+}
+
+// Unwrap decrypts data key with the master key it was wrapped by
+func (k *KeyRing) Unwrap(wrapped []byte) ([]byte, error) {
+	if k.Empty() {
+		return nil, ErrNoKey
+	}
+	id, sealed, ok := splitWrapped(wrapped)
+	if !ok {
+		if k.legacy == "" {
+			return nil, ErrUnknownKey
+		}
+		return Decrypt(wrapped, k.legacy)
+	}
+	key, found := k.keys[id]
+	if !found {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	return DecryptWithKey(sealed, key)
+}
+
+// WrappedKeyID returns id of the master key data key is wrapped by, empty for the legacy key
+func WrappedKeyID(wrapped []byte) string {
+	id, _, _ := splitWrapped(wrapped)
+	return id
+}
+
+func splitWrapped(wrapped []byte) (id string, sealed []byte, ok bool) {
+	if !bytes.HasPrefix(wrapped, wrapMagic) || len(wrapped) < len(wrapMagic)+1 {
+		return "", nil, false
+	}
+	n := int(wrapped[len(wrapMagic)])
+	rest := wrapped[len(wrapMagic)+1:]
+	if len(rest) < n {
+		return "", nil, false
+	}
+	return string(rest[:n]), rest[n:], true
+}
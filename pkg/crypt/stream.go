@@ -0,0 +1,320 @@
+package crypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Stream layout:
+//
+//	header | chunk size (uint32) | nonce prefix | chunk | ... | last chunk
+//
+// Header is the same as for Encrypt but starts with its own magic. Every chunk holds chunk size bytes
+// of plain text, except the last one which may be shorter or empty. Chunk nonce is the nonce prefix,
+// chunk counter (uint32) and a flag set for the last chunk, so reordered, dropped or truncated chunks
+// fail authentication. Header, chunk size and nonce prefix are authenticated with every chunk.
+const (
+	chunkSize       = 64 * 1024
+	noncePrefixSize = 7
+	maxChunkSize    = 16 * 1024 * 1024
+)
+
+var (
+	// ErrTruncated is returned when stream ends before its last chunk
+	ErrTruncated = errors.New("encrypted stream is truncated")
+	// ErrTooLong is returned when stream exceeds chunk counter
+	ErrTooLong = errors.New("encrypted stream is too long")
+)
+
+type streamWriter struct {
+	w       io.Writer
+	gcm     cipher.AEAD
+	ad      []byte
+	prefix  []byte
+	counter uint32
+	buf     []byte
+	out     []byte
+	closed  bool
+}
+
+// NewWriter returns writer encrypting data written to it into w with raw 32 bytes key.
+// Close must be called to write the last chunk, w itself is not closed.
+func NewWriter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	if len(key) != keySize {
+		return nil, ErrInvalidKey
+	}
+	return newStreamWriter(w, header{magic: streamMagic, algorithm: algAES256GCM, kdf: kdfNone}, key)
+}
+
+// NewPassphraseWriter is NewWriter with key derived from passphrase by Argon2id
+func NewPassphraseWriter(w io.Writer, passphrase string) (io.WriteCloser, error) {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	h := argonHeader(streamMagic, salt)
+	return newStreamWriter(w, h, deriveKey(passphrase, h))
+}
+
+func newStreamWriter(w io.Writer, h header, key []byte) (*streamWriter, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, noncePrefixSize)
+	if _, err = io.ReadFull(rand.Reader, prefix); err != nil {
+		return nil, err
+	}
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint32(size, chunkSize)
+	ad := append(append(h.marshal(), size...), prefix...)
+	if _, err = w.Write(ad); err != nil {
+		return nil, err
+	}
+	return &streamWriter{
+		w:      w,
+		gcm:    gcm,
+		ad:     ad,
+		prefix: prefix,
+		buf:    make([]byte, 0, chunkSize),
+		out:    make([]byte, 0, chunkSize+gcm.Overhead()),
+	}, nil
+}
+
+func chunkNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, noncePrefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+func (s *streamWriter) flush(last bool) error {
+	if s.counter == ^uint32(0) && !last {
+		return ErrTooLong
+	}
+	s.out = s.gcm.Seal(s.out[:0], chunkNonce(s.prefix, s.counter, last), s.buf, s.ad)
+	s.counter++
+	s.buf = s.buf[:0]
+	_, err := s.w.Write(s.out)
+	return err
+}
+
+func (s *streamWriter) Write(p []byte) (n int, err error) {
+	if s.closed {
+		return 0, errors.New("write to closed encrypted stream")
+	}
+	for len(p) > 0 {
+		// Full chunk is flushed only when more data arrives, so only an empty stream ends with an empty chunk
+		if len(s.buf) == chunkSize {
+			if err = s.flush(false); err != nil {
+				return n, err
+			}
+		}
+		c := copy(s.buf[len(s.buf):chunkSize], p)
+		s.buf = s.buf[:len(s.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return n, nil
+}
+
+func (s *streamWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.flush(true)
+}
+
+type streamReader struct {
+	r       *bufio.Reader
+	gcm     cipher.AEAD
+	ad      []byte
+	prefix  []byte
+	size    int
+	counter uint32
+	in      []byte
+	out     []byte
+	plain   []byte
+	done    bool
+}
+
+// NewReader returns reader decrypting stream produced by NewWriter
+func NewReader(r io.Reader, key []byte) (io.Reader, error) {
+	if len(key) != keySize {
+		return nil, ErrInvalidKey
+	}
+	br := bufio.NewReader(r)
+	h, raw, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	if h.kdf != kdfNone {
+		return nil, ErrUnsupported
+	}
+	return newStreamReader(br, raw, key)
+}
+
+// NewPassphraseReader returns reader decrypting stream produced by NewPassphraseWriter
+func NewPassphraseReader(r io.Reader, passphrase string) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	h, raw, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	if h.kdf != kdfArgon2id {
+		return nil, ErrUnsupported
+	}
+	return newStreamReader(br, raw, deriveKey(passphrase, h))
+}
+
+// IsStream reports whether data starts like a stream produced by NewWriter or NewPassphraseWriter
+func IsStream(data []byte) bool {
+	return bytes.HasPrefix(data, streamMagic)
+}
+
+// Read header from stream, it is read step by step since its length depends on kdf
+func readHeader(r io.Reader) (header, []byte, error) {
+	raw := make([]byte, len(streamMagic)+3)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return header{}, nil, ErrInvalidCiphertext
+	}
+	if !IsStream(raw) {
+		return header{}, nil, ErrInvalidCiphertext
+	}
+	if raw[len(raw)-1] == kdfArgon2id {
+		params := make([]byte, 10)
+		if _, err := io.ReadFull(r, params); err != nil {
+			return header{}, nil, ErrInvalidCiphertext
+		}
+		salt := make([]byte, params[9])
+		if _, err := io.ReadFull(r, salt); err != nil {
+			return header{}, nil, ErrInvalidCiphertext
+		}
+		raw = append(append(raw, params...), salt...)
+	}
+	h, _, err := parseHeader(raw, streamMagic)
+	if err != nil {
+		return header{}, nil, err
+	}
+	return h, raw, nil
+}
+
+func newStreamReader(r *bufio.Reader, raw []byte, key []byte) (*streamReader, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	rest := make([]byte, 4+noncePrefixSize)
+	if _, err = io.ReadFull(r, rest); err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+	size := int(binary.BigEndian.Uint32(rest))
+	if size == 0 || size > maxChunkSize {
+		return nil, ErrInvalidCiphertext
+	}
+	ad := append(append([]byte{}, raw...), rest...)
+	return &streamReader{
+		r:      r,
+		gcm:    gcm,
+		ad:     ad,
+		prefix: rest[4:],
+		size:   size,
+		in:     make([]byte, size+gcm.Overhead()),
+		out:    make([]byte, 0, size),
+	}, nil
+}
+
+func (s *streamReader) next() error {
+	n, err := io.ReadFull(s.r, s.in)
+	last := false
+	switch err {
+	case nil:
+		// Full chunk is the last one only if nothing follows it
+		if _, err = s.r.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	case io.ErrUnexpectedEOF:
+		last = true
+	case io.EOF:
+		return ErrTruncated
+	default:
+		return err
+	}
+	if n < s.gcm.Overhead() {
+		return ErrTruncated
+	}
+	plain, err := s.gcm.Open(s.out[:0], chunkNonce(s.prefix, s.counter, last), s.in[:n], s.ad)
+	if err != nil {
+		if last {
+			// Stream cut at a chunk boundary leaves a valid non-last chunk at the end
+			if _, openErr := s.gcm.Open(nil, chunkNonce(s.prefix, s.counter, false), s.in[:n], s.ad); openErr == nil {
+				return ErrTruncated
+			}
+		}
+		return err
+	}
+	if s.counter == ^uint32(0) && !last {
+		return ErrTooLong
+	}
+	s.counter++
+	s.plain = plain
+	s.done = last
+	return nil
+}
+
+func (s *streamReader) Read(p []byte) (int, error) {
+	for len(s.plain) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		if err := s.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.plain)
+	s.plain = s.plain[n:]
+	return n, nil
+}
+
+// EncryptStream copies src into dst encrypting it with key derived from passphrase
+func EncryptStream(dst io.Writer, src io.Reader, passphrase string) error {
+	w, err := NewPassphraseWriter(dst, passphrase)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(w, src); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// DecryptStream copies src into dst decrypting it with key derived from passphrase
+func DecryptStream(dst io.Writer, src io.Reader, passphrase string) error {
+	r, err := NewPassphraseReader(src, passphrase)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, r)
+	return err
+}
+
+// StreamSize returns size of stream produced by NewWriter for plain text of the given size
+func StreamSize(plainSize int64) int64 {
+	chunks := (plainSize + chunkSize - 1) / chunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	headerSize := int64(len(streamMagic) + 3 + 4 + noncePrefixSize)
+	return headerSize + plainSize + chunks*16
+}
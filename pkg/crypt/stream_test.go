@@ -0,0 +1,178 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// Size of stream header of raw key, chunk size and nonce prefix included
+const testStreamHeaderSize = 4 + 3 + 4 + noncePrefixSize
+
+func testData(t *testing.T, size int) []byte {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(rand.Reader, data); err != nil {
+		t.Fatalf("could not generate data: %v", err)
+	}
+	return data
+}
+
+func encryptStream(t *testing.T, data []byte, key []byte) []byte {
+	buf := &bytes.Buffer{}
+	w, err := NewWriter(buf, key)
+	if err != nil {
+		t.Fatalf("could not create writer: %v", err)
+	}
+	if _, err = w.Write(data); err != nil {
+		t.Fatalf("could not write: %v", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("could not close writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func decryptStream(data []byte, key []byte) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(data), key)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	key := testKey(t)
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 5} {
+		data := testData(t, size)
+		encrypted := encryptStream(t, data, key)
+		if !IsStream(encrypted) {
+			t.Errorf("stream of %v bytes does not start with magic", size)
+		}
+		plain, err := decryptStream(encrypted, key)
+		if err != nil {
+			t.Fatalf("could not decrypt stream of %v bytes: %v", size, err)
+		}
+		if !bytes.Equal(plain, data) {
+			t.Errorf("decrypted %v bytes do not match %v bytes", len(plain), size)
+		}
+		if _, err = decryptStream(encrypted, testKey(t)); err == nil {
+			t.Errorf("decrypted stream of %v bytes with wrong key", size)
+		}
+	}
+}
+
+func TestPassphraseStreamRoundTrip(t *testing.T) {
+	data := testData(t, chunkSize+1)
+	encrypted := &bytes.Buffer{}
+	if err := EncryptStream(encrypted, bytes.NewReader(data), testPassphrase); err != nil {
+		t.Fatalf("could not encrypt: %v", err)
+	}
+	plain := &bytes.Buffer{}
+	if err := DecryptStream(plain, bytes.NewReader(encrypted.Bytes()), testPassphrase); err != nil {
+		t.Fatalf("could not decrypt: %v", err)
+	}
+	if !bytes.Equal(plain.Bytes(), data) {
+		t.Errorf("decrypted %v bytes do not match %v bytes", plain.Len(), len(data))
+	}
+	if err := DecryptStream(ioutil.Discard, bytes.NewReader(encrypted.Bytes()), "wrong"); err == nil {
+		t.Errorf("decrypted with wrong passphrase")
+	}
+}
+
+func TestStreamSize(t *testing.T) {
+	key := testKey(t)
+	for _, size := range []int{0, chunkSize, chunkSize + 1} {
+		encrypted := encryptStream(t, testData(t, size), key)
+		if got := StreamSize(int64(size)); got != int64(len(encrypted)) {
+			t.Errorf("StreamSize(%v) = %v, stream is %v bytes", size, got, len(encrypted))
+		}
+	}
+}
+
+func TestStreamTruncated(t *testing.T) {
+	key := testKey(t)
+	encrypted := encryptStream(t, testData(t, 2*chunkSize+1), key)
+	sealedChunk := chunkSize + 16
+	tests := []struct {
+		name string
+		size int
+	}{
+		{"header only", testStreamHeaderSize},
+		{"at chunk boundary", testStreamHeaderSize + sealedChunk},
+		{"at second chunk boundary", testStreamHeaderSize + 2*sealedChunk},
+		{"within chunk", testStreamHeaderSize + sealedChunk/2},
+		{"last byte", len(encrypted) - 1},
+		{"within header", testStreamHeaderSize - 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := decryptStream(encrypted[:test.size], key); err == nil {
+				t.Errorf("decrypted truncated stream")
+			}
+		})
+	}
+	// Dropped last chunk is reported as truncation
+	if _, err := decryptStream(encrypted[:testStreamHeaderSize+sealedChunk], key); err != ErrTruncated {
+		t.Errorf("got %v, want %v", err, ErrTruncated)
+	}
+}
+
+func TestStreamSwappedChunks(t *testing.T) {
+	key := testKey(t)
+	encrypted := encryptStream(t, testData(t, 2*chunkSize+1), key)
+	sealedChunk := chunkSize + 16
+	first := encrypted[testStreamHeaderSize : testStreamHeaderSize+sealedChunk]
+	second := encrypted[testStreamHeaderSize+sealedChunk : testStreamHeaderSize+2*sealedChunk]
+
+	swapped := append([]byte{}, encrypted[:testStreamHeaderSize]...)
+	swapped = append(append(swapped, second...), first...)
+	swapped = append(swapped, encrypted[testStreamHeaderSize+2*sealedChunk:]...)
+	if _, err := decryptStream(swapped, key); err == nil {
+		t.Errorf("decrypted stream with swapped chunks")
+	}
+
+	// Chunk of another stream fails even at its own position
+	other := encryptStream(t, testData(t, 2*chunkSize+1), key)
+	mixed := append([]byte{}, encrypted...)
+	copy(mixed[testStreamHeaderSize:], other[testStreamHeaderSize:testStreamHeaderSize+sealedChunk])
+	if _, err := decryptStream(mixed, key); err == nil {
+		t.Errorf("decrypted stream with chunk of another stream")
+	}
+}
+
+func TestStreamLastChunkFlag(t *testing.T) {
+	key := testKey(t)
+	buf := &bytes.Buffer{}
+	s, err := newStreamWriter(buf, header{magic: streamMagic, algorithm: algAES256GCM, kdf: kdfNone}, key)
+	if err != nil {
+		t.Fatalf("could not create writer: %v", err)
+	}
+	// Full chunk flagged as the last one and followed by another chunk
+	s.buf = append(s.buf[:0], testData(t, chunkSize)...)
+	if err = s.flush(true); err != nil {
+		t.Fatalf("could not write chunk: %v", err)
+	}
+	s.buf = append(s.buf[:0], testData(t, 10)...)
+	if err = s.flush(true); err != nil {
+		t.Fatalf("could not write chunk: %v", err)
+	}
+	if _, err = decryptStream(buf.Bytes(), key); err == nil {
+		t.Errorf("decrypted stream with data after last chunk")
+	}
+
+	// Stream ending with a chunk which is not flagged as the last one
+	buf.Reset()
+	s, err = newStreamWriter(buf, header{magic: streamMagic, algorithm: algAES256GCM, kdf: kdfNone}, key)
+	if err != nil {
+		t.Fatalf("could not create writer: %v", err)
+	}
+	s.buf = append(s.buf[:0], testData(t, 10)...)
+	if err = s.flush(false); err != nil {
+		t.Fatalf("could not write chunk: %v", err)
+	}
+	if _, err = decryptStream(buf.Bytes(), key); err != ErrTruncated {
+		t.Errorf("got %v, want %v", err, ErrTruncated)
+	}
+}
@@ -0,0 +1,148 @@
+package events
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/Kolya59/todo-service/pkg/postgres"
+)
+
+// Types of events
+const (
+	TaskCreated    = "task.created"
+	TaskUpdated    = "task.updated"
+	TaskDeleted    = "task.deleted"
+	CommentCreated = "comment.created"
+	CommentDeleted = "comment.deleted"
+)
+
+// Event tells users who see the task that it has changed, clients fetch the task themselves
+type Event struct {
+	ID          string  `json:"id"`
+	Type        string  `json:"type"`
+	TaskUUID    string  `json:"task_uuid"`
+	ProjectUUID *string `json:"project_uuid,omitempty"`
+	CommentUUID string  `json:"comment_uuid,omitempty"`
+	// Version of the task after the change, comment events carry the current one
+	Version int64 `json:"version,omitempty"`
+}
+
+// Buffer of subscriber, events for a subscriber which does not keep up are dropped
+const subscriberBuffer = 64
+
+// Users per database notification, so that payload stays below the limit of Postgres
+const usersPerNotification = 100
+
+// Event with its audience sent to other instances
+type envelope struct {
+	Origin string   `json:"origin"`
+	Event  Event    `json:"event"`
+	Users  []string `json:"users"`
+}
+
+// Hub delivers events to subscribers of this instance and, through the database, of other instances
+type Hub struct {
+	origin      string
+	mu          sync.Mutex
+	closed      bool
+	subscribers map[string]map[chan Event]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{origin: uuid.NewV4().String(), subscribers: make(map[string]map[chan Event]struct{})}
+}
+
+// Subscribe user to events, returned func cancels the subscription. Channel is closed with the hub.
+func (h *Hub) Subscribe(userUUID string) (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	if h.subscribers[userUUID] == nil {
+		h.subscribers[userUUID] = make(map[chan Event]struct{})
+	}
+	h.subscribers[userUUID][ch] = struct{}{}
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subscribers[userUUID][ch]; !ok {
+			return
+		}
+		delete(h.subscribers[userUUID], ch)
+		if len(h.subscribers[userUUID]) == 0 {
+			delete(h.subscribers, userUUID)
+		}
+		close(ch)
+	}
+}
+
+// Publish event to users, event gets its id here and is returned with it
+func (h *Hub) Publish(e Event, users []string) Event {
+	e.ID = uuid.NewV4().String()
+	h.deliver(e, users)
+	for start := 0; start < len(users); start += usersPerNotification {
+		end := start + usersPerNotification
+		if end > len(users) {
+			end = len(users)
+		}
+		payload, err := json.Marshal(envelope{Origin: h.origin, Event: e, Users: users[start:end]})
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to marshal event")
+			return e
+		}
+		if err = postgres.NotifyEvent(string(payload)); err != nil {
+			log.Error().Err(err).Msgf("Failed to send event %v to other instances", e.ID)
+		}
+	}
+	return e
+}
+
+func (h *Hub) deliver(e Event, users []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, user := range users {
+		for ch := range h.subscribers[user] {
+			select {
+			case ch <- e:
+			default:
+				log.Warn().Msgf("Event %v is dropped for slow subscriber of user %v", e.ID, user)
+			}
+		}
+	}
+}
+
+// Listen delivers events published by other instances until done is closed
+func (h *Hub) Listen(done <-chan struct{}) {
+	err := postgres.ListenEvents(done, func(payload string) {
+		env := envelope{}
+		if err := json.Unmarshal([]byte(payload), &env); err != nil {
+			log.Error().Err(err).Msg("Failed to unmarshal event")
+			return
+		}
+		if env.Origin != h.origin {
+			h.deliver(env.Event, env.Users)
+		}
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to listen events of other instances")
+	}
+}
+
+// Close subscriptions, so that streams end before the server shuts down
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for user, channels := range h.subscribers {
+		for ch := range channels {
+			close(ch)
+		}
+		delete(h.subscribers, user)
+	}
+}
@@ -0,0 +1,11 @@
+package notifier
+
+import "github.com/rs/zerolog/log"
+
+// LogNotifier writes messages to the service log
+type LogNotifier struct{}
+
+func (n *LogNotifier) Notify(msg Message) error {
+	log.Info().Str("ID", msg.ID).Str("TO", msg.To).Str("TASK", msg.TaskUUID).Msg(msg.Subject)
+	return nil
+}
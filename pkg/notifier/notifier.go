@@ -0,0 +1,46 @@
+package notifier
+
+import (
+	"fmt"
+	"time"
+)
+
+// Message is a single notification for a user
+type Message struct {
+	ID       string    `json:"id"`
+	To       string    `json:"to"`
+	Subject  string    `json:"subject"`
+	Body     string    `json:"body"`
+	TaskUUID string    `json:"task_uuid"`
+	FireAt   time.Time `json:"fire_at"`
+}
+
+// Notifier delivers messages, ID is stable across retries so receivers can deduplicate
+type Notifier interface {
+	Notify(msg Message) error
+}
+
+// New creates notifier by kind: log, file, webhook or smtp
+func New(kind string, target string, smtpFrom string) (Notifier, error) {
+	switch kind {
+	case "", "log":
+		return &LogNotifier{}, nil
+	case "file":
+		if target == "" {
+			return nil, fmt.Errorf("file notifier requires a path")
+		}
+		return &FileNotifier{Path: target}, nil
+	case "webhook":
+		if target == "" {
+			return nil, fmt.Errorf("webhook notifier requires an url")
+		}
+		return &WebhookNotifier{URL: target, Client: defaultClient}, nil
+	case "smtp":
+		if target == "" || smtpFrom == "" {
+			return nil, fmt.Errorf("smtp notifier requires an address and a sender")
+		}
+		return &SMTPNotifier{Addr: target, From: smtpFrom}, nil
+	default:
+		return nil, fmt.Errorf("unknown notifier %q", kind)
+	}
+}
@@ -0,0 +1,26 @@
+package notifier
+
+import (
+	"fmt"
+	"net/smtp"
+	"strings"
+)
+
+// SMTPNotifier sends messages by email, recipients are user logins
+type SMTPNotifier struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+func (n *SMTPNotifier) Notify(msg Message) error {
+	if strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(msg.Subject, "\r\n") {
+		return fmt.Errorf("invalid message header")
+	}
+	body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nMessage-ID: <%s@todo-service>\r\n\r\n%s\r\n",
+		n.From, msg.To, msg.Subject, msg.ID, msg.Body)
+	if err := smtp.SendMail(n.Addr, n.Auth, n.From, []string{msg.To}, []byte(body)); err != nil {
+		return fmt.Errorf("could not send email: %v", err)
+	}
+	return nil
+}
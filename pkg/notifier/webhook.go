@@ -0,0 +1,39 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var defaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// WebhookNotifier posts messages as JSON
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+func (n *WebhookNotifier) Notify(msg Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("could not marshal message: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, n.URL, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("could not create webhook request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", msg.ID)
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not send webhook: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %v", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Kolya59/todo-service/models"
+)
+
+// Logins of assignees of task as array column, used in task selects aliased as t
+const assigneesColumn = "ARRAY(SELECT au.login FROM public.task_assignees a JOIN public.users au ON au.uuid = a.user_uuid " +
+	"WHERE a.task_uuid = t.uuid ORDER BY au.login)"
+
+// Tasks are assigned by their editors to users who see them. Assignee may also unassign itself.
+var (
+	checkAssigneeQuery = "SELECT 1 FROM public.tasks t WHERE t.uuid = $1 AND " + readAccess("$2")
+	assignTaskQuery    = "INSERT INTO public.task_assignees(task_uuid, user_uuid) VALUES ($1, $2) ON CONFLICT DO NOTHING"
+	unassignTaskQuery  = "DELETE FROM public.task_assignees a USING public.tasks t WHERE t.uuid = a.task_uuid AND " +
+		"a.task_uuid = $1 AND a.user_uuid = $3 AND (a.user_uuid = $2 OR " + writeAccess("$2") + ")"
+)
+
+// ErrNotAssignable is returned for assignee who does not see the task
+var ErrNotAssignable = errors.New("task is not visible to the user")
+
+// Assign task to user by login and notify the user, assigning twice is not an error
+func AssignTask(userUUID string, taskUUID string, login string) (err error) {
+	if _, err = taskOwner(db, taskUUID, userUUID); err != nil {
+		return err
+	}
+	var assigneeUUID string
+	if err = db.QueryRow(selectUserUUIDQuery, login).Scan(&assigneeUUID); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrUserNotFound
+		}
+		return fmt.Errorf("could not select user: %v", err)
+	}
+	var found int
+	if err = db.QueryRow(checkAssigneeQuery, taskUUID, assigneeUUID).Scan(&found); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotAssignable
+		}
+		return fmt.Errorf("could not select task: %v", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer func() {
+		if err != nil {
+			if err := tx.Rollback(); err != nil {
+				log.Error().Err(err).Msg("Could not rollback transaction")
+			}
+		}
+	}()
+
+	res, err := tx.Exec(assignTaskQuery, taskUUID, assigneeUUID)
+	if err != nil {
+		return fmt.Errorf("could not assign task: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		notification := models.Notification{UserUUID: assigneeUUID, Kind: models.NotificationAssigned, TaskUUID: &taskUUID}
+		if err = insertNotification(tx, notification, userUUID, true); err != nil {
+			return err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit assignee: %v", err)
+	}
+	log.Info().Msgf("Task with uuid = %s has been assigned to %s", taskUUID, login)
+	return nil
+}
+
+// Unassign user by login from task
+func UnassignTask(userUUID string, taskUUID string, login string) (err error) {
+	var assigneeUUID string
+	if err = db.QueryRow(selectUserUUIDQuery, login).Scan(&assigneeUUID); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return fmt.Errorf("could not select user: %v", err)
+	}
+	res, err := db.Exec(unassignTaskQuery, taskUUID, userUUID, assigneeUUID)
+	if err != nil {
+		return fmt.Errorf("could not unassign task: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	log.Info().Msgf("Task with uuid = %s has been unassigned from %s", taskUUID, login)
+	return nil
+}
@@ -0,0 +1,129 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/Kolya59/todo-service/models"
+)
+
+// Attachments are read by those who see the task, added by those who may edit it and removed by those who may delete it
+var (
+	insertAttachmentQuery = "INSERT INTO public.attachments(uuid, task_uuid, name, content_type, size, created_at, encrypted) " +
+		"SELECT $1, t.uuid, $4, $5, $6, $7, $8 FROM public.tasks t WHERE t.uuid = $2 AND " + writeAccess("$3")
+	selectAttachmentsQuery = "SELECT a.uuid, a.name, a.content_type, a.size, a.created_at FROM public.attachments a " +
+		"JOIN public.tasks t ON t.uuid = a.task_uuid WHERE a.task_uuid = $1 AND " + readAccess("$2") + " ORDER BY a.created_at"
+	selectAttachmentQuery = "SELECT a.name, a.content_type, a.size, a.created_at, a.encrypted FROM public.attachments a " +
+		"JOIN public.tasks t ON t.uuid = a.task_uuid WHERE a.uuid = $1 AND a.task_uuid = $2 AND " + readAccess("$3")
+	deleteAttachmentQuery = "DELETE FROM public.attachments a USING public.tasks t " +
+		"WHERE t.uuid = a.task_uuid AND a.uuid = $1 AND a.task_uuid = $2 AND " + deleteAccess("$3")
+)
+
+// Insert attachment metadata, user must be allowed to edit the task
+func InsertAttachment(userUUID string, attachment models.Attachment) (res models.Attachment, err error) {
+	insertAttachment, err := db.Prepare(insertAttachmentQuery)
+	if err != nil {
+		return models.Attachment{}, fmt.Errorf("could not prepare insert attachment query: %v", err)
+	}
+	defer func() {
+		if err := insertAttachment.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close database connection")
+		}
+	}()
+	if attachment.UUID == "" {
+		attachment.UUID = uuid.NewV4().String()
+	}
+	attachment.CreatedAt = time.Now().UTC()
+	result, err := insertAttachment.Exec(attachment.UUID, attachment.TaskUUID, userUUID,
+		attachment.Name, attachment.ContentType, attachment.Size, attachment.CreatedAt, attachment.Encrypted)
+	if err != nil {
+		return models.Attachment{}, fmt.Errorf("could not insert attachment into database: %v", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return models.Attachment{}, taskMissing(db, attachment.TaskUUID, userUUID)
+	}
+	log.Info().Msgf("Attachment with uuid = %s is added in database", attachment.UUID)
+	return attachment, nil
+}
+
+// Select attachments of the task
+func SelectAttachments(userUUID string, taskUUID string) (attachments []models.Attachment, err error) {
+	selectAttachments, err := db.Prepare(selectAttachmentsQuery)
+	if err != nil {
+		return nil, fmt.Errorf("could not prepare select attachments query: %v", err)
+	}
+	defer func() {
+		if err := selectAttachments.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close database connection")
+		}
+	}()
+	rows, err := selectAttachments.Query(taskUUID, userUUID)
+	if err != nil {
+		return nil, fmt.Errorf("could not select attachments: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		attachment := models.Attachment{TaskUUID: taskUUID}
+		err = rows.Scan(&attachment.UUID, &attachment.Name, &attachment.ContentType, &attachment.Size, &attachment.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("could not read query: %v", err)
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, nil
+}
+
+// Select attachment metadata
+func SelectAttachment(userUUID string, taskUUID string, attachmentUUID string) (attachment models.Attachment, err error) {
+	selectAttachment, err := db.Prepare(selectAttachmentQuery)
+	if err != nil {
+		return models.Attachment{}, fmt.Errorf("could not prepare select attachment query: %v", err)
+	}
+	defer func() {
+		if err := selectAttachment.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close database connection")
+		}
+	}()
+	attachment = models.Attachment{UUID: attachmentUUID, TaskUUID: taskUUID}
+	err = selectAttachment.QueryRow(attachmentUUID, taskUUID, userUUID).Scan(
+		&attachment.Name,
+		&attachment.ContentType,
+		&attachment.Size,
+		&attachment.CreatedAt,
+		&attachment.Encrypted,
+	)
+	if err == sql.ErrNoRows {
+		return models.Attachment{}, ErrNotFound
+	}
+	if err != nil {
+		return models.Attachment{}, fmt.Errorf("could not select attachment: %v", err)
+	}
+	return attachment, nil
+}
+
+// Delete attachment metadata
+func DeleteAttachment(userUUID string, taskUUID string, attachmentUUID string) (err error) {
+	deleteAttachment, err := db.Prepare(deleteAttachmentQuery)
+	if err != nil {
+		return fmt.Errorf("could not prepare delete attachment query: %v", err)
+	}
+	defer func() {
+		if err := deleteAttachment.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close database connection")
+		}
+	}()
+	result, err := deleteAttachment.Exec(attachmentUUID, taskUUID, userUUID)
+	if err != nil {
+		return fmt.Errorf("could not delete attachment: %v", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	log.Info().Msgf("Attachment with uuid = %s has been deleted", attachmentUUID)
+	return nil
+}
@@ -0,0 +1,502 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	exportUsersQuery = "SELECT uuid, login, password, salt, data_key, data_key_id FROM public.users ORDER BY uuid"
+	exportTasksQuery = "SELECT t.uuid, t.value, t.author_uuid, t.is_resolved, t.due_date, t.created_at, " + labelsColumn +
+		", t.project_uuid, t.priority FROM public.tasks t ORDER BY t.uuid"
+	exportRemindersQuery     = "SELECT uuid, task_uuid, user_uuid, remind_at, offset_seconds, sent_at FROM public.reminders ORDER BY uuid"
+	exportAttachmentsQuery   = "SELECT uuid, task_uuid, name, content_type, size, created_at, encrypted FROM public.attachments ORDER BY uuid"
+	exportCommentsQuery      = "SELECT uuid, task_uuid, author_uuid, value, created_at FROM public.comments ORDER BY uuid"
+	exportMentionsQuery      = "SELECT comment_uuid, user_uuid FROM public.comment_mentions ORDER BY comment_uuid, user_uuid"
+	exportNotificationsQuery = "SELECT uuid, user_uuid, kind, actor_uuid, task_uuid, comment_uuid, project_uuid, created_at, delivered_at, read_at " +
+		"FROM public.notifications ORDER BY uuid"
+	exportProjectsQuery    = "SELECT uuid, owner_uuid, name, created_at, workspace_uuid FROM public.projects ORDER BY uuid"
+	exportSmartListsQuery  = "SELECT uuid, user_uuid, name, query, pinned, created_at FROM public.smart_lists ORDER BY uuid"
+	exportSharesQuery      = "SELECT uuid, task_uuid, project_uuid, user_uuid, role, created_at FROM public.shares ORDER BY uuid"
+	exportAssigneesQuery   = "SELECT task_uuid, user_uuid, assigned_at FROM public.task_assignees ORDER BY task_uuid, user_uuid"
+	exportWorkspacesQuery  = "SELECT uuid, name, created_at FROM public.workspaces ORDER BY uuid"
+	exportMembersQuery     = "SELECT workspace_uuid, user_uuid, role, created_at FROM public.workspace_members ORDER BY workspace_uuid, user_uuid"
+	exportInvitationsQuery = "SELECT uuid, workspace_uuid, user_uuid, inviter_uuid, role, created_at FROM public.workspace_invitations ORDER BY uuid"
+	// Deliveries are not exported, restored webhooks start with an empty log
+	exportWebhooksQuery = "SELECT uuid, user_uuid, url, secret, events, created_at FROM public.webhooks ORDER BY uuid"
+	exportCapturesQuery = "SELECT uuid, user_uuid, name, token_hash, created_at, last_used_at FROM public.capture_tokens ORDER BY uuid"
+
+	importUserQuery = "INSERT INTO public.users(uuid, login, password, salt, data_key, data_key_id) " +
+		"VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT DO NOTHING"
+	importTaskQuery = "INSERT INTO public.tasks(uuid, value, author_uuid, is_resolved, due_date, created_at, project_uuid, priority) " +
+		"VALUES ($1, $2, $3, $4, $5, COALESCE($6, now()), $7, $8) ON CONFLICT DO NOTHING"
+	importReminderQuery = "INSERT INTO public.reminders(uuid, task_uuid, user_uuid, remind_at, offset_seconds, sent_at) " +
+		"VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT DO NOTHING"
+	importAttachmentQuery = "INSERT INTO public.attachments(uuid, task_uuid, name, content_type, size, created_at, encrypted) " +
+		"VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT DO NOTHING"
+	importCommentQuery = "INSERT INTO public.comments(uuid, task_uuid, author_uuid, value, created_at) " +
+		"VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING"
+	importMentionQuery = "INSERT INTO public.comment_mentions(comment_uuid, user_uuid) " +
+		"VALUES ($1, $2) ON CONFLICT DO NOTHING"
+	// Restored notifications are not delivered again
+	importNotificationQuery = "INSERT INTO public.notifications(uuid, user_uuid, kind, actor_uuid, task_uuid, comment_uuid, project_uuid, created_at, delivered_at, read_at) " +
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, COALESCE($9, now()), $10) ON CONFLICT DO NOTHING"
+	importProjectQuery = "INSERT INTO public.projects(uuid, owner_uuid, name, created_at, workspace_uuid) " +
+		"VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING"
+	importSmartListQuery = "INSERT INTO public.smart_lists(uuid, user_uuid, name, query, pinned, created_at) " +
+		"VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT DO NOTHING"
+	importShareQuery = "INSERT INTO public.shares(uuid, task_uuid, project_uuid, user_uuid, role, created_at) " +
+		"VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT DO NOTHING"
+	importAssigneeQuery = "INSERT INTO public.task_assignees(task_uuid, user_uuid, assigned_at) " +
+		"VALUES ($1, $2, $3) ON CONFLICT DO NOTHING"
+	importWorkspaceQuery = "INSERT INTO public.workspaces(uuid, name, created_at) " +
+		"VALUES ($1, $2, $3) ON CONFLICT DO NOTHING"
+	importMemberQuery = "INSERT INTO public.workspace_members(workspace_uuid, user_uuid, role, created_at) " +
+		"VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING"
+	importInvitationQuery = "INSERT INTO public.workspace_invitations(uuid, workspace_uuid, user_uuid, inviter_uuid, role, created_at) " +
+		"VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT DO NOTHING"
+	importWebhookQuery = "INSERT INTO public.webhooks(uuid, user_uuid, url, secret, events, created_at) " +
+		"VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT DO NOTHING"
+	importCaptureQuery = "INSERT INTO public.capture_tokens(uuid, user_uuid, name, token_hash, created_at, last_used_at) " +
+		"VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT DO NOTHING"
+)
+
+// Rows are dumped as stored, encrypted values and wrapped data keys stay encrypted
+
+type UserRecord struct {
+	UUID      string  `json:"uuid"`
+	Login     string  `json:"login"`
+	Password  []byte  `json:"password"`
+	Salt      []byte  `json:"salt"`
+	DataKey   []byte  `json:"data_key,omitempty"`
+	DataKeyID *string `json:"data_key_id,omitempty"`
+}
+
+type TaskRecord struct {
+	UUID       string     `json:"uuid"`
+	Value      string     `json:"value"`
+	AuthorUUID string     `json:"author_uuid"`
+	IsResolved bool       `json:"is_resolved"`
+	DueDate    *time.Time `json:"due_date,omitempty"`
+	// Absent in backups written before tasks were ordered by creation
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+	Labels      []string   `json:"labels,omitempty"`
+	ProjectUUID *string    `json:"project_uuid,omitempty"`
+	Priority    *string    `json:"priority,omitempty"`
+}
+
+type ReminderRecord struct {
+	UUID     string     `json:"uuid"`
+	TaskUUID string     `json:"task_uuid"`
+	UserUUID string     `json:"user_uuid"`
+	RemindAt *time.Time `json:"remind_at,omitempty"`
+	Offset   *int64     `json:"offset,omitempty"`
+	SentAt   *time.Time `json:"sent_at,omitempty"`
+}
+
+type AttachmentRecord struct {
+	UUID        string    `json:"uuid"`
+	TaskUUID    string    `json:"task_uuid"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"created_at"`
+	Encrypted   bool      `json:"encrypted"`
+}
+
+type CommentRecord struct {
+	UUID       string    `json:"uuid"`
+	TaskUUID   string    `json:"task_uuid"`
+	AuthorUUID string    `json:"author_uuid"`
+	Value      string    `json:"value"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type MentionRecord struct {
+	CommentUUID string `json:"comment_uuid"`
+	UserUUID    string `json:"user_uuid"`
+}
+
+type NotificationRecord struct {
+	UUID        string     `json:"uuid"`
+	UserUUID    string     `json:"user_uuid"`
+	Kind        string     `json:"kind"`
+	ActorUUID   *string    `json:"actor_uuid,omitempty"`
+	TaskUUID    *string    `json:"task_uuid,omitempty"`
+	CommentUUID *string    `json:"comment_uuid,omitempty"`
+	ProjectUUID *string    `json:"project_uuid,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	ReadAt      *time.Time `json:"read_at,omitempty"`
+}
+
+type ProjectRecord struct {
+	UUID          string    `json:"uuid"`
+	OwnerUUID     string    `json:"owner_uuid"`
+	Name          string    `json:"name"`
+	CreatedAt     time.Time `json:"created_at"`
+	WorkspaceUUID *string   `json:"workspace_uuid,omitempty"`
+}
+
+type SmartListRecord struct {
+	UUID      string    `json:"uuid"`
+	UserUUID  string    `json:"user_uuid"`
+	Name      string    `json:"name"`
+	Query     string    `json:"query"`
+	Pinned    bool      `json:"pinned"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ShareRecord struct {
+	UUID        string    `json:"uuid"`
+	TaskUUID    *string   `json:"task_uuid,omitempty"`
+	ProjectUUID *string   `json:"project_uuid,omitempty"`
+	UserUUID    string    `json:"user_uuid"`
+	Role        string    `json:"role"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type AssigneeRecord struct {
+	TaskUUID   string    `json:"task_uuid"`
+	UserUUID   string    `json:"user_uuid"`
+	AssignedAt time.Time `json:"assigned_at"`
+}
+
+type WorkspaceRecord struct {
+	UUID      string    `json:"uuid"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type MemberRecord struct {
+	WorkspaceUUID string    `json:"workspace_uuid"`
+	UserUUID      string    `json:"user_uuid"`
+	Role          string    `json:"role"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type InvitationRecord struct {
+	UUID          string    `json:"uuid"`
+	WorkspaceUUID string    `json:"workspace_uuid"`
+	UserUUID      string    `json:"user_uuid"`
+	InviterUUID   string    `json:"inviter_uuid"`
+	Role          string    `json:"role"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type WebhookRecord struct {
+	UUID      string    `json:"uuid"`
+	UserUUID  string    `json:"user_uuid"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CaptureRecord struct {
+	UUID       string     `json:"uuid"`
+	UserUUID   string     `json:"user_uuid"`
+	Name       string     `json:"name"`
+	TokenHash  []byte     `json:"token_hash"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+func export(query string, scan func(rows *sql.Rows) error) error {
+	rows, err := db.Query(query)
+	if err != nil {
+		return fmt.Errorf("could not export rows: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err = scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Export all users, fn is called for every row
+func ExportUsers(fn func(UserRecord) error) error {
+	return export(exportUsersQuery, func(rows *sql.Rows) error {
+		r := UserRecord{}
+		if err := rows.Scan(&r.UUID, &r.Login, &r.Password, &r.Salt, &r.DataKey, &r.DataKeyID); err != nil {
+			return fmt.Errorf("could not read query: %v", err)
+		}
+		return fn(r)
+	})
+}
+
+// Export all tasks, fn is called for every row
+func ExportTasks(fn func(TaskRecord) error) error {
+	return export(exportTasksQuery, func(rows *sql.Rows) error {
+		r := TaskRecord{}
+		if err := rows.Scan(&r.UUID, &r.Value, &r.AuthorUUID, &r.IsResolved, &r.DueDate, &r.CreatedAt, pq.Array(&r.Labels), &r.ProjectUUID, &r.Priority); err != nil {
+			return fmt.Errorf("could not read query: %v", err)
+		}
+		return fn(r)
+	})
+}
+
+// Export all reminders, fn is called for every row
+func ExportReminders(fn func(ReminderRecord) error) error {
+	return export(exportRemindersQuery, func(rows *sql.Rows) error {
+		r := ReminderRecord{}
+		if err := rows.Scan(&r.UUID, &r.TaskUUID, &r.UserUUID, &r.RemindAt, &r.Offset, &r.SentAt); err != nil {
+			return fmt.Errorf("could not read query: %v", err)
+		}
+		return fn(r)
+	})
+}
+
+// Export all attachments metadata, fn is called for every row
+func ExportAttachments(fn func(AttachmentRecord) error) error {
+	return export(exportAttachmentsQuery, func(rows *sql.Rows) error {
+		r := AttachmentRecord{}
+		if err := rows.Scan(&r.UUID, &r.TaskUUID, &r.Name, &r.ContentType, &r.Size, &r.CreatedAt, &r.Encrypted); err != nil {
+			return fmt.Errorf("could not read query: %v", err)
+		}
+		return fn(r)
+	})
+}
+
+// Export all comments, fn is called for every row
+func ExportComments(fn func(CommentRecord) error) error {
+	return export(exportCommentsQuery, func(rows *sql.Rows) error {
+		r := CommentRecord{}
+		if err := rows.Scan(&r.UUID, &r.TaskUUID, &r.AuthorUUID, &r.Value, &r.CreatedAt); err != nil {
+			return fmt.Errorf("could not read query: %v", err)
+		}
+		return fn(r)
+	})
+}
+
+func ExportMentions(fn func(MentionRecord) error) error {
+	return export(exportMentionsQuery, func(rows *sql.Rows) error {
+		r := MentionRecord{}
+		if err := rows.Scan(&r.CommentUUID, &r.UserUUID); err != nil {
+			return fmt.Errorf("could not read query: %v", err)
+		}
+		return fn(r)
+	})
+}
+
+func ExportNotifications(fn func(NotificationRecord) error) error {
+	return export(exportNotificationsQuery, func(rows *sql.Rows) error {
+		r := NotificationRecord{}
+		err := rows.Scan(&r.UUID, &r.UserUUID, &r.Kind, &r.ActorUUID, &r.TaskUUID, &r.CommentUUID, &r.ProjectUUID, &r.CreatedAt,
+			&r.DeliveredAt, &r.ReadAt)
+		if err != nil {
+			return fmt.Errorf("could not read query: %v", err)
+		}
+		return fn(r)
+	})
+}
+
+func ExportProjects(fn func(ProjectRecord) error) error {
+	return export(exportProjectsQuery, func(rows *sql.Rows) error {
+		r := ProjectRecord{}
+		if err := rows.Scan(&r.UUID, &r.OwnerUUID, &r.Name, &r.CreatedAt, &r.WorkspaceUUID); err != nil {
+			return fmt.Errorf("could not read query: %v", err)
+		}
+		return fn(r)
+	})
+}
+
+func ExportSmartLists(fn func(SmartListRecord) error) error {
+	return export(exportSmartListsQuery, func(rows *sql.Rows) error {
+		r := SmartListRecord{}
+		if err := rows.Scan(&r.UUID, &r.UserUUID, &r.Name, &r.Query, &r.Pinned, &r.CreatedAt); err != nil {
+			return fmt.Errorf("could not read query: %v", err)
+		}
+		return fn(r)
+	})
+}
+
+func ExportWebhooks(fn func(WebhookRecord) error) error {
+	return export(exportWebhooksQuery, func(rows *sql.Rows) error {
+		r := WebhookRecord{}
+		if err := rows.Scan(&r.UUID, &r.UserUUID, &r.URL, &r.Secret, pq.Array(&r.Events), &r.CreatedAt); err != nil {
+			return fmt.Errorf("could not read query: %v", err)
+		}
+		return fn(r)
+	})
+}
+
+func ExportCaptures(fn func(CaptureRecord) error) error {
+	return export(exportCapturesQuery, func(rows *sql.Rows) error {
+		r := CaptureRecord{}
+		if err := rows.Scan(&r.UUID, &r.UserUUID, &r.Name, &r.TokenHash, &r.CreatedAt, &r.LastUsedAt); err != nil {
+			return fmt.Errorf("could not read query: %v", err)
+		}
+		return fn(r)
+	})
+}
+
+func ExportShares(fn func(ShareRecord) error) error {
+	return export(exportSharesQuery, func(rows *sql.Rows) error {
+		r := ShareRecord{}
+		if err := rows.Scan(&r.UUID, &r.TaskUUID, &r.ProjectUUID, &r.UserUUID, &r.Role, &r.CreatedAt); err != nil {
+			return fmt.Errorf("could not read query: %v", err)
+		}
+		return fn(r)
+	})
+}
+
+func ExportAssignees(fn func(AssigneeRecord) error) error {
+	return export(exportAssigneesQuery, func(rows *sql.Rows) error {
+		r := AssigneeRecord{}
+		if err := rows.Scan(&r.TaskUUID, &r.UserUUID, &r.AssignedAt); err != nil {
+			return fmt.Errorf("could not read query: %v", err)
+		}
+		return fn(r)
+	})
+}
+
+func ExportWorkspaces(fn func(WorkspaceRecord) error) error {
+	return export(exportWorkspacesQuery, func(rows *sql.Rows) error {
+		r := WorkspaceRecord{}
+		if err := rows.Scan(&r.UUID, &r.Name, &r.CreatedAt); err != nil {
+			return fmt.Errorf("could not read query: %v", err)
+		}
+		return fn(r)
+	})
+}
+
+func ExportMembers(fn func(MemberRecord) error) error {
+	return export(exportMembersQuery, func(rows *sql.Rows) error {
+		r := MemberRecord{}
+		if err := rows.Scan(&r.WorkspaceUUID, &r.UserUUID, &r.Role, &r.CreatedAt); err != nil {
+			return fmt.Errorf("could not read query: %v", err)
+		}
+		return fn(r)
+	})
+}
+
+func ExportInvitations(fn func(InvitationRecord) error) error {
+	return export(exportInvitationsQuery, func(rows *sql.Rows) error {
+		r := InvitationRecord{}
+		if err := rows.Scan(&r.UUID, &r.WorkspaceUUID, &r.UserUUID, &r.InviterUUID, &r.Role, &r.CreatedAt); err != nil {
+			return fmt.Errorf("could not read query: %v", err)
+		}
+		return fn(r)
+	})
+}
+
+// Restore inserts dumped rows in a single transaction, existing rows are kept
+type Restore struct {
+	tx *sql.Tx
+}
+
+// Begin restore transaction
+func BeginRestore() (*Restore, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %v", err)
+	}
+	return &Restore{tx: tx}, nil
+}
+
+// Insert row, returns false when row already exists
+func (r *Restore) insert(query string, args ...interface{}) (bool, error) {
+	res, err := r.tx.Exec(query, args...)
+	if err != nil {
+		return false, fmt.Errorf("could not restore row: %v", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("could not restore row: %v", err)
+	}
+	return n == 1, nil
+}
+
+func (r *Restore) InsertUser(u UserRecord) (bool, error) {
+	return r.insert(importUserQuery, u.UUID, u.Login, u.Password, u.Salt, u.DataKey, u.DataKeyID)
+}
+
+func (r *Restore) InsertTask(t TaskRecord) (bool, error) {
+	inserted, err := r.insert(importTaskQuery, t.UUID, t.Value, t.AuthorUUID, t.IsResolved, t.DueDate, t.CreatedAt, t.ProjectUUID, t.Priority)
+	if err != nil || !inserted {
+		return inserted, err
+	}
+	for _, label := range t.Labels {
+		if _, err = r.insert(insertTaskLabelQuery, t.UUID, label); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (r *Restore) InsertReminder(rem ReminderRecord) (bool, error) {
+	return r.insert(importReminderQuery, rem.UUID, rem.TaskUUID, rem.UserUUID, rem.RemindAt, rem.Offset, rem.SentAt)
+}
+
+func (r *Restore) InsertAttachment(a AttachmentRecord) (bool, error) {
+	return r.insert(importAttachmentQuery, a.UUID, a.TaskUUID, a.Name, a.ContentType, a.Size, a.CreatedAt, a.Encrypted)
+}
+
+func (r *Restore) InsertComment(c CommentRecord) (bool, error) {
+	return r.insert(importCommentQuery, c.UUID, c.TaskUUID, c.AuthorUUID, c.Value, c.CreatedAt)
+}
+
+func (r *Restore) InsertMention(m MentionRecord) (bool, error) {
+	return r.insert(importMentionQuery, m.CommentUUID, m.UserUUID)
+}
+
+func (r *Restore) InsertNotification(n NotificationRecord) (bool, error) {
+	return r.insert(importNotificationQuery, n.UUID, n.UserUUID, n.Kind, n.ActorUUID, n.TaskUUID, n.CommentUUID, n.ProjectUUID,
+		n.CreatedAt, n.DeliveredAt, n.ReadAt)
+}
+
+func (r *Restore) InsertProject(p ProjectRecord) (bool, error) {
+	return r.insert(importProjectQuery, p.UUID, p.OwnerUUID, p.Name, p.CreatedAt, p.WorkspaceUUID)
+}
+
+func (r *Restore) InsertSmartList(l SmartListRecord) (bool, error) {
+	return r.insert(importSmartListQuery, l.UUID, l.UserUUID, l.Name, l.Query, l.Pinned, l.CreatedAt)
+}
+
+func (r *Restore) InsertShare(s ShareRecord) (bool, error) {
+	return r.insert(importShareQuery, s.UUID, s.TaskUUID, s.ProjectUUID, s.UserUUID, s.Role, s.CreatedAt)
+}
+
+func (r *Restore) InsertAssignee(a AssigneeRecord) (bool, error) {
+	return r.insert(importAssigneeQuery, a.TaskUUID, a.UserUUID, a.AssignedAt)
+}
+
+func (r *Restore) InsertWorkspace(w WorkspaceRecord) (bool, error) {
+	return r.insert(importWorkspaceQuery, w.UUID, w.Name, w.CreatedAt)
+}
+
+func (r *Restore) InsertMember(m MemberRecord) (bool, error) {
+	return r.insert(importMemberQuery, m.WorkspaceUUID, m.UserUUID, m.Role, m.CreatedAt)
+}
+
+func (r *Restore) InsertInvitation(i InvitationRecord) (bool, error) {
+	return r.insert(importInvitationQuery, i.UUID, i.WorkspaceUUID, i.UserUUID, i.InviterUUID, i.Role, i.CreatedAt)
+}
+
+func (r *Restore) Commit() error {
+	if err := r.tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit restore: %v", err)
+	}
+	return nil
+}
+
+func (r *Restore) Rollback() {
+	if err := r.tx.Rollback(); err != nil && err != sql.ErrTxDone {
+		log.Error().Err(err).Msg("Could not rollback restore")
+	}
+}
+
+func (r *Restore) InsertWebhook(w WebhookRecord) (bool, error) {
+	return r.insert(importWebhookQuery, w.UUID, w.UserUUID, w.URL, w.Secret, pq.Array(w.Events), w.CreatedAt)
+}
+
+func (r *Restore) InsertCapture(c CaptureRecord) (bool, error) {
+	return r.insert(importCaptureQuery, c.UUID, c.UserUUID, c.Name, c.TokenHash, c.CreatedAt, c.LastUsedAt)
+}
@@ -0,0 +1,147 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/Kolya59/todo-service/models"
+)
+
+const (
+	savepointQuery         = "SAVEPOINT bulk_operation"
+	rollbackSavepointQuery = "ROLLBACK TO SAVEPOINT bulk_operation"
+	releaseSavepointQuery  = "RELEASE SAVEPOINT bulk_operation"
+
+	removeTaskLabelsQuery = "DELETE FROM public.task_labels WHERE task_uuid = $1 AND label = ANY($2)"
+)
+
+// Editors change tasks within their project, moving exposes the task to other users
+var updateTaskProjectQuery = "UPDATE public.tasks t SET project_uuid = $3 WHERE t.uuid = $1 AND " + moveAccess("$2", "$3")
+
+// ErrProjectNotFound is returned when task is moved to missing project or project of another user
+var ErrProjectNotFound = errors.New("project is not found")
+
+// Executor of queries, either database or transaction
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Execute operations on tasks of user in a single transaction. Operation on missing or forbidden task or project is
+// rolled back to its savepoint and the others are committed, unless atomic is set: then any failure
+// rolls back the whole transaction. Operations have to be validated by caller.
+func BulkTasks(userUUID string, ops []models.BulkOperation, atomic bool) (results []models.BulkResult, committed bool, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, false, fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer func() {
+		if err != nil || !committed {
+			if err := tx.Rollback(); err != nil {
+				log.Error().Err(err).Msg("Could not rollback transaction")
+			}
+		}
+	}()
+
+	failed := false
+	results = make([]models.BulkResult, len(ops))
+	for i, op := range ops {
+		results[i] = models.BulkResult{Op: op.Op, TaskUUID: op.TaskUUID, Status: models.BulkOK}
+		if _, err = tx.Exec(savepointQuery); err != nil {
+			return nil, false, fmt.Errorf("could not create savepoint: %v", err)
+		}
+		opErr := bulkOperation(tx, userUUID, op)
+		if opErr == ErrNotFound || opErr == ErrProjectNotFound || opErr == ErrForbidden {
+			failed = true
+			results[i].Status = models.BulkNotFound
+			if opErr == ErrForbidden {
+				results[i].Status = models.BulkForbidden
+			}
+			results[i].Message = opErr.Error()
+			if _, err = tx.Exec(rollbackSavepointQuery); err != nil {
+				return nil, false, fmt.Errorf("could not rollback to savepoint: %v", err)
+			}
+			continue
+		}
+		if opErr != nil {
+			return nil, false, opErr
+		}
+		if _, err = tx.Exec(releaseSavepointQuery); err != nil {
+			return nil, false, fmt.Errorf("could not release savepoint: %v", err)
+		}
+	}
+
+	if atomic && failed {
+		for i := range results {
+			if results[i].Status == models.BulkOK {
+				results[i].Status = models.BulkRolledBack
+			}
+		}
+		return results, false, nil
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, false, fmt.Errorf("could not commit bulk operations: %v", err)
+	}
+	committed = true
+	log.Info().Msgf("%v bulk operations on tasks are executed", len(ops))
+	return results, true, nil
+}
+
+func bulkOperation(tx *sql.Tx, userUUID string, op models.BulkOperation) error {
+	switch op.Op {
+	case models.BulkResolve, models.BulkReopen:
+		return affectTask(tx, updateTaskQuery, op.TaskUUID, userUUID, op.Op == models.BulkResolve)
+	case models.BulkDelete:
+		return affectTask(tx, deleteTaskQuery, op.TaskUUID, userUUID)
+	case models.BulkRelabel:
+		var found int
+		if err := tx.QueryRow(checkTaskQuery, op.TaskUUID, userUUID).Scan(&found); err != nil {
+			if err == sql.ErrNoRows {
+				return taskMissing(tx, op.TaskUUID, userUUID)
+			}
+			return fmt.Errorf("could not select task: %v", err)
+		}
+		if _, err := tx.Exec(removeTaskLabelsQuery, op.TaskUUID, pq.Array(op.RemoveLabels)); err != nil {
+			return fmt.Errorf("could not delete labels: %v", err)
+		}
+		for _, label := range op.AddLabels {
+			if _, err := tx.Exec(insertTaskLabelQuery, op.TaskUUID, label); err != nil {
+				return fmt.Errorf("could not insert label: %v", err)
+			}
+		}
+		return nil
+	case models.BulkMove:
+		return moveTask(tx, userUUID, op.TaskUUID, op.ProjectUUID)
+	default:
+		return fmt.Errorf("unknown bulk operation %v", op.Op)
+	}
+}
+
+// Execute update or delete of a single task, ErrNotFound or ErrForbidden is returned when no task is affected
+func affectTask(e execer, query string, taskUUID string, userUUID string, args ...interface{}) error {
+	res, err := e.Exec(query, append([]interface{}{taskUUID, userUUID}, args...)...)
+	if err != nil {
+		return fmt.Errorf("could not update task: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return taskMissing(e, taskUUID, userUUID)
+	}
+	return nil
+}
+
+func moveTask(e execer, userUUID string, taskUUID string, projectUUID *string) error {
+	if projectUUID != nil {
+		var found int
+		if err := e.QueryRow(checkProjectQuery, *projectUUID, userUUID).Scan(&found); err != nil {
+			if err == sql.ErrNoRows {
+				return ErrProjectNotFound
+			}
+			return fmt.Errorf("could not select project: %v", err)
+		}
+	}
+	return affectTask(e, updateTaskProjectQuery, taskUUID, userUUID, projectUUID)
+}
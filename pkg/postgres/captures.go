@@ -0,0 +1,76 @@
+package postgres
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/Kolya59/todo-service/models"
+)
+
+// Only SHA-256 of capture tokens is stored, tokens are looked up by it
+const (
+	insertCaptureTokenQuery  = "INSERT INTO public.capture_tokens(uuid, user_uuid, name, token_hash) VALUES ($1, $2, $3, $4) RETURNING created_at"
+	selectCaptureTokensQuery = "SELECT uuid, name, created_at, last_used_at FROM public.capture_tokens WHERE user_uuid = $1 ORDER BY created_at"
+	deleteCaptureTokenQuery  = "DELETE FROM public.capture_tokens WHERE uuid = $2 AND user_uuid = $1"
+	useCaptureTokenQuery     = "UPDATE public.capture_tokens SET last_used_at = now() WHERE token_hash = $1 RETURNING user_uuid"
+)
+
+func captureTokenHash(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// Insert capture token of user, token is returned only here
+func InsertCaptureToken(userUUID string, name string, token string) (models.CaptureToken, error) {
+	capture := models.CaptureToken{UUID: uuid.NewV4().String(), Name: name, Token: token}
+	err := db.QueryRow(insertCaptureTokenQuery, capture.UUID, userUUID, name, captureTokenHash(token)).Scan(&capture.CreatedAt)
+	if err != nil {
+		return models.CaptureToken{}, fmt.Errorf("could not insert capture token: %v", err)
+	}
+	return capture, nil
+}
+
+// Select capture tokens of user from the oldest, tokens themselves are not set
+func SelectCaptureTokens(userUUID string) (captures []models.CaptureToken, err error) {
+	rows, err := db.Query(selectCaptureTokensQuery, userUUID)
+	if err != nil {
+		return nil, fmt.Errorf("could not select capture tokens: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		capture := models.CaptureToken{}
+		if err = rows.Scan(&capture.UUID, &capture.Name, &capture.CreatedAt, &capture.LastUsedAt); err != nil {
+			return nil, fmt.Errorf("could not read query: %v", err)
+		}
+		captures = append(captures, capture)
+	}
+	return captures, rows.Err()
+}
+
+// Delete capture token, its URL stops working at once
+func DeleteCaptureToken(userUUID string, captureUUID string) error {
+	res, err := db.Exec(deleteCaptureTokenQuery, userUUID, captureUUID)
+	if err != nil {
+		return fmt.Errorf("could not delete capture token: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Select user of capture token and record its use, unknown token gives ErrNotFound
+func UseCaptureToken(token string) (userUUID string, err error) {
+	err = db.QueryRow(useCaptureTokenQuery, captureTokenHash(token)).Scan(&userUUID)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not use capture token: %v", err)
+	}
+	return userUUID, nil
+}
@@ -0,0 +1,168 @@
+package postgres
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/Kolya59/todo-service/models"
+)
+
+// Comments of shared task are read by viewers and written by editors,
+// comment is removed by its author while the author is editor or by owner of the task
+var (
+	selectCommentsQuery = "SELECT c.uuid, c.value, u.login, c.created_at, t.author_uuid, " + mentionsColumn + " FROM public.comments c " +
+		"JOIN public.tasks t ON t.uuid = c.task_uuid JOIN public.users u ON u.uuid = c.author_uuid " +
+		"WHERE c.task_uuid = $1 AND " + readAccess("$2") + " ORDER BY c.created_at"
+	insertCommentQuery = "INSERT INTO public.comments(uuid, task_uuid, author_uuid, value, created_at, search_vector) " +
+		"SELECT $1, t.uuid, $3, $4, $5, to_tsvector($6::regconfig, $7) FROM public.tasks t WHERE t.uuid = $2 AND " + writeAccess("$3")
+	deleteCommentQuery = "DELETE FROM public.comments c USING public.tasks t " +
+		"WHERE t.uuid = c.task_uuid AND c.uuid = $1 AND c.task_uuid = $2 AND " +
+		"(t.author_uuid = $3 OR (c.author_uuid = $3 AND " + writeAccess("$3") + "))"
+	// Owner and assignees of the task are notified about comments unless they are mentioned in it
+	selectWatchersQuery = "SELECT w.user_uuid FROM (SELECT t.author_uuid AS user_uuid FROM public.tasks t WHERE t.uuid = $1 " +
+		"UNION SELECT a.user_uuid FROM public.task_assignees a WHERE a.task_uuid = $1) w " +
+		"WHERE NOT EXISTS (SELECT 1 FROM public.comment_mentions cm WHERE cm.comment_uuid = $2 AND cm.user_uuid = w.user_uuid)"
+)
+
+// Select comments of the task, values are encrypted with data key of the task owner
+func SelectComments(userUUID string, taskUUID string) (comments []models.Comment, err error) {
+	selectComments, err := db.Prepare(selectCommentsQuery)
+	if err != nil {
+		return nil, fmt.Errorf("could not prepare select comments query: %v", err)
+	}
+	defer func() {
+		if err := selectComments.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close database connection")
+		}
+	}()
+	rows, err := selectComments.Query(taskUUID, userUUID)
+	if err != nil {
+		return nil, fmt.Errorf("could not select comments: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		comment := models.Comment{TaskId: taskUUID}
+		var ownerUUID string
+		err = rows.Scan(&comment.UUID, &comment.Value, &comment.Author, &comment.CreatedAt, &ownerUUID, pq.Array(&comment.Mentions))
+		if err != nil {
+			return nil, fmt.Errorf("could not read query: %v", err)
+		}
+		comment.Value, err = decryptValue(ownerUUID, comment.Value)
+		if err != nil {
+			return nil, fmt.Errorf("could not decrypt comment: %v", err)
+		}
+		comments = append(comments, comment)
+	}
+	return comments, nil
+}
+
+// Insert comment to the task, users mentioned as @login are notified in the same transaction
+func InsertComment(userUUID string, taskUUID string, value string) (comment models.Comment, err error) {
+	login, err := SelectLoginByUUID(userUUID)
+	if err != nil {
+		return models.Comment{}, fmt.Errorf("could not get login: %v", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return models.Comment{}, fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer func() {
+		if err != nil {
+			if err := tx.Rollback(); err != nil {
+				log.Error().Err(err).Msg("Could not rollback transaction")
+			}
+		}
+	}()
+
+	ownerUUID, err := taskOwner(tx, taskUUID, userUUID)
+	if err != nil {
+		return models.Comment{}, err
+	}
+	storedValue, err := encryptValue(ownerUUID, value)
+	if err != nil {
+		return models.Comment{}, fmt.Errorf("could not encrypt comment: %v", err)
+	}
+	comment = models.Comment{
+		UUID:      uuid.NewV4().String(),
+		Value:     value,
+		Author:    login,
+		TaskId:    taskUUID,
+		CreatedAt: time.Now().UTC(),
+	}
+	res, err := tx.Exec(insertCommentQuery, comment.UUID, taskUUID, userUUID, storedValue, comment.CreatedAt, searchLanguage, indexedText(value))
+	if err != nil {
+		return models.Comment{}, fmt.Errorf("could not insert comment into database: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return models.Comment{}, taskMissing(tx, taskUUID, userUUID)
+	}
+	if comment.Mentions, err = insertMentions(tx, comment, userUUID); err != nil {
+		return models.Comment{}, err
+	}
+	if err = notifyWatchers(tx, comment, userUUID); err != nil {
+		return models.Comment{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return models.Comment{}, fmt.Errorf("could not commit comment: %v", err)
+	}
+	log.Info().Msgf("Comment with uuid = %s is added in database", comment.UUID)
+	return comment, nil
+}
+
+func notifyWatchers(q querier, comment models.Comment, authorUUID string) error {
+	rows, err := q.Query(selectWatchersQuery, comment.TaskId, comment.UUID)
+	if err != nil {
+		return fmt.Errorf("could not select watchers: %v", err)
+	}
+	defer rows.Close()
+	var watchers []string
+	for rows.Next() {
+		var userUUID string
+		if err = rows.Scan(&userUUID); err != nil {
+			return fmt.Errorf("could not read query: %v", err)
+		}
+		watchers = append(watchers, userUUID)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("could not select watchers: %v", err)
+	}
+	for _, userUUID := range watchers {
+		err = insertNotification(q, models.Notification{
+			UserUUID:    userUUID,
+			Kind:        models.NotificationComment,
+			TaskUUID:    &comment.TaskId,
+			CommentUUID: &comment.UUID,
+		}, authorUUID, true)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete comment from the task
+func DeleteComment(userUUID string, taskUUID string, commentUUID string) (err error) {
+	deleteComment, err := db.Prepare(deleteCommentQuery)
+	if err != nil {
+		return fmt.Errorf("could not prepare delete comment query: %v", err)
+	}
+	defer func() {
+		if err := deleteComment.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close database connection")
+		}
+	}()
+	res, err := deleteComment.Exec(commentUUID, taskUUID, userUUID)
+	if err != nil {
+		return fmt.Errorf("could not delete comment: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	log.Info().Msgf("Comment with uuid = %s has been deleted", commentUUID)
+	return nil
+}
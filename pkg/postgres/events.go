@@ -0,0 +1,126 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+)
+
+// Channel of task events shared by instances of the service
+const eventsChannel = "todo_events"
+
+const (
+	// Users who see the task: its owner, users it is shared with by itself or with its project and
+	// members of the workspace of its project
+	selectTaskAudienceQuery = "SELECT t.author_uuid FROM public.tasks t WHERE t.uuid = $1 " +
+		"UNION SELECT s.user_uuid FROM public.tasks t JOIN public.shares s ON s.task_uuid = t.uuid OR s.project_uuid = t.project_uuid " +
+		"WHERE t.uuid = $1 " +
+		"UNION SELECT m.user_uuid FROM public.tasks t JOIN public.projects wp ON wp.uuid = t.project_uuid " +
+		"JOIN public.workspace_members m ON m.workspace_uuid = wp.workspace_uuid WHERE t.uuid = $1"
+	notifyEventQuery       = "SELECT pg_notify($1, $2)"
+	selectTaskVersionQuery = "SELECT version, project_uuid FROM public.tasks WHERE uuid = $1"
+	bumpTaskVersionQuery   = "UPDATE public.tasks SET version = version + 1 WHERE uuid = $1 RETURNING version, project_uuid"
+	// Null expected version bumps any version
+	bumpExpectedVersionQuery = "UPDATE public.tasks SET version = version + 1 WHERE uuid = $1 AND version = COALESCE($2, version) RETURNING version, project_uuid"
+)
+
+// ErrVersionConflict is returned when task is changed with version other than the current one
+var ErrVersionConflict = errors.New("task has another version")
+
+// Listener reconnects between these intervals and pings connection when notifications are quiet
+const (
+	minReconnectInterval = 10 * time.Second
+	maxReconnectInterval = time.Minute
+	listenerPingInterval = 90 * time.Second
+)
+
+// Select users who see the task, audience of a task being deleted has to be selected before
+func SelectTaskAudience(taskUUID string) (users []string, err error) {
+	rows, err := db.Query(selectTaskAudienceQuery, taskUUID)
+	if err != nil {
+		return nil, fmt.Errorf("could not select audience: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userUUID string
+		if err = rows.Scan(&userUUID); err != nil {
+			return nil, fmt.Errorf("could not read query: %v", err)
+		}
+		users = append(users, userUUID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not select audience: %v", err)
+	}
+	return users, nil
+}
+
+// Select version and project of the task for its events
+func SelectTaskVersion(taskUUID string) (version int64, projectUUID *string, err error) {
+	err = db.QueryRow(selectTaskVersionQuery, taskUUID).Scan(&version, &projectUUID)
+	if err == sql.ErrNoRows {
+		return 0, nil, ErrNotFound
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("could not select task version: %v", err)
+	}
+	return version, projectUUID, nil
+}
+
+// Count published change of the task, version grows by one with every event of the task
+func BumpTaskVersion(taskUUID string) (version int64, projectUUID *string, err error) {
+	err = db.QueryRow(bumpTaskVersionQuery, taskUUID).Scan(&version, &projectUUID)
+	if err == sql.ErrNoRows {
+		return 0, nil, ErrNotFound
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("could not bump task version: %v", err)
+	}
+	return version, projectUUID, nil
+}
+
+// Send event to instances listening for events, payload is limited to 8000 bytes by Postgres
+func NotifyEvent(payload string) error {
+	if _, err := db.Exec(notifyEventQuery, eventsChannel, payload); err != nil {
+		return fmt.Errorf("could not notify event: %v", err)
+	}
+	return nil
+}
+
+// Pass events sent by NotifyEvent to fn until done is closed. Events sent while connection is lost are missed.
+func ListenEvents(done <-chan struct{}, fn func(payload string)) error {
+	listener := pq.NewListener(connInfo, minReconnectInterval, maxReconnectInterval, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Error().Err(err).Msg("Events listener failed")
+		}
+	})
+	defer func() {
+		if err := listener.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close events listener")
+		}
+	}()
+	if err := listener.Listen(eventsChannel); err != nil {
+		return fmt.Errorf("could not listen events: %v", err)
+	}
+	for {
+		select {
+		case <-done:
+			return nil
+		case n := <-listener.Notify:
+			// Nil notification is sent after reconnect
+			if n != nil {
+				fn(n.Extra)
+			}
+		case <-time.After(listenerPingInterval):
+			go func() {
+				if err := listener.Ping(); err != nil {
+					log.Error().Err(err).Msg("Events listener ping failed")
+				}
+			}()
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package postgres
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Kolya59/todo-service/pkg/query"
+)
+
+// errTextFilter is returned when filter searches text of encrypted values, such filter is matched in memory
+var errTextFilter = errors.New("text filter requires decrypted values")
+
+var likeReplacer = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// Compiler of filter into condition over tasks aliased as t, values are passed as parameters after the user
+type filterCompiler struct {
+	args []interface{}
+	// Text can be matched in database only while values are not encrypted
+	text bool
+}
+
+func (c *filterCompiler) arg(v interface{}) string {
+	c.args = append(c.args, v)
+	return fmt.Sprintf("$%d", len(c.args))
+}
+
+// Condition to append to WHERE clause, empty for nil filter
+func (c *filterCompiler) where(n query.Node) (string, error) {
+	if n == nil {
+		return "", nil
+	}
+	condition, err := c.compile(n)
+	if err != nil {
+		return "", err
+	}
+	return " AND " + condition, nil
+}
+
+func (c *filterCompiler) compile(n query.Node) (string, error) {
+	switch n := n.(type) {
+	case query.And:
+		return c.join(n.Nodes, " AND ")
+	case query.Or:
+		return c.join(n.Nodes, " OR ")
+	case query.Not:
+		condition, err := c.compile(n.Node)
+		if err != nil {
+			return "", err
+		}
+		return "NOT (" + condition + ")", nil
+	case query.Resolved:
+		return "(t.is_resolved = " + c.arg(n.Value) + ")", nil
+	case query.Label:
+		return "EXISTS (SELECT 1 FROM public.task_labels l WHERE l.task_uuid = t.uuid AND l.label = " + c.arg(n.Name) + ")", nil
+	case query.HasDue:
+		return "(t.due_date IS NOT NULL)", nil
+	case query.HasLabel:
+		return "EXISTS (SELECT 1 FROM public.task_labels l WHERE l.task_uuid = t.uuid)", nil
+	case query.Project:
+		return "COALESCE(t.project_uuid = " + c.arg(n.UUID) + ", false)", nil
+	case query.HasProject:
+		return "(t.project_uuid IS NOT NULL)", nil
+	case query.Shared:
+		return "(t.author_uuid <> $1)", nil
+	case query.Assignee:
+		return "EXISTS (SELECT 1 FROM public.task_assignees a JOIN public.users au ON au.uuid = a.user_uuid " +
+			"WHERE a.task_uuid = t.uuid AND lower(au.login) = " + c.arg(strings.ToLower(n.Login)) + ")", nil
+	case query.AssignedToMe:
+		return "EXISTS (SELECT 1 FROM public.task_assignees a WHERE a.task_uuid = t.uuid AND a.user_uuid = $1)", nil
+	case query.HasAssignee:
+		return "EXISTS (SELECT 1 FROM public.task_assignees a WHERE a.task_uuid = t.uuid)", nil
+	case query.Before:
+		column, err := timeColumn(n.Field)
+		if err != nil {
+			return "", err
+		}
+		// Missing date never matches, also under NOT
+		return "COALESCE(" + column + " < " + c.arg(n.Time) + ", false)", nil
+	case query.NotBefore:
+		column, err := timeColumn(n.Field)
+		if err != nil {
+			return "", err
+		}
+		return "COALESCE(" + column + " >= " + c.arg(n.Time) + ", false)", nil
+	case query.Text:
+		if !c.text {
+			return "", errTextFilter
+		}
+		return "(t.value ILIKE '%' || " + c.arg(likeReplacer.Replace(n.Value)) + " || '%')", nil
+	default:
+		return "", fmt.Errorf("unsupported filter %v", n)
+	}
+}
+
+func (c *filterCompiler) join(nodes []query.Node, sep string) (string, error) {
+	conditions := make([]string, len(nodes))
+	for i, n := range nodes {
+		condition, err := c.compile(n)
+		if err != nil {
+			return "", err
+		}
+		conditions[i] = condition
+	}
+	return "(" + strings.Join(conditions, sep) + ")", nil
+}
+
+// Filter matched in memory gets login of the user in place of assignee:me
+func resolveMe(filter query.Node, userUUID string) (query.Node, error) {
+	found := false
+	query.Walk(filter, func(n query.Node) {
+		if _, ok := n.(query.AssignedToMe); ok {
+			found = true
+		}
+	})
+	if !found {
+		return filter, nil
+	}
+	login, err := SelectLoginByUUID(userUUID)
+	if err != nil {
+		return nil, fmt.Errorf("could not get login: %v", err)
+	}
+	return query.Me(filter, login), nil
+}
+
+func timeColumn(field string) (string, error) {
+	switch field {
+	case query.FieldDue:
+		return "t.due_date", nil
+	case query.FieldCreated:
+		return "t.created_at", nil
+	default:
+		return "", fmt.Errorf("unsupported field %v", field)
+	}
+}
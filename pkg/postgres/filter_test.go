@@ -0,0 +1,67 @@
+package postgres
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Kolya59/todo-service/pkg/query"
+)
+
+func TestFilterCompiler(t *testing.T) {
+	now := time.Date(2026, time.October, 19, 10, 0, 0, 0, time.UTC)
+	today := time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		query string
+		where string
+		args  []interface{}
+	}{
+		{"", "", []interface{}{"u1"}},
+		{"is:open", " AND (t.is_resolved = $2)", []interface{}{"u1", false}},
+		{"label:work -is:done", " AND (EXISTS (SELECT 1 FROM public.task_labels l WHERE l.task_uuid = t.uuid AND l.label = $2) AND NOT ((t.is_resolved = $3)))",
+			[]interface{}{"u1", "work", true}},
+		{"label:a OR label:b is:shared", " AND (EXISTS (SELECT 1 FROM public.task_labels l WHERE l.task_uuid = t.uuid AND l.label = $2) OR " +
+			"(EXISTS (SELECT 1 FROM public.task_labels l WHERE l.task_uuid = t.uuid AND l.label = $3) AND (t.author_uuid <> $1)))",
+			[]interface{}{"u1", "a", "b"}},
+		{"due:today", " AND (COALESCE(t.due_date >= $2, false) AND COALESCE(t.due_date < $3, false))",
+			[]interface{}{"u1", today, today.AddDate(0, 0, 1)}},
+		{"-created<today", " AND NOT (COALESCE(t.created_at < $2, false))", []interface{}{"u1", today}},
+		{"due:none has:project", " AND (NOT ((t.due_date IS NOT NULL)) AND (t.project_uuid IS NOT NULL))", []interface{}{"u1"}},
+		{"project:6ba7b810-9dad-11d1-80b4-00c04fd430c8", " AND COALESCE(t.project_uuid = $2, false)",
+			[]interface{}{"u1", "6ba7b810-9dad-11d1-80b4-00c04fd430c8"}},
+		{"assignee:Bob", " AND EXISTS (SELECT 1 FROM public.task_assignees a JOIN public.users au ON au.uuid = a.user_uuid " +
+			"WHERE a.task_uuid = t.uuid AND lower(au.login) = $2)", []interface{}{"u1", "bob"}},
+		{"assignee:me", " AND EXISTS (SELECT 1 FROM public.task_assignees a WHERE a.task_uuid = t.uuid AND a.user_uuid = $1)", []interface{}{"u1"}},
+		// Wildcards of text are escaped
+		{`"100%_done"`, " AND (t.value ILIKE '%' || $2 || '%')", []interface{}{"u1", `100\%\_done`}},
+	}
+	for _, test := range tests {
+		n, err := query.Parse(test.query, now)
+		if err != nil {
+			t.Fatalf("could not parse %q: %v", test.query, err)
+		}
+		c := &filterCompiler{args: []interface{}{"u1"}, text: true}
+		where, err := c.where(n)
+		if err != nil {
+			t.Errorf("could not compile %q: %v", test.query, err)
+			continue
+		}
+		if where != test.where {
+			t.Errorf("%q compiled to %q, want %q", test.query, where, test.where)
+		}
+		if !reflect.DeepEqual(c.args, test.args) {
+			t.Errorf("%q has args %v, want %v", test.query, c.args, test.args)
+		}
+	}
+}
+
+func TestFilterCompilerEncryptedText(t *testing.T) {
+	n, err := query.Parse("is:open (rent OR label:home)", time.Now())
+	if err != nil {
+		t.Fatalf("could not parse: %v", err)
+	}
+	c := &filterCompiler{args: []interface{}{"u1"}}
+	if _, err = c.where(n); err != errTextFilter {
+		t.Errorf("got %v, want %v", err, errTextFilter)
+	}
+}
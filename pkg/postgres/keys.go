@@ -0,0 +1,132 @@
+package postgres
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Kolya59/todo-service/pkg/crypt"
+)
+
+const (
+	selectDataKeyQuery = "SELECT data_key FROM public.users WHERE uuid = $1"
+	updateDataKeyQuery = "UPDATE public.users SET data_key = $2, data_key_id = $3 WHERE uuid = $1 AND data_key IS NULL"
+)
+
+var (
+	keyRing *crypt.KeyRing
+	// Unwrapped data keys by user uuid
+	dataKeys sync.Map
+)
+
+// Enable at-rest encryption of user data, empty ring keeps data in plain text
+func SetKeyRing(ring *crypt.KeyRing) {
+	keyRing = ring
+}
+
+// EncryptionEnabled reports whether new data is encrypted
+func EncryptionEnabled() bool {
+	return !keyRing.Empty()
+}
+
+// Get data key of user, it is created on first use
+func dataKey(userUUID string) ([]byte, error) {
+	if key, ok := dataKeys.Load(userUUID); ok {
+		return key.([]byte), nil
+	}
+	if keyRing.Empty() {
+		return nil, crypt.ErrNoKey
+	}
+	var wrapped []byte
+	err := db.QueryRow(selectDataKeyQuery, userUUID).Scan(&wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("could not select data key: %v", err)
+	}
+	if wrapped == nil {
+		key, newWrapped, err := crypt.GenerateDataKey(keyRing)
+		if err != nil {
+			return nil, err
+		}
+		res, err := db.Exec(updateDataKeyQuery, userUUID, newWrapped, keyRing.Primary())
+		if err != nil {
+			return nil, fmt.Errorf("could not save data key: %v", err)
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 1 {
+			log.Info().Msgf("Data key for user with uuid = %s is created", userUUID)
+			dataKeys.Store(userUUID, key)
+			return key, nil
+		}
+		// Concurrent request has created the key first
+		err = db.QueryRow(selectDataKeyQuery, userUUID).Scan(&wrapped)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, fmt.Errorf("could not select data key: %v", err)
+		}
+	}
+	key, err := crypt.UnwrapDataKey(wrapped, keyRing)
+	if err != nil {
+		return nil, err
+	}
+	dataKeys.Store(userUUID, key)
+	return key, nil
+}
+
+// Encrypt value of user if encryption is enabled
+func encryptValue(userUUID string, value string) (string, error) {
+	if !EncryptionEnabled() {
+		return value, nil
+	}
+	key, err := dataKey(userUUID)
+	if err != nil {
+		return "", fmt.Errorf("could not get data key: %v", err)
+	}
+	return crypt.EncryptValue(value, key)
+}
+
+// Decrypt value of user, plain values are returned as is
+func decryptValue(userUUID string, value string) (string, error) {
+	if !crypt.IsEncryptedValue(value) {
+		return value, nil
+	}
+	key, err := dataKey(userUUID)
+	if err != nil {
+		return "", fmt.Errorf("could not get data key: %v", err)
+	}
+	return crypt.DecryptValue(value, key)
+}
+
+// EncryptBlobWriter returns writer encrypting data of user into w as a chunked stream
+func EncryptBlobWriter(userUUID string, w io.Writer) (io.WriteCloser, error) {
+	key, err := dataKey(userUUID)
+	if err != nil {
+		return nil, fmt.Errorf("could not get data key: %v", err)
+	}
+	return crypt.NewWriter(w, key)
+}
+
+// DecryptBlobReader returns reader decrypting data of user, both chunked streams and whole encrypted blobs are supported
+func DecryptBlobReader(userUUID string, r io.Reader) (io.Reader, error) {
+	key, err := dataKey(userUUID)
+	if err != nil {
+		return nil, fmt.Errorf("could not get data key: %v", err)
+	}
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(4)
+	if crypt.IsStream(head) {
+		return crypt.NewReader(br, key)
+	}
+	data, err := ioutil.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := crypt.DecryptWithDataKey(data, key)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(plain), nil
+}
@@ -0,0 +1,45 @@
+package postgres
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Labels of task are changed by its editors
+var checkTaskQuery = "SELECT 1 FROM public.tasks t WHERE t.uuid = $1 AND " + writeAccess("$2") + " FOR UPDATE OF t"
+
+const (
+	// Labels of task as array column, used in task selects aliased as t
+	labelsColumn = "ARRAY(SELECT l.label FROM public.task_labels l WHERE l.task_uuid = t.uuid ORDER BY l.label)"
+
+	deleteTaskLabelsQuery = "DELETE FROM public.task_labels WHERE task_uuid = $1"
+	insertTaskLabelQuery  = "INSERT INTO public.task_labels(task_uuid, label) VALUES ($1, $2) ON CONFLICT DO NOTHING"
+)
+
+const maxLabelLength = 64
+
+// ErrInvalidLabel is returned for labels which can not be used in filter query
+var ErrInvalidLabel = errors.New("label must be a single word without quotes and parentheses")
+
+// Normalize labels to lower case, sorted and without duplicates
+func NormalizeLabels(labels []string) ([]string, error) {
+	set := map[string]bool{}
+	normalized := make([]string, 0, len(labels))
+	for _, label := range labels {
+		label = strings.ToLower(strings.TrimSpace(label))
+		if label == "" || len(label) > maxLabelLength || strings.HasPrefix(label, "-") ||
+			strings.IndexFunc(label, func(r rune) bool {
+				return unicode.IsSpace(r) || r == '"' || r == '(' || r == ')'
+			}) >= 0 {
+			return nil, ErrInvalidLabel
+		}
+		if !set[label] {
+			set[label] = true
+			normalized = append(normalized, label)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
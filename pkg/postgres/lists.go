@@ -0,0 +1,236 @@
+package postgres
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/Kolya59/todo-service/models"
+	"github.com/Kolya59/todo-service/pkg/query"
+)
+
+// Name and query of smart list are encrypted like task values, query is parsed again on every use
+// so that relative dates such as today follow the clock
+const (
+	insertSmartListQuery = "INSERT INTO public.smart_lists(uuid, user_uuid, name, query, pinned) " +
+		"VALUES ($1, $2, $3, $4, $5) RETURNING created_at"
+	selectSmartListsQuery = "SELECT uuid, name, query, pinned, created_at FROM public.smart_lists " +
+		"WHERE user_uuid = $1 ORDER BY pinned DESC, created_at, uuid"
+	selectSmartListQuery = "SELECT uuid, name, query, pinned, created_at FROM public.smart_lists " +
+		"WHERE uuid = $1 AND user_uuid = $2"
+	updateSmartListQuery = "UPDATE public.smart_lists SET name = $3, query = $4, pinned = $5 WHERE uuid = $1 AND user_uuid = $2"
+	deleteSmartListQuery = "DELETE FROM public.smart_lists WHERE uuid = $1 AND user_uuid = $2"
+)
+
+// Insert smart list, query has to be validated by caller
+func InsertSmartList(userUUID string, name string, q string, pinned bool) (list models.SmartList, err error) {
+	insertList, err := db.Prepare(insertSmartListQuery)
+	if err != nil {
+		return models.SmartList{}, fmt.Errorf("could not prepare insert smart list query: %v", err)
+	}
+	defer func() {
+		if err := insertList.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close database connection")
+		}
+	}()
+	storedName, err := encryptValue(userUUID, name)
+	if err != nil {
+		return models.SmartList{}, fmt.Errorf("could not encrypt smart list: %v", err)
+	}
+	storedQuery, err := encryptValue(userUUID, q)
+	if err != nil {
+		return models.SmartList{}, fmt.Errorf("could not encrypt smart list: %v", err)
+	}
+	list = models.SmartList{UUID: uuid.NewV4().String(), Name: name, Query: q, Pinned: pinned}
+	err = insertList.QueryRow(list.UUID, userUUID, storedName, storedQuery, pinned).Scan(&list.CreatedAt)
+	if err != nil {
+		return models.SmartList{}, fmt.Errorf("could not insert smart list into database: %v", err)
+	}
+	if list.Count, err = countSmartList(userUUID, list, time.Now(), nil); err != nil {
+		return models.SmartList{}, err
+	}
+	log.Info().Msgf("Smart list with uuid = %s is added in database", list.UUID)
+	return list, nil
+}
+
+// Select smart lists of user with counts of matching tasks, pinned lists go first
+func SelectSmartLists(userUUID string) (lists []models.SmartList, err error) {
+	selectLists, err := db.Prepare(selectSmartListsQuery)
+	if err != nil {
+		return nil, fmt.Errorf("could not prepare select smart lists query: %v", err)
+	}
+	defer func() {
+		if err := selectLists.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close database connection")
+		}
+	}()
+	rows, err := selectLists.Query(userUUID)
+	if err != nil {
+		return nil, fmt.Errorf("could not select smart lists: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		list, err := scanSmartList(userUUID, rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, list)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not select smart lists: %v", err)
+	}
+
+	// Tasks decrypted for one list are reused by the others
+	now := time.Now()
+	var all []models.Task
+	for i := range lists {
+		if lists[i].Count, err = countSmartList(userUUID, lists[i], now, &all); err != nil {
+			return nil, err
+		}
+	}
+	return lists, nil
+}
+
+// Select smart list with count of matching tasks
+func SelectSmartList(userUUID string, listUUID string) (list models.SmartList, err error) {
+	selectList, err := db.Prepare(selectSmartListQuery)
+	if err != nil {
+		return models.SmartList{}, fmt.Errorf("could not prepare select smart list query: %v", err)
+	}
+	defer func() {
+		if err := selectList.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close database connection")
+		}
+	}()
+	rows, err := selectList.Query(listUUID, userUUID)
+	if err != nil {
+		return models.SmartList{}, fmt.Errorf("could not select smart list: %v", err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return models.SmartList{}, fmt.Errorf("could not select smart list: %v", err)
+		}
+		return models.SmartList{}, ErrNotFound
+	}
+	if list, err = scanSmartList(userUUID, rows.Scan); err != nil {
+		return models.SmartList{}, err
+	}
+	if list.Count, err = countSmartList(userUUID, list, time.Now(), nil); err != nil {
+		return models.SmartList{}, err
+	}
+	return list, nil
+}
+
+// Update name, query and pin of smart list
+func UpdateSmartList(userUUID string, list models.SmartList) (err error) {
+	updateList, err := db.Prepare(updateSmartListQuery)
+	if err != nil {
+		return fmt.Errorf("could not prepare update smart list query: %v", err)
+	}
+	defer func() {
+		if err := updateList.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close database connection")
+		}
+	}()
+	storedName, err := encryptValue(userUUID, list.Name)
+	if err != nil {
+		return fmt.Errorf("could not encrypt smart list: %v", err)
+	}
+	storedQuery, err := encryptValue(userUUID, list.Query)
+	if err != nil {
+		return fmt.Errorf("could not encrypt smart list: %v", err)
+	}
+	res, err := updateList.Exec(list.UUID, userUUID, storedName, storedQuery, list.Pinned)
+	if err != nil {
+		return fmt.Errorf("could not update smart list: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	log.Info().Msgf("Smart list with uuid = %s has been updated", list.UUID)
+	return nil
+}
+
+// Delete smart list, tasks are not affected
+func DeleteSmartList(userUUID string, listUUID string) (err error) {
+	deleteList, err := db.Prepare(deleteSmartListQuery)
+	if err != nil {
+		return fmt.Errorf("could not prepare delete smart list query: %v", err)
+	}
+	defer func() {
+		if err := deleteList.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close database connection")
+		}
+	}()
+	res, err := deleteList.Exec(listUUID, userUUID)
+	if err != nil {
+		return fmt.Errorf("could not delete smart list: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	log.Info().Msgf("Smart list with uuid = %s has been deleted", listUUID)
+	return nil
+}
+
+func scanSmartList(userUUID string, scan func(dest ...interface{}) error) (list models.SmartList, err error) {
+	if err = scan(&list.UUID, &list.Name, &list.Query, &list.Pinned, &list.CreatedAt); err != nil {
+		return models.SmartList{}, fmt.Errorf("could not read query: %v", err)
+	}
+	if list.Name, err = decryptValue(userUUID, list.Name); err != nil {
+		return models.SmartList{}, fmt.Errorf("could not decrypt smart list: %v", err)
+	}
+	if list.Query, err = decryptValue(userUUID, list.Query); err != nil {
+		return models.SmartList{}, fmt.Errorf("could not decrypt smart list: %v", err)
+	}
+	return list, nil
+}
+
+// Count tasks matching query of the list. Filter over encrypted text is matched in memory,
+// all tasks of user are then selected once and kept in cache when it is given.
+func countSmartList(userUUID string, list models.SmartList, now time.Time, cache *[]models.Task) (int, error) {
+	filter, err := query.Parse(list.Query, now)
+	if err != nil {
+		// Queries are validated on save, so it can only be an outdated one
+		log.Error().Err(err).Msgf("Could not parse query of smart list with uuid = %s", list.UUID)
+		return 0, nil
+	}
+	compiler := &filterCompiler{args: []interface{}{userUUID}, text: !EncryptionEnabled()}
+	where, err := compiler.where(filter)
+	if err != errTextFilter {
+		if err != nil {
+			return 0, fmt.Errorf("could not compile filter: %v", err)
+		}
+		return countTasks(countTasksQuery+where, compiler.args)
+	}
+	if filter, err = resolveMe(filter, userUUID); err != nil {
+		return 0, err
+	}
+
+	var all []models.Task
+	if cache != nil && *cache != nil {
+		all = *cache
+	} else {
+		all, err = selectTasks(selectTasksQuery+allCondition, []interface{}{userUUID})
+		if err != nil {
+			return 0, err
+		}
+		if all == nil {
+			all = []models.Task{}
+		}
+		if cache != nil {
+			*cache = all
+		}
+	}
+	count := 0
+	for _, task := range all {
+		if query.Match(filter, task) {
+			count++
+		}
+	}
+	return count, nil
+}
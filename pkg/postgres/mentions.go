@@ -0,0 +1,83 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+
+	"github.com/lib/pq"
+
+	"github.com/Kolya59/todo-service/models"
+)
+
+// Mention is @login which does not continue a word, so e-mail addresses are not mentions
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_.@])@([\p{L}\p{N}_][\p{L}\p{N}_.-]*[\p{L}\p{N}_]|[\p{L}\p{N}_])`)
+
+// Logins of mentioned users, each once in order of appearance
+func parseMentions(value string) []string {
+	var logins []string
+	seen := make(map[string]bool)
+	for _, match := range mentionPattern.FindAllStringSubmatch(value, -1) {
+		if login := match[1]; !seen[login] {
+			seen[login] = true
+			logins = append(logins, login)
+		}
+	}
+	return logins
+}
+
+// Logins of mentioned users as array column, used in comment selects aliased as c
+const mentionsColumn = "ARRAY(SELECT mu.login FROM public.comment_mentions cm JOIN public.users mu ON mu.uuid = cm.user_uuid " +
+	"WHERE cm.comment_uuid = c.uuid ORDER BY mu.login)"
+
+// Only users who see the task are mentioned, author does not mention itself
+var (
+	selectMentionedQuery = "SELECT u.uuid, u.login FROM public.users u JOIN public.tasks t ON t.uuid = $1 " +
+		"WHERE u.login = ANY($2) AND u.uuid <> $3 AND " + readAccess("u.uuid") + " ORDER BY u.login"
+	insertMentionQuery = "INSERT INTO public.comment_mentions(comment_uuid, user_uuid) VALUES ($1, $2) ON CONFLICT DO NOTHING"
+)
+
+type querier interface {
+	execer
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Store mentions of the comment and notify mentioned users, logins of mentioned users are returned
+func insertMentions(q querier, comment models.Comment, authorUUID string) ([]string, error) {
+	logins := parseMentions(comment.Value)
+	if len(logins) == 0 {
+		return nil, nil
+	}
+	rows, err := q.Query(selectMentionedQuery, comment.TaskId, pq.Array(logins), authorUUID)
+	if err != nil {
+		return nil, fmt.Errorf("could not select mentioned users: %v", err)
+	}
+	defer rows.Close()
+	var userUUIDs, mentioned []string
+	for rows.Next() {
+		var userUUID, login string
+		if err = rows.Scan(&userUUID, &login); err != nil {
+			return nil, fmt.Errorf("could not read query: %v", err)
+		}
+		userUUIDs = append(userUUIDs, userUUID)
+		mentioned = append(mentioned, login)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not select mentioned users: %v", err)
+	}
+	for _, userUUID := range userUUIDs {
+		if _, err = q.Exec(insertMentionQuery, comment.UUID, userUUID); err != nil {
+			return nil, fmt.Errorf("could not insert mention: %v", err)
+		}
+		err = insertNotification(q, models.Notification{
+			UserUUID:    userUUID,
+			Kind:        models.NotificationMention,
+			TaskUUID:    &comment.TaskId,
+			CommentUUID: &comment.UUID,
+		}, authorUUID, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return mentioned, nil
+}
@@ -0,0 +1,189 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/Kolya59/todo-service/models"
+	"github.com/Kolya59/todo-service/pkg/authz"
+)
+
+// Notifications are kept in the inbox of user and delivered by notifier at least once like reminders, reminders are
+// put into the inbox already delivered. Task, comment and project are joined only while the user sees them,
+// their values are encrypted with data key of the owner.
+var (
+	insertNotificationQuery = "INSERT INTO public.notifications(uuid, user_uuid, kind, actor_uuid, task_uuid, comment_uuid, project_uuid, delivered_at) " +
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, CASE WHEN $8 THEN NULL ELSE now() END)"
+	selectNotificationsColumns = "SELECT n.uuid, n.user_uuid, n.kind, a.login, n.task_uuid, t.value, t.author_uuid, n.comment_uuid, c.value, " +
+		"n.project_uuid, p.name, p.owner_uuid, n.read_at, n.created_at, n.attempts " +
+		"FROM public.notifications n LEFT JOIN public.users a ON a.uuid = n.actor_uuid " +
+		"LEFT JOIN public.tasks t ON t.uuid = n.task_uuid AND " + readAccess("n.user_uuid") + " " +
+		"LEFT JOIN public.comments c ON c.uuid = n.comment_uuid AND c.task_uuid = t.uuid " +
+		"LEFT JOIN public.projects p ON p.uuid = n.project_uuid AND (" + projectAccess("n.user_uuid", authz.View) + " OR " +
+		"EXISTS (SELECT 1 FROM public.shares s WHERE s.project_uuid = p.uuid AND s.user_uuid = n.user_uuid)) "
+	selectNotificationsQuery = selectNotificationsColumns + "WHERE n.user_uuid = $1 AND (NOT $3 OR n.read_at IS NULL) " +
+		"ORDER BY n.created_at DESC, n.uuid LIMIT $2"
+	selectNotificationQuery = selectNotificationsColumns + "WHERE n.uuid = $1"
+	countUnreadQuery        = "SELECT count(*) FROM public.notifications WHERE user_uuid = $1 AND read_at IS NULL"
+	markReadQuery           = "UPDATE public.notifications SET read_at = COALESCE(read_at, now()) WHERE uuid = $1 AND user_uuid = $2"
+	markAllReadQuery        = "UPDATE public.notifications SET read_at = now() WHERE user_uuid = $1 AND read_at IS NULL"
+	claimNotificationsQuery = "UPDATE public.notifications n SET claimed_until = now() + $1 * interval '1 second', attempts = n.attempts + 1 " +
+		"WHERE n.uuid IN (SELECT uuid FROM public.notifications WHERE delivered_at IS NULL AND " +
+		"(claimed_until IS NULL OR claimed_until < now()) ORDER BY created_at LIMIT $2 FOR UPDATE SKIP LOCKED) " +
+		"RETURNING n.uuid, n.user_uuid, n.attempts"
+	markNotificationDeliveredQuery = "UPDATE public.notifications SET delivered_at = now(), claimed_until = NULL " +
+		"WHERE uuid = $1 AND delivered_at IS NULL"
+)
+
+// Put notification into the inbox of user, it is also sent by notifier when deliver is set.
+// Users are not notified about their own changes.
+func insertNotification(e execer, n models.Notification, actorUUID string, deliver bool) error {
+	if n.UserUUID == actorUUID {
+		return nil
+	}
+	var actor *string
+	if actorUUID != "" {
+		actor = &actorUUID
+	}
+	_, err := e.Exec(insertNotificationQuery, uuid.NewV4().String(), n.UserUUID, n.Kind, actor, n.TaskUUID, n.CommentUUID, n.ProjectUUID, deliver)
+	if err != nil {
+		return fmt.Errorf("could not insert notification: %v", err)
+	}
+	return nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanNotification(row scanner) (models.Notification, error) {
+	n := models.Notification{}
+	var actor, taskOwnerUUID, projectOwnerUUID *string
+	err := row.Scan(&n.UUID, &n.UserUUID, &n.Kind, &actor, &n.TaskUUID, &n.Task, &taskOwnerUUID, &n.CommentUUID, &n.Comment,
+		&n.ProjectUUID, &n.Project, &projectOwnerUUID, &n.ReadAt, &n.CreatedAt, &n.Attempts)
+	if err != nil {
+		return models.Notification{}, err
+	}
+	if actor != nil {
+		n.Actor = *actor
+	}
+	values := []struct {
+		value *string
+		owner *string
+	}{{n.Task, taskOwnerUUID}, {n.Comment, taskOwnerUUID}, {n.Project, projectOwnerUUID}}
+	for _, v := range values {
+		if v.value == nil || v.owner == nil {
+			continue
+		}
+		if *v.value, err = decryptValue(*v.owner, *v.value); err != nil {
+			return models.Notification{}, fmt.Errorf("could not decrypt notification: %v", err)
+		}
+	}
+	return n, nil
+}
+
+// Select latest notifications of user, only unread ones when unread is set
+func SelectNotifications(userUUID string, limit int, unread bool) (notifications []models.Notification, err error) {
+	selectNotifications, err := db.Prepare(selectNotificationsQuery)
+	if err != nil {
+		return nil, fmt.Errorf("could not prepare select notifications query: %v", err)
+	}
+	defer func() {
+		if err := selectNotifications.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close database connection")
+		}
+	}()
+	rows, err := selectNotifications.Query(userUUID, limit, unread)
+	if err != nil {
+		return nil, fmt.Errorf("could not select notifications: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		n, err := scanNotification(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not read query: %v", err)
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, nil
+}
+
+// Count unread notifications of user
+func CountUnreadNotifications(userUUID string) (count int, err error) {
+	if err = db.QueryRow(countUnreadQuery, userUUID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("could not count notifications: %v", err)
+	}
+	return count, nil
+}
+
+// Mark notification of user as read, reading it again keeps the first time
+func MarkNotificationRead(userUUID string, notificationUUID string) error {
+	res, err := db.Exec(markReadQuery, notificationUUID, userUUID)
+	if err != nil {
+		return fmt.Errorf("could not mark notification as read: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Mark all notifications of user as read
+func MarkAllNotificationsRead(userUUID string) error {
+	if _, err := db.Exec(markAllReadQuery, userUUID); err != nil {
+		return fmt.Errorf("could not mark notifications as read: %v", err)
+	}
+	return nil
+}
+
+// Select notification for delivery
+func SelectNotification(notificationUUID string) (models.Notification, error) {
+	n, err := scanNotification(db.QueryRow(selectNotificationQuery, notificationUUID))
+	if err == sql.ErrNoRows {
+		return models.Notification{}, ErrNotFound
+	}
+	if err != nil {
+		return models.Notification{}, fmt.Errorf("could not select notification: %v", err)
+	}
+	return n, nil
+}
+
+// Claim undelivered notifications for lease, they become available again when the lease expires
+func ClaimNotifications(lease time.Duration, limit int) (notifications []models.Notification, err error) {
+	claimNotifications, err := db.Prepare(claimNotificationsQuery)
+	if err != nil {
+		return nil, fmt.Errorf("could not prepare claim notifications query: %v", err)
+	}
+	defer func() {
+		if err := claimNotifications.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close database connection")
+		}
+	}()
+	rows, err := claimNotifications.Query(int64(lease.Seconds()), limit)
+	if err != nil {
+		return nil, fmt.Errorf("could not claim notifications: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		n := models.Notification{}
+		if err = rows.Scan(&n.UUID, &n.UserUUID, &n.Attempts); err != nil {
+			return nil, fmt.Errorf("could not read query: %v", err)
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, nil
+}
+
+// Mark notification as delivered by notifier
+func MarkNotificationDelivered(notificationUUID string) error {
+	if _, err := db.Exec(markNotificationDeliveredQuery, notificationUUID); err != nil {
+		return fmt.Errorf("could not mark notification as delivered: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package postgres
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/Kolya59/todo-service/models"
+	"github.com/Kolya59/todo-service/pkg/query"
+)
+
+// Listing holds own tasks and tasks shared with the user
+var (
+	selectTasksQuery = "SELECT t.uuid, t.value, t.is_resolved, t.due_date, t.created_at, " + labelsColumn +
+		", t.project_uuid, t.author_uuid, u.login, " + roleColumn("$1") + ", " + assigneesColumn + ", t.version, COALESCE(t.priority, '')" +
+		" FROM public.tasks t JOIN public.users u ON u.uuid = t.author_uuid WHERE " + readAccess("$1")
+	countTasksQuery = "SELECT count(*) FROM public.tasks t WHERE " + readAccess("$1")
+)
+
+// Tasks are ordered by (created_at, uuid) from the newest, pages are selected by keyset of the edge task
+const (
+	afterCondition  = " AND (t.created_at, t.uuid) < (%s, %s) ORDER BY t.created_at DESC, t.uuid DESC LIMIT %s"
+	beforeCondition = " AND (t.created_at, t.uuid) > (%s, %s) ORDER BY t.created_at ASC, t.uuid ASC LIMIT %s"
+	firstCondition  = " ORDER BY t.created_at DESC, t.uuid DESC LIMIT %s"
+	allCondition    = " ORDER BY t.created_at DESC, t.uuid DESC"
+)
+
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 200
+)
+
+// ErrInvalidCursor is returned for cursor which was not produced by SelectTasksPage
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor points to the edge task of a page and tells whether tasks after or before it are requested
+type pageCursor struct {
+	before    bool
+	createdAt time.Time
+	uuid      string
+}
+
+func (c pageCursor) String() string {
+	direction := "a"
+	if c.before {
+		direction = "b"
+	}
+	micros := c.createdAt.Unix()*1e6 + int64(c.createdAt.Nanosecond()/1e3)
+	raw := fmt.Sprintf("%s|%d|%s", direction, micros, c.uuid)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func parseCursor(s string) (pageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return pageCursor{}, ErrInvalidCursor
+	}
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 3 || (parts[0] != "a" && parts[0] != "b") {
+		return pageCursor{}, ErrInvalidCursor
+	}
+	micros, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return pageCursor{}, ErrInvalidCursor
+	}
+	if _, err = uuid.FromString(parts[2]); err != nil {
+		return pageCursor{}, ErrInvalidCursor
+	}
+	return pageCursor{
+		before:    parts[0] == "b",
+		createdAt: time.Unix(micros/1e6, (micros%1e6)*1e3).UTC(),
+		uuid:      parts[2],
+	}, nil
+}
+
+func taskCursor(task models.Task, before bool) string {
+	return pageCursor{before: before, createdAt: task.CreatedAt, uuid: task.UUID}.String()
+}
+
+// Position of task relative to cursor in the tasks order: -1 for newer tasks, 1 for older ones
+func comparePosition(task models.Task, c pageCursor) int {
+	switch {
+	case task.CreatedAt.After(c.createdAt):
+		return -1
+	case task.CreatedAt.Before(c.createdAt):
+		return 1
+	case task.UUID > c.uuid:
+		return -1
+	case task.UUID < c.uuid:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Select page of tasks matching filter starting after or before the cursor, empty cursor selects the first page
+func SelectTasksPage(userUUID string, filter query.Node, cursor string, limit int) (page models.TaskPage, err error) {
+	if limit <= 0 {
+		limit = DefaultPageLimit
+	}
+	if limit > MaxPageLimit {
+		limit = MaxPageLimit
+	}
+	var c pageCursor
+	if cursor != "" {
+		c, err = parseCursor(cursor)
+		if err != nil {
+			return models.TaskPage{}, err
+		}
+	}
+
+	compiler := &filterCompiler{args: []interface{}{userUUID}, text: !EncryptionEnabled()}
+	where, err := compiler.where(filter)
+	if err == errTextFilter {
+		return selectTasksPageInMemory(userUUID, filter, c, cursor != "", limit)
+	}
+	if err != nil {
+		return models.TaskPage{}, fmt.Errorf("could not compile filter: %v", err)
+	}
+
+	total, err := countTasks(countTasksQuery+where, compiler.args)
+	if err != nil {
+		return models.TaskPage{}, err
+	}
+	// One extra row tells whether there is a page further in the same direction
+	var keyset string
+	switch {
+	case cursor == "":
+		keyset = fmt.Sprintf(firstCondition, compiler.arg(limit+1))
+	case c.before:
+		keyset = fmt.Sprintf(beforeCondition, compiler.arg(c.createdAt), compiler.arg(c.uuid), compiler.arg(limit+1))
+	default:
+		keyset = fmt.Sprintf(afterCondition, compiler.arg(c.createdAt), compiler.arg(c.uuid), compiler.arg(limit+1))
+	}
+	tasks, err := selectTasks(selectTasksQuery+where+keyset, compiler.args)
+	if err != nil {
+		return models.TaskPage{}, err
+	}
+	return makePage(tasks, total, c, cursor != "", limit), nil
+}
+
+// Filter over encrypted text is matched after decryption of all tasks of the user
+func selectTasksPageInMemory(userUUID string, filter query.Node, c pageCursor, hasCursor bool, limit int) (models.TaskPage, error) {
+	filter, err := resolveMe(filter, userUUID)
+	if err != nil {
+		return models.TaskPage{}, err
+	}
+	all, err := selectTasks(selectTasksQuery+allCondition, []interface{}{userUUID})
+	if err != nil {
+		return models.TaskPage{}, err
+	}
+	var matched []models.Task
+	for _, task := range all {
+		if query.Match(filter, task) {
+			matched = append(matched, task)
+		}
+	}
+
+	// Same rows as keyset query would return
+	var tasks []models.Task
+	switch {
+	case !hasCursor:
+		tasks = matched
+	case c.before:
+		newer := 0
+		for newer < len(matched) && comparePosition(matched[newer], c) < 0 {
+			newer++
+		}
+		for i := newer - 1; i >= 0; i-- {
+			tasks = append(tasks, matched[i])
+		}
+	default:
+		for i := range matched {
+			if comparePosition(matched[i], c) > 0 {
+				tasks = matched[i:]
+				break
+			}
+		}
+	}
+	if len(tasks) > limit+1 {
+		tasks = tasks[:limit+1]
+	}
+	return makePage(tasks, len(matched), c, hasCursor, limit), nil
+}
+
+// Make page of rows selected by keyset query, rows before cursor come in ascending order
+func makePage(tasks []models.Task, total int, c pageCursor, hasCursor bool, limit int) models.TaskPage {
+	page := models.TaskPage{Tasks: tasks, Total: total, Limit: limit}
+	more := len(page.Tasks) > limit
+	if more {
+		page.Tasks = page.Tasks[:limit]
+	}
+	if c.before {
+		for i, j := 0, len(page.Tasks)-1; i < j; i, j = i+1, j-1 {
+			page.Tasks[i], page.Tasks[j] = page.Tasks[j], page.Tasks[i]
+		}
+	}
+	if n := len(page.Tasks); n > 0 {
+		// Page reached by cursor always has a neighbour in the opposite direction
+		if (!c.before && more) || (c.before && hasCursor) {
+			page.Next = taskCursor(page.Tasks[n-1], false)
+		}
+		if (c.before && more) || (!c.before && hasCursor) {
+			page.Prev = taskCursor(page.Tasks[0], true)
+		}
+	}
+	return page
+}
+
+func selectTasks(q string, args []interface{}) (tasks []models.Task, err error) {
+	selectTasks, err := db.Prepare(q)
+	if err != nil {
+		return nil, fmt.Errorf("could not prepare select tasks query: %v", err)
+	}
+	defer func() {
+		if err := selectTasks.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close database connection")
+		}
+	}()
+	rows, err := selectTasks.Query(args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not select tasks: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		task := models.Task{}
+		var ownerUUID string
+		err = rows.Scan(&task.UUID, &task.Value, &task.IsResolved, &task.DueDate, &task.CreatedAt, pq.Array(&task.Labels),
+			&task.ProjectUUID, &ownerUUID, &task.Author, &task.Role, pq.Array(&task.Assignees), &task.Version, &task.Priority)
+		if err != nil {
+			return nil, fmt.Errorf("could not read query: %v", err)
+		}
+		task.Value, err = decryptValue(ownerUUID, task.Value)
+		if err != nil {
+			return nil, fmt.Errorf("could not decrypt task: %v", err)
+		}
+		tasks = append(tasks, task)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not select tasks: %v", err)
+	}
+	return tasks, nil
+}
+
+func countTasks(q string, args []interface{}) (total int, err error) {
+	countTasks, err := db.Prepare(q)
+	if err != nil {
+		return 0, fmt.Errorf("could not prepare count tasks query: %v", err)
+	}
+	defer func() {
+		if err := countTasks.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close database connection")
+		}
+	}()
+	if err = countTasks.QueryRow(args...).Scan(&total); err != nil {
+		return 0, fmt.Errorf("could not count tasks: %v", err)
+	}
+	return total, nil
+}
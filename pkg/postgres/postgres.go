@@ -4,34 +4,49 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/rs/zerolog/log"
 	uuid "github.com/satori/go.uuid"
 
 	"golang.org/x/crypto/bcrypt"
 
-	"github.com/kolya59/todo-service/models"
+	"github.com/Kolya59/todo-service/models"
 )
 
 const (
-	selectAllTasksQuery = "SELECT uuid, value, is_resolved FROM public.tasks WHERE author_uuid = $1"
-	selectTaskQuery     = "SELECT value, is_resolved FROM public.tasks WHERE author_uuid = $1 AND uuid = $2"
-	insertTaskQuery     = "INSERT INTO public.tasks(uuid, value, author_uuid, is_resolved) VALUES ($1, $2, $3, $4)"
-	updateTaskQuery     = "UPDATE public.tasks SET is_resolved = $3 WHERE uuid = $1 AND author_uuid = $2"
-	deleteTaskQuery     = "DELETE FROM public.tasks WHERE uuid = $1 AND author_uuid = $2"
-	selectUserQuery     = "SELECT uuid, password, salt FROM public.users WHERE login = $1"
-	insertUserQuery     = "INSERT INTO public.users(uuid, login, password, salt) VALUES ($1, $2, $3, $4)"
-	selectLoginByUUID   = "SELECT login FROM public.users WHERE uuid = $1"
+	insertTaskQuery   = "INSERT INTO public.tasks(uuid, value, author_uuid, is_resolved, due_date, search_vector) VALUES ($1, $2, $3, $4, $5, to_tsvector($6::regconfig, $7)) RETURNING created_at"
+	selectUserQuery   = "SELECT uuid, password, salt FROM public.users WHERE login = $1"
+	insertUserQuery   = "INSERT INTO public.users(uuid, login, password, salt) VALUES ($1, $2, $3, $4)"
+	selectLoginByUUID = "SELECT login FROM public.users WHERE uuid = $1"
+)
+
+// Shared tasks are read by viewers and changed by editors, moving them is limited by moveAccess.
+// Tasks of workspace are also deleted by members allowed to delete tasks of others.
+var (
+	selectTaskQuery = "SELECT t.value, t.is_resolved, t.due_date, t.created_at, " + labelsColumn + ", t.project_uuid, t.author_uuid, u.login, " +
+		roleColumn("$1") + ", " + assigneesColumn + ", t.version, COALESCE(t.priority, '') FROM public.tasks t JOIN public.users u ON u.uuid = t.author_uuid WHERE t.uuid = $2 AND " + readAccess("$1")
+	updateTaskQuery         = "UPDATE public.tasks t SET is_resolved = $3 WHERE t.uuid = $1 AND " + writeAccess("$2")
+	updateTaskDueQuery      = "UPDATE public.tasks t SET due_date = $3 WHERE t.uuid = $1 AND " + writeAccess("$2")
+	updateTaskPriorityQuery = "UPDATE public.tasks t SET priority = $3 WHERE t.uuid = $1 AND " + writeAccess("$2")
+	updateTaskValueQuery    = "UPDATE public.tasks t SET value = $3, search_vector = to_tsvector($4::regconfig, $5) WHERE t.uuid = $1 AND " + writeAccess("$2")
+	deleteTaskQuery         = "DELETE FROM public.tasks t WHERE t.uuid = $1 AND " + deleteAccess("$2")
 )
 
 var db *sql.DB
 
+// Connection string of db, listeners of notifications open their own connections
+var connInfo string
+
+// ErrNotFound is returned when requested row does not exist or belongs to another user
+var ErrNotFound = errors.New("not found")
+
 // Init database
 func InitDatabaseConnection(host string, port string, user string, password string, name string) (err error) {
 	// Open connection
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", host, port, user, password, name)
-	db, err = sql.Open("postgres", connStr)
+	connInfo = fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", host, port, user, password, name)
+	db, err = sql.Open("postgres", connInfo)
 	if err != nil {
 		return fmt.Errorf("could not open database connection: %v", err)
 	}
@@ -55,8 +70,7 @@ func SelectLoginByUUID(uuid string) (login string, err error) {
 		return "", fmt.Errorf("could not prepare select login query: %v", err)
 	}
 	defer func() {
-		err = selectLogin.Close()
-		if err != nil {
+		if err := selectLogin.Close(); err != nil {
 			log.Error().Msgf("Could not close database connection: %v", err)
 		}
 	}()
@@ -70,42 +84,6 @@ func SelectLoginByUUID(uuid string) (login string, err error) {
 	return login, nil
 }
 
-// Select all tasks from database
-func SelectAllTasks(userUUID string) (tasks []models.Task, err error) {
-	// Initialize
-	selectAllTask, err := db.Prepare(selectAllTasksQuery)
-	if err != nil {
-		return nil, fmt.Errorf("could not prepare select all query: %v task", err)
-	}
-	defer func() {
-		err = selectAllTask.Close()
-		if err != nil {
-			log.Error().Msgf("Could not close database connection: %v", err)
-		}
-	}()
-	login, err := SelectLoginByUUID(userUUID)
-	if err != nil {
-		return nil, fmt.Errorf("could not get login: %v", err)
-	}
-
-	task := models.Task{Author: login}
-	// Execute query
-	rows, err := selectAllTask.Query(userUUID)
-	if err != nil {
-		return nil, fmt.Errorf("could not select all tasks: %v", err)
-	}
-
-	// Fill collection
-	for rows.Next() {
-		err = rows.Scan(&task.UUID, &task.Value, &task.IsResolved)
-		tasks = append(tasks, task)
-		if err != nil {
-			return nil, fmt.Errorf("could not read query: %v", err)
-		}
-	}
-	return tasks, nil
-}
-
 // Select task
 func SelectTask(userUUID string, taskUUID string) (task models.Task, err error) {
 	// Initialize
@@ -114,86 +92,222 @@ func SelectTask(userUUID string, taskUUID string) (task models.Task, err error)
 		return models.Task{}, fmt.Errorf("could not prepare select task query: %v", err)
 	}
 	defer func() {
-		err = selectTask.Close()
-		if err != nil {
+		if err := selectTask.Close(); err != nil {
 			log.Error().Msgf("Could not close database connection: %v", err)
 		}
 	}()
 
-	login, err := SelectLoginByUUID(userUUID)
-	if err != nil {
-		return models.Task{}, fmt.Errorf("could not get login: %v", err)
-	}
-
 	task.UUID = taskUUID
-	task.Author = login
 
 	// Select task
+	var ownerUUID string
 	err = selectTask.QueryRow(userUUID, task.UUID).Scan(
 		&task.Value,
 		&task.IsResolved,
+		&task.DueDate,
+		&task.CreatedAt,
+		pq.Array(&task.Labels),
+		&task.ProjectUUID,
+		&ownerUUID,
+		&task.Author,
+		&task.Role,
+		pq.Array(&task.Assignees),
+		&task.Version,
+		&task.Priority,
 	)
 
+	if err == sql.ErrNoRows {
+		return models.Task{}, ErrNotFound
+	}
 	if err != nil {
 		return models.Task{}, fmt.Errorf("could not select task: %v", err)
 	}
-	task.Comments = models.GenerateComments(task.UUID)
+	task.Value, err = decryptValue(ownerUUID, task.Value)
+	if err != nil {
+		return models.Task{}, fmt.Errorf("could not decrypt task: %v", err)
+	}
+	task.Comments, err = SelectComments(userUUID, task.UUID)
+	if err != nil {
+		return models.Task{}, fmt.Errorf("could not select comments: %v", err)
+	}
 
 	return task, nil
 }
 
-// Insert new task into database
-func InsertTask(value string, author string, isResolved bool) (task models.Task, err error) {
-	insertTask, err := db.Prepare(insertTaskQuery)
-	if err != nil {
-		return models.Task{}, fmt.Errorf("could not prepare insert query: %v", err)
+// TaskChange holds fields of task changed together, nil fields are kept. Nullable fields are changed when their
+// flag is set: nil due date and project and empty priority clear them.
+type TaskChange struct {
+	Value       *string
+	IsResolved  *bool
+	DueDate     *time.Time
+	SetDueDate  bool
+	Priority    string
+	SetPriority bool
+	Labels      *[]string
+	ProjectUUID *string
+	SetProject  bool
+	// Version the change is based on, nil changes any version
+	Version *int64
+}
+
+// Insert new task with fields of change in a single transaction, nothing is inserted when any of them fails.
+// Value of change is required, unset fields are left empty.
+func InsertTask(author string, change TaskChange) (task models.Task, err error) {
+	if change.Value == nil {
+		return models.Task{}, errors.New("value of task is required")
 	}
-	defer func() {
-		err = insertTask.Close()
-		if err != nil {
-			log.Error().Err(err).Msgf("Could not close database connection")
+	labels := []string{}
+	if change.Labels != nil {
+		if labels, err = NormalizeLabels(*change.Labels); err != nil {
+			return models.Task{}, err
 		}
-	}()
-	id := uuid.NewV4()
-	if err != nil {
-		return models.Task{}, fmt.Errorf("could not generate uuid: %v", err)
 	}
 	login, err := SelectLoginByUUID(author)
 	if err != nil {
 		return models.Task{}, fmt.Errorf("could not get login: %v", err)
 	}
-	_, err = insertTask.Exec(id.String(), value, author, isResolved)
+	storedValue, err := encryptValue(author, *change.Value)
+	if err != nil {
+		return models.Task{}, fmt.Errorf("could not encrypt task: %v", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return models.Task{}, fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer func() {
+		if err != nil {
+			if err := tx.Rollback(); err != nil {
+				log.Error().Err(err).Msg("Could not rollback transaction")
+			}
+		}
+	}()
+
+	id := uuid.NewV4().String()
+	isResolved := change.IsResolved != nil && *change.IsResolved
+	var createdAt time.Time
+	err = tx.QueryRow(insertTaskQuery, id, storedValue, author, isResolved, change.DueDate, searchLanguage, indexedText(*change.Value)).Scan(&createdAt)
 	if err != nil {
 		return models.Task{}, fmt.Errorf("could not insert task into database: %v", err)
 	}
+	// The rest is changed as for existing task, so unknown project rolls back the task as well
+	rest := TaskChange{Priority: change.Priority, SetPriority: change.Priority != "", ProjectUUID: change.ProjectUUID, SetProject: change.ProjectUUID != nil}
+	if len(labels) > 0 {
+		rest.Labels = &labels
+	}
+	if err = changeTask(tx, id, author, author, rest); err != nil {
+		return models.Task{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return models.Task{}, fmt.Errorf("could not commit task: %v", err)
+	}
 	log.Info().Msgf("Task with uuid = %s is added in database", id)
 	return models.Task{
-		UUID:       id.String(),
-		Author:     login,
-		Value:      value,
-		IsResolved: false,
-		Comments:   nil,
+		UUID:        id,
+		Author:      login,
+		Value:       *change.Value,
+		IsResolved:  isResolved,
+		DueDate:     change.DueDate,
+		CreatedAt:   createdAt,
+		Labels:      labels,
+		Priority:    change.Priority,
+		ProjectUUID: change.ProjectUUID,
+		Role:        models.RoleOwner,
+		Comments:    nil,
 	}, nil
 }
 
-// Update task status
-func UpdateTask(taskId string, authorId string, isResolved bool) (err error) {
-	updateTask, err := db.Prepare(updateTaskQuery)
+// Update fields of task in a single transaction, nothing is changed when any of them fails. Version of the task
+// is bumped with the change and returned with its project for the event of the change.
+func UpdateTaskFields(taskId string, userId string, change TaskChange) (version int64, projectUUID *string, err error) {
+	if change.Labels != nil {
+		labels, err := NormalizeLabels(*change.Labels)
+		if err != nil {
+			return 0, nil, err
+		}
+		change.Labels = &labels
+	}
+	tx, err := db.Begin()
 	if err != nil {
-		return fmt.Errorf("could not prepare update query: %v", err)
+		return 0, nil, fmt.Errorf("could not begin transaction: %v", err)
 	}
 	defer func() {
-		err = updateTask.Close()
 		if err != nil {
-			log.Error().Err(err).Msgf("Could not close database connection")
+			if err := tx.Rollback(); err != nil {
+				log.Error().Err(err).Msg("Could not rollback transaction")
+			}
 		}
 	}()
 
-	_, err = updateTask.Exec(taskId, authorId, isResolved)
+	// Task is locked until commit, value of shared task stays encrypted with data key of its owner
+	var ownerId string
+	err = tx.QueryRow(lockTaskOwnerQuery, taskId, userId).Scan(&ownerId)
+	if err == sql.ErrNoRows {
+		return 0, nil, taskMissing(tx, taskId, userId)
+	}
 	if err != nil {
-		return fmt.Errorf("could not update task in database: %v", err)
+		return 0, nil, fmt.Errorf("could not select task: %v", err)
+	}
+	// Task is locked, so no change comes between the check of version and the change
+	err = tx.QueryRow(bumpExpectedVersionQuery, taskId, change.Version).Scan(&version, &projectUUID)
+	if err == sql.ErrNoRows {
+		return 0, nil, ErrVersionConflict
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("could not bump task version: %v", err)
+	}
+	if err = changeTask(tx, taskId, userId, ownerId, change); err != nil {
+		return 0, nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, nil, fmt.Errorf("could not commit task: %v", err)
+	}
+	if change.SetProject {
+		projectUUID = change.ProjectUUID
+	}
+	log.Info().Msgf("Task with uuid = %s is updated in database", taskId)
+	return version, projectUUID, nil
+}
+
+// Apply change to task within transaction, labels of change have to be normalized
+func changeTask(tx *sql.Tx, taskId string, userId string, ownerId string, change TaskChange) error {
+	if change.Value != nil {
+		storedValue, err := encryptValue(ownerId, *change.Value)
+		if err != nil {
+			return fmt.Errorf("could not encrypt task: %v", err)
+		}
+		if err = affectTask(tx, updateTaskValueQuery, taskId, userId, storedValue, searchLanguage, indexedText(*change.Value)); err != nil {
+			return err
+		}
+	}
+	if change.IsResolved != nil {
+		if err := affectTask(tx, updateTaskQuery, taskId, userId, *change.IsResolved); err != nil {
+			return err
+		}
+	}
+	if change.SetDueDate {
+		if err := affectTask(tx, updateTaskDueQuery, taskId, userId, change.DueDate); err != nil {
+			return err
+		}
+	}
+	if change.SetPriority {
+		priority := sql.NullString{String: change.Priority, Valid: change.Priority != ""}
+		if err := affectTask(tx, updateTaskPriorityQuery, taskId, userId, priority); err != nil {
+			return err
+		}
+	}
+	if change.Labels != nil {
+		if _, err := tx.Exec(deleteTaskLabelsQuery, taskId); err != nil {
+			return fmt.Errorf("could not delete labels: %v", err)
+		}
+		for _, label := range *change.Labels {
+			if _, err := tx.Exec(insertTaskLabelQuery, taskId, label); err != nil {
+				return fmt.Errorf("could not insert label: %v", err)
+			}
+		}
+	}
+	if change.SetProject {
+		return moveTask(tx, userId, taskId, change.ProjectUUID)
 	}
-	log.Info().Msgf("Task with uuid = %s is updated in database with value %v", taskId, isResolved)
 	return nil
 }
 
@@ -204,15 +318,17 @@ func DeleteTask(userId string, taskId string) (err error) {
 		return fmt.Errorf("could not prepare delete query: %v", err)
 	}
 	defer func() {
-		err = deleteTask.Close()
-		if err != nil {
+		if err := deleteTask.Close(); err != nil {
 			log.Error().Err(err).Msg("Could not close database connection:")
 		}
 	}()
-	_, err = deleteTask.Exec(taskId, userId)
+	res, err := deleteTask.Exec(taskId, userId)
 	if err != nil {
 		return fmt.Errorf("could not delete task: %v", err)
 	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return taskMissing(db, taskId, userId)
+	}
 	log.Info().Msgf("Task with taskId = %s has been deleted", taskId)
 	return nil
 }
@@ -224,8 +340,7 @@ func SignUp(login string, password string) (string, error) {
 		return "", fmt.Errorf("could not prepare insert query: %v", err)
 	}
 	defer func() {
-		err = insertUser.Close()
-		if err != nil {
+		if err := insertUser.Close(); err != nil {
 			log.Error().Err(err).Msgf("Could not close database connection:")
 		}
 	}()
@@ -262,8 +377,7 @@ func SignIn(login string, password string) (string, error) {
 		return "", fmt.Errorf("could not prepare select user query: %v", err)
 	}
 	defer func() {
-		err = selectUser.Close()
-		if err != nil {
+		if err := selectUser.Close(); err != nil {
 			log.Error().Msgf("Could not close database connection: %v", err)
 		}
 	}()
@@ -0,0 +1,144 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/Kolya59/todo-service/models"
+	"github.com/Kolya59/todo-service/pkg/authz"
+)
+
+// Names of projects are encrypted with data key of their owner like task values. Tasks of deleted project are kept
+// out of any project. Project of workspace is reachable only through membership, also for its owner.
+func projectAccess(user string, action authz.Action) string {
+	return "((p.workspace_uuid IS NULL AND p.owner_uuid = " + user + ") OR " + memberCan(user, "p.workspace_uuid", action) + ")"
+}
+
+const insertProjectQuery = "INSERT INTO public.projects(uuid, owner_uuid, name, workspace_uuid) VALUES ($1, $2, $3, $4) RETURNING created_at"
+
+var (
+	selectProjectsQuery = "SELECT p.uuid, p.name, p.workspace_uuid, p.created_at, p.owner_uuid FROM public.projects p WHERE " +
+		projectAccess("$1", authz.View) + " ORDER BY p.created_at, p.uuid"
+	selectProjectOwnerQuery = "SELECT p.owner_uuid FROM public.projects p WHERE p.uuid = $1 AND " + projectAccess("$2", authz.ManageProjects)
+	renameProjectQuery      = "UPDATE public.projects p SET name = $3 WHERE p.uuid = $1 AND " + projectAccess("$2", authz.ManageProjects)
+	deleteProjectQuery      = "DELETE FROM public.projects p WHERE p.uuid = $1 AND " + projectAccess("$2", authz.ManageProjects)
+	// Tasks are moved into projects whose tasks user may edit
+	checkProjectQuery = "SELECT 1 FROM public.projects p WHERE p.uuid = $1 AND " + projectAccess("$2", authz.EditTasks)
+)
+
+// Insert project, nil workspace makes personal project
+func InsertProject(userUUID string, name string, workspaceUUID *string) (project models.Project, err error) {
+	insertProject, err := db.Prepare(insertProjectQuery)
+	if err != nil {
+		return models.Project{}, fmt.Errorf("could not prepare insert project query: %v", err)
+	}
+	defer func() {
+		if err := insertProject.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close database connection")
+		}
+	}()
+	storedName, err := encryptValue(userUUID, name)
+	if err != nil {
+		return models.Project{}, fmt.Errorf("could not encrypt project: %v", err)
+	}
+	project = models.Project{UUID: uuid.NewV4().String(), Name: name, WorkspaceUUID: workspaceUUID}
+	if err = insertProject.QueryRow(project.UUID, userUUID, storedName, workspaceUUID).Scan(&project.CreatedAt); err != nil {
+		return models.Project{}, fmt.Errorf("could not insert project into database: %v", err)
+	}
+	log.Info().Msgf("Project with uuid = %s is added in database", project.UUID)
+	return project, nil
+}
+
+// Select personal projects of user and projects of workspaces of user from the oldest
+func SelectProjects(userUUID string) (projects []models.Project, err error) {
+	selectProjects, err := db.Prepare(selectProjectsQuery)
+	if err != nil {
+		return nil, fmt.Errorf("could not prepare select projects query: %v", err)
+	}
+	defer func() {
+		if err := selectProjects.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close database connection")
+		}
+	}()
+	rows, err := selectProjects.Query(userUUID)
+	if err != nil {
+		return nil, fmt.Errorf("could not select projects: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		project := models.Project{}
+		var ownerUUID string
+		if err = rows.Scan(&project.UUID, &project.Name, &project.WorkspaceUUID, &project.CreatedAt, &ownerUUID); err != nil {
+			return nil, fmt.Errorf("could not read query: %v", err)
+		}
+		project.Name, err = decryptValue(ownerUUID, project.Name)
+		if err != nil {
+			return nil, fmt.Errorf("could not decrypt project: %v", err)
+		}
+		projects = append(projects, project)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not select projects: %v", err)
+	}
+	return projects, nil
+}
+
+// Rename project
+func RenameProject(userUUID string, projectUUID string, name string) (err error) {
+	renameProject, err := db.Prepare(renameProjectQuery)
+	if err != nil {
+		return fmt.Errorf("could not prepare rename project query: %v", err)
+	}
+	defer func() {
+		if err := renameProject.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close database connection")
+		}
+	}()
+	var ownerUUID string
+	err = db.QueryRow(selectProjectOwnerQuery, projectUUID, userUUID).Scan(&ownerUUID)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("could not select project: %v", err)
+	}
+	storedName, err := encryptValue(ownerUUID, name)
+	if err != nil {
+		return fmt.Errorf("could not encrypt project: %v", err)
+	}
+	res, err := renameProject.Exec(projectUUID, userUUID, storedName)
+	if err != nil {
+		return fmt.Errorf("could not rename project: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	log.Info().Msgf("Project with uuid = %s has been renamed", projectUUID)
+	return nil
+}
+
+// Delete project, its tasks are kept out of any project
+func DeleteProject(userUUID string, projectUUID string) (err error) {
+	deleteProject, err := db.Prepare(deleteProjectQuery)
+	if err != nil {
+		return fmt.Errorf("could not prepare delete project query: %v", err)
+	}
+	defer func() {
+		if err := deleteProject.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close database connection")
+		}
+	}()
+	res, err := deleteProject.Exec(projectUUID, userUUID)
+	if err != nil {
+		return fmt.Errorf("could not delete project: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	log.Info().Msgf("Project with uuid = %s has been deleted", projectUUID)
+	return nil
+}
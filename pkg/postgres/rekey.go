@@ -0,0 +1,116 @@
+package postgres
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Kolya59/todo-service/pkg/crypt"
+)
+
+const (
+	countStaleKeysQuery  = "SELECT count(*) FROM public.users WHERE data_key IS NOT NULL AND data_key_id IS DISTINCT FROM $1"
+	selectStaleKeysQuery = "SELECT uuid, data_key FROM public.users " +
+		"WHERE data_key IS NOT NULL AND data_key_id IS DISTINCT FROM $1 AND uuid > $2 ORDER BY uuid LIMIT $3"
+	// Compare old value so that concurrent rekey runs never overwrite each other
+	rewrapKeyQuery = "UPDATE public.users SET data_key = $2, data_key_id = $3 WHERE uuid = $1 AND data_key = $4"
+)
+
+// RekeyProgress is reported after every batch
+type RekeyProgress struct {
+	Total   int
+	Done    int
+	Skipped int
+}
+
+// Re-wrap data keys of all users with the primary master key. Data itself is encrypted by data keys
+// and stays untouched. Keys are processed in batches ordered by user uuid, every batch is committed
+// separately and re-wrapped keys are not selected again, so interrupted run is resumed by running again.
+func RekeyDataKeys(batchSize int, progress func(RekeyProgress)) (res RekeyProgress, err error) {
+	if keyRing.Empty() {
+		return res, crypt.ErrNoKey
+	}
+	primary := keyRing.Primary()
+	if err = db.QueryRow(countStaleKeysQuery, primary).Scan(&res.Total); err != nil {
+		return res, fmt.Errorf("could not count data keys: %v", err)
+	}
+	log.Info().Msgf("%v data keys are not wrapped by master key %q", res.Total, primary)
+
+	cursor := "00000000-0000-0000-0000-000000000000"
+	for {
+		n, last, err := rekeyBatch(primary, cursor, batchSize, &res)
+		if err != nil {
+			return res, err
+		}
+		if progress != nil {
+			progress(res)
+		}
+		if n < batchSize {
+			return res, nil
+		}
+		cursor = last
+	}
+}
+
+func rekeyBatch(primary string, cursor string, batchSize int, res *RekeyProgress) (n int, last string, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, "", fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer func() {
+		if err != nil {
+			if err := tx.Rollback(); err != nil {
+				log.Error().Err(err).Msg("Could not rollback transaction")
+			}
+		}
+	}()
+
+	rows, err := tx.Query(selectStaleKeysQuery, primary, cursor, batchSize)
+	if err != nil {
+		return 0, "", fmt.Errorf("could not select data keys: %v", err)
+	}
+	type staleKey struct {
+		userUUID string
+		wrapped  []byte
+	}
+	var keys []staleKey
+	for rows.Next() {
+		key := staleKey{}
+		if err = rows.Scan(&key.userUUID, &key.wrapped); err != nil {
+			rows.Close()
+			return 0, "", fmt.Errorf("could not read query: %v", err)
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, "", fmt.Errorf("could not select data keys: %v", err)
+	}
+
+	for _, key := range keys {
+		last = key.userUUID
+		plain, unwrapErr := keyRing.Unwrap(key.wrapped)
+		if unwrapErr != nil {
+			// Key wrapped by a master key missing in ring stays as is and is reported
+			log.Error().Err(unwrapErr).Msgf("Could not unwrap data key of user with uuid = %s", key.userUUID)
+			res.Skipped++
+			continue
+		}
+		wrapped, err := keyRing.Wrap(plain)
+		if err != nil {
+			return 0, "", fmt.Errorf("could not wrap data key: %v", err)
+		}
+		if bytes.Equal(wrapped, key.wrapped) {
+			continue
+		}
+		if _, err = tx.Exec(rewrapKeyQuery, key.userUUID, wrapped, primary, key.wrapped); err != nil {
+			return 0, "", fmt.Errorf("could not update data key: %v", err)
+		}
+		res.Done++
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, "", fmt.Errorf("could not commit transaction: %v", err)
+	}
+	return len(keys), last, nil
+}
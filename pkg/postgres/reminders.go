@@ -0,0 +1,179 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/Kolya59/todo-service/models"
+)
+
+const (
+	reminderFireAt = "COALESCE(r.remind_at, t.due_date - r.offset_seconds * interval '1 second')"
+
+	insertReminderQuery = "INSERT INTO public.reminders(uuid, task_uuid, user_uuid, remind_at, offset_seconds) " +
+		"SELECT $1, t.uuid, t.author_uuid, $4, $5 FROM public.tasks t WHERE t.uuid = $2 AND t.author_uuid = $3"
+	selectRemindersQuery = "SELECT r.uuid, r.remind_at, r.offset_seconds, " + reminderFireAt + ", r.sent_at, r.failed_at, r.attempts " +
+		"FROM public.reminders r JOIN public.tasks t ON t.uuid = r.task_uuid " +
+		"WHERE r.task_uuid = $1 AND r.user_uuid = $2 ORDER BY 4"
+	deleteReminderQuery = "DELETE FROM public.reminders WHERE uuid = $1 AND task_uuid = $2 AND user_uuid = $3"
+	// Lease due reminders so that concurrent schedulers and restarts never lose one:
+	// an expired lease without sent_at is picked up again until the reminder is failed
+	claimRemindersQuery = "UPDATE public.reminders r SET claimed_until = now() + $1 * interval '1 second', attempts = r.attempts + 1 " +
+		"FROM public.tasks t " +
+		"WHERE t.uuid = r.task_uuid AND r.uuid IN (" +
+		"SELECT r.uuid FROM public.reminders r JOIN public.tasks t ON t.uuid = r.task_uuid " +
+		"WHERE r.sent_at IS NULL AND r.failed_at IS NULL AND (r.claimed_until IS NULL OR r.claimed_until < now()) AND " + reminderFireAt + " <= now() " +
+		"ORDER BY " + reminderFireAt + " LIMIT $2 FOR UPDATE OF r SKIP LOCKED) " +
+		"RETURNING r.uuid, r.task_uuid, r.user_uuid, " + reminderFireAt + ", r.attempts"
+	markReminderSentQuery = "UPDATE public.reminders SET sent_at = now(), claimed_until = NULL WHERE uuid = $1 AND sent_at IS NULL " +
+		"RETURNING task_uuid, user_uuid"
+	markReminderFailedQuery = "UPDATE public.reminders SET failed_at = now(), claimed_until = NULL WHERE uuid = $1 AND sent_at IS NULL"
+)
+
+// Insert reminder for a task, either remindAt or offset (seconds before due date) must be set
+func InsertReminder(userUUID string, taskUUID string, remindAt *time.Time, offset *int64) (reminder models.Reminder, err error) {
+	insertReminder, err := db.Prepare(insertReminderQuery)
+	if err != nil {
+		return models.Reminder{}, fmt.Errorf("could not prepare insert reminder query: %v", err)
+	}
+	defer func() {
+		if err := insertReminder.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close database connection")
+		}
+	}()
+	id := uuid.NewV4()
+	res, err := insertReminder.Exec(id.String(), taskUUID, userUUID, remindAt, offset)
+	if err != nil {
+		return models.Reminder{}, fmt.Errorf("could not insert reminder into database: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return models.Reminder{}, fmt.Errorf("task %s is not found", taskUUID)
+	}
+	log.Info().Msgf("Reminder with uuid = %s is added in database", id)
+	return models.Reminder{
+		UUID:     id.String(),
+		TaskUUID: taskUUID,
+		UserUUID: userUUID,
+		RemindAt: remindAt,
+		Offset:   offset,
+	}, nil
+}
+
+// Select reminders of the task
+func SelectReminders(userUUID string, taskUUID string) (reminders []models.Reminder, err error) {
+	selectReminders, err := db.Prepare(selectRemindersQuery)
+	if err != nil {
+		return nil, fmt.Errorf("could not prepare select reminders query: %v", err)
+	}
+	defer func() {
+		if err := selectReminders.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close database connection")
+		}
+	}()
+	rows, err := selectReminders.Query(taskUUID, userUUID)
+	if err != nil {
+		return nil, fmt.Errorf("could not select reminders: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		reminder := models.Reminder{TaskUUID: taskUUID, UserUUID: userUUID}
+		err = rows.Scan(&reminder.UUID, &reminder.RemindAt, &reminder.Offset, &reminder.FireAt, &reminder.SentAt, &reminder.FailedAt, &reminder.Attempts)
+		if err != nil {
+			return nil, fmt.Errorf("could not read query: %v", err)
+		}
+		reminders = append(reminders, reminder)
+	}
+	return reminders, nil
+}
+
+// Delete reminder
+func DeleteReminder(userUUID string, taskUUID string, reminderUUID string) (err error) {
+	deleteReminder, err := db.Prepare(deleteReminderQuery)
+	if err != nil {
+		return fmt.Errorf("could not prepare delete reminder query: %v", err)
+	}
+	defer func() {
+		if err := deleteReminder.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close database connection")
+		}
+	}()
+	_, err = deleteReminder.Exec(reminderUUID, taskUUID, userUUID)
+	if err != nil {
+		return fmt.Errorf("could not delete reminder: %v", err)
+	}
+	log.Info().Msgf("Reminder with uuid = %s has been deleted", reminderUUID)
+	return nil
+}
+
+// Claim due reminders for lease, unsent reminders become available again when the lease expires
+func ClaimDueReminders(lease time.Duration, limit int) (reminders []models.Reminder, err error) {
+	claimReminders, err := db.Prepare(claimRemindersQuery)
+	if err != nil {
+		return nil, fmt.Errorf("could not prepare claim reminders query: %v", err)
+	}
+	defer func() {
+		if err := claimReminders.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close database connection")
+		}
+	}()
+	rows, err := claimReminders.Query(int64(lease.Seconds()), limit)
+	if err != nil {
+		return nil, fmt.Errorf("could not claim reminders: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		reminder := models.Reminder{}
+		err = rows.Scan(&reminder.UUID, &reminder.TaskUUID, &reminder.UserUUID, &reminder.FireAt, &reminder.Attempts)
+		if err != nil {
+			return nil, fmt.Errorf("could not read query: %v", err)
+		}
+		reminders = append(reminders, reminder)
+	}
+	return reminders, nil
+}
+
+// Mark reminder as delivered and put it into the inbox of its user
+func MarkReminderSent(reminderUUID string) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer func() {
+		if err != nil {
+			if err := tx.Rollback(); err != nil {
+				log.Error().Err(err).Msg("Could not rollback transaction")
+			}
+		}
+	}()
+
+	notification := models.Notification{Kind: models.NotificationReminder}
+	err = tx.QueryRow(markReminderSentQuery, reminderUUID).Scan(&notification.TaskUUID, &notification.UserUUID)
+	if err == sql.ErrNoRows {
+		// Reminder is already sent by another scheduler
+		return tx.Rollback()
+	}
+	if err != nil {
+		return fmt.Errorf("could not mark reminder as sent: %v", err)
+	}
+	if err = insertNotification(tx, notification, "", false); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit reminder: %v", err)
+	}
+	return nil
+}
+
+// Mark reminder as failed, it is never claimed again
+func MarkReminderFailed(reminderUUID string) (err error) {
+	if _, err = db.Exec(markReminderFailedQuery, reminderUUID); err != nil {
+		return fmt.Errorf("could not mark reminder as failed: %v", err)
+	}
+	return nil
+}
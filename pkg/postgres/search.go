@@ -0,0 +1,391 @@
+package postgres
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/Kolya59/todo-service/models"
+)
+
+// Text of tasks and comments is indexed only while values are stored in plain text,
+// encrypted values are searched in memory after decryption
+var (
+	// Search query params: user, language, text, headline options, limit
+	searchTasksQuery = "WITH query AS (SELECT websearch_to_tsquery($2::regconfig, $3) AS q), " +
+		"matches AS (SELECT t.uuid, t.value, t.is_resolved, t.due_date, t.created_at, " + labelsColumn + " AS labels, t.project_uuid, " +
+		"u.login AS author, " + roleColumn("$1") + " AS role, " + assigneesColumn + " AS assignees, " +
+		"COALESCE(t.search_vector @@ q, false) AS task_match, " +
+		"COALESCE(ts_rank_cd(t.search_vector, q), 0) AS task_rank, " +
+		"(SELECT c.value FROM public.comments c WHERE c.task_uuid = t.uuid AND c.search_vector @@ q " +
+		"ORDER BY ts_rank_cd(c.search_vector, q) DESC LIMIT 1) AS comment_value, " +
+		"(SELECT max(ts_rank_cd(c.search_vector, q)) FROM public.comments c WHERE c.task_uuid = t.uuid AND c.search_vector @@ q) AS comment_rank " +
+		"FROM public.tasks t JOIN public.users u ON u.uuid = t.author_uuid CROSS JOIN query WHERE " + readAccess("$1") +
+		" AND (t.search_vector @@ q OR EXISTS " +
+		"(SELECT 1 FROM public.comments c WHERE c.task_uuid = t.uuid AND c.search_vector @@ q))) " +
+		"SELECT m.uuid, m.value, m.is_resolved, m.due_date, m.created_at, m.labels, m.project_uuid, m.author, m.role, m.assignees, " +
+		"m.task_rank + COALESCE(m.comment_rank, 0) / 2 AS rank, m.task_match, " +
+		"ts_headline($2::regconfig, CASE WHEN m.task_match THEN m.value ELSE m.comment_value END, q, $4) " +
+		"FROM matches m, query ORDER BY rank DESC, m.created_at DESC, m.uuid DESC LIMIT $5"
+
+	selectUserCommentsQuery = "SELECT c.task_uuid, c.value, t.author_uuid FROM public.comments c " +
+		"JOIN public.tasks t ON t.uuid = c.task_uuid WHERE " + readAccess("$1") + " ORDER BY c.created_at"
+)
+
+const (
+	checkLanguageQuery = "SELECT $1::regconfig"
+
+	// Values encrypted by crypt.EncryptValue start with enc:v1: and are not indexed
+	reindexTasksQuery = "UPDATE public.tasks SET search_vector = " +
+		"CASE WHEN value LIKE 'enc:v1:%' THEN NULL ELSE to_tsvector($1::regconfig, COALESCE(value, '')) END"
+	reindexCommentsQuery = "UPDATE public.comments SET search_vector = " +
+		"CASE WHEN value LIKE 'enc:v1:%' THEN NULL ELSE to_tsvector($1::regconfig, value) END"
+)
+
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+
+	// Matched words are wrapped by private use characters, which are split into fragments afterwards
+	highlightStart   = "\ue000"
+	highlightStop    = "\ue001"
+	headlineOptions  = "StartSel=" + highlightStart + ", StopSel=" + highlightStop + ", MinWords=10, MaxWords=30, MaxFragments=2, FragmentDelimiter=\" … \""
+	snippetRadius    = 60
+	commentRankRatio = 0.5
+)
+
+var searchLanguage = "english"
+
+// Set text search configuration used for indexing and queries, e.g. english or simple
+func SetSearchLanguage(language string) error {
+	if language == "" {
+		return nil
+	}
+	var checked string
+	if err := db.QueryRow(checkLanguageQuery, language).Scan(&checked); err != nil {
+		return fmt.Errorf("unknown text search configuration %v: %v", language, err)
+	}
+	searchLanguage = language
+	return nil
+}
+
+// Text to index, encrypted values are not indexed so that words are not disclosed
+func indexedText(value string) *string {
+	if EncryptionEnabled() {
+		return nil
+	}
+	return &value
+}
+
+// Search tasks of user by text of tasks and their comments, results are ordered by rank
+func SearchTasks(userUUID string, text string, limit int) ([]models.SearchResult, error) {
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	if limit > MaxSearchLimit {
+		limit = MaxSearchLimit
+	}
+	if EncryptionEnabled() {
+		return searchTasksInMemory(userUUID, text, limit)
+	}
+	return searchTasksIndexed(userUUID, text, limit)
+}
+
+func searchTasksIndexed(userUUID string, text string, limit int) (results []models.SearchResult, err error) {
+	searchTasks, err := db.Prepare(searchTasksQuery)
+	if err != nil {
+		return nil, fmt.Errorf("could not prepare search query: %v", err)
+	}
+	defer func() {
+		if err := searchTasks.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close database connection")
+		}
+	}()
+	rows, err := searchTasks.Query(userUUID, searchLanguage, text, headlineOptions, limit)
+	if err != nil {
+		return nil, fmt.Errorf("could not search tasks: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		result := models.SearchResult{Source: "comment"}
+		var taskMatch bool
+		var headline string
+		err = rows.Scan(&result.Task.UUID, &result.Task.Value, &result.Task.IsResolved, &result.Task.DueDate,
+			&result.Task.CreatedAt, pq.Array(&result.Task.Labels), &result.Task.ProjectUUID,
+			&result.Task.Author, &result.Task.Role, pq.Array(&result.Task.Assignees), &result.Rank, &taskMatch, &headline)
+		if err != nil {
+			return nil, fmt.Errorf("could not read query: %v", err)
+		}
+		if taskMatch {
+			result.Source = "task"
+		}
+		result.Snippet = splitHighlights(headline)
+		results = append(results, result)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not search tasks: %v", err)
+	}
+	return results, nil
+}
+
+// Split headline into fragments by highlight marks
+func splitHighlights(headline string) []models.Fragment {
+	var fragments []models.Fragment
+	for {
+		start := strings.Index(headline, highlightStart)
+		if start < 0 {
+			break
+		}
+		stop := strings.Index(headline[start:], highlightStop)
+		if stop < 0 {
+			break
+		}
+		stop += start
+		if start > 0 {
+			fragments = append(fragments, models.Fragment{Text: headline[:start]})
+		}
+		fragments = append(fragments, models.Fragment{Text: headline[start+len(highlightStart) : stop], Match: true})
+		headline = headline[stop+len(highlightStop):]
+	}
+	if headline != "" {
+		fragments = append(fragments, models.Fragment{Text: headline})
+	}
+	return fragments
+}
+
+// Search terms for in memory search, quoted phrases are kept together and words with minus are excluded
+func searchTerms(text string) (include []string, exclude []string) {
+	text = strings.ToLower(text)
+	for text != "" {
+		text = strings.TrimLeft(text, " \t\r\n")
+		if text == "" {
+			break
+		}
+		negative := strings.HasPrefix(text, "-")
+		if negative {
+			text = text[1:]
+		}
+		var term string
+		if strings.HasPrefix(text, `"`) {
+			end := strings.Index(text[1:], `"`)
+			if end < 0 {
+				term, text = text[1:], ""
+			} else {
+				term, text = text[1:end+1], text[end+2:]
+			}
+		} else {
+			end := strings.IndexAny(text, " \t\r\n")
+			if end < 0 {
+				end = len(text)
+			}
+			term, text = text[:end], text[end:]
+		}
+		term = strings.TrimSpace(term)
+		switch {
+		case term == "" || term == "or":
+		case negative:
+			exclude = append(exclude, term)
+		default:
+			include = append(include, term)
+		}
+	}
+	return include, exclude
+}
+
+func searchTasksInMemory(userUUID string, text string, limit int) ([]models.SearchResult, error) {
+	include, exclude := searchTerms(text)
+	if len(include) == 0 {
+		return nil, nil
+	}
+	tasks, err := selectTasks(selectTasksQuery+allCondition, []interface{}{userUUID})
+	if err != nil {
+		return nil, err
+	}
+	comments, err := selectUserComments(userUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []models.SearchResult
+	for _, task := range tasks {
+		value := strings.ToLower(task.Value)
+		rank := 0.0
+		matched := true
+		bestComment, bestCommentRank := "", 0
+		for _, term := range exclude {
+			if strings.Contains(value, term) {
+				matched = false
+			}
+		}
+		for _, term := range include {
+			count := strings.Count(value, term)
+			for _, comment := range comments[task.UUID] {
+				count += strings.Count(strings.ToLower(comment), term)
+			}
+			if count == 0 {
+				matched = false
+			}
+		}
+		if !matched {
+			continue
+		}
+		for _, term := range include {
+			rank += float64(strings.Count(value, term))
+		}
+		for _, comment := range comments[task.UUID] {
+			commentRank := 0
+			for _, term := range include {
+				commentRank += strings.Count(strings.ToLower(comment), term)
+			}
+			rank += float64(commentRank) * commentRankRatio
+			if commentRank > bestCommentRank {
+				bestComment, bestCommentRank = comment, commentRank
+			}
+		}
+		result := models.SearchResult{Task: task, Rank: rank, Source: "task"}
+		snippetText := task.Value
+		if !containsAny(value, include) {
+			result.Source, snippetText = "comment", bestComment
+		}
+		result.Snippet = snippet(snippetText, include)
+		results = append(results, result)
+	}
+	// Tasks are already ordered from the newest, so stable sort keeps it for equal ranks
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Rank > results[j].Rank
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+func containsAny(value string, terms []string) bool {
+	for _, term := range terms {
+		if strings.Contains(value, term) {
+			return true
+		}
+	}
+	return false
+}
+
+// Snippet around the first matched term with all terms highlighted
+func snippet(text string, terms []string) []models.Fragment {
+	lower := strings.ToLower(text)
+	// Lower case may change byte length, then positions are taken from the text itself
+	if len(lower) != len(text) {
+		lower = text
+	}
+	first := len(text)
+	for _, term := range terms {
+		if i := strings.Index(lower, term); i >= 0 && i < first {
+			first = i
+		}
+	}
+	if first == len(text) {
+		first = 0
+	}
+	start, end := first-snippetRadius, first+2*snippetRadius
+	if start < 0 {
+		start = 0
+	}
+	if end > len(text) {
+		end = len(text)
+	}
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+
+	var fragments []models.Fragment
+	if start > 0 {
+		fragments = append(fragments, models.Fragment{Text: "…"})
+	}
+	plain := start
+	for i := start; i < end; {
+		match := ""
+		for _, term := range terms {
+			if strings.HasPrefix(lower[i:], term) && len(term) > len(match) {
+				match = term
+			}
+		}
+		if match == "" {
+			i++
+			continue
+		}
+		if plain < i {
+			fragments = append(fragments, models.Fragment{Text: text[plain:i]})
+		}
+		fragments = append(fragments, models.Fragment{Text: text[i : i+len(match)], Match: true})
+		i += len(match)
+		plain = i
+	}
+	if plain < end {
+		fragments = append(fragments, models.Fragment{Text: text[plain:end]})
+	}
+	if end < len(text) {
+		fragments = append(fragments, models.Fragment{Text: "…"})
+	}
+	return fragments
+}
+
+// Select decrypted comments of all tasks seen by user by task
+func selectUserComments(userUUID string) (comments map[string][]string, err error) {
+	selectComments, err := db.Prepare(selectUserCommentsQuery)
+	if err != nil {
+		return nil, fmt.Errorf("could not prepare select comments query: %v", err)
+	}
+	defer func() {
+		if err := selectComments.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close database connection")
+		}
+	}()
+	rows, err := selectComments.Query(userUUID)
+	if err != nil {
+		return nil, fmt.Errorf("could not select comments: %v", err)
+	}
+	defer rows.Close()
+
+	comments = map[string][]string{}
+	for rows.Next() {
+		var taskUUID, value, ownerUUID string
+		if err = rows.Scan(&taskUUID, &value, &ownerUUID); err != nil {
+			return nil, fmt.Errorf("could not read query: %v", err)
+		}
+		value, err = decryptValue(ownerUUID, value)
+		if err != nil {
+			return nil, fmt.Errorf("could not decrypt comment: %v", err)
+		}
+		comments[taskUUID] = append(comments[taskUUID], value)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not select comments: %v", err)
+	}
+	return comments, nil
+}
+
+// Rebuild search index of plain text values with the current language
+func ReindexSearch() (tasks int64, comments int64, err error) {
+	res, err := db.Exec(reindexTasksQuery, searchLanguage)
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not reindex tasks: %v", err)
+	}
+	if tasks, err = res.RowsAffected(); err != nil {
+		return 0, 0, fmt.Errorf("could not reindex tasks: %v", err)
+	}
+	res, err = db.Exec(reindexCommentsQuery, searchLanguage)
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not reindex comments: %v", err)
+	}
+	if comments, err = res.RowsAffected(); err != nil {
+		return 0, 0, fmt.Errorf("could not reindex comments: %v", err)
+	}
+	return tasks, comments, nil
+}
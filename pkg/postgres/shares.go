@@ -0,0 +1,266 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/Kolya59/todo-service/models"
+	"github.com/Kolya59/todo-service/pkg/authz"
+)
+
+// Task is shared either by itself or with its project, or it belongs to a workspace through its project.
+// Access conditions are used in queries over tasks aliased as t, user is given by placeholder.
+
+func shareOf(user string) string {
+	return "FROM public.shares s WHERE s.user_uuid = " + user + " AND (s.task_uuid = t.uuid OR s.project_uuid = t.project_uuid)"
+}
+
+func workspaceMemberOf(user string) string {
+	return "FROM public.projects wp JOIN public.workspace_members m ON m.workspace_uuid = wp.workspace_uuid " +
+		"WHERE wp.uuid = t.project_uuid AND m.user_uuid = " + user
+}
+
+// User owns the task, it is shared with the user or the user is member of its workspace
+func readAccess(user string) string {
+	return "(t.author_uuid = " + user + " OR EXISTS (SELECT 1 " + shareOf(user) + ") OR EXISTS (SELECT 1 " +
+		workspaceMemberOf(user) + " AND m.role IN (" + rolesOf(authz.View) + ")))"
+}
+
+// User owns the task, it is shared with the user as editor or the user may edit tasks of its workspace
+func writeAccess(user string) string {
+	return "(t.author_uuid = " + user + " OR EXISTS (SELECT 1 " + shareOf(user) + " AND s.role = '" + models.RoleEditor + "') OR EXISTS (SELECT 1 " +
+		workspaceMemberOf(user) + " AND m.role IN (" + rolesOf(authz.EditTasks) + ")))"
+}
+
+// User owns the task or may delete tasks of its workspace, shares never allow it
+func deleteAccess(user string) string {
+	return "(t.author_uuid = " + user + " OR EXISTS (SELECT 1 " + workspaceMemberOf(user) + " AND m.role IN (" + rolesOf(authz.DeleteTasks) + ")))"
+}
+
+// User owns the task or may delete tasks both of its workspace and of workspace of the target project, which is
+// given by placeholder. So only authors take tasks out of workspaces and bring private tasks into them.
+func moveAccess(user string, target string) string {
+	return "(t.author_uuid = " + user + " OR (EXISTS (SELECT 1 " + workspaceMemberOf(user) + " AND m.role IN (" + rolesOf(authz.DeleteTasks) + ")) AND " +
+		"EXISTS (SELECT 1 FROM public.projects tp WHERE tp.uuid = " + target + " AND " + memberCan(user, "tp.workspace_uuid", authz.DeleteTasks) + ")))"
+}
+
+// Role of user on the task, editor wins over viewer when task is reachable by several shares or by workspace
+func roleColumn(user string) string {
+	return "CASE WHEN t.author_uuid = " + user + " THEN '" + models.RoleOwner + "' ELSE (SELECT min(r.role) FROM (SELECT s.role " + shareOf(user) +
+		" UNION ALL SELECT CASE WHEN m.role IN (" + rolesOf(authz.EditTasks) + ") THEN '" + models.RoleEditor + "' ELSE '" + models.RoleViewer + "' END " +
+		workspaceMemberOf(user) + ") r) END"
+}
+
+const (
+	selectUserUUIDQuery = "SELECT uuid FROM public.users WHERE login = $1"
+	// Role of existing share is replaced
+	shareTaskQuery = "INSERT INTO public.shares(uuid, task_uuid, user_uuid, role) " +
+		"SELECT $1, t.uuid, $4, $5 FROM public.tasks t WHERE t.uuid = $2 AND t.author_uuid = $3 " +
+		"ON CONFLICT (task_uuid, user_uuid) WHERE task_uuid IS NOT NULL DO UPDATE SET role = excluded.role RETURNING uuid, created_at"
+	shareProjectQuery = "INSERT INTO public.shares(uuid, project_uuid, user_uuid, role) " +
+		"SELECT $1, p.uuid, $4, $5 FROM public.projects p WHERE p.uuid = $2 AND p.owner_uuid = $3 " +
+		"ON CONFLICT (project_uuid, user_uuid) WHERE project_uuid IS NOT NULL DO UPDATE SET role = excluded.role RETURNING uuid, created_at"
+	selectSharesColumns = "SELECT s.uuid, s.task_uuid, s.project_uuid, o.login, u.login, s.role, s.created_at FROM public.shares s " +
+		"LEFT JOIN public.tasks t ON t.uuid = s.task_uuid LEFT JOIN public.projects p ON p.uuid = s.project_uuid " +
+		"JOIN public.users o ON o.uuid = COALESCE(t.author_uuid, p.owner_uuid) JOIN public.users u ON u.uuid = s.user_uuid "
+	selectTaskSharesQuery     = selectSharesColumns + "WHERE s.task_uuid = $1 AND t.author_uuid = $2 ORDER BY s.created_at"
+	selectProjectSharesQuery  = selectSharesColumns + "WHERE s.project_uuid = $1 AND p.owner_uuid = $2 ORDER BY s.created_at"
+	selectReceivedSharesQuery = selectSharesColumns + "WHERE s.user_uuid = $1 ORDER BY s.created_at"
+	// Share is removed by owner of shared item or by user it is shared with
+	deleteShareQuery = "DELETE FROM public.shares s WHERE s.uuid = $1 AND (s.user_uuid = $2 OR " +
+		"EXISTS (SELECT 1 FROM public.tasks t WHERE t.uuid = s.task_uuid AND t.author_uuid = $2) OR " +
+		"EXISTS (SELECT 1 FROM public.projects p WHERE p.uuid = s.project_uuid AND p.owner_uuid = $2))"
+)
+
+var (
+	// ErrForbidden is returned when user sees the task but the role does not allow the change
+	ErrForbidden = errors.New("forbidden")
+	// ErrUserNotFound is returned for share with unknown login
+	ErrUserNotFound = errors.New("user is not found")
+	// ErrShareWithOwner is returned for share with owner of the item
+	ErrShareWithOwner = errors.New("item can not be shared with its owner")
+)
+
+var (
+	checkTaskAccessQuery = "SELECT 1 FROM public.tasks t WHERE t.uuid = $1 AND " + readAccess("$2")
+	selectTaskOwnerQuery = "SELECT t.author_uuid FROM public.tasks t WHERE t.uuid = $1 AND " + writeAccess("$2")
+	lockTaskOwnerQuery   = selectTaskOwnerQuery + " FOR UPDATE OF t"
+	// Editor sorts before viewer, so the best role of shares is the least one
+	selectTaskAccessQuery = "SELECT t.author_uuid, COALESCE((SELECT min(s.role) " + shareOf("$2") + "), ''), " +
+		"COALESCE((SELECT m.role " + workspaceMemberOf("$2") + "), '') FROM public.tasks t WHERE t.uuid = $1"
+)
+
+// Error for a task which was not changed: ErrForbidden when user sees the task, otherwise ErrNotFound
+func taskMissing(e execer, taskUUID string, userUUID string) error {
+	var found int
+	err := e.QueryRow(checkTaskAccessQuery, taskUUID, userUUID).Scan(&found)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("could not select task: %v", err)
+	}
+	return ErrForbidden
+}
+
+// Owner of task which user may change, values of the task are encrypted with data key of the owner
+func taskOwner(e execer, taskUUID string, userUUID string) (string, error) {
+	var owner string
+	err := e.QueryRow(selectTaskOwnerQuery, taskUUID, userUUID).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return "", taskMissing(e, taskUUID, userUUID)
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not select task: %v", err)
+	}
+	return owner, nil
+}
+
+// Select owner of task and how user reaches it, handlers decide on it by authz.CanTask.
+// ErrNotFound is returned only for missing task, the access tells whether user sees it.
+func SelectTaskAccess(userUUID string, taskUUID string) (owner string, access authz.TaskAccess, err error) {
+	err = db.QueryRow(selectTaskAccessQuery, taskUUID, userUUID).Scan(&owner, &access.ShareRole, &access.WorkspaceRole)
+	if err == sql.ErrNoRows {
+		return "", authz.TaskAccess{}, ErrNotFound
+	}
+	if err != nil {
+		return "", authz.TaskAccess{}, fmt.Errorf("could not select task access: %v", err)
+	}
+	access.Author = owner == userUUID
+	return owner, access, nil
+}
+
+// Share task of owner with user by login
+func ShareTask(ownerUUID string, taskUUID string, login string, role string) (models.Share, error) {
+	share := models.Share{TaskUUID: &taskUUID}
+	if err := insertShare(shareTaskQuery, ownerUUID, taskUUID, login, role, &share); err != nil {
+		return models.Share{}, err
+	}
+	return share, nil
+}
+
+// Share all tasks of project of owner with user by login
+func ShareProject(ownerUUID string, projectUUID string, login string, role string) (models.Share, error) {
+	share := models.Share{ProjectUUID: &projectUUID}
+	if err := insertShare(shareProjectQuery, ownerUUID, projectUUID, login, role, &share); err != nil {
+		return models.Share{}, err
+	}
+	return share, nil
+}
+
+func insertShare(q string, ownerUUID string, itemUUID string, login string, role string, share *models.Share) (err error) {
+	var userUUID string
+	if err = db.QueryRow(selectUserUUIDQuery, login).Scan(&userUUID); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrUserNotFound
+		}
+		return fmt.Errorf("could not select user: %v", err)
+	}
+	if userUUID == ownerUUID {
+		return ErrShareWithOwner
+	}
+	owner, err := SelectLoginByUUID(ownerUUID)
+	if err != nil {
+		return fmt.Errorf("could not get login: %v", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer func() {
+		if err != nil {
+			if err := tx.Rollback(); err != nil {
+				log.Error().Err(err).Msg("Could not rollback transaction")
+			}
+		}
+	}()
+
+	err = tx.QueryRow(q, uuid.NewV4().String(), itemUUID, ownerUUID, userUUID, role).Scan(&share.UUID, &share.CreatedAt)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("could not insert share into database: %v", err)
+	}
+	notification := models.Notification{UserUUID: userUUID, Kind: models.NotificationShare, TaskUUID: share.TaskUUID, ProjectUUID: share.ProjectUUID}
+	if err = insertNotification(tx, notification, ownerUUID, true); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit share: %v", err)
+	}
+	share.Owner, share.Login, share.Role = owner, login, role
+	log.Info().Msgf("Share with uuid = %s is saved in database", share.UUID)
+	return nil
+}
+
+// Select shares of task of owner
+func SelectTaskShares(ownerUUID string, taskUUID string) ([]models.Share, error) {
+	return selectShares(selectTaskSharesQuery, taskUUID, ownerUUID)
+}
+
+// Select shares of project of owner
+func SelectProjectShares(ownerUUID string, projectUUID string) ([]models.Share, error) {
+	return selectShares(selectProjectSharesQuery, projectUUID, ownerUUID)
+}
+
+// Select shares with user
+func SelectReceivedShares(userUUID string) ([]models.Share, error) {
+	return selectShares(selectReceivedSharesQuery, userUUID)
+}
+
+func selectShares(q string, args ...interface{}) (shares []models.Share, err error) {
+	selectShares, err := db.Prepare(q)
+	if err != nil {
+		return nil, fmt.Errorf("could not prepare select shares query: %v", err)
+	}
+	defer func() {
+		if err := selectShares.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close database connection")
+		}
+	}()
+	rows, err := selectShares.Query(args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not select shares: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		share := models.Share{}
+		err = rows.Scan(&share.UUID, &share.TaskUUID, &share.ProjectUUID, &share.Owner, &share.Login, &share.Role, &share.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("could not read query: %v", err)
+		}
+		shares = append(shares, share)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not select shares: %v", err)
+	}
+	return shares, nil
+}
+
+// Delete share, either by owner of shared item or by user it is shared with
+func DeleteShare(userUUID string, shareUUID string) (err error) {
+	deleteShare, err := db.Prepare(deleteShareQuery)
+	if err != nil {
+		return fmt.Errorf("could not prepare delete share query: %v", err)
+	}
+	defer func() {
+		if err := deleteShare.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close database connection")
+		}
+	}()
+	res, err := deleteShare.Exec(shareUUID, userUUID)
+	if err != nil {
+		return fmt.Errorf("could not delete share: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	log.Info().Msgf("Share with uuid = %s has been deleted", shareUUID)
+	return nil
+}
@@ -0,0 +1,276 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/Kolya59/todo-service/models"
+)
+
+// Secrets and payloads of webhooks are encrypted with data key of their user. Deliveries wait for next_attempt_at,
+// it is cleared when the delivery succeeds or attempts run out.
+const (
+	insertWebhookQuery       = "INSERT INTO public.webhooks(uuid, user_uuid, url, secret, events) VALUES ($1, $2, $3, $4, $5) RETURNING created_at"
+	selectWebhooksQuery      = "SELECT uuid, url, events, created_at FROM public.webhooks WHERE user_uuid = $1 ORDER BY created_at"
+	selectWebhookQuery       = "SELECT uuid, url, events, created_at FROM public.webhooks WHERE uuid = $2 AND user_uuid = $1"
+	deleteWebhookQuery       = "DELETE FROM public.webhooks WHERE uuid = $2 AND user_uuid = $1"
+	selectEventWebhooksQuery = "SELECT uuid, user_uuid FROM public.webhooks WHERE user_uuid = ANY($1) AND $2 = ANY(events)"
+
+	insertDeliveryQuery   = "INSERT INTO public.webhook_deliveries(uuid, webhook_uuid, event_type, payload, next_attempt_at) VALUES ($1, $2, $3, $4, now())"
+	deliveryColumns       = "SELECT d.uuid, d.webhook_uuid, d.event_type, d.attempts, d.next_attempt_at, d.delivered_at, d.created_at "
+	selectDeliveriesQuery = deliveryColumns + "FROM public.webhook_deliveries d JOIN public.webhooks w ON w.uuid = d.webhook_uuid " +
+		"WHERE w.user_uuid = $1 AND w.uuid = $2 ORDER BY d.created_at DESC, d.uuid LIMIT $3"
+	selectDeliveryQuery = deliveryColumns + ", d.payload FROM public.webhook_deliveries d JOIN public.webhooks w ON w.uuid = d.webhook_uuid " +
+		"WHERE w.user_uuid = $1 AND w.uuid = $2 AND d.uuid = $3"
+	selectAttemptsQuery = "SELECT attempted_at, status_code, error, duration_ms FROM public.webhook_attempts " +
+		"WHERE delivery_uuid = $1 ORDER BY attempted_at"
+	// Redelivered delivery gets all attempts again, its history is kept
+	redeliverQuery = "UPDATE public.webhook_deliveries d SET next_attempt_at = now(), attempts = 0, delivered_at = NULL " +
+		"FROM public.webhooks w WHERE w.uuid = d.webhook_uuid AND w.user_uuid = $1 AND w.uuid = $2 AND d.uuid = $3"
+	claimDeliveriesQuery = "UPDATE public.webhook_deliveries d SET claimed_until = now() + $1 * interval '1 second', attempts = d.attempts + 1 " +
+		"FROM public.webhooks w WHERE w.uuid = d.webhook_uuid AND d.uuid IN (SELECT uuid FROM public.webhook_deliveries " +
+		"WHERE next_attempt_at <= now() AND (claimed_until IS NULL OR claimed_until < now()) " +
+		"ORDER BY next_attempt_at LIMIT $2 FOR UPDATE SKIP LOCKED) " +
+		"RETURNING d.uuid, d.webhook_uuid, d.event_type, d.payload, d.attempts, d.created_at, w.url, w.secret, w.user_uuid"
+	insertAttemptQuery = "INSERT INTO public.webhook_attempts(delivery_uuid, attempted_at, status_code, error, duration_ms) " +
+		"VALUES ($1, $2, NULLIF($3, 0), NULLIF($4, ''), $5)"
+	finishDeliveryQuery = "UPDATE public.webhook_deliveries SET claimed_until = NULL, next_attempt_at = $2, " +
+		"delivered_at = CASE WHEN $3 THEN now() ELSE delivered_at END WHERE uuid = $1"
+)
+
+// Insert webhook of user, secret is returned only here
+func InsertWebhook(userUUID string, url string, events []string, secret string) (models.Webhook, error) {
+	storedSecret, err := encryptValue(userUUID, secret)
+	if err != nil {
+		return models.Webhook{}, fmt.Errorf("could not encrypt secret: %v", err)
+	}
+	hook := models.Webhook{UUID: uuid.NewV4().String(), UserUUID: userUUID, URL: url, Events: events, Secret: secret}
+	err = db.QueryRow(insertWebhookQuery, hook.UUID, userUUID, url, storedSecret, pq.Array(events)).Scan(&hook.CreatedAt)
+	if err != nil {
+		return models.Webhook{}, fmt.Errorf("could not insert webhook: %v", err)
+	}
+	return hook, nil
+}
+
+func scanWebhook(row scanner) (models.Webhook, error) {
+	hook := models.Webhook{}
+	err := row.Scan(&hook.UUID, &hook.URL, pq.Array(&hook.Events), &hook.CreatedAt)
+	return hook, err
+}
+
+// Select webhooks of user from the oldest
+func SelectWebhooks(userUUID string) (hooks []models.Webhook, err error) {
+	rows, err := db.Query(selectWebhooksQuery, userUUID)
+	if err != nil {
+		return nil, fmt.Errorf("could not select webhooks: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		hook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not read query: %v", err)
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+func SelectWebhook(userUUID string, webhookUUID string) (models.Webhook, error) {
+	hook, err := scanWebhook(db.QueryRow(selectWebhookQuery, userUUID, webhookUUID))
+	if err == sql.ErrNoRows {
+		return models.Webhook{}, ErrNotFound
+	}
+	if err != nil {
+		return models.Webhook{}, fmt.Errorf("could not select webhook: %v", err)
+	}
+	return hook, nil
+}
+
+// Delete webhook with its deliveries
+func DeleteWebhook(userUUID string, webhookUUID string) error {
+	res, err := db.Exec(deleteWebhookQuery, userUUID, webhookUUID)
+	if err != nil {
+		return fmt.Errorf("could not delete webhook: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Select webhooks of users subscribed to event type, only uuid and user are set
+func SelectEventWebhooks(users []string, eventType string) (hooks []models.Webhook, err error) {
+	rows, err := db.Query(selectEventWebhooksQuery, pq.Array(users), eventType)
+	if err != nil {
+		return nil, fmt.Errorf("could not select webhooks: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		hook := models.Webhook{}
+		if err = rows.Scan(&hook.UUID, &hook.UserUUID); err != nil {
+			return nil, fmt.Errorf("could not read query: %v", err)
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+// Queue delivery of payload to webhook, it is attempted on the next tick of scheduler
+func InsertWebhookDelivery(hook models.Webhook, eventType string, payload string) error {
+	storedPayload, err := encryptValue(hook.UserUUID, payload)
+	if err != nil {
+		return fmt.Errorf("could not encrypt payload: %v", err)
+	}
+	if _, err = db.Exec(insertDeliveryQuery, uuid.NewV4().String(), hook.UUID, eventType, storedPayload); err != nil {
+		return fmt.Errorf("could not insert delivery: %v", err)
+	}
+	return nil
+}
+
+func scanDelivery(row scanner, dest ...interface{}) (models.WebhookDelivery, error) {
+	d := models.WebhookDelivery{}
+	err := row.Scan(append([]interface{}{&d.UUID, &d.WebhookUUID, &d.EventType, &d.Attempts, &d.NextAttemptAt,
+		&d.DeliveredAt, &d.CreatedAt}, dest...)...)
+	switch {
+	case d.DeliveredAt != nil:
+		d.Status = models.DeliveryDelivered
+	case d.NextAttemptAt == nil:
+		d.Status = models.DeliveryFailed
+	default:
+		d.Status = models.DeliveryPending
+	}
+	return d, err
+}
+
+// Select latest deliveries of webhook of user
+func SelectWebhookDeliveries(userUUID string, webhookUUID string, limit int) (deliveries []models.WebhookDelivery, err error) {
+	rows, err := db.Query(selectDeliveriesQuery, userUUID, webhookUUID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("could not select deliveries: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not read query: %v", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, nil
+}
+
+// Select delivery of webhook of user with its payload and attempts
+func SelectWebhookDelivery(userUUID string, webhookUUID string, deliveryUUID string) (models.WebhookDelivery, error) {
+	var payload string
+	d, err := scanDelivery(db.QueryRow(selectDeliveryQuery, userUUID, webhookUUID, deliveryUUID), &payload)
+	if err == sql.ErrNoRows {
+		return models.WebhookDelivery{}, ErrNotFound
+	}
+	if err != nil {
+		return models.WebhookDelivery{}, fmt.Errorf("could not select delivery: %v", err)
+	}
+	if payload, err = decryptValue(userUUID, payload); err != nil {
+		return models.WebhookDelivery{}, fmt.Errorf("could not decrypt payload: %v", err)
+	}
+	d.Payload = json.RawMessage(payload)
+
+	rows, err := db.Query(selectAttemptsQuery, deliveryUUID)
+	if err != nil {
+		return models.WebhookDelivery{}, fmt.Errorf("could not select attempts: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		a := models.WebhookAttempt{}
+		var statusCode *int
+		var attemptErr *string
+		if err = rows.Scan(&a.AttemptedAt, &statusCode, &attemptErr, &a.DurationMs); err != nil {
+			return models.WebhookDelivery{}, fmt.Errorf("could not read query: %v", err)
+		}
+		if statusCode != nil {
+			a.StatusCode = *statusCode
+		}
+		if attemptErr != nil {
+			a.Error = *attemptErr
+		}
+		d.History = append(d.History, a)
+	}
+	return d, nil
+}
+
+// Schedule delivery of webhook of user again, delivered ones are sent once more
+func RedeliverWebhookDelivery(userUUID string, webhookUUID string, deliveryUUID string) error {
+	res, err := db.Exec(redeliverQuery, userUUID, webhookUUID, deliveryUUID)
+	if err != nil {
+		return fmt.Errorf("could not redeliver delivery: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Claim due deliveries for lease with their decrypted payloads and targets, attempts are counted on claim
+func ClaimWebhookDeliveries(lease time.Duration, limit int) (deliveries []models.WebhookDelivery, err error) {
+	rows, err := db.Query(claimDeliveriesQuery, int64(lease.Seconds()), limit)
+	if err != nil {
+		return nil, fmt.Errorf("could not claim deliveries: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		d := models.WebhookDelivery{Status: models.DeliveryPending}
+		var payload, userUUID string
+		err = rows.Scan(&d.UUID, &d.WebhookUUID, &d.EventType, &payload, &d.Attempts, &d.CreatedAt, &d.URL, &d.Secret, &userUUID)
+		if err != nil {
+			return nil, fmt.Errorf("could not read query: %v", err)
+		}
+		if payload, err = decryptValue(userUUID, payload); err != nil {
+			// Lease expires and the delivery is claimed again, key may be restored meanwhile
+			log.Error().Err(err).Msgf("Could not decrypt payload of delivery %v", d.UUID)
+			continue
+		}
+		if d.Secret, err = decryptValue(userUUID, d.Secret); err != nil {
+			log.Error().Err(err).Msgf("Could not decrypt secret of webhook %v", d.WebhookUUID)
+			continue
+		}
+		d.Payload = json.RawMessage(payload)
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, nil
+}
+
+// Record attempt of claimed delivery and release it. Delivery is retried at nextAttemptAt, nil stops retries.
+func FinishWebhookAttempt(deliveryUUID string, attempt models.WebhookAttempt, nextAttemptAt *time.Time) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer func() {
+		if err != nil {
+			if err := tx.Rollback(); err != nil {
+				log.Error().Err(err).Msg("Could not rollback transaction")
+			}
+		}
+	}()
+
+	_, err = tx.Exec(insertAttemptQuery, deliveryUUID, attempt.AttemptedAt, attempt.StatusCode, attempt.Error, attempt.DurationMs)
+	if err != nil {
+		return fmt.Errorf("could not insert attempt: %v", err)
+	}
+	if _, err = tx.Exec(finishDeliveryQuery, deliveryUUID, nextAttemptAt, attempt.Error == ""); err != nil {
+		return fmt.Errorf("could not finish delivery: %v", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %v", err)
+	}
+	return nil
+}
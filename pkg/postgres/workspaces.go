@@ -0,0 +1,386 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/Kolya59/todo-service/models"
+	"github.com/Kolya59/todo-service/pkg/authz"
+)
+
+// Roles allowed to perform the action as SQL list
+func rolesOf(action authz.Action) string {
+	return "'" + strings.Join(authz.Roles(action), "', '") + "'"
+}
+
+// User is member of workspace with role allowing the action, workspace is given by column or placeholder
+func memberCan(user string, workspace string, action authz.Action) string {
+	return "EXISTS (SELECT 1 FROM public.workspace_members m WHERE m.workspace_uuid = " + workspace +
+		" AND m.user_uuid = " + user + " AND m.role IN (" + rolesOf(action) + "))"
+}
+
+// Names of workspaces are not encrypted, workspace outlives membership of any user. Roles are checked
+// by handlers with pkg/authz, so changes of workspace and its members are not restricted here.
+const (
+	insertWorkspaceQuery  = "INSERT INTO public.workspaces(uuid, name) VALUES ($1, $2) RETURNING created_at"
+	selectWorkspacesQuery = "SELECT w.uuid, w.name, m.role, w.created_at FROM public.workspaces w " +
+		"JOIN public.workspace_members m ON m.workspace_uuid = w.uuid WHERE m.user_uuid = $1 ORDER BY w.created_at, w.uuid"
+	selectWorkspaceQuery = "SELECT w.uuid, w.name, m.role, w.created_at FROM public.workspaces w " +
+		"JOIN public.workspace_members m ON m.workspace_uuid = w.uuid WHERE m.user_uuid = $1 AND w.uuid = $2"
+	renameWorkspaceQuery = "UPDATE public.workspaces SET name = $2 WHERE uuid = $1"
+	deleteWorkspaceQuery = "DELETE FROM public.workspaces WHERE uuid = $1"
+
+	insertMemberQuery  = "INSERT INTO public.workspace_members(workspace_uuid, user_uuid, role) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING"
+	selectMembersQuery = "SELECT m.user_uuid, u.login, m.role, m.created_at FROM public.workspace_members m " +
+		"JOIN public.users u ON u.uuid = m.user_uuid WHERE m.workspace_uuid = $1 ORDER BY m.created_at, u.login"
+	selectMemberRoleQuery = "SELECT role FROM public.workspace_members WHERE workspace_uuid = $1 AND user_uuid = $2"
+	updateMemberQuery     = "UPDATE public.workspace_members SET role = $3 WHERE workspace_uuid = $1 AND user_uuid = $2"
+	deleteMemberQuery     = "DELETE FROM public.workspace_members WHERE workspace_uuid = $1 AND user_uuid = $2"
+	// Owners are locked, so that concurrent changes can not remove the last one
+	selectOwnersQuery = "SELECT user_uuid FROM public.workspace_members WHERE workspace_uuid = $1 AND role = '" + models.WorkspaceOwner + "' FOR UPDATE"
+
+	// Repeated invitation replaces role of the previous one
+	insertInvitationQuery = "INSERT INTO public.workspace_invitations(uuid, workspace_uuid, user_uuid, inviter_uuid, role) VALUES ($1, $2, $3, $4, $5) " +
+		"ON CONFLICT (workspace_uuid, user_uuid) DO UPDATE SET role = excluded.role, inviter_uuid = excluded.inviter_uuid RETURNING uuid, created_at"
+	selectInvitationsColumns = "SELECT i.uuid, i.workspace_uuid, w.name, u.login, i.role, b.login, i.created_at FROM public.workspace_invitations i " +
+		"JOIN public.workspaces w ON w.uuid = i.workspace_uuid JOIN public.users u ON u.uuid = i.user_uuid JOIN public.users b ON b.uuid = i.inviter_uuid "
+	selectWorkspaceInvitationsQuery = selectInvitationsColumns + "WHERE i.workspace_uuid = $1 ORDER BY i.created_at"
+	selectReceivedInvitationsQuery  = selectInvitationsColumns + "WHERE i.user_uuid = $1 ORDER BY i.created_at"
+	acceptInvitationQuery           = "DELETE FROM public.workspace_invitations WHERE uuid = $1 AND user_uuid = $2 RETURNING workspace_uuid, role"
+)
+
+// Invitation is declined by invited user or revoked by member managing members
+var deleteInvitationQuery = "DELETE FROM public.workspace_invitations i WHERE i.uuid = $1 AND (i.user_uuid = $2 OR " +
+	memberCan("$2", "i.workspace_uuid", authz.ManageMembers) + ")"
+
+var (
+	// ErrLastOwner is returned when change of members would leave workspace without owner
+	ErrLastOwner = errors.New("workspace must keep an owner")
+	// ErrAlreadyMember is returned for invitation of member of the workspace
+	ErrAlreadyMember = errors.New("user is already a member")
+)
+
+// Insert workspace, user becomes its owner
+func InsertWorkspace(userUUID string, name string) (workspace models.Workspace, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return models.Workspace{}, fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer func() {
+		if err != nil {
+			if err := tx.Rollback(); err != nil {
+				log.Error().Err(err).Msg("Could not rollback transaction")
+			}
+		}
+	}()
+
+	workspace = models.Workspace{UUID: uuid.NewV4().String(), Name: name, Role: models.WorkspaceOwner}
+	if err = tx.QueryRow(insertWorkspaceQuery, workspace.UUID, name).Scan(&workspace.CreatedAt); err != nil {
+		return models.Workspace{}, fmt.Errorf("could not insert workspace into database: %v", err)
+	}
+	if _, err = tx.Exec(insertMemberQuery, workspace.UUID, userUUID, models.WorkspaceOwner); err != nil {
+		return models.Workspace{}, fmt.Errorf("could not insert member into database: %v", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return models.Workspace{}, fmt.Errorf("could not commit workspace: %v", err)
+	}
+	log.Info().Msgf("Workspace with uuid = %s is added in database", workspace.UUID)
+	return workspace, nil
+}
+
+// Select workspaces of user from the oldest
+func SelectWorkspaces(userUUID string) (workspaces []models.Workspace, err error) {
+	selectWorkspaces, err := db.Prepare(selectWorkspacesQuery)
+	if err != nil {
+		return nil, fmt.Errorf("could not prepare select workspaces query: %v", err)
+	}
+	defer func() {
+		if err := selectWorkspaces.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close database connection")
+		}
+	}()
+	rows, err := selectWorkspaces.Query(userUUID)
+	if err != nil {
+		return nil, fmt.Errorf("could not select workspaces: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		workspace := models.Workspace{}
+		if err = rows.Scan(&workspace.UUID, &workspace.Name, &workspace.Role, &workspace.CreatedAt); err != nil {
+			return nil, fmt.Errorf("could not read query: %v", err)
+		}
+		workspaces = append(workspaces, workspace)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not select workspaces: %v", err)
+	}
+	return workspaces, nil
+}
+
+// Select workspace of user, ErrNotFound is returned when user is not its member
+func SelectWorkspace(userUUID string, workspaceUUID string) (workspace models.Workspace, err error) {
+	err = db.QueryRow(selectWorkspaceQuery, userUUID, workspaceUUID).Scan(&workspace.UUID, &workspace.Name, &workspace.Role, &workspace.CreatedAt)
+	if err == sql.ErrNoRows {
+		return models.Workspace{}, ErrNotFound
+	}
+	if err != nil {
+		return models.Workspace{}, fmt.Errorf("could not select workspace: %v", err)
+	}
+	return workspace, nil
+}
+
+// Select role of user in workspace, ErrNotFound is returned when user is not its member
+func SelectWorkspaceRole(userUUID string, workspaceUUID string) (role string, err error) {
+	err = db.QueryRow(selectMemberRoleQuery, workspaceUUID, userUUID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not select member: %v", err)
+	}
+	return role, nil
+}
+
+// Rename workspace
+func RenameWorkspace(workspaceUUID string, name string) error {
+	res, err := db.Exec(renameWorkspaceQuery, workspaceUUID, name)
+	if err != nil {
+		return fmt.Errorf("could not rename workspace: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	log.Info().Msgf("Workspace with uuid = %s has been renamed", workspaceUUID)
+	return nil
+}
+
+// Delete workspace with its projects, tasks of the projects are kept by their authors
+func DeleteWorkspace(workspaceUUID string) error {
+	res, err := db.Exec(deleteWorkspaceQuery, workspaceUUID)
+	if err != nil {
+		return fmt.Errorf("could not delete workspace: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	log.Info().Msgf("Workspace with uuid = %s has been deleted", workspaceUUID)
+	return nil
+}
+
+// Select members of workspace in order of joining
+func SelectMembers(workspaceUUID string) (members []models.Member, err error) {
+	selectMembers, err := db.Prepare(selectMembersQuery)
+	if err != nil {
+		return nil, fmt.Errorf("could not prepare select members query: %v", err)
+	}
+	defer func() {
+		if err := selectMembers.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close database connection")
+		}
+	}()
+	rows, err := selectMembers.Query(workspaceUUID)
+	if err != nil {
+		return nil, fmt.Errorf("could not select members: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		member := models.Member{}
+		if err = rows.Scan(&member.UserUUID, &member.Login, &member.Role, &member.CreatedAt); err != nil {
+			return nil, fmt.Errorf("could not read query: %v", err)
+		}
+		members = append(members, member)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not select members: %v", err)
+	}
+	return members, nil
+}
+
+// Change role of member, ErrLastOwner is returned when the only owner would be demoted
+func UpdateMemberRole(workspaceUUID string, userUUID string, role string) error {
+	return changeMember(workspaceUUID, userUUID, role)
+}
+
+// Remove member from workspace, ErrLastOwner is returned for the only owner
+func DeleteMember(workspaceUUID string, userUUID string) error {
+	return changeMember(workspaceUUID, userUUID, "")
+}
+
+// Set role of member, empty role removes the member
+func changeMember(workspaceUUID string, userUUID string, role string) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer func() {
+		if err != nil {
+			if err := tx.Rollback(); err != nil {
+				log.Error().Err(err).Msg("Could not rollback transaction")
+			}
+		}
+	}()
+
+	owners, err := tx.Query(selectOwnersQuery, workspaceUUID)
+	if err != nil {
+		return fmt.Errorf("could not select owners: %v", err)
+	}
+	count, isOwner := 0, false
+	for owners.Next() {
+		var owner string
+		if err = owners.Scan(&owner); err != nil {
+			owners.Close()
+			return fmt.Errorf("could not read query: %v", err)
+		}
+		count++
+		isOwner = isOwner || owner == userUUID
+	}
+	owners.Close()
+	if err = owners.Err(); err != nil {
+		return fmt.Errorf("could not select owners: %v", err)
+	}
+	if isOwner && count == 1 && role != models.WorkspaceOwner {
+		return ErrLastOwner
+	}
+
+	var res sql.Result
+	if role == "" {
+		res, err = tx.Exec(deleteMemberQuery, workspaceUUID, userUUID)
+	} else {
+		res, err = tx.Exec(updateMemberQuery, workspaceUUID, userUUID, role)
+	}
+	if err != nil {
+		return fmt.Errorf("could not change member: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit member: %v", err)
+	}
+	log.Info().Msgf("Member %s of workspace with uuid = %s has been changed", userUUID, workspaceUUID)
+	return nil
+}
+
+// Invite user by login into workspace with the role
+func InsertInvitation(workspaceUUID string, inviterUUID string, login string, role string) (invitation models.Invitation, err error) {
+	var userUUID string
+	if err = db.QueryRow(selectUserUUIDQuery, login).Scan(&userUUID); err != nil {
+		if err == sql.ErrNoRows {
+			return models.Invitation{}, ErrUserNotFound
+		}
+		return models.Invitation{}, fmt.Errorf("could not select user: %v", err)
+	}
+	if _, err = SelectWorkspaceRole(userUUID, workspaceUUID); err == nil {
+		return models.Invitation{}, ErrAlreadyMember
+	} else if err != ErrNotFound {
+		return models.Invitation{}, err
+	}
+	workspace, err := SelectWorkspace(inviterUUID, workspaceUUID)
+	if err != nil {
+		return models.Invitation{}, err
+	}
+	inviter, err := SelectLoginByUUID(inviterUUID)
+	if err != nil {
+		return models.Invitation{}, fmt.Errorf("could not get login: %v", err)
+	}
+
+	invitation = models.Invitation{WorkspaceUUID: workspaceUUID, Workspace: workspace.Name, Login: login, Role: role, InvitedBy: inviter}
+	err = db.QueryRow(insertInvitationQuery, uuid.NewV4().String(), workspaceUUID, userUUID, inviterUUID, role).
+		Scan(&invitation.UUID, &invitation.CreatedAt)
+	if err != nil {
+		return models.Invitation{}, fmt.Errorf("could not insert invitation into database: %v", err)
+	}
+	log.Info().Msgf("Invitation with uuid = %s is saved in database", invitation.UUID)
+	return invitation, nil
+}
+
+// Select pending invitations into workspace
+func SelectWorkspaceInvitations(workspaceUUID string) ([]models.Invitation, error) {
+	return selectInvitations(selectWorkspaceInvitationsQuery, workspaceUUID)
+}
+
+// Select pending invitations of user
+func SelectReceivedInvitations(userUUID string) ([]models.Invitation, error) {
+	return selectInvitations(selectReceivedInvitationsQuery, userUUID)
+}
+
+func selectInvitations(q string, args ...interface{}) (invitations []models.Invitation, err error) {
+	selectInvitations, err := db.Prepare(q)
+	if err != nil {
+		return nil, fmt.Errorf("could not prepare select invitations query: %v", err)
+	}
+	defer func() {
+		if err := selectInvitations.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close database connection")
+		}
+	}()
+	rows, err := selectInvitations.Query(args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not select invitations: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		i := models.Invitation{}
+		if err = rows.Scan(&i.UUID, &i.WorkspaceUUID, &i.Workspace, &i.Login, &i.Role, &i.InvitedBy, &i.CreatedAt); err != nil {
+			return nil, fmt.Errorf("could not read query: %v", err)
+		}
+		invitations = append(invitations, i)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not select invitations: %v", err)
+	}
+	return invitations, nil
+}
+
+// Accept invitation of user, user joins workspace with the invited role
+func AcceptInvitation(userUUID string, invitationUUID string) (workspace models.Workspace, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return models.Workspace{}, fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer func() {
+		if err != nil {
+			if err := tx.Rollback(); err != nil {
+				log.Error().Err(err).Msg("Could not rollback transaction")
+			}
+		}
+	}()
+
+	var workspaceUUID, role string
+	err = tx.QueryRow(acceptInvitationQuery, invitationUUID, userUUID).Scan(&workspaceUUID, &role)
+	if err == sql.ErrNoRows {
+		return models.Workspace{}, ErrNotFound
+	}
+	if err != nil {
+		return models.Workspace{}, fmt.Errorf("could not delete invitation: %v", err)
+	}
+	if _, err = tx.Exec(insertMemberQuery, workspaceUUID, userUUID, role); err != nil {
+		return models.Workspace{}, fmt.Errorf("could not insert member into database: %v", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return models.Workspace{}, fmt.Errorf("could not commit invitation: %v", err)
+	}
+	log.Info().Msgf("Invitation with uuid = %s has been accepted", invitationUUID)
+	return SelectWorkspace(userUUID, workspaceUUID)
+}
+
+// Delete invitation, either by invited user or by member managing members of the workspace
+func DeleteInvitation(userUUID string, invitationUUID string) error {
+	res, err := db.Exec(deleteInvitationQuery, invitationUUID, userUUID)
+	if err != nil {
+		return fmt.Errorf("could not delete invitation: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	log.Info().Msgf("Invitation with uuid = %s has been deleted", invitationUUID)
+	return nil
+}
@@ -0,0 +1,83 @@
+package query
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Kolya59/todo-service/models"
+)
+
+// Match reports whether task matches the node, nil node matches any task.
+// It is used where filter can not be compiled into a database query, e.g. for encrypted values.
+func Match(n Node, task models.Task) bool {
+	if n == nil {
+		return true
+	}
+	switch n := n.(type) {
+	case And:
+		for _, child := range n.Nodes {
+			if !Match(child, task) {
+				return false
+			}
+		}
+		return true
+	case Or:
+		for _, child := range n.Nodes {
+			if Match(child, task) {
+				return true
+			}
+		}
+		return false
+	case Not:
+		return !Match(n.Node, task)
+	case Resolved:
+		return task.IsResolved == n.Value
+	case Label:
+		for _, label := range task.Labels {
+			if label == n.Name {
+				return true
+			}
+		}
+		return false
+	case HasDue:
+		return task.DueDate != nil
+	case HasLabel:
+		return len(task.Labels) > 0
+	case Project:
+		return task.ProjectUUID != nil && strings.EqualFold(*task.ProjectUUID, n.UUID)
+	case HasProject:
+		return task.ProjectUUID != nil
+	case Shared:
+		return task.Role != "" && task.Role != models.RoleOwner
+	case Assignee:
+		for _, login := range task.Assignees {
+			if strings.EqualFold(login, n.Login) {
+				return true
+			}
+		}
+		return false
+	case HasAssignee:
+		return len(task.Assignees) > 0
+	case Before:
+		t := field(task, n.Field)
+		return t != nil && t.Before(n.Time)
+	case NotBefore:
+		t := field(task, n.Field)
+		return t != nil && !t.Before(n.Time)
+	case Text:
+		return strings.Contains(strings.ToLower(task.Value), strings.ToLower(n.Value))
+	default:
+		return false
+	}
+}
+
+func field(task models.Task, name string) *time.Time {
+	switch name {
+	case FieldDue:
+		return task.DueDate
+	case FieldCreated:
+		return &task.CreatedAt
+	default:
+		return nil
+	}
+}
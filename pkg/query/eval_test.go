@@ -0,0 +1,109 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Kolya59/todo-service/models"
+)
+
+func TestMatch(t *testing.T) {
+	project := "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+	yesterday := testNow.Add(-24 * time.Hour)
+	tomorrow := testNow.Add(24 * time.Hour)
+	tasks := map[string]models.Task{
+		"overdue": {
+			Value:     "Pay the Rent",
+			DueDate:   &yesterday,
+			CreatedAt: testNow.Add(-72 * time.Hour),
+			Labels:    []string{"home"},
+			Role:      models.RoleOwner,
+		},
+		"shared": {
+			Value:       "Review release notes",
+			DueDate:     &tomorrow,
+			CreatedAt:   testNow,
+			Labels:      []string{"work", "urgent"},
+			ProjectUUID: &project,
+			Role:        models.RoleEditor,
+			Assignees:   []string{"Alice"},
+		},
+		"done": {
+			Value:      "Water plants",
+			IsResolved: true,
+			CreatedAt:  testNow.Add(-24 * time.Hour),
+			Labels:     []string{},
+			Role:       models.RoleOwner,
+		},
+	}
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"done", "overdue", "shared"}},
+		{"is:open", []string{"overdue", "shared"}},
+		{"is:done", []string{"done"}},
+		{"is:overdue", []string{"overdue"}},
+		{"is:shared", []string{"shared"}},
+		{"label:home OR label:urgent", []string{"overdue", "shared"}},
+		{"-label:home", []string{"done", "shared"}},
+		{"has:label", []string{"overdue", "shared"}},
+		{"no:due", []string{"done"}},
+		{"due:none", []string{"done"}},
+		// Missing due date does not match comparisons, also negated ones
+		{"due<today", []string{"overdue"}},
+		{"due>=today", []string{"shared"}},
+		{"-due<today", []string{"done", "shared"}},
+		{"due:tomorrow", []string{"shared"}},
+		{"created:today", []string{"shared"}},
+		{"created<today", []string{"done", "overdue"}},
+		{"project:" + project, []string{"shared"}},
+		{"no:project", []string{"done", "overdue"}},
+		{"assignee:alice", []string{"shared"}},
+		{"has:assignee", []string{"shared"}},
+		{"rent", []string{"overdue"}},
+		{`"release notes" is:open`, []string{"shared"}},
+		{"(rent OR plants) -is:done", []string{"overdue"}},
+	}
+	for _, test := range tests {
+		n, err := Parse(test.query, testNow)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", test.query, err)
+		}
+		var got []string
+		for _, name := range []string{"done", "overdue", "shared"} {
+			if Match(n, tasks[name]) {
+				got = append(got, name)
+			}
+		}
+		if !equalNames(got, test.want) {
+			t.Errorf("%q matches %v, want %v", test.query, got, test.want)
+		}
+	}
+}
+
+func TestMatchMe(t *testing.T) {
+	n, err := Parse("assignee:me OR label:x", testNow)
+	if err != nil {
+		t.Fatalf("could not parse: %v", err)
+	}
+	task := models.Task{Assignees: []string{"bob"}}
+	if Match(n, task) {
+		t.Errorf("unresolved assignee:me matches")
+	}
+	if !Match(Me(n, "Bob"), task) {
+		t.Errorf("assignee:me of bob does not match task assigned to bob")
+	}
+}
+
+func equalNames(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
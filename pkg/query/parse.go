@@ -0,0 +1,341 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Grammar:
+//
+//	query   = or
+//	or      = and { "OR" and }
+//	and     = unary { unary }
+//	unary   = "-" unary | "(" or ")" | term
+//	term    = key ":" value | field op date | word | quoted
+//
+// Keys are is (open, resolved, done, closed, overdue, shared), has and no (due, label, project, assignee), label,
+// project (uuid) and assignee (login or me).
+// Fields are due and created, op is one of : < <= > >=, date is YYYY-MM-DD, today, tomorrow or
+// yesterday in UTC, due:none matches tasks without due date. Any other word or quoted phrase is text.
+
+// Error is returned for malformed query
+type Error struct {
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("invalid query at %d: %s", e.Pos, e.Msg)
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenQuoted
+	tokenOpen
+	tokenClose
+	tokenNot
+	tokenOr
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+func tokenize(q string) ([]token, error) {
+	var tokens []token
+	runes := []rune(q)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenOpen, pos: i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenClose, pos: i})
+			i++
+		case r == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]):
+			tokens = append(tokens, token{kind: tokenNot, pos: i})
+			i++
+		case r == '"':
+			start := i
+			var value []rune
+			for i++; ; i++ {
+				if i >= len(runes) {
+					return nil, &Error{Pos: start, Msg: "unterminated quote"}
+				}
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				} else if runes[i] == '"' {
+					break
+				}
+				value = append(value, runes[i])
+			}
+			i++
+			tokens = append(tokens, token{kind: tokenQuoted, value: string(value), pos: start})
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '(' && runes[i] != ')' && runes[i] != '"' {
+				i++
+			}
+			value := string(runes[start:i])
+			kind := tokenWord
+			if value == "OR" {
+				kind = tokenOr
+			}
+			tokens = append(tokens, token{kind: kind, value: value, pos: start})
+		}
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	now    time.Time
+	end    int
+}
+
+// Parse parses filter query, relative dates are resolved against now. Empty query gives nil node.
+func Parse(q string, now time.Time) (Node, error) {
+	tokens, err := tokenize(q)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	p := &parser{tokens: tokens, now: now.UTC(), end: len([]rune(q))}
+	n, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, &Error{Pos: p.tokens[p.pos].pos, Msg: "unexpected )"}
+	}
+	return n, nil
+}
+
+func (p *parser) peek() *token {
+	if p.pos < len(p.tokens) {
+		return &p.tokens[p.pos]
+	}
+	return nil
+}
+
+func (p *parser) or() (Node, error) {
+	n, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	nodes := []Node{n}
+	for t := p.peek(); t != nil && t.kind == tokenOr; t = p.peek() {
+		p.pos++
+		n, err = p.and()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return Or{Nodes: nodes}, nil
+}
+
+func (p *parser) and() (Node, error) {
+	var nodes []Node
+	for t := p.peek(); t != nil && t.kind != tokenOr && t.kind != tokenClose; t = p.peek() {
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+	if len(nodes) == 0 {
+		pos := p.end
+		if t := p.peek(); t != nil {
+			pos = t.pos
+		}
+		return nil, &Error{Pos: pos, Msg: "expected filter"}
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return And{Nodes: nodes}, nil
+}
+
+func (p *parser) unary() (Node, error) {
+	t := p.peek()
+	p.pos++
+	switch t.kind {
+	case tokenNot:
+		if next := p.peek(); next == nil || next.kind == tokenOr || next.kind == tokenClose {
+			return nil, &Error{Pos: t.pos, Msg: "nothing to negate"}
+		}
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return Not{Node: n}, nil
+	case tokenOpen:
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if next := p.peek(); next == nil || next.kind != tokenClose {
+			return nil, &Error{Pos: t.pos, Msg: "unclosed ("}
+		}
+		p.pos++
+		return n, nil
+	case tokenQuoted:
+		return Text{Value: t.value}, nil
+	default:
+		return p.term(t)
+	}
+}
+
+func (p *parser) term(t *token) (Node, error) {
+	i := strings.IndexAny(t.value, ":<>")
+	if i <= 0 {
+		return Text{Value: t.value}, nil
+	}
+	key, rest := strings.ToLower(t.value[:i]), t.value[i:]
+	op := rest[:1]
+	if strings.HasPrefix(rest, "<=") || strings.HasPrefix(rest, ">=") {
+		op = rest[:2]
+	}
+	value := rest[len(op):]
+
+	switch key {
+	case FieldDue, FieldCreated:
+		return p.compare(t, key, op, value)
+	case "is", "has", "no", "label", "project", "assignee":
+		if op != ":" {
+			return nil, &Error{Pos: t.pos, Msg: fmt.Sprintf("%s requires :", key)}
+		}
+	default:
+		if op == ":" {
+			return nil, &Error{Pos: t.pos, Msg: fmt.Sprintf("unknown filter %q, quote it to search text", key)}
+		}
+		// Comparison of unknown field is plain text like a<b
+		return Text{Value: t.value}, nil
+	}
+
+	value = strings.ToLower(value)
+	switch key {
+	case "is":
+		switch value {
+		case "open":
+			return Resolved{Value: false}, nil
+		case "resolved", "done", "closed":
+			return Resolved{Value: true}, nil
+		case "overdue":
+			return And{Nodes: []Node{Resolved{Value: false}, Before{Field: FieldDue, Time: p.now}}}, nil
+		case "shared":
+			return Shared{}, nil
+		}
+	case "has", "no":
+		var n Node
+		switch value {
+		case "due":
+			n = HasDue{}
+		case "label":
+			n = HasLabel{}
+		case "project":
+			n = HasProject{}
+		case "assignee":
+			n = HasAssignee{}
+		}
+		if n != nil && key == "no" {
+			return Not{Node: n}, nil
+		}
+		if n != nil {
+			return n, nil
+		}
+	case "label":
+		if value != "" {
+			return Label{Name: value}, nil
+		}
+	case "project":
+		if isUUID(value) {
+			return Project{UUID: value}, nil
+		}
+	case "assignee":
+		if value == "me" {
+			return AssignedToMe{}, nil
+		}
+		if value != "" {
+			return Assignee{Login: value}, nil
+		}
+	}
+	return nil, &Error{Pos: t.pos, Msg: fmt.Sprintf("invalid value %q of %s", value, key)}
+}
+
+func (p *parser) compare(t *token, field string, op string, value string) (Node, error) {
+	if field == FieldDue && op == ":" && strings.ToLower(value) == "none" {
+		return Not{Node: HasDue{}}, nil
+	}
+	day, err := p.date(value)
+	if err != nil {
+		return nil, &Error{Pos: t.pos, Msg: err.Error()}
+	}
+	next := day.AddDate(0, 0, 1)
+	// Dates mean whole days, so every comparison is a half-open range of midnights
+	switch op {
+	case ":":
+		return And{Nodes: []Node{NotBefore{Field: field, Time: day}, Before{Field: field, Time: next}}}, nil
+	case "<":
+		return Before{Field: field, Time: day}, nil
+	case "<=":
+		return Before{Field: field, Time: next}, nil
+	case ">":
+		return NotBefore{Field: field, Time: next}, nil
+	default:
+		return NotBefore{Field: field, Time: day}, nil
+	}
+}
+
+func (p *parser) date(value string) (time.Time, error) {
+	today := time.Date(p.now.Year(), p.now.Month(), p.now.Day(), 0, 0, 0, 0, time.UTC)
+	switch strings.ToLower(value) {
+	case "today":
+		return today, nil
+	case "tomorrow":
+		return today.AddDate(0, 0, 1), nil
+	case "yesterday":
+		return today.AddDate(0, 0, -1), nil
+	}
+	day, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", value)
+	}
+	return day, nil
+}
+
+// Project is referenced by uuid, so that filter never reaches database with malformed one
+func isUUID(value string) bool {
+	if len(value) != 36 {
+		return false
+	}
+	for i, r := range value {
+		switch i {
+		case 8, 13, 18, 23:
+			if r != '-' {
+				return false
+			}
+		default:
+			if !strings.ContainsRune("0123456789abcdef", r) {
+				return false
+			}
+		}
+	}
+	return true
+}
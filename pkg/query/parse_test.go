@@ -0,0 +1,111 @@
+package query
+
+import (
+	"testing"
+	"time"
+)
+
+// Monday
+var testNow = time.Date(2026, time.October, 19, 10, 0, 0, 0, time.UTC)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		// Precedence, AND binds tighter than OR
+		{"a b OR c", `(("a" "b") OR "c")`},
+		{"a OR b c", `("a" OR ("b" "c"))`},
+		{"a (b OR c)", `("a" ("b" OR "c"))`},
+		{"((a))", `"a"`},
+		{"a or b", `("a" "or" "b")`},
+		// Negation
+		{"-label:x is:open", `(-label:x is:open)`},
+		{"-(a OR b)", `-("a" OR "b")`},
+		{"--a", `--"a"`},
+		{"- a", `("-" "a")`},
+		{"a-b", `"a-b"`},
+		// Quoting
+		{`"hello world"`, `"hello world"`},
+		{`"say \"hi\"" x`, `("say \"hi\"" "x")`},
+		{`"is:open"`, `"is:open"`},
+		{`a"b"`, `("a" "b")`},
+		// Keys
+		{"IS:DONE", "is:resolved"},
+		{"is:closed is:shared", "(is:resolved is:shared)"},
+		{"is:overdue", "(is:open due<2026-10-19T10:00:00Z)"},
+		{"has:due no:label", "(has:due -has:label)"},
+		{"no:project has:assignee", "(-has:project has:assignee)"},
+		{"label:Work", "label:work"},
+		{"assignee:me", "assignee:me"},
+		{"assignee:Bob", "assignee:bob"},
+		{"project:6BA7B810-9DAD-11D1-80B4-00C04FD430C8", "project:6ba7b810-9dad-11d1-80b4-00c04fd430c8"},
+		{"a<b", `"a<b"`},
+		// Dates are whole days in UTC
+		{"due:2026-10-20", "(due>=2026-10-20T00:00:00Z due<2026-10-21T00:00:00Z)"},
+		{"due:today", "(due>=2026-10-19T00:00:00Z due<2026-10-20T00:00:00Z)"},
+		{"due<today", "due<2026-10-19T00:00:00Z"},
+		{"due<=tomorrow", "due<2026-10-21T00:00:00Z"},
+		{"created>yesterday", "created>=2026-10-19T00:00:00Z"},
+		{"created>=2026-01-31", "created>=2026-01-31T00:00:00Z"},
+		{"due:none", "-has:due"},
+		{"DUE:NONE", "-has:due"},
+	}
+	for _, test := range tests {
+		n, err := Parse(test.query, testNow)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", test.query, err)
+			continue
+		}
+		if got := n.String(); got != test.want {
+			t.Errorf("Parse(%q) = %v, want %v", test.query, got, test.want)
+		}
+	}
+}
+
+func TestParseEmpty(t *testing.T) {
+	for _, q := range []string{"", "   "} {
+		if n, err := Parse(q, testNow); n != nil || err != nil {
+			t.Errorf("Parse(%q) = %v, %v, want nil", q, n, err)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		pos   int
+		msg   string
+	}{
+		{`a "bc`, 2, "unterminated quote"},
+		{"a (b", 2, "unclosed ("},
+		{"a )", 2, "unexpected )"},
+		{"a OR", 4, "expected filter"},
+		{"OR a", 0, "expected filter"},
+		{"()", 1, "expected filter"},
+		{"a -)", 2, "nothing to negate"},
+		{"a -OR b", 2, "nothing to negate"},
+		{"x foo:bar", 2, `unknown filter "foo", quote it to search text`},
+		{"x due:2026-13-01", 2, `invalid date "2026-13-01", expected YYYY-MM-DD`},
+		{"created<soon", 0, `invalid date "soon", expected YYYY-MM-DD`},
+		{"is:maybe", 0, `invalid value "maybe" of is`},
+		{"has:time", 0, `invalid value "time" of has`},
+		{"label:", 0, `invalid value "" of label`},
+		{"label<x", 0, "label requires :"},
+		{"project:abc", 0, `invalid value "abc" of project`},
+		// Positions count runes
+		{"ünïcode )", 8, "unexpected )"},
+		{"ünï (", 5, "expected filter"},
+	}
+	for _, test := range tests {
+		_, err := Parse(test.query, testNow)
+		queryErr, ok := err.(*Error)
+		if !ok {
+			t.Errorf("Parse(%q) = %v, want query error", test.query, err)
+			continue
+		}
+		if queryErr.Pos != test.pos || queryErr.Msg != test.msg {
+			t.Errorf("Parse(%q) = %v at %d, want %v at %d", test.query, queryErr.Msg, queryErr.Pos, test.msg, test.pos)
+		}
+	}
+}
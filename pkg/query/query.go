@@ -0,0 +1,178 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Node is a node of parsed filter
+type Node interface {
+	String() string
+}
+
+// And matches tasks matching all nodes
+type And struct {
+	Nodes []Node
+}
+
+// Or matches tasks matching any node
+type Or struct {
+	Nodes []Node
+}
+
+// Not matches tasks not matching the node
+type Not struct {
+	Node Node
+}
+
+// Resolved matches tasks by status, is:resolved or is:open
+type Resolved struct {
+	Value bool
+}
+
+// Label matches tasks having the label
+type Label struct {
+	Name string
+}
+
+// HasDue matches tasks having a due date
+type HasDue struct{}
+
+// HasLabel matches tasks having any label
+type HasLabel struct{}
+
+// Project matches tasks of the project
+type Project struct {
+	UUID string
+}
+
+// HasProject matches tasks of any project
+type HasProject struct{}
+
+// Shared matches tasks of other users shared with the user
+type Shared struct{}
+
+// Assignee matches tasks assigned to the user by login, case is ignored
+type Assignee struct {
+	Login string
+}
+
+// AssignedToMe matches tasks assigned to the requesting user, it is resolved by Me before matching in memory
+type AssignedToMe struct{}
+
+// HasAssignee matches tasks assigned to anyone
+type HasAssignee struct{}
+
+// Fields of tasks compared with time
+const (
+	FieldDue     = "due"
+	FieldCreated = "created"
+)
+
+// Before matches tasks whose field is earlier than Time, tasks without the field never match
+type Before struct {
+	Field string
+	Time  time.Time
+}
+
+// NotBefore matches tasks whose field is Time or later, tasks without the field never match
+type NotBefore struct {
+	Field string
+	Time  time.Time
+}
+
+// Text matches tasks containing the text, case is ignored
+type Text struct {
+	Value string
+}
+
+func join(nodes []Node, sep string) string {
+	parts := make([]string, len(nodes))
+	for i, n := range nodes {
+		parts[i] = n.String()
+	}
+	return "(" + strings.Join(parts, sep) + ")"
+}
+
+func (n And) String() string          { return join(n.Nodes, " ") }
+func (n Or) String() string           { return join(n.Nodes, " OR ") }
+func (n Not) String() string          { return "-" + n.Node.String() }
+func (n Label) String() string        { return "label:" + n.Name }
+func (n HasDue) String() string       { return "has:due" }
+func (n HasLabel) String() string     { return "has:label" }
+func (n Project) String() string      { return "project:" + n.UUID }
+func (n HasProject) String() string   { return "has:project" }
+func (n Shared) String() string       { return "is:shared" }
+func (n Assignee) String() string     { return "assignee:" + n.Login }
+func (n AssignedToMe) String() string { return "assignee:me" }
+func (n HasAssignee) String() string  { return "has:assignee" }
+func (n Text) String() string         { return fmt.Sprintf("%q", n.Value) }
+func (n Before) String() string       { return n.Field + "<" + n.Time.Format(time.RFC3339) }
+func (n NotBefore) String() string    { return n.Field + ">=" + n.Time.Format(time.RFC3339) }
+
+func (n Resolved) String() string {
+	if n.Value {
+		return "is:resolved"
+	}
+	return "is:open"
+}
+
+// All joins nodes with And, nil nodes are skipped and nil is returned when none is left
+func All(nodes ...Node) Node {
+	var rest []Node
+	for _, n := range nodes {
+		if n != nil {
+			rest = append(rest, n)
+		}
+	}
+	switch len(rest) {
+	case 0:
+		return nil
+	case 1:
+		return rest[0]
+	default:
+		return And{Nodes: rest}
+	}
+}
+
+// Me replaces AssignedToMe nodes with Assignee of the login
+func Me(n Node, login string) Node {
+	switch n := n.(type) {
+	case And:
+		nodes := make([]Node, len(n.Nodes))
+		for i, child := range n.Nodes {
+			nodes[i] = Me(child, login)
+		}
+		return And{Nodes: nodes}
+	case Or:
+		nodes := make([]Node, len(n.Nodes))
+		for i, child := range n.Nodes {
+			nodes[i] = Me(child, login)
+		}
+		return Or{Nodes: nodes}
+	case Not:
+		return Not{Node: Me(n.Node, login)}
+	case AssignedToMe:
+		return Assignee{Login: login}
+	default:
+		return n
+	}
+}
+
+// Walk calls fn for the node and all its descendants
+func Walk(n Node, fn func(Node)) {
+	fn(n)
+	switch n := n.(type) {
+	case And:
+		for _, child := range n.Nodes {
+			Walk(child, fn)
+		}
+	case Or:
+		for _, child := range n.Nodes {
+			Walk(child, fn)
+		}
+	case Not:
+		Walk(n.Node, fn)
+	}
+}
@@ -0,0 +1,405 @@
+package quickadd
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kolya59/todo-service/models"
+)
+
+// Inline syntax of task values:
+//
+//	#label          adds label
+//	!priority       sets priority, one of !low, !medium and !high
+//	high priority   sets priority as well, also low and medium
+//	+project        moves task into project by its name, case is ignored and names may have spaces
+//	^date           sets due date, date is a day of one word (today, tomorrow, fri, friday, YYYY-MM-DD),
+//	                optionally followed by @ and time (17:00, 5pm, 5:30pm, noon)
+//
+// Unless ^date is given, the first natural date in the text sets due date:
+//
+//	[on|by|due] day [[at] time]   tomorrow 5pm, next friday, on nov 3, in 2 weeks at 9:30
+//	[at] time [day]               at noon, 17:00 tomorrow
+//
+// Day is today, tonight, tomorrow, weekday, next weekday (the one of next week), next week (its monday),
+// in N days or weeks, month and day (nov 3, november 3rd) or YYYY-MM-DD. Weekdays are written in full,
+// short ones like sat or sun are words too often, they are understood only in ^date.
+// Weekday and month with day are the next ones, today included. Time alone is today or tomorrow when
+// it has passed, day without time is due by its end. Dates are resolved in location of now.
+// Words which do not parse stay in the value.
+
+// Task holds fields parsed out of the value, the value keeps the rest of the text
+type Task struct {
+	Value       string     `json:"value"`
+	Labels      []string   `json:"labels"`
+	Priority    string     `json:"priority,omitempty"`
+	DueDate     *time.Time `json:"due_date,omitempty"`
+	ProjectUUID *string    `json:"project_uuid,omitempty"`
+	// Name of the project as it is written in the value
+	Project string `json:"project,omitempty"`
+}
+
+// Same as limit of postgres.NormalizeLabels
+const maxLabelLength = 64
+
+// Longest project name of +project, in words
+const maxProjectWords = 5
+
+var priorities = map[string]string{
+	"low":    models.PriorityLow,
+	"medium": models.PriorityMedium,
+	"high":   models.PriorityHigh,
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+var shortWeekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+var months = map[string]time.Month{
+	"jan": time.January, "january": time.January,
+	"feb": time.February, "february": time.February,
+	"mar": time.March, "march": time.March,
+	"apr": time.April, "april": time.April,
+	"may": time.May,
+	"jun": time.June, "june": time.June,
+	"jul": time.July, "july": time.July,
+	"aug": time.August, "august": time.August,
+	"sep": time.September, "sept": time.September, "september": time.September,
+	"oct": time.October, "october": time.October,
+	"nov": time.November, "november": time.November,
+	"dec": time.December, "december": time.December,
+}
+
+// Words introducing natural date, they are dropped with it
+var dateWords = map[string]bool{"on": true, "by": true, "due": true}
+
+type parser struct {
+	task Task
+	now  time.Time
+	// Project uuids by lower case name
+	projects map[string]string
+	// Natural date is taken once and not at all when ^date is given
+	dated bool
+}
+
+// Parse moves inline syntax and natural date of text into fields, relative dates are resolved against now.
+// Projects map lower case names to uuids, +project of other names stays in the value.
+// Whitespace of the rest is collapsed within lines, line breaks are kept.
+func Parse(text string, now time.Time, projects map[string]string) Task {
+	p := &parser{task: Task{Labels: []string{}}, now: now, projects: projects}
+	lines := strings.Split(text, "\n")
+	for _, line := range lines {
+		for _, word := range strings.Fields(line) {
+			if strings.HasPrefix(word, "^") {
+				_, ok := p.due(word[1:])
+				p.dated = p.dated || ok
+			}
+		}
+	}
+	for i, line := range lines {
+		words := strings.Fields(line)
+		var rest []string
+		for j := 0; j < len(words); {
+			n := p.take(words[j:])
+			if n == 0 {
+				rest = append(rest, words[j])
+				n = 1
+			}
+			j += n
+		}
+		lines[i] = strings.Join(rest, " ")
+	}
+	p.task.Value = strings.TrimSpace(strings.Join(lines, "\n"))
+	return p.task
+}
+
+// Take leading words into the fields, number of taken words is returned
+func (p *parser) take(words []string) int {
+	word := words[0]
+	if len(word) >= 2 {
+		rest := word[1:]
+		switch word[0] {
+		case '#':
+			// Words which are not valid labels, like #(1), stay in the value
+			if len(rest) > maxLabelLength || rest[0] == '-' || strings.ContainsAny(rest, `"()`) {
+				return 0
+			}
+			p.task.Labels = append(p.task.Labels, rest)
+			return 1
+		case '!':
+			priority, ok := priorities[strings.ToLower(rest)]
+			if !ok {
+				return 0
+			}
+			p.task.Priority = priority
+			return 1
+		case '^':
+			due, ok := p.due(rest)
+			if !ok {
+				return 0
+			}
+			p.task.DueDate = &due
+			return 1
+		case '+':
+			return p.project(words)
+		}
+	}
+	lower := strings.ToLower(word)
+	if len(words) >= 2 && strings.ToLower(trim(words[1])) == "priority" {
+		if priority, ok := priorities[lower]; ok {
+			p.task.Priority = priority
+			return 2
+		}
+	}
+	if p.dated {
+		return 0
+	}
+	skip := 0
+	switch {
+	case dateWords[lower]:
+		skip = 1
+	case lower == "at":
+		// At is followed by time, at home is not a date
+		if !startsWithClock(words[1:]) {
+			return 0
+		}
+		skip = 1
+	}
+	due, n := p.natural(words[skip:])
+	if n == 0 {
+		return 0
+	}
+	p.task.DueDate, p.dated = &due, true
+	return skip + n
+}
+
+// Take the longest known project name following +
+func (p *parser) project(words []string) int {
+	taken := 0
+	name := words[0][1:]
+	for n := 1; n <= len(words) && n <= maxProjectWords; n++ {
+		if n > 1 {
+			name += " " + words[n-1]
+		}
+		if id, ok := p.projects[strings.ToLower(trim(name))]; ok {
+			projectUUID := id
+			p.task.ProjectUUID, p.task.Project, taken = &projectUUID, trim(name), n
+		}
+	}
+	return taken
+}
+
+// Parse day of ^date with optional @time
+func (p *parser) due(value string) (time.Time, bool) {
+	date, clock := value, ""
+	if i := strings.IndexByte(value, '@'); i >= 0 {
+		date, clock = value[:i], value[i+1:]
+	}
+	var day time.Time
+	n := 0
+	if weekday, ok := shortWeekdays[strings.ToLower(date)]; ok {
+		day, n = p.weekday(weekday), 1
+	} else {
+		day, n = p.day([]string{date})
+	}
+	if n != 1 {
+		return time.Time{}, false
+	}
+	if clock == "" {
+		return endOfDay(day), true
+	}
+	offset, ok := parseClock(clock)
+	if !ok {
+		return time.Time{}, false
+	}
+	return atClock(day, offset), true
+}
+
+// Parse natural date from the leading words, number of its words is returned
+func (p *parser) natural(words []string) (time.Time, int) {
+	if day, n := p.day(words); n > 0 {
+		rest := words[n:]
+		if len(rest) > 1 && strings.ToLower(rest[0]) == "at" && startsWithClock(rest[1:]) {
+			offset, _ := parseClock(trim(rest[1]))
+			return atClock(day, offset), n + 2
+		}
+		if startsWithClock(rest) {
+			offset, _ := parseClock(trim(rest[0]))
+			return atClock(day, offset), n + 1
+		}
+		return endOfDay(day), n
+	}
+	if !startsWithClock(words) {
+		return time.Time{}, 0
+	}
+	offset, _ := parseClock(trim(words[0]))
+	if day, n := p.day(words[1:]); n > 0 {
+		return atClock(day, offset), n + 1
+	}
+	due := atClock(p.today(), offset)
+	if !due.After(p.now) {
+		due = atClock(p.today().AddDate(0, 0, 1), offset)
+	}
+	return due, 1
+}
+
+func (p *parser) today() time.Time {
+	return time.Date(p.now.Year(), p.now.Month(), p.now.Day(), 0, 0, 0, 0, p.now.Location())
+}
+
+// Next occurrence of weekday, today included
+func (p *parser) weekday(weekday time.Weekday) time.Time {
+	today := p.today()
+	return today.AddDate(0, 0, (int(weekday)-int(today.Weekday())+7)%7)
+}
+
+// Parse day from the leading words into its midnight, number of its words is returned
+func (p *parser) day(words []string) (time.Time, int) {
+	if len(words) == 0 {
+		return time.Time{}, 0
+	}
+	today := p.today()
+	first := strings.ToLower(trim(words[0]))
+	second := ""
+	if len(words) > 1 {
+		second = strings.ToLower(trim(words[1]))
+	}
+	switch first {
+	case "today", "tonight":
+		return today, 1
+	case "tomorrow":
+		return today.AddDate(0, 0, 1), 1
+	case "next":
+		// Weeks start on monday
+		nextWeek := today.AddDate(0, 0, 7-(int(today.Weekday())+6)%7)
+		if second == "week" {
+			return nextWeek, 2
+		}
+		if weekday, ok := weekdays[second]; ok {
+			return nextWeek.AddDate(0, 0, (int(weekday)+6)%7), 2
+		}
+		return time.Time{}, 0
+	case "in":
+		if len(words) < 3 {
+			return time.Time{}, 0
+		}
+		count, err := strconv.Atoi(second)
+		if err != nil || count < 1 || count > 1000 {
+			return time.Time{}, 0
+		}
+		switch strings.ToLower(trim(words[2])) {
+		case "day", "days":
+			return today.AddDate(0, 0, count), 3
+		case "week", "weeks":
+			return today.AddDate(0, 0, 7*count), 3
+		}
+		return time.Time{}, 0
+	}
+	if weekday, ok := weekdays[first]; ok {
+		return p.weekday(weekday), 1
+	}
+	if month, ok := months[first]; ok && second != "" {
+		day, err := strconv.Atoi(strings.TrimRight(second, "stndrh"))
+		if err != nil || day < 1 || day > 31 {
+			return time.Time{}, 0
+		}
+		date := time.Date(today.Year(), month, day, 0, 0, 0, 0, today.Location())
+		if date.Day() != day {
+			// Day does not exist in the month, like feb 30
+			return time.Time{}, 0
+		}
+		if date.Before(today) {
+			date = date.AddDate(1, 0, 0)
+		}
+		return date, 2
+	}
+	if date, err := time.ParseInLocation("2006-01-02", first, today.Location()); err == nil {
+		return date, 1
+	}
+	return time.Time{}, 0
+}
+
+func startsWithClock(words []string) bool {
+	if len(words) == 0 {
+		return false
+	}
+	_, ok := parseClock(trim(words[0]))
+	return ok
+}
+
+// Parse time of day as 17:00, 5pm, 5:30pm or noon into the offset from midnight
+func parseClock(value string) (time.Duration, bool) {
+	value = strings.ToLower(value)
+	if value == "noon" {
+		return 12 * time.Hour, true
+	}
+	suffix := ""
+	if strings.HasSuffix(value, "am") || strings.HasSuffix(value, "pm") {
+		value, suffix = value[:len(value)-2], value[len(value)-2:]
+	}
+	hourPart, minutePart := value, "0"
+	if i := strings.IndexByte(value, ':'); i >= 0 {
+		hourPart, minutePart = value[:i], value[i+1:]
+		if len(minutePart) != 2 {
+			return 0, false
+		}
+	} else if suffix == "" {
+		// Bare number is not a time, 17 could be anything
+		return 0, false
+	}
+	hour, err := strconv.Atoi(hourPart)
+	if err != nil || hour < 0 {
+		return 0, false
+	}
+	minute, err := strconv.Atoi(minutePart)
+	if err != nil || minute < 0 || minute > 59 {
+		return 0, false
+	}
+	switch suffix {
+	case "":
+		if hour > 23 {
+			return 0, false
+		}
+	default:
+		if hour < 1 || hour > 12 {
+			return 0, false
+		}
+		hour %= 12
+		if suffix == "pm" {
+			hour += 12
+		}
+	}
+	return time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute, true
+}
+
+// Trailing punctuation of a sentence is ignored in dates and names
+func trim(word string) string {
+	return strings.TrimRight(word, ",.;!?")
+}
+
+// Wall clock time of the day in its zone, days of DST changes are not 24 hours long
+func atClock(day time.Time, offset time.Duration) time.Time {
+	hour, minute := int(offset/time.Hour), int(offset%time.Hour/time.Minute)
+	return time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, day.Location()).UTC()
+}
+
+func endOfDay(day time.Time) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), 23, 59, 59, 0, day.Location()).UTC()
+}
@@ -0,0 +1,96 @@
+package quickadd
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Kolya59/todo-service/models"
+)
+
+func TestParse(t *testing.T) {
+	// Monday
+	now := time.Date(2026, time.October, 19, 10, 0, 0, 0, time.UTC)
+	projects := map[string]string{"big launch": "u2", "home": "u1"}
+	endOf := func(year int, month time.Month, day int) *time.Time {
+		due := time.Date(year, month, day, 23, 59, 59, 0, time.UTC)
+		return &due
+	}
+	at := func(year int, month time.Month, day, hour, min int) *time.Time {
+		due := time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+		return &due
+	}
+	project := func(uuid string) *string {
+		return &uuid
+	}
+
+	tests := []struct {
+		text string
+		want Task
+	}{
+		{"Buy milk", Task{Value: "Buy milk", Labels: []string{}}},
+		{"sat down with team", Task{Value: "sat down with team", Labels: []string{}}},
+		{"Dentist tomorrow 5pm", Task{Value: "Dentist", Labels: []string{}, DueDate: at(2026, time.October, 20, 17, 0)}},
+		{"Pay rent today", Task{Value: "Pay rent", Labels: []string{}, DueDate: endOf(2026, time.October, 19)}},
+		{"Standup at 9:30", Task{Value: "Standup", Labels: []string{}, DueDate: at(2026, time.October, 20, 9, 30)}},
+		{"Lunch at noon", Task{Value: "Lunch", Labels: []string{}, DueDate: at(2026, time.October, 19, 12, 0)}},
+		{"meet at home at noon tomorrow", Task{Value: "meet at home", Labels: []string{}, DueDate: at(2026, time.October, 20, 12, 0)}},
+		{"Review next friday high priority", Task{Value: "Review", Labels: []string{}, Priority: models.PriorityHigh, DueDate: endOf(2026, time.October, 30)}},
+		{"Report due nov 3rd, please", Task{Value: "Report please", Labels: []string{}, DueDate: endOf(2026, time.November, 3)}},
+		{"x 2026-12-01 y tomorrow", Task{Value: "x y tomorrow", Labels: []string{}, DueDate: endOf(2026, time.December, 1)}},
+		{"Call mom tomorrow ^wed", Task{Value: "Call mom tomorrow", Labels: []string{}, DueDate: endOf(2026, time.October, 21)}},
+		{"Call mom ^fri@5pm", Task{Value: "Call mom", Labels: []string{}, DueDate: at(2026, time.October, 23, 17, 0)}},
+		{"Call mom ^someday", Task{Value: "Call mom ^someday", Labels: []string{}}},
+		{"Buy milk #errands #home !low", Task{Value: "Buy milk", Labels: []string{"errands", "home"}, Priority: models.PriorityLow}},
+		{"Fix #(1) !urgent", Task{Value: "Fix #(1) !urgent", Labels: []string{}}},
+		{"Ship +Big Launch in 2 weeks", Task{Value: "Ship", Labels: []string{}, DueDate: endOf(2026, time.November, 2), ProjectUUID: project("u2"), Project: "Big Launch"}},
+		{"Clean +home.", Task{Value: "Clean", Labels: []string{}, ProjectUUID: project("u1"), Project: "home"}},
+		{"Plan +Unknown", Task{Value: "Plan +Unknown", Labels: []string{}}},
+		{"First #a\n  second   line", Task{Value: "First\nsecond line", Labels: []string{"a"}}},
+	}
+	for _, test := range tests {
+		if got := Parse(test.text, now, projects); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Parse(%q) = %v, want %v", test.text, describe(got), describe(test.want))
+		}
+	}
+}
+
+// Task with due date and project shown by value
+func describe(task Task) string {
+	s := fmt.Sprintf("%q %v %v %q", task.Value, task.Labels, task.Priority, task.Project)
+	if task.DueDate != nil {
+		s += " " + task.DueDate.Format(time.RFC3339)
+	}
+	if task.ProjectUUID != nil {
+		s += " " + *task.ProjectUUID
+	}
+	return s
+}
+
+func TestParseDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("could not load zone: %v", err)
+	}
+	tests := []struct {
+		now  time.Time
+		text string
+		want time.Time
+	}{
+		// Clocks go back on November 1st, the day is 25 hours long
+		{time.Date(2026, time.October, 31, 12, 0, 0, 0, loc), "Dentist tomorrow 5pm", time.Date(2026, time.November, 1, 22, 0, 0, 0, time.UTC)},
+		{time.Date(2026, time.October, 31, 12, 0, 0, 0, loc), "Pay rent tomorrow", time.Date(2026, time.November, 2, 4, 59, 59, 0, time.UTC)},
+		{time.Date(2026, time.October, 31, 18, 0, 0, 0, loc), "Call at 5pm", time.Date(2026, time.November, 1, 22, 0, 0, 0, time.UTC)},
+		{time.Date(2026, time.October, 31, 12, 0, 0, 0, loc), "Call ^sun@9:30", time.Date(2026, time.November, 1, 14, 30, 0, 0, time.UTC)},
+		// Clocks go forward on March 8th, the day is 23 hours long
+		{time.Date(2026, time.March, 7, 12, 0, 0, 0, loc), "Standup tomorrow at 9am", time.Date(2026, time.March, 8, 13, 0, 0, 0, time.UTC)},
+		{time.Date(2026, time.March, 7, 12, 0, 0, 0, loc), "Pay rent tomorrow", time.Date(2026, time.March, 9, 3, 59, 59, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		got := Parse(test.text, test.now, nil)
+		if got.DueDate == nil || !got.DueDate.Equal(test.want) {
+			t.Errorf("Parse(%q) = %v, want due %v", test.text, describe(got), test.want.Format(time.RFC3339))
+		}
+	}
+}
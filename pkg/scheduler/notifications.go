@@ -0,0 +1,81 @@
+package scheduler
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Kolya59/todo-service/models"
+	"github.com/Kolya59/todo-service/pkg/notifier"
+	"github.com/Kolya59/todo-service/pkg/postgres"
+)
+
+func (s *Scheduler) deliverNotifications() {
+	notifications, err := postgres.ClaimNotifications(leaseTime, batchSize)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to claim notifications")
+		return
+	}
+	for _, n := range notifications {
+		if err := s.deliver(n); err != nil {
+			log.Error().Err(err).Msgf("Failed to deliver notification %v, attempt %v", n.UUID, n.Attempts)
+			continue
+		}
+		if err := postgres.MarkNotificationDelivered(n.UUID); err != nil {
+			log.Error().Err(err).Msgf("Failed to mark notification %v as delivered", n.UUID)
+		}
+	}
+}
+
+func (s *Scheduler) deliver(claimed models.Notification) error {
+	n, err := postgres.SelectNotification(claimed.UUID)
+	if err != nil {
+		return fmt.Errorf("could not get notification: %v", err)
+	}
+	to, err := postgres.SelectLoginByUUID(n.UserUUID)
+	if err != nil {
+		return fmt.Errorf("could not get login: %v", err)
+	}
+	msg := notifier.Message{
+		ID:      n.UUID,
+		To:      to,
+		Subject: subject(n),
+		FireAt:  n.CreatedAt,
+	}
+	if n.TaskUUID != nil {
+		msg.TaskUUID = *n.TaskUUID
+	}
+	switch {
+	case n.Comment != nil:
+		msg.Body = *n.Comment
+	case n.Task != nil:
+		msg.Body = *n.Task
+	}
+	return s.Notifier.Notify(msg)
+}
+
+func subject(n models.Notification) string {
+	task := "a task"
+	if n.Task != nil {
+		task = fmt.Sprintf("%q", *n.Task)
+	}
+	switch n.Kind {
+	case models.NotificationMention:
+		return fmt.Sprintf("%s mentioned you on %s", n.Actor, task)
+	case models.NotificationComment:
+		return fmt.Sprintf("%s commented on %s", n.Actor, task)
+	case models.NotificationAssigned:
+		return fmt.Sprintf("%s assigned you to %s", n.Actor, task)
+	case models.NotificationShare:
+		if n.ProjectUUID != nil {
+			project := "a project"
+			if n.Project != nil {
+				project = fmt.Sprintf("%q", *n.Project)
+			}
+			return fmt.Sprintf("%s shared %s with you", n.Actor, project)
+		}
+		return fmt.Sprintf("%s shared %s with you", n.Actor, task)
+	default:
+		return fmt.Sprintf("%s changed %s", n.Actor, task)
+	}
+}
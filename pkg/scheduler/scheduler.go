@@ -0,0 +1,90 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Kolya59/todo-service/models"
+	"github.com/Kolya59/todo-service/pkg/notifier"
+	"github.com/Kolya59/todo-service/pkg/postgres"
+)
+
+const (
+	pollInterval = 15 * time.Second
+	leaseTime    = 2 * time.Minute
+	batchSize    = 100
+	// Reminder is failed after the attempt, notifier that always fails does not repeat it every lease
+	maxReminderAttempts = 10
+)
+
+// Scheduler polls database for due reminders, undelivered notifications and webhook deliveries and delivers them
+// at least once
+type Scheduler struct {
+	Notifier notifier.Notifier
+}
+
+// Run fires reminders until done is closed
+func (s *Scheduler) Run(done <-chan struct{}) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		s.tick()
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) tick() {
+	s.fireReminders()
+	s.deliverNotifications()
+	s.deliverWebhooks()
+}
+
+func (s *Scheduler) fireReminders() {
+	reminders, err := postgres.ClaimDueReminders(leaseTime, batchSize)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to claim reminders")
+		return
+	}
+	for _, reminder := range reminders {
+		if err := s.fire(reminder); err != nil {
+			// Lease expires and reminder is retried on the next tick after it
+			log.Error().Err(err).Msgf("Failed to fire reminder %v, attempt %v", reminder.UUID, reminder.Attempts)
+			if reminder.Attempts >= maxReminderAttempts {
+				if err := postgres.MarkReminderFailed(reminder.UUID); err != nil {
+					log.Error().Err(err).Msgf("Failed to mark reminder %v as failed", reminder.UUID)
+				}
+			}
+			continue
+		}
+		if err := postgres.MarkReminderSent(reminder.UUID); err != nil {
+			log.Error().Err(err).Msgf("Failed to mark reminder %v as sent", reminder.UUID)
+		}
+	}
+}
+
+func (s *Scheduler) fire(reminder models.Reminder) error {
+	task, err := postgres.SelectTask(reminder.UserUUID, reminder.TaskUUID)
+	if err != nil {
+		return fmt.Errorf("could not get task: %v", err)
+	}
+	msg := notifier.Message{
+		ID:       reminder.UUID,
+		To:       task.Author,
+		Subject:  fmt.Sprintf("Reminder: %s", task.Value),
+		Body:     task.Value,
+		TaskUUID: task.UUID,
+	}
+	if reminder.FireAt != nil {
+		msg.FireAt = *reminder.FireAt
+	}
+	if task.DueDate != nil {
+		msg.Body = fmt.Sprintf("%s\nDue %s", task.Value, task.DueDate.Format(time.RFC1123))
+	}
+	return s.Notifier.Notify(msg)
+}
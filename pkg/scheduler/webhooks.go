@@ -0,0 +1,214 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Kolya59/todo-service/models"
+	"github.com/Kolya59/todo-service/pkg/postgres"
+)
+
+// Headers of webhook requests. Signature is "sha256=" and hex HMAC-SHA256 of timestamp, "." and body with the secret.
+const (
+	headerEvent     = "X-Todo-Event"
+	headerDelivery  = "X-Todo-Delivery"
+	headerTimestamp = "X-Todo-Timestamp"
+	headerSignature = "X-Todo-Signature"
+)
+
+const (
+	// Deliveries of a tick are posted one by one, so the batch is sent well within the lease
+	webhookBatchSize   = 10
+	webhookTimeout     = 10 * time.Second
+	maxWebhookAttempts = 10
+	minRetryDelay      = 30 * time.Second
+	maxRetryDelay      = 6 * time.Hour
+)
+
+// Webhooks are posted to public addresses only, so users can not reach internal services through them
+var webhookClient = newWebhookClient(publicIP)
+
+// ErrPrivateAddress is returned for webhook hosts resolving to loopback, private, link-local or unspecified address
+var ErrPrivateAddress = errors.New("webhook host does not resolve to public address")
+
+// Networks of addresses which are not public, besides loopback, link-local, multicast and unspecified ones
+var privateNetworks = parseNetworks(
+	"0.0.0.0/8",      // this network
+	"10.0.0.0/8",     // private
+	"100.64.0.0/10",  // carrier-grade NAT
+	"172.16.0.0/12",  // private
+	"192.0.0.0/24",   // protocol assignments
+	"192.168.0.0/16", // private
+	"198.18.0.0/15",  // benchmarking
+	"fc00::/7",       // unique local
+)
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+// Check that address is public, metadata endpoint 169.254.169.254 of clouds is link-local
+func publicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Client posting to addresses allowed by allow only. Address is checked when connection is dialed,
+// so host resolving to another address after CheckWebhookURL is refused as well. Redirects are not followed.
+func newWebhookClient(allow func(net.IP) bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !allow(ip) {
+				return ErrPrivateAddress
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: webhookTimeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: webhookTimeout,
+			MaxIdleConnsPerHost: 2,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// CheckWebhookURL resolves host of webhook url, ErrPrivateAddress is returned when any of its addresses is not public
+func CheckWebhookURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return fmt.Errorf("could not resolve webhook host: %v", err)
+	}
+	for _, addr := range addrs {
+		if !publicIP(addr.IP) {
+			return ErrPrivateAddress
+		}
+	}
+	return nil
+}
+
+// Sign body of webhook request sent at timestamp
+func sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Delay after failed attempt, it doubles with every attempt up to maxRetryDelay
+func retryDelay(attempts int) time.Duration {
+	delay := minRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+func (s *Scheduler) deliverWebhooks() {
+	deliveries, err := postgres.ClaimWebhookDeliveries(leaseTime, webhookBatchSize)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to claim webhook deliveries")
+		return
+	}
+	for _, d := range deliveries {
+		attempt := post(d)
+		var next *time.Time
+		if attempt.Error != "" {
+			log.Info().Msgf("Failed to deliver %v to webhook %v, attempt %v: %v", d.UUID, d.WebhookUUID, d.Attempts, attempt.Error)
+			if d.Attempts < maxWebhookAttempts {
+				retryAt := time.Now().Add(retryDelay(d.Attempts))
+				next = &retryAt
+			}
+		}
+		if err := postgres.FinishWebhookAttempt(d.UUID, attempt, next); err != nil {
+			// Lease expires and the delivery is attempted again
+			log.Error().Err(err).Msgf("Failed to record attempt of delivery %v", d.UUID)
+		}
+	}
+}
+
+// Post delivery once, any response out of 2xx is a failure, redirects included
+func post(d models.WebhookDelivery) (attempt models.WebhookAttempt) {
+	attempt.AttemptedAt = time.Now()
+	defer func() {
+		attempt.DurationMs = int64(time.Since(attempt.AttemptedAt) / time.Millisecond)
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		attempt.Error = fmt.Sprintf("could not create request: %v", err)
+		return attempt
+	}
+	timestamp := strconv.FormatInt(attempt.AttemptedAt.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "todo-service-webhooks")
+	req.Header.Set(headerEvent, d.EventType)
+	req.Header.Set(headerDelivery, d.UUID)
+	req.Header.Set(headerTimestamp, timestamp)
+	req.Header.Set(headerSignature, sign(d.Secret, timestamp, d.Payload))
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		// Dial errors tell about the network of the host, they are not shown to the owner of webhook
+		log.Info().Err(err).Msgf("Failed to post delivery %v", d.UUID)
+		attempt.Error = "could not send request"
+		return attempt
+	}
+	defer resp.Body.Close()
+	// Body is drained so that the connection is reused
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		attempt.Error = fmt.Sprintf("webhook responded with %v", resp.Status)
+	}
+	return attempt
+}
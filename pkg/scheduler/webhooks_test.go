@@ -0,0 +1,161 @@
+package scheduler
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kolya59/todo-service/models"
+)
+
+func TestSign(t *testing.T) {
+	got := sign("secret", "1700000000", []byte(`{"event":"task.created"}`))
+	want := "sha256=fc53e1d22cb0ed2216fe98c535f28e2e668e9a812f23e87d18f07b966afb540a"
+	if got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if sign("other", "1700000000", []byte(`{"event":"task.created"}`)) == want {
+		t.Errorf("signature does not depend on secret")
+	}
+	if sign("secret", "1700000001", []byte(`{"event":"task.created"}`)) == want {
+		t.Errorf("signature does not depend on timestamp")
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, minRetryDelay},
+		{1, minRetryDelay},
+		{2, 2 * minRetryDelay},
+		{3, 4 * minRetryDelay},
+		{10, 512 * minRetryDelay},
+		{11, maxRetryDelay},
+		{100, maxRetryDelay},
+	}
+	for _, test := range tests {
+		if got := retryDelay(test.attempts); got != test.want {
+			t.Errorf("retryDelay(%v) = %v, want %v", test.attempts, got, test.want)
+		}
+	}
+}
+
+// Use client allowing any address, test servers listen on loopback
+func allowLoopback(t *testing.T) {
+	client := webhookClient
+	webhookClient = newWebhookClient(func(net.IP) bool { return true })
+	t.Cleanup(func() { webhookClient = client })
+}
+
+func TestPost(t *testing.T) {
+	allowLoopback(t)
+	payload := []byte(`{"event":"task.created"}`)
+	var status int
+	var received *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	d := models.WebhookDelivery{UUID: "d1", EventType: "task.created", Payload: payload, URL: server.URL, Secret: "secret"}
+
+	status = http.StatusNoContent
+	attempt := post(d)
+	if attempt.Error != "" || attempt.StatusCode != http.StatusNoContent {
+		t.Fatalf("got status %v and error %q, want %v", attempt.StatusCode, attempt.Error, http.StatusNoContent)
+	}
+	if received.Method != http.MethodPost || string(body) != string(payload) {
+		t.Errorf("got %v with %s, want POST with %s", received.Method, body, payload)
+	}
+	if received.Header.Get(headerEvent) != "task.created" || received.Header.Get(headerDelivery) != "d1" {
+		t.Errorf("got event %q and delivery %q", received.Header.Get(headerEvent), received.Header.Get(headerDelivery))
+	}
+	timestamp := received.Header.Get(headerTimestamp)
+	if got, want := received.Header.Get(headerSignature), sign("secret", timestamp, payload); got != want {
+		t.Errorf("got signature %v, want %v", got, want)
+	}
+
+	status = http.StatusInternalServerError
+	attempt = post(d)
+	if attempt.StatusCode != http.StatusInternalServerError || !strings.Contains(attempt.Error, "500") {
+		t.Errorf("got status %v and error %q, want failed attempt with %v", attempt.StatusCode, attempt.Error, status)
+	}
+
+	// Redirect is a failed attempt, its target is not posted to
+	status = http.StatusFound
+	attempt = post(d)
+	if attempt.StatusCode != http.StatusFound || attempt.Error == "" {
+		t.Errorf("got status %v and error %q, want failed attempt with %v", attempt.StatusCode, attempt.Error, status)
+	}
+
+	server.Close()
+	attempt = post(d)
+	if attempt.Error != "could not send request" || attempt.StatusCode != 0 {
+		t.Errorf("got status %v and error %q for closed server", attempt.StatusCode, attempt.Error)
+	}
+}
+
+func TestPostPrivateAddress(t *testing.T) {
+	posted := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted = true
+	}))
+	defer server.Close()
+
+	attempt := post(models.WebhookDelivery{UUID: "d1", Payload: []byte("{}"), URL: server.URL, Secret: "secret"})
+	if posted {
+		t.Errorf("delivery is posted to loopback address")
+	}
+	if attempt.Error != "could not send request" || attempt.StatusCode != 0 {
+		t.Errorf("got status %v and error %q, want generic error", attempt.StatusCode, attempt.Error)
+	}
+}
+
+func TestPublicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"172.31.255.255", false},
+		{"172.32.0.1", true},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"::ffff:10.0.0.1", false},
+		{"100.64.0.1", false},
+		{"224.0.0.1", false},
+	}
+	for _, test := range tests {
+		if got := publicIP(net.ParseIP(test.ip)); got != test.want {
+			t.Errorf("publicIP(%v) = %v, want %v", test.ip, got, test.want)
+		}
+	}
+}
+
+func TestCheckWebhookURL(t *testing.T) {
+	for _, rawURL := range []string{"http://127.0.0.1:8080/hook", "http://localhost/hook", "https://[::1]/hook", "http://169.254.169.254/latest/meta-data/"} {
+		if err := CheckWebhookURL(rawURL); err != ErrPrivateAddress {
+			t.Errorf("CheckWebhookURL(%v) = %v, want %v", rawURL, err, ErrPrivateAddress)
+		}
+	}
+	if err := CheckWebhookURL("http://93.184.216.34/hook"); err != nil {
+		t.Errorf("public address is refused: %v", err)
+	}
+}
@@ -0,0 +1,435 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/Kolya59/todo-service/models"
+	"github.com/Kolya59/todo-service/pkg/authz"
+	"github.com/Kolya59/todo-service/pkg/events"
+	"github.com/Kolya59/todo-service/pkg/postgres"
+	"github.com/Kolya59/todo-service/pkg/query"
+)
+
+// Error codes of the JSON API
+const (
+	codeUnauthorized   = "unauthorized"
+	codeInvalidRequest = "invalid_request"
+	codeNotFound       = "not_found"
+	codeForbidden      = "forbidden"
+	codeConflict       = "conflict"
+	codeInternal       = "internal"
+)
+
+// Maximal size of JSON request body
+const maxRequestSize = 1 << 20
+
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type errorEnvelope struct {
+	Error apiError `json:"error"`
+}
+
+func apiRouter() chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/users/me", apiGetMe)
+
+	r.Get("/tasks", apiGetTasks)
+	r.Post("/tasks", apiInsertTask)
+	r.Post("/tasks/bulk", apiBulkTasks)
+	r.Get("/tasks/assigned", apiGetAssignedTasks)
+	r.Get("/tasks/{id}", apiGetTask)
+	r.Patch("/tasks/{id}", apiUpdateTask)
+	r.Delete("/tasks/{id}", apiRemoveTask)
+
+	r.Put("/tasks/{id}/assignees/{login}", apiAssignTask)
+	r.Delete("/tasks/{id}/assignees/{login}", apiUnassignTask)
+
+	r.Get("/tasks/{id}/shares", apiGetTaskShares)
+	r.Post("/tasks/{id}/shares", apiShareTask)
+
+	r.Get("/search", apiSearch)
+
+	r.Get("/projects", apiGetProjects)
+	r.Post("/projects", apiInsertProject)
+	r.Patch("/projects/{id}", apiRenameProject)
+	r.Delete("/projects/{id}", apiRemoveProject)
+	r.Get("/projects/{id}/shares", apiGetProjectShares)
+	r.Post("/projects/{id}/shares", apiShareProject)
+
+	r.Get("/shares", apiGetReceivedShares)
+	r.Delete("/shares/{id}", apiRemoveShare)
+
+	r.Get("/workspaces", apiGetWorkspaces)
+	r.Post("/workspaces", apiInsertWorkspace)
+	r.Get("/workspaces/{id}", apiGetWorkspace)
+	r.Patch("/workspaces/{id}", apiRenameWorkspace)
+	r.Delete("/workspaces/{id}", apiRemoveWorkspace)
+	r.Get("/workspaces/{id}/members", apiGetMembers)
+	r.Patch("/workspaces/{id}/members/{userId}", apiUpdateMember)
+	r.Delete("/workspaces/{id}/members/{userId}", apiRemoveMember)
+	r.Get("/workspaces/{id}/invitations", apiGetWorkspaceInvitations)
+	r.Post("/workspaces/{id}/invitations", apiInvite)
+
+	r.Get("/invitations", apiGetInvitations)
+	r.Post("/invitations/{id}/accept", apiAcceptInvitation)
+	r.Delete("/invitations/{id}", apiRemoveInvitation)
+
+	r.Get("/notifications", apiGetNotifications)
+	r.Get("/notifications/unread", apiCountUnreadNotifications)
+	r.Post("/notifications/read", apiReadAllNotifications)
+	r.Post("/notifications/{id}/read", apiReadNotification)
+
+	r.Get("/captures", apiGetCaptureTokens)
+	r.Post("/captures", apiInsertCaptureToken)
+	r.Delete("/captures/{id}", apiRemoveCaptureToken)
+
+	r.Get("/webhooks", apiGetWebhooks)
+	r.Post("/webhooks", apiInsertWebhook)
+	r.Delete("/webhooks/{id}", apiRemoveWebhook)
+	r.Get("/webhooks/{id}/deliveries", apiGetWebhookDeliveries)
+	r.Get("/webhooks/{id}/deliveries/{deliveryId}", apiGetWebhookDelivery)
+	r.Post("/webhooks/{id}/deliveries/{deliveryId}/redeliver", apiRedeliverWebhook)
+
+	r.Get("/lists", apiGetSmartLists)
+	r.Post("/lists", apiInsertSmartList)
+	r.Get("/lists/{id}", apiGetSmartList)
+	r.Patch("/lists/{id}", apiUpdateSmartList)
+	r.Delete("/lists/{id}", apiRemoveSmartList)
+	r.Get("/lists/{id}/tasks", apiGetSmartListTasks)
+
+	r.Get("/tasks/{id}/comments", apiGetComments)
+	r.Post("/tasks/{id}/comments", apiInsertComment)
+	r.Delete("/tasks/{id}/comments/{commentId}", apiRemoveComment)
+
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, codeNotFound, "Route is not found")
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusMethodNotAllowed, codeInvalidRequest, "Method is not allowed")
+	})
+	return r
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	response, err := json.Marshal(v)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal response")
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to marshal response")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(response)
+}
+
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	response, _ := json.Marshal(errorEnvelope{Error: apiError{Code: code, Message: message}})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(response)
+}
+
+// Write error of the store layer, ErrNotFound becomes 404 and ErrForbidden becomes 403
+func writeStoreError(w http.ResponseWriter, err error, message string) {
+	if err == postgres.ErrNotFound {
+		writeError(w, http.StatusNotFound, codeNotFound, "Not found")
+		return
+	}
+	if err == postgres.ErrForbidden {
+		writeError(w, http.StatusForbidden, codeForbidden, "Role does not allow the change")
+		return
+	}
+	log.Error().Err(err).Msg(message)
+	writeError(w, http.StatusInternalServerError, codeInternal, message)
+}
+
+// Write error of changing task, missing project is a client error unlike missing task
+func writeProjectError(w http.ResponseWriter, err error, message string) {
+	if err == postgres.ErrProjectNotFound {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Project is not found")
+		return
+	}
+	writeStoreError(w, err, message)
+}
+
+// Authorize API request, unlike auth it writes error response itself
+func apiAuth(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id, err := auth(r)
+	if err != nil || id == "" {
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "Authorization is required")
+		return "", false
+	}
+	return id, true
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if ct := r.Header.Get("Content-Type"); ct != "" && !strings.HasPrefix(ct, "application/json") {
+		writeError(w, http.StatusUnsupportedMediaType, codeInvalidRequest, "Content type must be application/json")
+		return false
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// Read uuid URL parameter, malformed uuid can not match any row so it is reported as not found
+func uuidParam(w http.ResponseWriter, r *http.Request, name string) (string, bool) {
+	value := chi.URLParam(r, name)
+	if _, err := uuid.FromString(value); err != nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Not found")
+		return "", false
+	}
+	return value, true
+}
+
+func apiGetMe(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	login, err := postgres.SelectLoginByUUID(userId)
+	if err != nil {
+		// Cookie of a removed user
+		log.Info().Err(err).Msg("Failed to get login")
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "Authorization is required")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"uuid": userId, "login": login})
+}
+
+func apiGetTasks(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	filter, err := filterParam(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	apiWriteTasksPage(w, r, userId, filter)
+}
+
+func apiWriteTasksPage(w http.ResponseWriter, r *http.Request, userId string, filter query.Node) {
+	cursor, limit, err := pageParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	page, err := postgres.SelectTasksPage(userId, filter, cursor, limit)
+	if err == postgres.ErrInvalidCursor {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid cursor")
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err, "Failed to get tasks")
+		return
+	}
+	// Body stays a list for existing clients, page is described by Link and X-Total-Count headers
+	writePageHeaders(w, r, page)
+	if page.Tasks == nil {
+		page.Tasks = make([]models.Task, 0)
+	}
+	writeJSON(w, http.StatusOK, page.Tasks)
+}
+
+func apiGetTask(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	id, ok := uuidParam(w, r, "id")
+	if !ok {
+		return
+	}
+	if _, err := authorizeTask(userId, id, authz.View); err != nil {
+		writeStoreError(w, err, "Failed to get task")
+		return
+	}
+	task, err := postgres.SelectTask(userId, id)
+	if err != nil {
+		writeStoreError(w, err, "Failed to get task")
+		return
+	}
+	writeJSON(w, http.StatusOK, task)
+}
+
+func apiInsertTask(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	request := newTask{}
+	if !readJSON(w, r, &request) {
+		return
+	}
+	if msg := request.validate(); msg != "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, msg)
+		return
+	}
+	task, msg, err := request.insert(userId)
+	if err != nil {
+		writeProjectError(w, err, msg)
+		return
+	}
+	task.Version = publishTaskEvent(events.TaskCreated, task.UUID)
+	w.Header().Set("Location", "/api/v1/tasks/"+task.UUID)
+	writeJSON(w, http.StatusCreated, task)
+}
+
+func apiUpdateTask(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	request := taskPatch{}
+	if !readJSON(w, r, &request) {
+		return
+	}
+	if msg := request.validate(); msg != "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, msg)
+		return
+	}
+
+	id, ok := uuidParam(w, r, "id")
+	if !ok {
+		return
+	}
+	if msg, err := request.apply(userId, id, nil); err != nil {
+		writeProjectError(w, err, msg)
+		return
+	}
+
+	task, err := postgres.SelectTask(userId, id)
+	if err != nil {
+		writeStoreError(w, err, "Failed to get task")
+		return
+	}
+	writeJSON(w, http.StatusOK, task)
+}
+
+func apiRemoveTask(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	id, ok := uuidParam(w, r, "id")
+	if !ok {
+		return
+	}
+	if _, err := authorizeTask(userId, id, authz.DeleteTasks); err != nil {
+		writeStoreError(w, err, "Failed to get task")
+		return
+	}
+	attachments, err := postgres.SelectAttachments(userId, id)
+	if err != nil {
+		writeStoreError(w, err, "Failed to get attachments")
+		return
+	}
+	deleted, audience := deletedTaskEvent(id)
+	if err = postgres.DeleteTask(userId, id); err != nil {
+		writeStoreError(w, err, "Failed to delete task")
+		return
+	}
+	removeAttachmentBlobs(id, attachments)
+	publishEvent(deleted, audience)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiGetComments(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	id, ok := uuidParam(w, r, "id")
+	if !ok {
+		return
+	}
+	// Unknown task is 404 rather than an empty list
+	if _, err := authorizeTask(userId, id, authz.View); err != nil {
+		writeStoreError(w, err, "Failed to get task")
+		return
+	}
+	comments, err := postgres.SelectComments(userId, id)
+	if err != nil {
+		writeStoreError(w, err, "Failed to get comments")
+		return
+	}
+	if comments == nil {
+		comments = make([]models.Comment, 0)
+	}
+	writeJSON(w, http.StatusOK, comments)
+}
+
+func apiInsertComment(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	id, ok := uuidParam(w, r, "id")
+	if !ok {
+		return
+	}
+	request := struct {
+		Value string `json:"value"`
+	}{}
+	if !readJSON(w, r, &request) {
+		return
+	}
+	if strings.TrimSpace(request.Value) == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Value is required")
+		return
+	}
+	if _, err := authorizeTask(userId, id, authz.EditTasks); err != nil {
+		writeStoreError(w, err, "Failed to get task")
+		return
+	}
+	comment, err := postgres.InsertComment(userId, id, request.Value)
+	if err != nil {
+		writeStoreError(w, err, "Failed to insert comment")
+		return
+	}
+	publishCommentEvent(events.CommentCreated, id, comment.UUID)
+	writeJSON(w, http.StatusCreated, comment)
+}
+
+func apiRemoveComment(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	id, ok := uuidParam(w, r, "id")
+	if !ok {
+		return
+	}
+	commentId, ok := uuidParam(w, r, "commentId")
+	if !ok {
+		return
+	}
+	// Authors of comments remove them while they may edit the task, the store checks authorship
+	if _, err := authorizeTask(userId, id, authz.EditTasks); err != nil {
+		writeStoreError(w, err, "Failed to get task")
+		return
+	}
+	err := postgres.DeleteComment(userId, id, commentId)
+	if err != nil {
+		writeStoreError(w, err, "Failed to delete comment")
+		return
+	}
+	publishCommentEvent(events.CommentDeleted, id, commentId)
+	w.WriteHeader(http.StatusNoContent)
+}
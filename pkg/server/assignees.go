@@ -0,0 +1,101 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+
+	"github.com/Kolya59/todo-service/pkg/authz"
+	"github.com/Kolya59/todo-service/pkg/events"
+	"github.com/Kolya59/todo-service/pkg/postgres"
+	"github.com/Kolya59/todo-service/pkg/query"
+)
+
+// Filter of tasks assigned to the user narrowed by q parameter
+func assignedFilter(r *http.Request) (query.Node, error) {
+	filter, err := filterParam(r)
+	if err != nil {
+		return nil, err
+	}
+	return query.All(query.AssignedToMe{}, filter), nil
+}
+
+func apiGetAssignedTasks(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	filter, err := assignedFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	apiWriteTasksPage(w, r, userId, filter)
+}
+
+func getAssignedTasks(w http.ResponseWriter, r *http.Request) {
+	userId, err := auth(r)
+	if err != nil || userId == "" {
+		log.Info().Err(err).Msg("Failed to authorize user")
+		http.Redirect(w, r, loginUrl, http.StatusUnauthorized)
+		return
+	}
+	filter, err := assignedFilter(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+	writeTasksPage(w, r, userId, filter, nil)
+}
+
+func apiAssignTask(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	id, ok := uuidParam(w, r, "id")
+	if !ok {
+		return
+	}
+	if _, err := authorizeTask(userId, id, authz.EditTasks); err != nil {
+		writeStoreError(w, err, "Failed to get task")
+		return
+	}
+	err := postgres.AssignTask(userId, id, chi.URLParam(r, "login"))
+	switch err {
+	case nil:
+		publishTaskEvent(events.TaskUpdated, id)
+		w.WriteHeader(http.StatusNoContent)
+	case postgres.ErrUserNotFound:
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "User is not found")
+	case postgres.ErrNotAssignable:
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Task is not visible to the user, share it first")
+	default:
+		writeStoreError(w, err, "Failed to assign task")
+	}
+}
+
+// Unassign user from task, assignee may unassign itself from task it only views
+func apiUnassignTask(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	id, ok := uuidParam(w, r, "id")
+	if !ok {
+		return
+	}
+	// Viewers may unassign themselves only, the store checks it
+	if _, err := authorizeTask(userId, id, authz.View); err != nil {
+		writeStoreError(w, err, "Failed to get task")
+		return
+	}
+	if err := postgres.UnassignTask(userId, id, chi.URLParam(r, "login")); err != nil {
+		writeStoreError(w, err, "Failed to unassign task")
+		return
+	}
+	publishTaskEvent(events.TaskUpdated, id)
+	w.WriteHeader(http.StatusNoContent)
+}
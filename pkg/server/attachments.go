@@ -0,0 +1,263 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/Kolya59/todo-service/models"
+	"github.com/Kolya59/todo-service/pkg/authz"
+	"github.com/Kolya59/todo-service/pkg/blob"
+	"github.com/Kolya59/todo-service/pkg/crypt"
+	"github.com/Kolya59/todo-service/pkg/postgres"
+)
+
+const (
+	maxAttachmentSize = 10 << 20
+	// Multipart envelope around the file
+	maxAttachmentOverhead = 1 << 20
+)
+
+func getAttachments(w http.ResponseWriter, r *http.Request) {
+	userId, err := auth(r)
+	if err != nil || userId == "" {
+		log.Info().Err(err).Msg("Failed to authorize user")
+		http.Redirect(w, r, loginUrl, http.StatusUnauthorized)
+		return
+	}
+	id := chi.URLParam(r, "id")
+	if _, err = authorizeTask(userId, id, authz.View); err != nil {
+		writeStoreStatus(w, err, "Failed to get task")
+		return
+	}
+	attachments, err := postgres.SelectAttachments(userId, id)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to get attachments of task %v", id)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	response, err := json.Marshal(attachments)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal attachments")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(response)
+}
+
+func insertAttachment(w http.ResponseWriter, r *http.Request) {
+	userId, err := auth(r)
+	if err != nil || userId == "" {
+		log.Info().Err(err).Msg("Failed to authorize user")
+		http.Redirect(w, r, loginUrl, http.StatusUnauthorized)
+		return
+	}
+	id := chi.URLParam(r, "id")
+	// Task is checked before reading the upload, blob is encrypted with data key of its owner like its value
+	owner, err := authorizeTask(userId, id, authz.EditTasks)
+	if err != nil {
+		writeStoreStatus(w, err, "Failed to get task")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentSize+maxAttachmentOverhead)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		log.Info().Err(err).Msg("Failed to read multipart body")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var part io.ReadCloser
+	var name string
+	for {
+		p, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Info().Err(err).Msg("Failed to read multipart body")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if p.FormName() == "file" {
+			part, name = p, filepath.Base(p.FileName())
+			break
+		}
+	}
+	if part == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("Field file is required"))
+		return
+	}
+
+	// Spool upload to disk to know its size and sniff content type without holding it in memory
+	tmp, err := ioutil.TempFile("", "attachment-")
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create temporary file")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+	size, err := io.Copy(tmp, io.LimitReader(part, maxAttachmentSize+1))
+	if err != nil {
+		log.Info().Err(err).Msg("Failed to read attachment")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if size > maxAttachmentSize {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		_, _ = w.Write([]byte(fmt.Sprintf("Attachment is larger than %v bytes", maxAttachmentSize)))
+		return
+	}
+	head := make([]byte, 512)
+	n, _ := tmp.ReadAt(head, 0)
+	contentType := http.DetectContentType(head[:n])
+	// Text files are indistinguishable by content, trust extension for them
+	if byExt := mime.TypeByExtension(filepath.Ext(name)); byExt != "" && contentType == "text/plain; charset=utf-8" {
+		contentType = byExt
+	}
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		log.Error().Err(err).Msg("Failed to rewind temporary file")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	attachment := models.Attachment{
+		UUID:        uuid.NewV4().String(),
+		TaskUUID:    id,
+		Name:        name,
+		ContentType: contentType,
+		Size:        size,
+	}
+	key := blob.AttachmentKey(id, attachment.UUID)
+	var body io.Reader = tmp
+	storedSize, storedType := size, contentType
+	if postgres.EncryptionEnabled() {
+		pr, pw := io.Pipe()
+		encrypter, err := postgres.EncryptBlobWriter(owner, pw)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to encrypt attachment")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		go func() {
+			_, err := io.Copy(encrypter, tmp)
+			if err == nil {
+				err = encrypter.Close()
+			}
+			pw.CloseWithError(err)
+		}()
+		defer pr.Close()
+		body, storedSize, storedType = pr, crypt.StreamSize(size), "application/octet-stream"
+		attachment.Encrypted = true
+	}
+	if err = blobStore.Put(key, body, storedSize, storedType); err != nil {
+		log.Error().Err(err).Msg("Failed to store attachment")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	attachment, err = postgres.InsertAttachment(userId, attachment)
+	if err != nil {
+		if err := blobStore.Delete(key); err != nil {
+			log.Error().Err(err).Msgf("Failed to delete orphan blob %v", key)
+		}
+		writeStoreStatus(w, err, fmt.Sprintf("Failed to insert attachment for task %v", id))
+		return
+	}
+
+	response, err := json.Marshal(attachment)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal attachment")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(response)
+}
+
+func getAttachment(w http.ResponseWriter, r *http.Request) {
+	userId, err := auth(r)
+	if err != nil || userId == "" {
+		log.Info().Err(err).Msg("Failed to authorize user")
+		http.Redirect(w, r, loginUrl, http.StatusUnauthorized)
+		return
+	}
+	id := chi.URLParam(r, "id")
+	attachmentId := chi.URLParam(r, "attachmentId")
+	owner, err := authorizeTask(userId, id, authz.View)
+	if err != nil {
+		writeStoreStatus(w, err, "Failed to get task")
+		return
+	}
+	attachment, err := postgres.SelectAttachment(userId, id, attachmentId)
+	if err == postgres.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to get attachment %v", attachmentId)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	data, err := blobStore.Get(blob.AttachmentKey(id, attachmentId))
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to read attachment %v", attachmentId)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer data.Close()
+	var body io.Reader = data
+	if attachment.Encrypted {
+		body, err = postgres.DecryptBlobReader(owner, data)
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to decrypt attachment %v", attachmentId)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Length", fmt.Sprint(attachment.Size))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if _, err = io.Copy(w, body); err != nil {
+		log.Error().Err(err).Msgf("Failed to send attachment %v", attachmentId)
+	}
+}
+
+func removeAttachment(w http.ResponseWriter, r *http.Request) {
+	userId, err := auth(r)
+	if err != nil || userId == "" {
+		log.Info().Err(err).Msg("Failed to authorize user")
+		http.Redirect(w, r, loginUrl, http.StatusUnauthorized)
+		return
+	}
+	id := chi.URLParam(r, "id")
+	attachmentId := chi.URLParam(r, "attachmentId")
+	if _, err = authorizeTask(userId, id, authz.DeleteTasks); err != nil {
+		writeStoreStatus(w, err, "Failed to get task")
+		return
+	}
+	if err = postgres.DeleteAttachment(userId, id, attachmentId); err != nil {
+		writeStoreStatus(w, err, fmt.Sprintf("Failed to delete attachment %v", attachmentId))
+		return
+	}
+	if err = blobStore.Delete(blob.AttachmentKey(id, attachmentId)); err != nil {
+		log.Error().Err(err).Msgf("Failed to delete blob of attachment %v", attachmentId)
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/Kolya59/todo-service/models"
+	"github.com/Kolya59/todo-service/pkg/events"
+	"github.com/Kolya59/todo-service/pkg/postgres"
+)
+
+// Maximal number of operations in bulk request
+const maxBulkOperations = 500
+
+// Check operation and normalize its labels, message describes the first problem
+func validateBulkOperation(op *models.BulkOperation) string {
+	if _, err := uuid.FromString(op.TaskUUID); err != nil {
+		return "task_uuid must be uuid"
+	}
+	switch op.Op {
+	case models.BulkResolve, models.BulkReopen, models.BulkDelete:
+	case models.BulkRelabel:
+		if len(op.AddLabels) == 0 && len(op.RemoveLabels) == 0 {
+			return "relabel requires add_labels or remove_labels"
+		}
+		var err error
+		if op.AddLabels, err = postgres.NormalizeLabels(op.AddLabels); err != nil {
+			return err.Error()
+		}
+		if op.RemoveLabels, err = postgres.NormalizeLabels(op.RemoveLabels); err != nil {
+			return err.Error()
+		}
+	case models.BulkMove:
+		if op.ProjectUUID != nil {
+			if _, err := uuid.FromString(*op.ProjectUUID); err != nil {
+				return "project_uuid must be uuid or null"
+			}
+		}
+	default:
+		return fmt.Sprintf("unknown op %q", op.Op)
+	}
+	return ""
+}
+
+func apiBulkTasks(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	request := struct {
+		Operations []models.BulkOperation `json:"operations"`
+		Atomic     bool                   `json:"atomic"`
+	}{}
+	if !readJSON(w, r, &request) {
+		return
+	}
+	if len(request.Operations) == 0 || len(request.Operations) > maxBulkOperations {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("From 1 to %d operations are expected", maxBulkOperations))
+		return
+	}
+	// Malformed request is rejected as a whole before anything is changed
+	for i := range request.Operations {
+		if msg := validateBulkOperation(&request.Operations[i]); msg != "" {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Operation %d: %s", i, msg))
+			return
+		}
+	}
+
+	// Blobs of deleted tasks are removed only after commit, their audience is gone with them
+	attachments := map[string][]models.Attachment{}
+	deleted := map[string]events.Event{}
+	audiences := map[string][]string{}
+	for _, op := range request.Operations {
+		if op.Op != models.BulkDelete {
+			continue
+		}
+		taskAttachments, err := postgres.SelectAttachments(userId, op.TaskUUID)
+		if err != nil {
+			writeStoreError(w, err, "Failed to get attachments")
+			return
+		}
+		attachments[op.TaskUUID] = taskAttachments
+		deleted[op.TaskUUID], audiences[op.TaskUUID] = deletedTaskEvent(op.TaskUUID)
+	}
+
+	results, committed, err := postgres.BulkTasks(userId, request.Operations, request.Atomic)
+	if err != nil {
+		writeStoreError(w, err, "Failed to execute bulk operations")
+		return
+	}
+	if committed {
+		for _, result := range results {
+			if result.Status != models.BulkOK {
+				continue
+			}
+			if result.Op == models.BulkDelete {
+				removeAttachmentBlobs(result.TaskUUID, attachments[result.TaskUUID])
+				publishEvent(deleted[result.TaskUUID], audiences[result.TaskUUID])
+			} else {
+				publishTaskEvent(events.TaskUpdated, result.TaskUUID)
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Committed bool                `json:"committed"`
+		Results   []models.BulkResult `json:"results"`
+	}{committed, results})
+}
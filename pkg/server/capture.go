@@ -0,0 +1,165 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+
+	"github.com/Kolya59/todo-service/models"
+	"github.com/Kolya59/todo-service/pkg/events"
+	"github.com/Kolya59/todo-service/pkg/postgres"
+)
+
+const maxCaptureNameLength = 100
+
+func apiGetCaptureTokens(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	captures, err := postgres.SelectCaptureTokens(userId)
+	if err != nil {
+		writeStoreError(w, err, "Failed to get capture tokens")
+		return
+	}
+	if captures == nil {
+		captures = make([]models.CaptureToken, 0)
+	}
+	writeJSON(w, http.StatusOK, captures)
+}
+
+// Create capture token, its token and URL are returned only in this response
+func apiInsertCaptureToken(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	request := struct {
+		Name string `json:"name"`
+	}{}
+	if !readJSON(w, r, &request) {
+		return
+	}
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" || len(request.Name) > maxCaptureNameLength {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Name must be from 1 to 100 characters")
+		return
+	}
+	token, err := newSecret()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to generate capture token")
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to generate token")
+		return
+	}
+	capture, err := postgres.InsertCaptureToken(userId, request.Name, token)
+	if err != nil {
+		writeStoreError(w, err, "Failed to insert capture token")
+		return
+	}
+	capture.URL = "/capture/" + token
+	writeJSON(w, http.StatusCreated, capture)
+}
+
+func apiRemoveCaptureToken(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	id, ok := uuidParam(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := postgres.DeleteCaptureToken(userId, id); err != nil {
+		writeStoreError(w, err, "Failed to delete capture token")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Create task from post to secret capture URL, the token stands for authorization.
+// Body is plain text, JSON like NewTask or form with the same fields and comma separated labels,
+// inline syntax and natural date of the value are parsed in all of them, dates are in UTC.
+func capture(w http.ResponseWriter, r *http.Request) {
+	userId, err := postgres.UseCaptureToken(chi.URLParam(r, "token"))
+	if err != nil {
+		writeStoreError(w, err, "Failed to check capture token")
+		return
+	}
+	t, ok := readCapture(w, r)
+	if !ok {
+		return
+	}
+	projects, err := projectNames(userId)
+	if err != nil {
+		writeStoreError(w, err, "Failed to get projects")
+		return
+	}
+	t.parseInline(time.Now().UTC(), projects)
+	if msg := t.validate(); msg != "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, msg)
+		return
+	}
+	task, msg, err := t.insert(userId)
+	if err != nil {
+		writeProjectError(w, err, msg)
+		return
+	}
+	task.Version = publishTaskEvent(events.TaskCreated, task.UUID)
+	w.Header().Set("Location", "/api/v1/tasks/"+task.UUID)
+	writeJSON(w, http.StatusCreated, task)
+}
+
+// Read captured task by content type of body, senders of alerts are not strict so unknown fields are ignored
+func readCapture(w http.ResponseWriter, r *http.Request) (newTask, bool) {
+	t := newTask{}
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid JSON body: "+err.Error())
+			return t, false
+		}
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		var err error
+		if mediaType == "multipart/form-data" {
+			err = r.ParseMultipartForm(maxRequestSize)
+		} else {
+			err = r.ParseForm()
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid form: "+err.Error())
+			return t, false
+		}
+		t.Value = r.PostFormValue("value")
+		t.Priority = r.PostFormValue("priority")
+		if labels := r.PostFormValue("labels"); labels != "" {
+			t.Labels = strings.Split(labels, ",")
+		}
+		if due := r.PostFormValue("due_date"); due != "" {
+			dueDate, err := time.Parse(time.RFC3339, due)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, "Due date must be RFC 3339 time")
+				return t, false
+			}
+			t.DueDate = &dueDate
+		}
+		if project := r.PostFormValue("project_uuid"); project != "" {
+			t.ProjectUUID = &project
+		}
+	default:
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Failed to read body")
+			return t, false
+		}
+		t.Value = string(body)
+	}
+	return t, true
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/Kolya59/todo-service/models"
+	"github.com/Kolya59/todo-service/pkg/authz"
+	"github.com/Kolya59/todo-service/pkg/events"
+	"github.com/Kolya59/todo-service/pkg/postgres"
+	"github.com/Kolya59/todo-service/pkg/quickadd"
+)
+
+// Task created through JSON API or WebSocket
+type newTask struct {
+	Value       string     `json:"value"`
+	DueDate     *time.Time `json:"due_date"`
+	Labels      []string   `json:"labels"`
+	Priority    string     `json:"priority"`
+	ProjectUUID *string    `json:"project_uuid"`
+}
+
+func validPriority(priority string) bool {
+	switch priority {
+	case models.PriorityLow, models.PriorityMedium, models.PriorityHigh:
+		return true
+	}
+	return false
+}
+
+// Check task and normalize its labels, message describes the first problem
+func (t *newTask) validate() string {
+	if strings.TrimSpace(t.Value) == "" {
+		return "Value is required"
+	}
+	labels, err := postgres.NormalizeLabels(t.Labels)
+	if err != nil {
+		return err.Error()
+	}
+	t.Labels = labels
+	if t.Priority != "" && !validPriority(t.Priority) {
+		return "Priority must be low, medium or high"
+	}
+	if t.ProjectUUID != nil {
+		if _, err = uuid.FromString(*t.ProjectUUID); err != nil {
+			return "Project must be uuid"
+		}
+	}
+	return ""
+}
+
+// Move inline syntax and natural date of value into fields, fields set explicitly win and labels are merged
+func (t *newTask) parseInline(now time.Time, projects map[string]string) {
+	parsed := quickadd.Parse(t.Value, now, projects)
+	t.Value = parsed.Value
+	t.Labels = append(t.Labels, parsed.Labels...)
+	if t.Priority == "" {
+		t.Priority = parsed.Priority
+	}
+	if t.DueDate == nil {
+		t.DueDate = parsed.DueDate
+	}
+	if t.ProjectUUID == nil {
+		t.ProjectUUID = parsed.ProjectUUID
+	}
+}
+
+// Projects the user sees by lower case name, they are found by +project of quick add
+func projectNames(userId string) (map[string]string, error) {
+	projects, err := postgres.SelectProjects(userId)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string, len(projects))
+	for _, project := range projects {
+		name := strings.ToLower(project.Name)
+		if _, ok := names[name]; !ok {
+			names[name] = project.UUID
+		}
+	}
+	return names, nil
+}
+
+// Insert validated task with all its fields or nothing, message of returned error names the failed step
+func (t newTask) insert(userId string) (task models.Task, message string, err error) {
+	task, err = postgres.InsertTask(userId, postgres.TaskChange{
+		Value:       &t.Value,
+		DueDate:     t.DueDate,
+		Priority:    t.Priority,
+		Labels:      &t.Labels,
+		ProjectUUID: t.ProjectUUID,
+	})
+	if err != nil {
+		return models.Task{}, "Failed to insert task", err
+	}
+	return task, "", nil
+}
+
+// Only present fields are changed, null due date or priority clears it and null project moves task out of project
+type taskPatch struct {
+	Value       *string         `json:"value"`
+	IsResolved  *bool           `json:"is_resolved"`
+	DueDate     json.RawMessage `json:"due_date"`
+	Labels      *[]string       `json:"labels"`
+	Priority    json.RawMessage `json:"priority"`
+	ProjectUUID json.RawMessage `json:"project_uuid"`
+
+	dueDate     *time.Time
+	priority    *string
+	projectUUID *string
+}
+
+// Check patch and decode its nullable fields, message describes the first problem
+func (p *taskPatch) validate() string {
+	if len(p.DueDate) > 0 {
+		if err := json.Unmarshal(p.DueDate, &p.dueDate); err != nil {
+			return "Invalid due date"
+		}
+	}
+	if len(p.Priority) > 0 {
+		err := json.Unmarshal(p.Priority, &p.priority)
+		if err != nil || p.priority != nil && !validPriority(*p.priority) {
+			return "Priority must be low, medium, high or null"
+		}
+	}
+	if len(p.ProjectUUID) > 0 {
+		err := json.Unmarshal(p.ProjectUUID, &p.projectUUID)
+		if err == nil && p.projectUUID != nil {
+			_, err = uuid.FromString(*p.projectUUID)
+		}
+		if err != nil {
+			return "Project must be uuid or null"
+		}
+	}
+	if p.Value != nil && strings.TrimSpace(*p.Value) == "" {
+		return "Value must not be empty"
+	}
+	if p.Labels != nil {
+		if _, err := postgres.NormalizeLabels(*p.Labels); err != nil {
+			return err.Error()
+		}
+	}
+	return ""
+}
+
+// Apply validated patch, its fields are changed together or not at all. Patch based on version other than
+// the current one fails with postgres.ErrVersionConflict, nil version applies to any. Change is published.
+func (p taskPatch) apply(userId string, taskId string, version *int64) (message string, err error) {
+	if _, err = authorizeTask(userId, taskId, authz.EditTasks); err != nil {
+		return "Failed to get task", err
+	}
+	change := postgres.TaskChange{
+		Value:       p.Value,
+		IsResolved:  p.IsResolved,
+		DueDate:     p.dueDate,
+		SetDueDate:  len(p.DueDate) > 0,
+		SetPriority: len(p.Priority) > 0,
+		Labels:      p.Labels,
+		ProjectUUID: p.projectUUID,
+		SetProject:  len(p.ProjectUUID) > 0,
+		Version:     version,
+	}
+	if p.priority != nil {
+		change.Priority = *p.priority
+	}
+	newVersion, projectUUID, err := postgres.UpdateTaskFields(taskId, userId, change)
+	if err != nil {
+		return "Failed to update task", err
+	}
+	publishTaskVersion(events.TaskUpdated, taskId, newVersion, projectUUID)
+	return "", nil
+}
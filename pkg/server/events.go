@@ -0,0 +1,131 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Kolya59/todo-service/pkg/events"
+	"github.com/Kolya59/todo-service/pkg/postgres"
+)
+
+// Comment is sent to idle streams, so that proxies do not close them
+const keepAliveInterval = 30 * time.Second
+
+// Delay before browser reconnects to closed stream
+const reconnectDelay = 3 * time.Second
+
+var hub *events.Hub
+
+// Select users who see the task, errors are logged since events are best effort
+func taskAudience(taskUUID string) []string {
+	users, err := postgres.SelectTaskAudience(taskUUID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to get audience of task %v", taskUUID)
+		return nil
+	}
+	return users
+}
+
+// Deliver event to streams and sockets of users and queue it for their webhooks
+func publishEvent(e events.Event, users []string) {
+	e = hub.Publish(e, users)
+	enqueueWebhooks(e, users)
+}
+
+// Publish created or updated task with its next version, 0 is returned when the version is not counted
+func publishTaskEvent(eventType string, taskUUID string) int64 {
+	version, projectUUID, err := postgres.BumpTaskVersion(taskUUID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to bump version of task %v", taskUUID)
+	}
+	publishTaskVersion(eventType, taskUUID, version, projectUUID)
+	return version
+}
+
+// Publish change of task whose version was bumped together with the change
+func publishTaskVersion(eventType string, taskUUID string, version int64, projectUUID *string) {
+	e := events.Event{Type: eventType, TaskUUID: taskUUID, ProjectUUID: projectUUID, Version: version}
+	publishEvent(e, taskAudience(taskUUID))
+}
+
+func publishCommentEvent(eventType string, taskUUID string, commentUUID string) {
+	version, projectUUID, err := postgres.SelectTaskVersion(taskUUID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to get version of task %v", taskUUID)
+	}
+	e := events.Event{Type: eventType, TaskUUID: taskUUID, ProjectUUID: projectUUID, CommentUUID: commentUUID, Version: version}
+	publishEvent(e, taskAudience(taskUUID))
+}
+
+// Prepare deletion event while the task and its audience still exist, it is published after deletion
+func deletedTaskEvent(taskUUID string) (events.Event, []string) {
+	e := events.Event{Type: events.TaskDeleted, TaskUUID: taskUUID}
+	version, projectUUID, err := postgres.SelectTaskVersion(taskUUID)
+	if err == nil {
+		e.ProjectUUID, e.Version = projectUUID, version+1
+	} else if err != postgres.ErrNotFound {
+		log.Error().Err(err).Msgf("Failed to get version of task %v", taskUUID)
+	}
+	return e, taskAudience(taskUUID)
+}
+
+// Stream events of current user as server-sent events until client disconnects or server shuts down
+func streamEvents(w http.ResponseWriter, r *http.Request) {
+	userId, err := auth(r)
+	if err != nil || userId == "" {
+		log.Info().Err(err).Msg("Failed to authorize user")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		log.Error().Msg("Response writer does not support flushing")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	stream, cancel := hub.Subscribe(userId)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Nginx buffers responses by default
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if _, err = fmt.Fprintf(w, "retry: %d\n\n", reconnectDelay/time.Millisecond); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-stream:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				log.Error().Err(err).Msgf("Failed to marshal event %v", e.ID)
+				continue
+			}
+			_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+			if err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err = fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
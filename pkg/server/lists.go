@@ -0,0 +1,220 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+
+	"github.com/Kolya59/todo-service/models"
+	"github.com/Kolya59/todo-service/pkg/postgres"
+	"github.com/Kolya59/todo-service/pkg/query"
+)
+
+// Maximal length of smart list name
+const maxListNameLength = 200
+
+// Check name and query of smart list before it is saved
+func validateSmartList(name string, q string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "Name is required"
+	}
+	if len([]rune(name)) > maxListNameLength {
+		return "Name is too long"
+	}
+	if _, err := query.Parse(q, time.Now()); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// Filter of smart list page, q narrows the list further
+func smartListFilter(r *http.Request, list models.SmartList) (query.Node, error) {
+	now := time.Now()
+	filter, err := query.Parse(list.Query, now)
+	if err != nil {
+		return nil, err
+	}
+	narrow, err := query.Parse(r.URL.Query().Get("q"), now)
+	if err != nil {
+		return nil, err
+	}
+	return query.All(filter, narrow), nil
+}
+
+func apiGetSmartLists(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	lists, err := postgres.SelectSmartLists(userId)
+	if err != nil {
+		writeStoreError(w, err, "Failed to get smart lists")
+		return
+	}
+	if lists == nil {
+		lists = make([]models.SmartList, 0)
+	}
+	writeJSON(w, http.StatusOK, lists)
+}
+
+func apiGetSmartList(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	id, ok := uuidParam(w, r, "id")
+	if !ok {
+		return
+	}
+	list, err := postgres.SelectSmartList(userId, id)
+	if err != nil {
+		writeStoreError(w, err, "Failed to get smart list")
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func apiInsertSmartList(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	request := struct {
+		Name   string `json:"name"`
+		Query  string `json:"query"`
+		Pinned bool   `json:"pinned"`
+	}{}
+	if !readJSON(w, r, &request) {
+		return
+	}
+	if msg := validateSmartList(request.Name, request.Query); msg != "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, msg)
+		return
+	}
+	list, err := postgres.InsertSmartList(userId, strings.TrimSpace(request.Name), request.Query, request.Pinned)
+	if err != nil {
+		writeStoreError(w, err, "Failed to insert smart list")
+		return
+	}
+	w.Header().Set("Location", "/api/v1/lists/"+list.UUID)
+	writeJSON(w, http.StatusCreated, list)
+}
+
+func apiUpdateSmartList(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	// Only present fields are changed
+	request := struct {
+		Name   *string `json:"name"`
+		Query  *string `json:"query"`
+		Pinned *bool   `json:"pinned"`
+	}{}
+	if !readJSON(w, r, &request) {
+		return
+	}
+	id, ok := uuidParam(w, r, "id")
+	if !ok {
+		return
+	}
+	list, err := postgres.SelectSmartList(userId, id)
+	if err != nil {
+		writeStoreError(w, err, "Failed to get smart list")
+		return
+	}
+	if request.Name != nil {
+		list.Name = strings.TrimSpace(*request.Name)
+	}
+	if request.Query != nil {
+		list.Query = *request.Query
+	}
+	if request.Pinned != nil {
+		list.Pinned = *request.Pinned
+	}
+	if msg := validateSmartList(list.Name, list.Query); msg != "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, msg)
+		return
+	}
+	if err = postgres.UpdateSmartList(userId, list); err != nil {
+		writeStoreError(w, err, "Failed to update smart list")
+		return
+	}
+	// Count follows the new query
+	list, err = postgres.SelectSmartList(userId, id)
+	if err != nil {
+		writeStoreError(w, err, "Failed to get smart list")
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func apiRemoveSmartList(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	id, ok := uuidParam(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := postgres.DeleteSmartList(userId, id); err != nil {
+		writeStoreError(w, err, "Failed to delete smart list")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiGetSmartListTasks(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	id, ok := uuidParam(w, r, "id")
+	if !ok {
+		return
+	}
+	list, err := postgres.SelectSmartList(userId, id)
+	if err != nil {
+		writeStoreError(w, err, "Failed to get smart list")
+		return
+	}
+	filter, err := smartListFilter(r, list)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	apiWriteTasksPage(w, r, userId, filter)
+}
+
+func getSmartList(w http.ResponseWriter, r *http.Request) {
+	userId, err := auth(r)
+	if err != nil || userId == "" {
+		log.Info().Err(err).Msg("Failed to authorize user")
+		http.Redirect(w, r, loginUrl, http.StatusUnauthorized)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	list, err := postgres.SelectSmartList(userId, id)
+	if err == postgres.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to get smart list %v", id)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	filter, err := smartListFilter(r, list)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+	writeTasksPage(w, r, userId, filter, &list)
+}
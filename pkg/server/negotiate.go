@@ -0,0 +1,333 @@
+package server
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Kolya59/todo-service/models"
+	"github.com/Kolya59/todo-service/pkg/postgres"
+)
+
+// Representations of tasks, the first one is used when client accepts anything
+const (
+	mediaHTML     = "text/html"
+	mediaJSON     = "application/json"
+	mediaCSV      = "text/csv"
+	mediaMarkdown = "text/markdown"
+)
+
+var taskMediaTypes = []string{mediaHTML, mediaJSON, mediaCSV, mediaMarkdown}
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// Parse Accept header into media ranges ordered by preference
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		if mediaType == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.ToLower(kv[0]) == "q" {
+				if v, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+	}
+	// More specific ranges win over wildcards with the same quality
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].q != ranges[j].q {
+			return ranges[i].q > ranges[j].q
+		}
+		return specificity(ranges[i].mediaType) > specificity(ranges[j].mediaType)
+	})
+	return ranges
+}
+
+func specificity(mediaType string) int {
+	switch {
+	case mediaType == "*/*":
+		return 0
+	case strings.HasSuffix(mediaType, "/*"):
+		return 1
+	default:
+		return 2
+	}
+}
+
+func matchMediaType(mediaRange string, offer string) bool {
+	if mediaRange == "*/*" || mediaRange == offer {
+		return true
+	}
+	if strings.HasSuffix(mediaRange, "/*") {
+		return strings.HasPrefix(offer, strings.TrimSuffix(mediaRange, "*"))
+	}
+	return false
+}
+
+// Choose representation for request, empty string means that none of offers is acceptable
+func negotiate(r *http.Request, offers []string) string {
+	header := r.Header.Get("Accept")
+	if strings.TrimSpace(header) == "" {
+		return offers[0]
+	}
+	ranges := parseAccept(header)
+	for _, ar := range ranges {
+		if ar.q <= 0 {
+			continue
+		}
+		for _, offer := range offers {
+			if !matchMediaType(ar.mediaType, offer) {
+				continue
+			}
+			// Refused types are skipped even if a less specific range allows them
+			if refused(ranges, offer) {
+				continue
+			}
+			return offer
+		}
+	}
+	return ""
+}
+
+// Offer is refused when the most specific range matching it has zero quality, wildcards included
+func refused(ranges []acceptRange, offer string) bool {
+	best := -1
+	q := 0.0
+	for _, ar := range ranges {
+		if matchMediaType(ar.mediaType, offer) && specificity(ar.mediaType) > best {
+			best, q = specificity(ar.mediaType), ar.q
+		}
+	}
+	return best >= 0 && q <= 0
+}
+
+// Workspace with its projects shown in sidebar of tasks page
+type workspaceProjects struct {
+	models.Workspace
+	Projects []models.Project
+}
+
+func personalProjects(projects []models.Project) (personal []models.Project) {
+	for _, project := range projects {
+		if project.WorkspaceUUID == nil {
+			personal = append(personal, project)
+		}
+	}
+	return personal
+}
+
+func groupProjects(workspaces []models.Workspace, projects []models.Project) []workspaceProjects {
+	groups := make([]workspaceProjects, len(workspaces))
+	for i, workspace := range workspaces {
+		groups[i].Workspace = workspace
+		for _, project := range projects {
+			if project.WorkspaceUUID != nil && *project.WorkspaceUUID == workspace.UUID {
+				groups[i].Projects = append(groups[i].Projects, project)
+			}
+		}
+	}
+	return groups
+}
+
+// Render page of tasks in representation requested by client, list is set for pages of smart list
+func renderTasks(w http.ResponseWriter, r *http.Request, userId string, page models.TaskPage, list *models.SmartList) {
+	w.Header().Add("Vary", "Accept")
+	writePageHeaders(w, r, page)
+	tasks := page.Tasks
+	switch negotiate(r, taskMediaTypes) {
+	case mediaHTML:
+		// Smart lists with counts, projects, workspaces and unread notifications are shown beside every listing
+		lists, err := postgres.SelectSmartLists(userId)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to get smart lists")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		projects, err := postgres.SelectProjects(userId)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to get projects")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		workspaces, err := postgres.SelectWorkspaces(userId)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to get workspaces")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		unread, err := postgres.CountUnreadNotifications(userId)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to count notifications")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		renderTemplate(w, "./assets/html/tasks.gohtml", struct {
+			models.TaskPage
+			Query      string
+			NextURL    string
+			PrevURL    string
+			List       *models.SmartList
+			Lists      []models.SmartList
+			Projects   []models.Project
+			Personal   []models.Project
+			Workspaces []workspaceProjects
+			Unread     int
+		}{page, r.URL.Query().Get("q"), pageURL(r, page.Next, page.Limit), pageURL(r, page.Prev, page.Limit), list, lists,
+			projects, personalProjects(projects), groupProjects(workspaces, projects), unread})
+	case mediaJSON:
+		if tasks == nil {
+			tasks = make([]models.Task, 0)
+		}
+		writeJSON(w, http.StatusOK, tasks)
+	case mediaCSV:
+		writeCSV(w, tasks)
+	case mediaMarkdown:
+		buf := &bytes.Buffer{}
+		title := "Tasks"
+		if list != nil {
+			title = markdownEscape(list.Name)
+		}
+		fmt.Fprintf(buf, "# %s\n\n", title)
+		for _, task := range tasks {
+			buf.WriteString(markdownTaskItem(task))
+		}
+		if len(tasks) == 0 {
+			buf.WriteString("User haven't got tasks\n")
+		}
+		writeBody(w, mediaMarkdown, buf.Bytes())
+	default:
+		writeNotAcceptable(w)
+	}
+}
+
+// Render single task in representation requested by client
+func renderTask(w http.ResponseWriter, r *http.Request, task models.Task) {
+	w.Header().Add("Vary", "Accept")
+	switch negotiate(r, taskMediaTypes) {
+	case mediaHTML:
+		renderTemplate(w, "./assets/html/task.gohtml", task)
+	case mediaJSON:
+		writeJSON(w, http.StatusOK, task)
+	case mediaCSV:
+		writeCSV(w, []models.Task{task})
+	case mediaMarkdown:
+		buf := &bytes.Buffer{}
+		fmt.Fprintf(buf, "# %s\n\n", markdownEscape(task.Value))
+		fmt.Fprintf(buf, "- ID: %s\n", task.UUID)
+		fmt.Fprintf(buf, "- Resolved: %v\n", task.IsResolved)
+		if len(task.Labels) > 0 {
+			fmt.Fprintf(buf, "- Labels: %s\n", strings.Join(task.Labels, ", "))
+		}
+		if task.DueDate != nil {
+			fmt.Fprintf(buf, "- Due date: %s\n", task.DueDate.Format(time.RFC3339))
+		}
+		if len(task.Assignees) > 0 {
+			fmt.Fprintf(buf, "- Assignees: %s\n", markdownEscape(strings.Join(task.Assignees, ", ")))
+		}
+		buf.WriteString("\n## Comments\n\n")
+		for _, comment := range task.Comments {
+			fmt.Fprintf(buf, "- **%s**: %s\n", markdownEscape(comment.Author), markdownEscape(comment.Value))
+		}
+		if len(task.Comments) == 0 {
+			buf.WriteString("This task haven't got comments\n")
+		}
+		writeBody(w, mediaMarkdown, buf.Bytes())
+	default:
+		writeNotAcceptable(w)
+	}
+}
+
+func renderTemplate(w http.ResponseWriter, file string, data interface{}) {
+	tmpl, err := template.New(path.Base(file)).ParseFiles(file)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to prepare template")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	// Template is executed into buffer so that failure is not mixed with partial page
+	buf := &bytes.Buffer{}
+	if err = tmpl.Execute(buf, data); err != nil {
+		log.Error().Err(err).Msg("Failed to execute template")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeBody(w, mediaHTML, buf.Bytes())
+}
+
+func writeCSV(w http.ResponseWriter, tasks []models.Task) {
+	buf := &bytes.Buffer{}
+	writer := csv.NewWriter(buf)
+	_ = writer.Write([]string{"uuid", "value", "is_resolved", "due_date", "labels"})
+	for _, task := range tasks {
+		dueDate := ""
+		if task.DueDate != nil {
+			dueDate = task.DueDate.Format(time.RFC3339)
+		}
+		labels := strings.Join(task.Labels, " ")
+		_ = writer.Write([]string{task.UUID, task.Value, strconv.FormatBool(task.IsResolved), dueDate, labels})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Error().Err(err).Msg("Failed to write csv")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeBody(w, mediaCSV, buf.Bytes())
+}
+
+func writeBody(w http.ResponseWriter, mediaType string, body []byte) {
+	w.Header().Set("Content-Type", mediaType+"; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+func writeNotAcceptable(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusNotAcceptable)
+	_, _ = w.Write([]byte("Supported types: " + strings.Join(taskMediaTypes, ", ")))
+}
+
+func markdownTaskItem(task models.Task) string {
+	mark := " "
+	if task.IsResolved {
+		mark = "x"
+	}
+	item := fmt.Sprintf("- [%s] %s", mark, markdownEscape(task.Value))
+	if task.DueDate != nil {
+		item += fmt.Sprintf(" (due %s)", task.DueDate.Format(time.RFC3339))
+	}
+	for _, label := range task.Labels {
+		item += " _" + markdownEscape(label) + "_"
+	}
+	return item + "\n"
+}
+
+var markdownReplacer = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`,
+	"#", `\#`, "<", `\<`, ">", `\>`, "|", `\|`, "\r\n", " ", "\n", " ",
+)
+
+// Escape user text so that it is rendered literally inside a single markdown line
+func markdownEscape(s string) string {
+	return markdownReplacer.Replace(s)
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", mediaHTML},
+		{"*/*", mediaHTML},
+		{"application/json", mediaJSON},
+		{"text/csv, application/json;q=0.5", mediaCSV},
+		{"text/*;q=0.5, application/json", mediaJSON},
+		{"text/markdown, text/*;q=0.1", mediaMarkdown},
+		{"text/html;q=0, */*", mediaJSON},
+		{"text/*;q=0", ""},
+		{"text/*;q=0, */*", mediaJSON},
+		{"*/*;q=0", ""},
+		{"*/*;q=0, text/csv", mediaCSV},
+		{"text/*;q=0, text/csv", mediaCSV},
+		{"image/png", ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/tasks", nil)
+		if test.accept != "" {
+			r.Header.Set("Accept", test.accept)
+		}
+		if got := negotiate(r, taskMediaTypes); got != test.want {
+			t.Errorf("negotiate(%q) = %q, want %q", test.accept, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Kolya59/todo-service/models"
+	"github.com/Kolya59/todo-service/pkg/postgres"
+)
+
+// Number of latest notifications shown in the inbox
+const inboxSize = 100
+
+// List latest notifications, only unread ones with unread=true
+func apiGetNotifications(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	unread := false
+	if value := r.URL.Query().Get("unread"); value != "" {
+		var err error
+		if unread, err = strconv.ParseBool(value); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Unread must be true or false")
+			return
+		}
+	}
+	notifications, err := postgres.SelectNotifications(userId, inboxSize, unread)
+	if err != nil {
+		writeStoreError(w, err, "Failed to get notifications")
+		return
+	}
+	if notifications == nil {
+		notifications = make([]models.Notification, 0)
+	}
+	writeJSON(w, http.StatusOK, notifications)
+}
+
+func apiCountUnreadNotifications(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	count, err := postgres.CountUnreadNotifications(userId)
+	if err != nil {
+		writeStoreError(w, err, "Failed to count notifications")
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Count int `json:"count"`
+	}{count})
+}
+
+func apiReadNotification(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	id, ok := uuidParam(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := postgres.MarkNotificationRead(userId, id); err != nil {
+		writeStoreError(w, err, "Failed to mark notification as read")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiReadAllNotifications(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	if err := postgres.MarkAllNotificationsRead(userId); err != nil {
+		writeStoreError(w, err, "Failed to mark notifications as read")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
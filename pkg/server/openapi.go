@@ -0,0 +1,716 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/Kolya59/todo-service/models"
+	"github.com/Kolya59/todo-service/pkg/events"
+	"github.com/Kolya59/todo-service/pkg/postgres"
+	"github.com/Kolya59/todo-service/pkg/quickadd"
+)
+
+// OpenAPI 3 document, only the parts used by the service are modeled
+type openAPIDocument struct {
+	OpenAPI    string                       `json:"openapi"`
+	Info       openAPIInfo                  `json:"info"`
+	Paths      map[string]map[string]*apiOp `json:"paths"`
+	Components map[string]map[string]schema `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type apiOp struct {
+	Summary     string                 `json:"summary"`
+	Tags        []string               `json:"tags,omitempty"`
+	Parameters  []apiParameter         `json:"parameters,omitempty"`
+	RequestBody *apiBody               `json:"requestBody,omitempty"`
+	Responses   map[string]apiResponse `json:"responses"`
+}
+
+type apiParameter struct {
+	Name     string `json:"name"`
+	In       string `json:"in"`
+	Required bool   `json:"required"`
+	Schema   schema `json:"schema"`
+}
+
+type apiBody struct {
+	Required bool                         `json:"required"`
+	Content  map[string]map[string]schema `json:"content"`
+}
+
+type apiResponse struct {
+	Description string                       `json:"description"`
+	Headers     map[string]map[string]schema `json:"headers,omitempty"`
+	Content     map[string]map[string]schema `json:"content,omitempty"`
+}
+
+type schema map[string]interface{}
+
+func ref(name string) schema {
+	return schema{"$ref": "#/components/schemas/" + name}
+}
+
+func arrayOf(items schema) schema {
+	return schema{"type": "array", "items": items}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Derive schema of a models type from its json tags, nested models types are referenced by name
+func schemaOf(t reflect.Type, components map[string]schema) schema {
+	nullable := false
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		nullable = true
+	}
+	var s schema
+	switch {
+	case t == timeType:
+		s = schema{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Struct:
+		if _, ok := components[t.Name()]; !ok {
+			components[t.Name()] = nil
+			components[t.Name()] = structSchema(t, components)
+		}
+		s = ref(t.Name())
+	case t.Kind() == reflect.Slice:
+		s = arrayOf(schemaOf(t.Elem(), components))
+	case t.Kind() == reflect.String:
+		s = schema{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		s = schema{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		s = schema{"type": "integer", "format": "int64"}
+	default:
+		s = schema{}
+	}
+	if nullable {
+		if _, isRef := s["$ref"]; isRef {
+			return schema{"allOf": []schema{s}, "nullable": true}
+		}
+		s["nullable"] = true
+	}
+	return s
+}
+
+func structSchema(t reflect.Type, components map[string]schema) schema {
+	properties := schema{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("json"), ",")
+		name := tag[0]
+		if name == "-" || field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = schemaOf(field.Type, components)
+		omitEmpty := false
+		for _, option := range tag[1:] {
+			omitEmpty = omitEmpty || option == "omitempty"
+		}
+		if !omitEmpty {
+			required = append(required, name)
+		}
+	}
+	return object(properties, required...)
+}
+
+// Pick some properties of object schema
+func pick(s schema, names ...string) schema {
+	properties := schema{}
+	for _, name := range names {
+		properties[name] = s["properties"].(schema)[name]
+	}
+	return schema{"type": "object", "properties": properties, "required": names}
+}
+
+func object(properties schema, required ...string) schema {
+	s := schema{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+func op(tag string, summary string) *apiOp {
+	return &apiOp{Summary: summary, Tags: []string{tag}, Responses: map[string]apiResponse{}}
+}
+
+func (o *apiOp) param(names ...string) *apiOp {
+	for _, name := range names {
+		o.Parameters = append(o.Parameters, apiParameter{Name: name, In: "path", Required: true, Schema: schema{"type": "string", "format": "uuid"}})
+	}
+	return o
+}
+
+func (o *apiOp) pathParam(name string, s schema) *apiOp {
+	o.Parameters = append(o.Parameters, apiParameter{Name: name, In: "path", Required: true, Schema: s})
+	return o
+}
+
+func (o *apiOp) query(name string, required bool, s schema) *apiOp {
+	o.Parameters = append(o.Parameters, apiParameter{Name: name, In: "query", Required: required, Schema: s})
+	return o
+}
+
+// Tasks listing is filtered by query and paged by cursor, page is described in response headers
+func (o *apiOp) paged() *apiOp {
+	o.Parameters = append(o.Parameters,
+		apiParameter{Name: "q", In: "query", Schema: schema{"type": "string", "description": `Filter like is:open label:work due<2026-11-01 "release notes"`}},
+		apiParameter{Name: "cursor", In: "query", Schema: schema{"type": "string"}},
+		apiParameter{Name: "limit", In: "query", Schema: schema{"type": "integer", "minimum": 1, "maximum": postgres.MaxPageLimit, "default": postgres.DefaultPageLimit}},
+	)
+	response := o.Responses["200"]
+	response.Headers = map[string]map[string]schema{
+		"X-Total-Count": {"schema": {"type": "integer"}},
+		"Link":          {"schema": {"type": "string", "description": `Cursor URLs of rel="next" and rel="prev" pages`}},
+	}
+	o.Responses["200"] = response
+	return o
+}
+
+// Body of media type, repeated calls add alternative media types
+func (o *apiOp) body(mediaType string, s schema) *apiOp {
+	if o.RequestBody == nil {
+		o.RequestBody = &apiBody{Required: true, Content: map[string]map[string]schema{}}
+	}
+	o.RequestBody.Content[mediaType] = map[string]schema{"schema": s}
+	return o
+}
+
+func (o *apiOp) respond(status int, description string) *apiOp {
+	o.Responses[fmt.Sprint(status)] = apiResponse{Description: description}
+	return o
+}
+
+func (o *apiOp) respondWith(status int, description string, content map[string]schema) *apiOp {
+	response := apiResponse{Description: description, Content: map[string]map[string]schema{}}
+	for mediaType, s := range content {
+		response.Content[mediaType] = map[string]schema{"schema": s}
+	}
+	o.Responses[fmt.Sprint(status)] = response
+	return o
+}
+
+func (o *apiOp) respondJSON(status int, description string, s schema) *apiOp {
+	return o.respondWith(status, description, map[string]schema{mediaJSON: s})
+}
+
+// Errors of JSON API are wrapped into error envelope
+func (o *apiOp) apiErrors(statuses ...int) *apiOp {
+	for _, status := range statuses {
+		o.respondJSON(status, http.StatusText(status), ref("Error"))
+	}
+	return o
+}
+
+func (o *apiOp) file(mediaType string) *apiOp {
+	return o.respondWith(http.StatusOK, "Static file", map[string]schema{mediaType: {"type": "string"}})
+}
+
+// Build OpenAPI document describing routes registered in StartServer
+func buildOpenAPI() openAPIDocument {
+	components := map[string]schema{}
+	task := schemaOf(reflect.TypeOf(models.Task{}), components)
+	comment := schemaOf(reflect.TypeOf(models.Comment{}), components)
+	reminder := schemaOf(reflect.TypeOf(models.Reminder{}), components)
+	attachment := schemaOf(reflect.TypeOf(models.Attachment{}), components)
+	searchResult := schemaOf(reflect.TypeOf(models.SearchResult{}), components)
+	smartList := schemaOf(reflect.TypeOf(models.SmartList{}), components)
+	project := schemaOf(reflect.TypeOf(models.Project{}), components)
+	bulkOperation := schemaOf(reflect.TypeOf(models.BulkOperation{}), components)
+	bulkResult := schemaOf(reflect.TypeOf(models.BulkResult{}), components)
+	share := schemaOf(reflect.TypeOf(models.Share{}), components)
+	workspace := schemaOf(reflect.TypeOf(models.Workspace{}), components)
+	member := schemaOf(reflect.TypeOf(models.Member{}), components)
+	invitation := schemaOf(reflect.TypeOf(models.Invitation{}), components)
+	notification := schemaOf(reflect.TypeOf(models.Notification{}), components)
+	captureToken := schemaOf(reflect.TypeOf(models.CaptureToken{}), components)
+	event := schemaOf(reflect.TypeOf(events.Event{}), components)
+	webhook := schemaOf(reflect.TypeOf(models.Webhook{}), components)
+	delivery := schemaOf(reflect.TypeOf(models.WebhookDelivery{}), components)
+	requestMessage := structSchema(reflect.TypeOf(socketRequest{}), components)
+	replyMessage := structSchema(reflect.TypeOf(socketReply{}), components)
+	// Named apart from Task of models
+	components["QuickAddResult"] = structSchema(reflect.TypeOf(quickadd.Task{}), components)
+	user := structSchema(reflect.TypeOf(models.User{}), components)
+	components["User"] = pick(user, "uuid", "login")
+	components["Credentials"] = pick(user, "login", "password")
+	components["Error"] = object(schema{
+		"error": object(schema{"code": schema{"type": "string"}, "message": schema{"type": "string"}}, "code", "message"),
+	}, "error")
+
+	taskProperties := components["Task"]["properties"].(schema)
+	taskProperties["priority"] = schema{"type": "string", "enum": []string{models.PriorityLow, models.PriorityMedium, models.PriorityHigh}}
+	components["NewTask"] = object(schema{
+		"value":        taskProperties["value"],
+		"due_date":     taskProperties["due_date"],
+		"labels":       taskProperties["labels"],
+		"priority":     taskProperties["priority"],
+		"project_uuid": taskProperties["project_uuid"],
+	}, "value")
+	components["TaskPatch"] = object(schema{
+		"value":        taskProperties["value"],
+		"is_resolved":  taskProperties["is_resolved"],
+		"due_date":     taskProperties["due_date"],
+		"labels":       taskProperties["labels"],
+		"priority":     schema{"type": "string", "enum": []string{models.PriorityLow, models.PriorityMedium, models.PriorityHigh}, "nullable": true},
+		"project_uuid": taskProperties["project_uuid"],
+	})
+	quickProperties := schema{"time_zone": schema{"type": "string", "description": "IANA time zone relative dates are resolved in, UTC by default"}}
+	for name, property := range components["NewTask"]["properties"].(schema) {
+		quickProperties[name] = property
+	}
+	components["QuickTask"] = object(quickProperties, "value")
+	// Fields missing from body are not changed
+	components["TaskStatus"] = object(schema{"is_resolved": taskProperties["is_resolved"], "due_date": taskProperties["due_date"]})
+	components["NewComment"] = object(schema{"value": components["Comment"]["properties"].(schema)["value"]}, "value")
+	projectProperties := components["Project"]["properties"].(schema)
+	components["NewProject"] = object(schema{"name": projectProperties["name"], "workspace_uuid": projectProperties["workspace_uuid"]}, "name")
+	components["ProjectName"] = object(schema{"name": projectProperties["name"]}, "name")
+	workspaceRole := schema{"type": "string", "enum": []string{models.WorkspaceOwner, models.WorkspaceAdmin, models.WorkspaceMember, models.WorkspaceGuest}}
+	components["NewWorkspace"] = object(schema{"name": components["Workspace"]["properties"].(schema)["name"]}, "name")
+	components["MemberPatch"] = object(schema{"role": workspaceRole}, "role")
+	components["NewInvitation"] = object(schema{"login": components["Invitation"]["properties"].(schema)["login"], "role": workspaceRole}, "login", "role")
+	components["NewShare"] = object(schema{
+		"login": components["Share"]["properties"].(schema)["login"],
+		"role":  schema{"type": "string", "enum": []string{models.RoleViewer, models.RoleEditor}},
+	}, "login", "role")
+	components["BulkRequest"] = object(schema{
+		"operations": arrayOf(bulkOperation),
+		"atomic":     schema{"type": "boolean", "description": "Roll back all operations when any of them fails"},
+	}, "operations")
+	components["BulkResponse"] = object(schema{
+		"committed": schema{"type": "boolean"},
+		"results":   arrayOf(bulkResult),
+	}, "committed", "results")
+	listProperties := components["SmartList"]["properties"].(schema)
+	components["NewSmartList"] = object(schema{
+		"name":   listProperties["name"],
+		"query":  listProperties["query"],
+		"pinned": listProperties["pinned"],
+	}, "name")
+	components["SmartListPatch"] = object(schema{
+		"name":   listProperties["name"],
+		"query":  listProperties["query"],
+		"pinned": listProperties["pinned"],
+	})
+	components["NewCaptureToken"] = object(schema{"name": components["CaptureToken"]["properties"].(schema)["name"]}, "name")
+	captureForm := object(schema{
+		"value":        taskProperties["value"],
+		"labels":       schema{"type": "string", "description": "Comma separated labels"},
+		"priority":     taskProperties["priority"],
+		"due_date":     schema{"type": "string", "format": "date-time"},
+		"project_uuid": schema{"type": "string", "format": "uuid"},
+	}, "value")
+	webhookProperties := components["Webhook"]["properties"].(schema)
+	webhookProperties["events"] = arrayOf(schema{"type": "string", "enum": webhookEvents})
+	components["NewWebhook"] = object(schema{"url": webhookProperties["url"], "events": webhookProperties["events"]}, "url", "events")
+	deliveryProperties := components["WebhookDelivery"]["properties"].(schema)
+	deliveryProperties["status"] = schema{"type": "string", "enum": []string{models.DeliveryPending, models.DeliveryDelivered, models.DeliveryFailed}}
+	deliveryProperties["payload"] = schema{"type": "object", "description": "Posted body with event and task"}
+	// Messages of /ws are not described by OpenAPI paths, they are kept as components for clients
+	requestMessage["properties"].(schema)["type"] = schema{"type": "string", "enum": []string{
+		socketSubscribe, socketUnsubscribe, socketCreate, socketUpdate, socketResolve}}
+	requestMessage["properties"].(schema)["task"] = schema{"oneOf": []schema{ref("NewTask"), ref("TaskPatch")}}
+	requestMessage["required"] = []string{"type"}
+	replyMessage["properties"].(schema)["type"] = schema{"type": "string", "enum": []string{socketAck, socketError, socketEvent}}
+	components["SocketRequest"] = requestMessage
+	components["SocketReply"] = replyMessage
+	reminderProperties := components["Reminder"]["properties"].(schema)
+	components["NewReminder"] = object(schema{"remind_at": reminderProperties["remind_at"], "offset": reminderProperties["offset"]})
+
+	// Representations of tasks chosen by Accept header
+	tasksContent := map[string]schema{
+		mediaHTML:     {"type": "string"},
+		mediaJSON:     arrayOf(task),
+		mediaCSV:      {"type": "string"},
+		mediaMarkdown: {"type": "string"},
+	}
+	taskContent := map[string]schema{
+		mediaHTML:     {"type": "string"},
+		mediaJSON:     task,
+		mediaCSV:      {"type": "string"},
+		mediaMarkdown: {"type": "string"},
+	}
+	upload := object(schema{"file": schema{"type": "string", "format": "binary"}}, "file")
+
+	paths := map[string]map[string]*apiOp{
+		"/": {
+			"options": op("meta", "CORS preflight").respond(http.StatusOK, "Allowed methods"),
+		},
+		"/openapi.json": {
+			"get": op("meta", "OpenAPI document").respondJSON(http.StatusOK, "This document", schema{"type": "object"}),
+		},
+		"/events": {
+			"get": op("events", "Stream task and comment events of current user, clients fetch changed tasks themselves").
+				respondWith(http.StatusOK, "Server-sent events, data of every event is Event", map[string]schema{
+					"text/event-stream": {"type": "string", "description": "Event stream", "x-event": event},
+				}).
+				respond(http.StatusUnauthorized, "Authorization is required"),
+		},
+		"/ws": {
+			"get": op("events", "WebSocket of collaborative clients, messages are SocketRequest and SocketReply").
+				respond(http.StatusSwitchingProtocols, "Upgraded to WebSocket").
+				respond(http.StatusBadRequest, "Not a WebSocket handshake").
+				respond(http.StatusUnauthorized, "Authorization is required"),
+		},
+		"/capture/{token}": {
+			"post": op("capture", "Create task by secret capture URL, inline syntax and natural date of value are parsed").
+				pathParam("token", schema{"type": "string"}).
+				body("text/plain", schema{"type": "string", "description": "Value of task"}).
+				body(mediaJSON, ref("NewTask")).
+				body("application/x-www-form-urlencoded", captureForm).
+				body("multipart/form-data", captureForm).
+				respondJSON(http.StatusCreated, "Created task", task).
+				apiErrors(http.StatusBadRequest, http.StatusNotFound),
+		},
+		"/auth/signin": {
+			"post": op("auth", "Sign in, sets id cookie").body(mediaJSON, ref("Credentials")).
+				respond(http.StatusOK, "Signed in").respond(http.StatusForbidden, "Wrong login or password"),
+		},
+		"/auth/signup": {
+			"post": op("auth", "Sign up, sets id cookie").body(mediaJSON, ref("Credentials")).
+				respond(http.StatusOK, "Signed up").respond(http.StatusUnprocessableEntity, "User exists"),
+		},
+
+		"/api/v1/users/me": {
+			"get": op("api", "Current user").respondJSON(http.StatusOK, "Current user", ref("User")).
+				apiErrors(http.StatusUnauthorized),
+		},
+		"/api/v1/tasks": {
+			"get": op("api", "List tasks from the newest").respondJSON(http.StatusOK, "Tasks", arrayOf(task)).paged().
+				apiErrors(http.StatusBadRequest, http.StatusUnauthorized),
+			"post": op("api", "Create task").body(mediaJSON, ref("NewTask")).
+				respondJSON(http.StatusCreated, "Created task", task).
+				apiErrors(http.StatusBadRequest, http.StatusUnauthorized, http.StatusUnsupportedMediaType),
+		},
+		"/api/v1/tasks/{id}": {
+			"get": op("api", "Get task with comments").param("id").
+				respondJSON(http.StatusOK, "Task", task).apiErrors(http.StatusUnauthorized, http.StatusNotFound),
+			"patch": op("api", "Change present fields of task").param("id").body(mediaJSON, ref("TaskPatch")).
+				respondJSON(http.StatusOK, "Changed task", task).
+				apiErrors(http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound),
+			"delete": op("api", "Delete task").param("id").respond(http.StatusNoContent, "Deleted").
+				apiErrors(http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound),
+		},
+		"/api/v1/tasks/{id}/shares": {
+			"get": op("api", "List shares of own task").param("id").
+				respondJSON(http.StatusOK, "Shares", arrayOf(share)).
+				apiErrors(http.StatusUnauthorized),
+			"post": op("api", "Share own task by login, role of existing share is replaced").param("id").
+				body(mediaJSON, ref("NewShare")).respondJSON(http.StatusCreated, "Created share", share).
+				apiErrors(http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusUnsupportedMediaType),
+		},
+		"/api/v1/tasks/assigned": {
+			"get": op("api", "List tasks assigned to current user, q narrows it").
+				respondJSON(http.StatusOK, "Tasks", arrayOf(task)).paged().
+				apiErrors(http.StatusBadRequest, http.StatusUnauthorized),
+		},
+		"/api/v1/tasks/{id}/assignees/{login}": {
+			"put": op("api", "Assign task to user who sees it").param("id").pathParam("login", schema{"type": "string"}).
+				respond(http.StatusNoContent, "Assigned").
+				apiErrors(http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound),
+			"delete": op("api", "Unassign user from task").param("id").pathParam("login", schema{"type": "string"}).
+				respond(http.StatusNoContent, "Unassigned").apiErrors(http.StatusUnauthorized, http.StatusNotFound),
+		},
+		"/api/v1/tasks/bulk": {
+			"post": op("api", "Resolve, reopen, delete, relabel and move tasks in a single transaction").
+				body(mediaJSON, ref("BulkRequest")).
+				respondJSON(http.StatusOK, "Results in order of operations", ref("BulkResponse")).
+				apiErrors(http.StatusBadRequest, http.StatusUnauthorized, http.StatusUnsupportedMediaType),
+		},
+		"/api/v1/projects": {
+			"get": op("api", "List projects").respondJSON(http.StatusOK, "Projects", arrayOf(project)).
+				apiErrors(http.StatusUnauthorized),
+			"post": op("api", "Create project, personal one unless workspace is given").body(mediaJSON, ref("NewProject")).
+				respondJSON(http.StatusCreated, "Created project", project).
+				apiErrors(http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusUnsupportedMediaType),
+		},
+		"/api/v1/projects/{id}": {
+			"patch": op("api", "Rename project").param("id").body(mediaJSON, ref("ProjectName")).
+				respond(http.StatusNoContent, "Renamed").
+				apiErrors(http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound),
+			"delete": op("api", "Delete project, its tasks are kept out of any project").param("id").
+				respond(http.StatusNoContent, "Deleted").apiErrors(http.StatusUnauthorized, http.StatusNotFound),
+		},
+		"/api/v1/projects/{id}/shares": {
+			"get": op("api", "List shares of own project").param("id").
+				respondJSON(http.StatusOK, "Shares", arrayOf(share)).
+				apiErrors(http.StatusUnauthorized),
+			"post": op("api", "Share all tasks of own project by login, role of existing share is replaced").param("id").
+				body(mediaJSON, ref("NewShare")).respondJSON(http.StatusCreated, "Created share", share).
+				apiErrors(http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusUnsupportedMediaType),
+		},
+		"/api/v1/shares": {
+			"get": op("api", "List tasks and projects shared with current user").
+				respondJSON(http.StatusOK, "Shares", arrayOf(share)).
+				apiErrors(http.StatusUnauthorized),
+		},
+		"/api/v1/shares/{id}": {
+			"delete": op("api", "Revoke share as owner or leave it as recipient").param("id").
+				respond(http.StatusNoContent, "Deleted").apiErrors(http.StatusUnauthorized, http.StatusNotFound),
+		},
+		"/api/v1/workspaces": {
+			"get": op("workspaces", "List workspaces of current user with the role").
+				respondJSON(http.StatusOK, "Workspaces", arrayOf(workspace)).apiErrors(http.StatusUnauthorized),
+			"post": op("workspaces", "Create workspace owned by current user").body(mediaJSON, ref("NewWorkspace")).
+				respondJSON(http.StatusCreated, "Created workspace", workspace).
+				apiErrors(http.StatusBadRequest, http.StatusUnauthorized, http.StatusUnsupportedMediaType),
+		},
+		"/api/v1/workspaces/{id}": {
+			"get": op("workspaces", "Get workspace").param("id").
+				respondJSON(http.StatusOK, "Workspace", workspace).apiErrors(http.StatusUnauthorized, http.StatusNotFound),
+			"patch": op("workspaces", "Rename workspace, owners and admins only").param("id").body(mediaJSON, ref("NewWorkspace")).
+				respond(http.StatusNoContent, "Renamed").
+				apiErrors(http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound),
+			"delete": op("workspaces", "Delete workspace with its projects, owners only").param("id").
+				respond(http.StatusNoContent, "Deleted").apiErrors(http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound),
+		},
+		"/api/v1/workspaces/{id}/members": {
+			"get": op("workspaces", "List members of workspace").param("id").
+				respondJSON(http.StatusOK, "Members", arrayOf(member)).apiErrors(http.StatusUnauthorized, http.StatusNotFound),
+		},
+		"/api/v1/workspaces/{id}/members/{userId}": {
+			"patch": op("workspaces", "Change role of member, only owners grant ownership").param("id", "userId").
+				body(mediaJSON, ref("MemberPatch")).respond(http.StatusNoContent, "Changed").
+				apiErrors(http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusConflict),
+			"delete": op("workspaces", "Remove member or leave workspace").param("id", "userId").
+				respond(http.StatusNoContent, "Removed").
+				apiErrors(http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusConflict),
+		},
+		"/api/v1/workspaces/{id}/invitations": {
+			"get": op("workspaces", "List pending invitations into workspace").param("id").
+				respondJSON(http.StatusOK, "Invitations", arrayOf(invitation)).
+				apiErrors(http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound),
+			"post": op("workspaces", "Invite user by login, repeated invitation replaces the role").param("id").
+				body(mediaJSON, ref("NewInvitation")).respondJSON(http.StatusCreated, "Created invitation", invitation).
+				apiErrors(http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusConflict),
+		},
+		"/api/v1/invitations": {
+			"get": op("workspaces", "List invitations of current user").
+				respondJSON(http.StatusOK, "Invitations", arrayOf(invitation)).apiErrors(http.StatusUnauthorized),
+		},
+		"/api/v1/notifications": {
+			"get": op("notifications", "List latest notifications of current user").
+				query("unread", false, schema{"type": "boolean", "description": "Only unread notifications"}).
+				respondJSON(http.StatusOK, "Notifications", arrayOf(notification)).
+				apiErrors(http.StatusBadRequest, http.StatusUnauthorized),
+		},
+		"/api/v1/notifications/unread": {
+			"get": op("notifications", "Count unread notifications of current user").
+				respondJSON(http.StatusOK, "Unread count", object(schema{"count": schema{"type": "integer"}}, "count")).
+				apiErrors(http.StatusUnauthorized),
+		},
+		"/api/v1/notifications/read": {
+			"post": op("notifications", "Mark all notifications as read").
+				respond(http.StatusNoContent, "Marked").apiErrors(http.StatusUnauthorized),
+		},
+		"/api/v1/notifications/{id}/read": {
+			"post": op("notifications", "Mark notification as read").param("id").
+				respond(http.StatusNoContent, "Marked").apiErrors(http.StatusUnauthorized, http.StatusNotFound),
+		},
+		"/api/v1/captures": {
+			"get": op("capture", "List capture tokens of current user").
+				respondJSON(http.StatusOK, "Capture tokens", arrayOf(captureToken)).apiErrors(http.StatusUnauthorized),
+			"post": op("capture", "Create capture token, its token and URL are returned only once").
+				body(mediaJSON, ref("NewCaptureToken")).respondJSON(http.StatusCreated, "Created capture token", captureToken).
+				apiErrors(http.StatusBadRequest, http.StatusUnauthorized),
+		},
+		"/api/v1/captures/{id}": {
+			"delete": op("capture", "Revoke capture token").param("id").
+				respond(http.StatusNoContent, "Deleted").apiErrors(http.StatusUnauthorized, http.StatusNotFound),
+		},
+		"/api/v1/webhooks": {
+			"get": op("webhooks", "List webhooks of current user").
+				respondJSON(http.StatusOK, "Webhooks", arrayOf(webhook)).apiErrors(http.StatusUnauthorized),
+			"post": op("webhooks", "Register webhook, its signing secret is returned only once").
+				body(mediaJSON, ref("NewWebhook")).respondJSON(http.StatusCreated, "Created webhook", webhook).
+				apiErrors(http.StatusBadRequest, http.StatusUnauthorized),
+		},
+		"/api/v1/webhooks/{id}": {
+			"delete": op("webhooks", "Delete webhook with its deliveries").param("id").
+				respond(http.StatusNoContent, "Deleted").apiErrors(http.StatusUnauthorized, http.StatusNotFound),
+		},
+		"/api/v1/webhooks/{id}/deliveries": {
+			"get": op("webhooks", "List latest deliveries of webhook").param("id").
+				respondJSON(http.StatusOK, "Deliveries", arrayOf(delivery)).apiErrors(http.StatusUnauthorized, http.StatusNotFound),
+		},
+		"/api/v1/webhooks/{id}/deliveries/{deliveryId}": {
+			"get": op("webhooks", "Get delivery with payload and attempts").param("id", "deliveryId").
+				respondJSON(http.StatusOK, "Delivery", delivery).apiErrors(http.StatusUnauthorized, http.StatusNotFound),
+		},
+		"/api/v1/webhooks/{id}/deliveries/{deliveryId}/redeliver": {
+			"post": op("webhooks", "Queue delivery again with its original payload").param("id", "deliveryId").
+				respond(http.StatusAccepted, "Queued").apiErrors(http.StatusUnauthorized, http.StatusNotFound),
+		},
+		"/api/v1/invitations/{id}/accept": {
+			"post": op("workspaces", "Accept invitation and join workspace").param("id").
+				respondJSON(http.StatusOK, "Joined workspace", workspace).apiErrors(http.StatusUnauthorized, http.StatusNotFound),
+		},
+		"/api/v1/invitations/{id}": {
+			"delete": op("workspaces", "Decline invitation or revoke it").param("id").
+				respond(http.StatusNoContent, "Deleted").apiErrors(http.StatusUnauthorized, http.StatusNotFound),
+		},
+		"/api/v1/search": {
+			"get": op("api", "Full text search over tasks and comments").
+				query("text", true, schema{"type": "string", "description": `Words, "quoted phrases", OR and -excluded words`}).
+				query("limit", false, schema{"type": "integer", "minimum": 1, "maximum": postgres.MaxSearchLimit, "default": postgres.DefaultSearchLimit}).
+				respondJSON(http.StatusOK, "Results ordered by rank", arrayOf(searchResult)).
+				apiErrors(http.StatusBadRequest, http.StatusUnauthorized),
+		},
+		"/api/v1/lists": {
+			"get": op("api", "List smart lists with counts of tasks, pinned first").
+				respondJSON(http.StatusOK, "Smart lists", arrayOf(smartList)).
+				apiErrors(http.StatusUnauthorized),
+			"post": op("api", "Save filter query as smart list").body(mediaJSON, ref("NewSmartList")).
+				respondJSON(http.StatusCreated, "Created smart list", smartList).
+				apiErrors(http.StatusBadRequest, http.StatusUnauthorized, http.StatusUnsupportedMediaType),
+		},
+		"/api/v1/lists/{id}": {
+			"get": op("api", "Get smart list with count of tasks").param("id").
+				respondJSON(http.StatusOK, "Smart list", smartList).apiErrors(http.StatusUnauthorized, http.StatusNotFound),
+			"patch": op("api", "Change present fields of smart list").param("id").body(mediaJSON, ref("SmartListPatch")).
+				respondJSON(http.StatusOK, "Changed smart list", smartList).
+				apiErrors(http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound),
+			"delete": op("api", "Delete smart list, tasks are kept").param("id").respond(http.StatusNoContent, "Deleted").
+				apiErrors(http.StatusUnauthorized, http.StatusNotFound),
+		},
+		"/api/v1/lists/{id}/tasks": {
+			"get": op("api", "List tasks of smart list, q narrows it").param("id").
+				respondJSON(http.StatusOK, "Tasks", arrayOf(task)).paged().
+				apiErrors(http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound),
+		},
+		"/api/v1/tasks/{id}/comments": {
+			"get": op("api", "List comments of task").param("id").
+				respondJSON(http.StatusOK, "Comments", arrayOf(comment)).
+				apiErrors(http.StatusUnauthorized, http.StatusNotFound),
+			"post": op("api", "Comment task").param("id").body(mediaJSON, ref("NewComment")).
+				respondJSON(http.StatusCreated, "Created comment", comment).
+				apiErrors(http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound),
+		},
+		"/api/v1/tasks/{id}/comments/{commentId}": {
+			"delete": op("api", "Delete comment").param("id", "commentId").respond(http.StatusNoContent, "Deleted").
+				apiErrors(http.StatusUnauthorized, http.StatusNotFound),
+		},
+
+		"/ui/tasks": {
+			"get": op("ui", "Tasks page").respondWith(http.StatusOK, "Tasks", tasksContent).paged().
+				respond(http.StatusBadRequest, "Invalid filter, cursor or limit").
+				respond(http.StatusNotAcceptable, "Unsupported Accept header"),
+		},
+		"/ui/tasks/{id}": {
+			"get": op("ui", "Task page").param("id").respondWith(http.StatusOK, "Task", taskContent).
+				respond(http.StatusNotFound, "Task is not found").
+				respond(http.StatusNotAcceptable, "Unsupported Accept header"),
+		},
+		"/ui/assigned": {
+			"get": op("ui", "Tasks assigned to current user, q narrows it").respondWith(http.StatusOK, "Tasks", tasksContent).paged().
+				respond(http.StatusBadRequest, "Invalid filter, cursor or limit").
+				respond(http.StatusNotAcceptable, "Unsupported Accept header"),
+		},
+		"/ui/lists/{id}": {
+			"get": op("ui", "Smart list page, q narrows it").param("id").respondWith(http.StatusOK, "Tasks", tasksContent).paged().
+				respond(http.StatusBadRequest, "Invalid filter, cursor or limit").
+				respond(http.StatusNotFound, "Smart list is not found").
+				respond(http.StatusNotAcceptable, "Unsupported Accept header"),
+		},
+		"/ui/search": {
+			"get": op("ui", "Search page").
+				query("text", false, schema{"type": "string"}).
+				query("limit", false, schema{"type": "integer", "minimum": 1, "maximum": postgres.MaxSearchLimit}).
+				respondWith(http.StatusOK, "Search results", map[string]schema{mediaHTML: {"type": "string"}}),
+		},
+
+		"/tasks": {
+			"get": op("legacy", "List tasks").respondWith(http.StatusOK, "Tasks", tasksContent).paged().
+				respond(http.StatusBadRequest, "Invalid filter, cursor or limit").
+				respond(http.StatusNotAcceptable, "Unsupported Accept header"),
+			"post": op("legacy", "Create task, #label !priority +project and natural dates of value set its fields").
+				body(mediaJSON, ref("QuickTask")).respondJSON(http.StatusOK, "Created task", task).
+				respond(http.StatusBadRequest, "Invalid task or time zone"),
+		},
+		"/tasks/parse": {
+			"post": op("legacy", "Preview fields parsed out of value of new task").body(mediaJSON, ref("QuickTask")).
+				respondJSON(http.StatusOK, "Parsed task", ref("QuickAddResult")).
+				respond(http.StatusBadRequest, "Invalid body or time zone"),
+		},
+		"/tasks/{id}": {
+			"get": op("legacy", "Get task").param("id").respondWith(http.StatusOK, "Task", taskContent).
+				respond(http.StatusNotFound, "Task is not found").
+				respond(http.StatusNotAcceptable, "Unsupported Accept header"),
+			"put": op("legacy", "Change task status and due date").param("id").body(mediaJSON, ref("TaskStatus")).
+				respond(http.StatusOK, "Changed").respond(http.StatusBadRequest, "Invalid due date").
+				respond(http.StatusForbidden, "Task is shared as viewer").
+				respond(http.StatusNotFound, "Task is not found"),
+			"delete": op("legacy", "Delete task").param("id").
+				respond(http.StatusOK, "Deleted").respond(http.StatusForbidden, "Task is shared with user").
+				respond(http.StatusNotFound, "Task is not found"),
+		},
+		"/tasks/{id}/reminders": {
+			"get": op("reminders", "List reminders of task").param("id").
+				respondJSON(http.StatusOK, "Reminders", arrayOf(reminder)),
+			"post": op("reminders", "Create reminder, either remind_at or offset is required").param("id").
+				body(mediaJSON, ref("NewReminder")).respondJSON(http.StatusCreated, "Created reminder", reminder).
+				respond(http.StatusBadRequest, "Invalid reminder"),
+		},
+		"/tasks/{id}/reminders/{reminderId}": {
+			"delete": op("reminders", "Delete reminder").param("id", "reminderId").respond(http.StatusOK, "Deleted"),
+		},
+		"/tasks/{id}/attachments": {
+			"get": op("attachments", "List attachments of task").param("id").
+				respondJSON(http.StatusOK, "Attachments", arrayOf(attachment)),
+			"post": op("attachments", "Upload attachment").param("id").body("multipart/form-data", upload).
+				respondJSON(http.StatusCreated, "Created attachment", attachment).
+				respond(http.StatusBadRequest, "Invalid upload").
+				respond(http.StatusNotFound, "Task is not found").
+				respond(http.StatusRequestEntityTooLarge, "Attachment is too large"),
+		},
+		"/tasks/{id}/attachments/{attachmentId}": {
+			"get": op("attachments", "Download attachment").param("id", "attachmentId").
+				respondWith(http.StatusOK, "Attachment content", map[string]schema{"application/octet-stream": {"type": "string", "format": "binary"}}).
+				respond(http.StatusNotFound, "Attachment is not found"),
+			"delete": op("attachments", "Delete attachment").param("id", "attachmentId").
+				respond(http.StatusOK, "Deleted").respond(http.StatusNotFound, "Attachment is not found"),
+		},
+
+		"/auth":      {"get": op("files", "Sign in page").file(mediaHTML)},
+		"/tasks.js":  {"get": op("files", "Script of tasks page").file("application/javascript")},
+		"/task.js":   {"get": op("files", "Script of task page").file("application/javascript")},
+		"/auth.js":   {"get": op("files", "Script of sign in page").file("application/javascript")},
+		"/tasks.css": {"get": op("files", "Style of tasks page").file("text/css")},
+		"/task.css":  {"get": op("files", "Style of task page").file("text/css")},
+		"/auth.css":  {"get": op("files", "Style of sign in page").file("text/css")},
+	}
+
+	return openAPIDocument{
+		OpenAPI:    "3.0.3",
+		Info:       openAPIInfo{Title: "todo-service", Version: "1.0.0"},
+		Paths:      paths,
+		Components: map[string]map[string]schema{"schemas": components},
+	}
+}
+
+func openAPIHandler(doc openAPIDocument) (http.HandlerFunc, error) {
+	response, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal openapi document: %v", err)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", mediaJSON)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(response)
+	}, nil
+}
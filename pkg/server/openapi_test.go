@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+// Every route has to be described for generated clients and every described route has to exist
+func TestOpenAPIDescribesRoutes(t *testing.T) {
+	doc := buildOpenAPI()
+	r := newRouter(func(w http.ResponseWriter, r *http.Request) {})
+
+	routed := map[string]bool{}
+	err := chi.Walk(r, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		// Mounted routers are walked with "/*" of the mount pattern
+		route = strings.Replace(route, "/*/", "/", -1)
+		method = strings.ToLower(method)
+		routed[method+" "+route] = true
+		if doc.Paths[route][method] == nil {
+			t.Errorf("route %v %v is not described", strings.ToUpper(method), route)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("could not walk routes: %v", err)
+	}
+	for route, ops := range doc.Paths {
+		for method := range ops {
+			if !routed[method+" "+route] {
+				t.Errorf("described route %v %v does not exist", strings.ToUpper(method), route)
+			}
+		}
+	}
+}
+
+func TestOpenAPIHandler(t *testing.T) {
+	if _, err := openAPIHandler(buildOpenAPI()); err != nil {
+		t.Fatalf("could not prepare openapi document: %v", err)
+	}
+}
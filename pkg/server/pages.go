@@ -0,0 +1,50 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Kolya59/todo-service/models"
+	"github.com/Kolya59/todo-service/pkg/query"
+)
+
+// Read cursor and limit of tasks page from query
+func pageParams(r *http.Request) (cursor string, limit int, err error) {
+	query := r.URL.Query()
+	if value := query.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return "", 0, fmt.Errorf("limit must be a positive integer")
+		}
+	}
+	return query.Get("cursor"), limit, nil
+}
+
+// Parse filter query of tasks listing
+func filterParam(r *http.Request) (query.Node, error) {
+	return query.Parse(r.URL.Query().Get("q"), time.Now())
+}
+
+// URL of the same listing at another cursor, other query parameters are kept
+func pageURL(r *http.Request, cursor string, limit int) string {
+	if cursor == "" {
+		return ""
+	}
+	query := r.URL.Query()
+	query.Set("cursor", cursor)
+	query.Set("limit", strconv.Itoa(limit))
+	return r.URL.Path + "?" + query.Encode()
+}
+
+// Describe page in headers, so that all representations carry it
+func writePageHeaders(w http.ResponseWriter, r *http.Request, page models.TaskPage) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	if next := pageURL(r, page.Next, page.Limit); next != "" {
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="next"`, next))
+	}
+	if prev := pageURL(r, page.Prev, page.Limit); prev != "" {
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="prev"`, prev))
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/Kolya59/todo-service/models"
+	"github.com/Kolya59/todo-service/pkg/authz"
+	"github.com/Kolya59/todo-service/pkg/postgres"
+)
+
+// Maximal length of project name
+const maxProjectNameLength = 200
+
+func validateProjectName(name string) string {
+	switch {
+	case name == "":
+		return "Name is required"
+	case len([]rune(name)) > maxProjectNameLength:
+		return "Name is too long"
+	default:
+		return ""
+	}
+}
+
+func apiGetProjects(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	projects, err := postgres.SelectProjects(userId)
+	if err != nil {
+		writeStoreError(w, err, "Failed to get projects")
+		return
+	}
+	if projects == nil {
+		projects = make([]models.Project, 0)
+	}
+	writeJSON(w, http.StatusOK, projects)
+}
+
+func apiInsertProject(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	request := struct {
+		Name          string  `json:"name"`
+		WorkspaceUUID *string `json:"workspace_uuid"`
+	}{}
+	if !readJSON(w, r, &request) {
+		return
+	}
+	name := strings.TrimSpace(request.Name)
+	if msg := validateProjectName(name); msg != "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, msg)
+		return
+	}
+	if request.WorkspaceUUID != nil {
+		if _, err := uuid.FromString(*request.WorkspaceUUID); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Workspace must be uuid")
+			return
+		}
+		if _, ok := authorizeWorkspace(w, userId, *request.WorkspaceUUID, authz.ManageProjects); !ok {
+			return
+		}
+	}
+	project, err := postgres.InsertProject(userId, name, request.WorkspaceUUID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to insert project")
+		return
+	}
+	writeJSON(w, http.StatusCreated, project)
+}
+
+func apiRenameProject(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	request := struct {
+		Name string `json:"name"`
+	}{}
+	if !readJSON(w, r, &request) {
+		return
+	}
+	name := strings.TrimSpace(request.Name)
+	if msg := validateProjectName(name); msg != "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, msg)
+		return
+	}
+	id, ok := uuidParam(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := postgres.RenameProject(userId, id, name); err != nil {
+		writeStoreError(w, err, "Failed to rename project")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiRemoveProject(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	id, ok := uuidParam(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := postgres.DeleteProject(userId, id); err != nil {
+		writeStoreError(w, err, "Failed to delete project")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
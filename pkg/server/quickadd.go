@@ -0,0 +1,89 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Kolya59/todo-service/pkg/postgres"
+	"github.com/Kolya59/todo-service/pkg/quickadd"
+)
+
+// Task typed into the single input of tasks page, inline syntax and natural date of its value are parsed
+type quickTask struct {
+	newTask
+	// IANA time zone of the user, relative dates are resolved in UTC without it
+	TimeZone string `json:"time_zone"`
+}
+
+// Read quick task of legacy handlers, error response is written when false is returned
+func readQuickTask(w http.ResponseWriter, r *http.Request) (quickTask, bool) {
+	q := quickTask{}
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to decode body")
+		w.WriteHeader(http.StatusBadRequest)
+		return q, false
+	}
+	if err = json.Unmarshal(data, &q); err != nil {
+		log.Info().Err(err).Msg("Failed to unmarshal body")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("Invalid JSON body"))
+		return q, false
+	}
+	return q, true
+}
+
+// Current time in time zone of quick task, false is returned for unknown zone
+func (q quickTask) now() (time.Time, bool) {
+	if q.TimeZone == "" {
+		return time.Now().UTC(), true
+	}
+	location, err := time.LoadLocation(q.TimeZone)
+	if err != nil || q.TimeZone == "Local" {
+		return time.Time{}, false
+	}
+	return time.Now().In(location), true
+}
+
+// Parse value of quick task without creating it, so that the tasks page shows what is recognized
+func previewTask(w http.ResponseWriter, r *http.Request) {
+	userId, err := auth(r)
+	if err != nil || userId == "" {
+		log.Info().Err(err).Msg("Failed to authorize user")
+		http.Redirect(w, r, loginUrl, http.StatusUnauthorized)
+		return
+	}
+	q, ok := readQuickTask(w, r)
+	if !ok {
+		return
+	}
+	now, ok := q.now()
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("Unknown time zone"))
+		return
+	}
+	projects, err := projectNames(userId)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get projects")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	parsed := quickadd.Parse(q.Value, now, projects)
+	// Labels are shown as they are stored
+	if labels, err := postgres.NormalizeLabels(parsed.Labels); err == nil {
+		parsed.Labels = labels
+	}
+	response, err := json.Marshal(parsed)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal parsed task")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(response)
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+
+	"github.com/Kolya59/todo-service/pkg/postgres"
+)
+
+func getReminders(w http.ResponseWriter, r *http.Request) {
+	userId, err := auth(r)
+	if err != nil || userId == "" {
+		log.Info().Err(err).Msg("Failed to authorize user")
+		http.Redirect(w, r, loginUrl, http.StatusUnauthorized)
+		return
+	}
+	id := chi.URLParam(r, "id")
+	reminders, err := postgres.SelectReminders(userId, id)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to get reminders of task %v", id)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	response, err := json.Marshal(reminders)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal reminders")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(response)
+}
+
+func insertReminder(w http.ResponseWriter, r *http.Request) {
+	userId, err := auth(r)
+	if err != nil || userId == "" {
+		log.Info().Err(err).Msg("Failed to authorize user")
+		http.Redirect(w, r, loginUrl, http.StatusUnauthorized)
+		return
+	}
+
+	type requestBody struct {
+		RemindAt *time.Time `json:"remind_at"`
+		Offset   *int64     `json:"offset"`
+	}
+	request := &requestBody{}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to decode body")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = json.Unmarshal(data, request)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to unmarshal body")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if (request.RemindAt == nil) == (request.Offset == nil) || (request.Offset != nil && *request.Offset < 0) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("Either remind_at or non-negative offset must be set"))
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	reminder, err := postgres.InsertReminder(userId, id, request.RemindAt, request.Offset)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to insert reminder for task %v", id)
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte("Failed to insert reminder"))
+		return
+	}
+	response, err := json.Marshal(reminder)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal reminder")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(response)
+}
+
+func removeReminder(w http.ResponseWriter, r *http.Request) {
+	userId, err := auth(r)
+	if err != nil || userId == "" {
+		log.Info().Err(err).Msg("Failed to authorize user")
+		http.Redirect(w, r, loginUrl, http.StatusUnauthorized)
+		return
+	}
+	id := chi.URLParam(r, "id")
+	reminderId := chi.URLParam(r, "reminderId")
+	err = postgres.DeleteReminder(userId, id, reminderId)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to delete reminder %v", reminderId)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
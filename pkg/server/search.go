@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Kolya59/todo-service/models"
+	"github.com/Kolya59/todo-service/pkg/postgres"
+)
+
+// Read search text and limit from query
+func searchParams(r *http.Request) (text string, limit int, ok bool) {
+	query := r.URL.Query()
+	text = strings.TrimSpace(query.Get("text"))
+	if value := query.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return "", 0, false
+		}
+	}
+	return text, limit, text != ""
+}
+
+func apiSearch(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	text, limit, ok := searchParams(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Text is required and limit must be a positive integer")
+		return
+	}
+	results, err := postgres.SearchTasks(userId, text, limit)
+	if err != nil {
+		writeStoreError(w, err, "Failed to search tasks")
+		return
+	}
+	if results == nil {
+		results = make([]models.SearchResult, 0)
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+func getSearch(w http.ResponseWriter, r *http.Request) {
+	userId, err := auth(r)
+	if err != nil || userId == "" {
+		log.Info().Err(err).Msg("Failed to authorize user")
+		http.Redirect(w, r, loginUrl, http.StatusUnauthorized)
+		return
+	}
+	text, limit, ok := searchParams(r)
+	var results []models.SearchResult
+	if ok {
+		results, err = postgres.SearchTasks(userId, text, limit)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to search tasks")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	renderTemplate(w, "./assets/html/search.gohtml", struct {
+		Text    string
+		Results []models.SearchResult
+	}{text, results})
+}
@@ -4,12 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"html/template"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"os/signal"
-	"path"
 	"syscall"
 	"time"
 
@@ -18,7 +16,13 @@ import (
 	"github.com/rs/zerolog/log"
 
 	"github.com/Kolya59/todo-service/models"
+	"github.com/Kolya59/todo-service/pkg/authz"
+	"github.com/Kolya59/todo-service/pkg/blob"
+	"github.com/Kolya59/todo-service/pkg/events"
+	"github.com/Kolya59/todo-service/pkg/notifier"
 	"github.com/Kolya59/todo-service/pkg/postgres"
+	"github.com/Kolya59/todo-service/pkg/query"
+	"github.com/Kolya59/todo-service/pkg/scheduler"
 )
 
 const (
@@ -26,50 +30,21 @@ const (
 )
 
 var (
-	tasksUrl string
-	loginUrl string
+	tasksUrl  string
+	loginUrl  string
+	blobStore blob.Store
 )
 
-func StartServer(host string, port string, profilerPort string) {
-	// Create router
-	r := chi.NewRouter()
-	// Setup routes
-	r.Options("/", optionsHandler)
-
-	r.Post("/auth/signin", authorize)
-	r.Post("/auth/signup", register)
-
-	r.Get("/tasks", getAllTask)
-	r.Post("/tasks", insertTask)
+func StartServer(host string, port string, profilerPort string, n notifier.Notifier, store blob.Store) {
+	blobStore = store
+	hub = events.NewHub()
 
-	r.Get("/tasks/{id}", getTask)
-	r.Put("/tasks/{id}", updateTaskStatus)
-	r.Delete("/tasks/{id}", removeTask)
-
-	// File routes
-	r.Get("/auth", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "./assets/html/auth.gohtml")
-	})
-
-	r.Get("/tasks.js", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "./assets/js/tasks.js")
-	})
-	r.Get("/task.js", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "./assets/js/task.js")
-	})
-	r.Get("/auth.js", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "./assets/js/auth.js")
-	})
+	specHandler, err := openAPIHandler(buildOpenAPI())
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to prepare openapi document")
+	}
 
-	r.Get("/tasks.css", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "./assets/style/tasks.css")
-	})
-	r.Get("/task.css", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "./assets/style/task.css")
-	})
-	r.Get("/auth.css", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "./assets/style/auth.css")
-	})
+	r := newRouter(specHandler)
 
 	// Server definition
 	srv := http.Server{
@@ -108,8 +83,14 @@ func StartServer(host string, port string, profilerPort string) {
 		}
 	}()
 
+	// Fire reminders
+	go (&scheduler.Scheduler{Notifier: n}).Run(done)
+
+	// Deliver events published by other instances
+	go hub.Listen(done)
+
 	loginUrl = fmt.Sprintf("%v:%v/auth", host, port)
-	tasksUrl = fmt.Sprintf("%v:%v/tasks", host, port)
+	tasksUrl = fmt.Sprintf("%v:%v/ui/tasks", host, port)
 
 	// Listen requests
 	go func() {
@@ -126,6 +107,9 @@ func StartServer(host string, port string, profilerPort string) {
 
 	<-done
 
+	// Event streams never end by themselves, so they are closed before shutdown waits for connections
+	hub.Close()
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	if err := srv.Shutdown(ctx); err != nil {
@@ -134,6 +118,74 @@ func StartServer(host string, port string, profilerPort string) {
 	}
 }
 
+func newRouter(specHandler http.HandlerFunc) chi.Router {
+	// Create router
+	r := chi.NewRouter()
+	// Setup routes
+	r.Options("/", optionsHandler)
+	r.Get("/openapi.json", specHandler)
+	r.Get("/events", streamEvents)
+	r.Get("/ws", serveSocket)
+	r.Post("/capture/{token}", capture)
+
+	r.Post("/auth/signin", authorize)
+	r.Post("/auth/signup", register)
+
+	// JSON API
+	r.Mount("/api/v1", apiRouter())
+
+	// HTML UI
+	r.Get("/ui/tasks", getAllTask)
+	r.Get("/ui/tasks/{id}", getTask)
+	r.Get("/ui/assigned", getAssignedTasks)
+	r.Get("/ui/search", getSearch)
+	r.Get("/ui/lists/{id}", getSmartList)
+
+	// Routes used before JSON API, kept for existing clients
+	r.Get("/tasks", getAllTask)
+	r.Post("/tasks", insertTask)
+	r.Post("/tasks/parse", previewTask)
+
+	r.Get("/tasks/{id}", getTask)
+	r.Put("/tasks/{id}", updateTaskStatus)
+	r.Delete("/tasks/{id}", removeTask)
+
+	r.Get("/tasks/{id}/reminders", getReminders)
+	r.Post("/tasks/{id}/reminders", insertReminder)
+	r.Delete("/tasks/{id}/reminders/{reminderId}", removeReminder)
+
+	r.Get("/tasks/{id}/attachments", getAttachments)
+	r.Post("/tasks/{id}/attachments", insertAttachment)
+	r.Get("/tasks/{id}/attachments/{attachmentId}", getAttachment)
+	r.Delete("/tasks/{id}/attachments/{attachmentId}", removeAttachment)
+
+	// File routes
+	r.Get("/auth", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "./assets/html/auth.gohtml")
+	})
+
+	r.Get("/tasks.js", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "./assets/js/tasks.js")
+	})
+	r.Get("/task.js", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "./assets/js/task.js")
+	})
+	r.Get("/auth.js", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "./assets/js/auth.js")
+	})
+
+	r.Get("/tasks.css", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "./assets/style/tasks.css")
+	})
+	r.Get("/task.css", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "./assets/style/task.css")
+	})
+	r.Get("/auth.css", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "./assets/style/auth.css")
+	})
+	return r
+}
+
 func auth(r *http.Request) (string, error) {
 	c, err := r.Cookie("id")
 	if err != nil {
@@ -150,31 +202,35 @@ func getAllTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tasks, err := postgres.SelectAllTasks(id)
+	filter, err := filterParam(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+	writeTasksPage(w, r, id, filter, nil)
+}
+
+// Select and render page of tasks matching filter, list is set for pages of smart list
+func writeTasksPage(w http.ResponseWriter, r *http.Request, userId string, filter query.Node, list *models.SmartList) {
+	cursor, limit, err := pageParams(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+	page, err := postgres.SelectTasksPage(userId, filter, cursor, limit)
+	if err == postgres.ErrInvalidCursor {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to get tasks")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	files := []string{"./assets/html/tasks.gohtml"}
-	if len(files) > 0 {
-		name := path.Base(files[0])
-		tmpl, err := template.New(name).ParseFiles(files...)
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to prepare template")
-			w.WriteHeader(500)
-			_, _ = w.Write([]byte(err.Error()))
-			return
-		}
-		w.WriteHeader(200)
-		err = tmpl.Execute(w, tasks)
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to execute template")
-			w.WriteHeader(500)
-			_, _ = w.Write([]byte(err.Error()))
-			return
-		}
-	}
+	renderTasks(w, r, userId, page, list)
 }
 
 func getTask(w http.ResponseWriter, r *http.Request) {
@@ -186,67 +242,74 @@ func getTask(w http.ResponseWriter, r *http.Request) {
 	}
 
 	id := chi.URLParam(r, "id")
+	if _, err = authorizeTask(userId, id, authz.View); err != nil {
+		writeStoreStatus(w, err, "Failed to get task")
+		return
+	}
 	task, err := postgres.SelectTask(userId, id)
-	files := []string{"./assets/html/task.gohtml"}
-	if len(files) > 0 {
-		name := path.Base(files[0])
-		tmpl, err := template.New(name).ParseFiles(files...)
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to prepare template")
-			w.WriteHeader(500)
-			_, _ = w.Write([]byte(err.Error()))
-		}
-		w.WriteHeader(200)
-		err = tmpl.Execute(w, task)
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to execute template")
-			w.WriteHeader(500)
-			_, _ = w.Write([]byte(err.Error()))
-		}
+	if err == postgres.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to get task %v", id)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
+	renderTask(w, r, task)
 }
 
+// Create task typed into tasks page, its value is parsed like in previewTask
 func insertTask(w http.ResponseWriter, r *http.Request) {
-	task := &models.Task{}
-	var err error
-
-	task.Author, err = auth(r)
-	if err != nil || task.Author == "" {
+	userId, err := auth(r)
+	if err != nil || userId == "" {
 		log.Info().Err(err).Msg("Failed to authorize user")
 		http.Redirect(w, r, loginUrl, http.StatusUnauthorized)
 		return
 	}
-	data, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to decode body")
-		w.WriteHeader(http.StatusInternalServerError)
+	q, ok := readQuickTask(w, r)
+	if !ok {
+		return
+	}
+	now, ok := q.now()
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("Unknown time zone"))
 		return
 	}
-	err = json.Unmarshal(data, task)
+	projects, err := projectNames(userId)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to unmarshall body")
+		log.Error().Err(err).Msg("Failed to get projects")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	res, err := postgres.InsertTask(task.Value, task.Author, false)
-	if err != nil {
-		w.WriteHeader(500)
-		_, _ = w.Write([]byte(fmt.Sprint("Failed to insert task")))
+	q.parseInline(now, projects)
+	if msg := q.validate(); msg != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(msg))
+		return
+	}
+	res, msg, err := q.insert(userId)
+	if err == postgres.ErrProjectNotFound {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("Project is not found"))
 		return
 	}
-	response, err := json.Marshal(res)
 	if err != nil {
-		w.WriteHeader(500)
-		_, _ = w.Write([]byte(fmt.Sprint("Failed to insert task")))
+		log.Error().Err(err).Msg(msg)
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(msg))
 		return
 	}
-	_, err = w.Write(response)
-	w.WriteHeader(200)
+	res.Version = publishTaskEvent(events.TaskCreated, res.UUID)
+	response, err := json.Marshal(res)
 	if err != nil {
 		w.WriteHeader(500)
 		_, _ = w.Write([]byte(fmt.Sprint("Failed to insert task")))
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(response)
 }
 
 func removeTask(w http.ResponseWriter, r *http.Request) {
@@ -257,15 +320,45 @@ func removeTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	id := chi.URLParam(r, "id")
+	if _, err = authorizeTask(userId, id, authz.DeleteTasks); err != nil {
+		writeStoreStatus(w, err, "Failed to get task")
+		return
+	}
+	attachments, err := postgres.SelectAttachments(userId, id)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to get attachments of task %v", id)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	deleted, audience := deletedTaskEvent(id)
 	err = postgres.DeleteTask(userId, id)
+	if err == postgres.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err == postgres.ErrForbidden {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		log.Error().Err(err).Msgf("Failed to delete task %v", id)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	removeAttachmentBlobs(id, attachments)
+	publishEvent(deleted, audience)
 	w.WriteHeader(http.StatusOK)
 }
 
+// Attachment rows are removed by cascade with their task, blobs have to be removed explicitly
+func removeAttachmentBlobs(taskId string, attachments []models.Attachment) {
+	for _, attachment := range attachments {
+		if err := blobStore.Delete(blob.AttachmentKey(taskId, attachment.UUID)); err != nil {
+			log.Error().Err(err).Msgf("Failed to delete blob of attachment %v", attachment.UUID)
+		}
+	}
+}
+
 func updateTaskStatus(w http.ResponseWriter, r *http.Request) {
 	userId, err := auth(r)
 	if err != nil || userId == "" {
@@ -274,8 +367,10 @@ func updateTaskStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Only fields present in body are changed, null due date clears it
 	type requestBody struct {
-		IsResolved bool `json:"is_resolved"`
+		IsResolved *bool           `json:"is_resolved"`
+		DueDate    json.RawMessage `json:"due_date"`
 	}
 	request := &requestBody{}
 	data, err := ioutil.ReadAll(r.Body)
@@ -290,13 +385,26 @@ func updateTaskStatus(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	var dueDate *time.Time
+	if len(request.DueDate) > 0 {
+		if err = json.Unmarshal(request.DueDate, &dueDate); err != nil {
+			log.Info().Err(err).Msg("Failed to unmarshal due date")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
 	id := chi.URLParam(r, "id")
-	err = postgres.UpdateTask(id, userId, request.IsResolved)
+	if _, err = authorizeTask(userId, id, authz.EditTasks); err != nil {
+		writeStoreStatus(w, err, "Failed to get task")
+		return
+	}
+	change := postgres.TaskChange{IsResolved: request.IsResolved, DueDate: dueDate, SetDueDate: len(request.DueDate) > 0}
+	version, projectUUID, err := postgres.UpdateTaskFields(id, userId, change)
 	if err != nil {
-		w.WriteHeader(500)
-		_, _ = w.Write([]byte(fmt.Sprint("Failed to update task")))
+		writeStoreStatus(w, err, "Failed to update task")
 		return
 	}
+	publishTaskVersion(events.TaskUpdated, id, version, projectUUID)
 	w.WriteHeader(200)
 }
 
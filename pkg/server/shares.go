@@ -0,0 +1,166 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Kolya59/todo-service/models"
+	"github.com/Kolya59/todo-service/pkg/authz"
+	"github.com/Kolya59/todo-service/pkg/postgres"
+)
+
+// Authorize action of user on task like authorizeWorkspace, owner of the task is returned. Task the user does not see
+// is not found and access not allowing the action is forbidden. Store queries check the same policy again.
+func authorizeTask(userId string, taskId string, action authz.Action) (string, error) {
+	owner, access, err := postgres.SelectTaskAccess(userId, taskId)
+	if err != nil {
+		return "", err
+	}
+	if !authz.CanTask(access, authz.View) {
+		return "", postgres.ErrNotFound
+	}
+	if !authz.CanTask(access, action) {
+		return "", postgres.ErrForbidden
+	}
+	return owner, nil
+}
+
+// Write store error of legacy handlers, they answer with status only
+func writeStoreStatus(w http.ResponseWriter, err error, message string) {
+	switch err {
+	case postgres.ErrNotFound:
+		w.WriteHeader(http.StatusNotFound)
+	case postgres.ErrForbidden:
+		w.WriteHeader(http.StatusForbidden)
+	default:
+		log.Error().Err(err).Msg(message)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+type shareRequest struct {
+	Login string `json:"login"`
+	Role  string `json:"role"`
+}
+
+func validateShare(request shareRequest) string {
+	switch {
+	case request.Login == "":
+		return "Login is required"
+	case request.Role != models.RoleViewer && request.Role != models.RoleEditor:
+		return "Role must be viewer or editor"
+	default:
+		return ""
+	}
+}
+
+// Write error of sharing, unknown login and owner login are client errors
+func writeShareError(w http.ResponseWriter, err error) {
+	switch err {
+	case postgres.ErrUserNotFound:
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "User is not found")
+	case postgres.ErrShareWithOwner:
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Item can not be shared with its owner")
+	default:
+		writeStoreError(w, err, "Failed to share")
+	}
+}
+
+func writeShares(w http.ResponseWriter, shares []models.Share, err error) {
+	if err != nil {
+		writeStoreError(w, err, "Failed to get shares")
+		return
+	}
+	if shares == nil {
+		shares = make([]models.Share, 0)
+	}
+	writeJSON(w, http.StatusOK, shares)
+}
+
+func apiGetTaskShares(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	id, ok := uuidParam(w, r, "id")
+	if !ok {
+		return
+	}
+	shares, err := postgres.SelectTaskShares(userId, id)
+	writeShares(w, shares, err)
+}
+
+func apiGetProjectShares(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	id, ok := uuidParam(w, r, "id")
+	if !ok {
+		return
+	}
+	shares, err := postgres.SelectProjectShares(userId, id)
+	writeShares(w, shares, err)
+}
+
+func apiGetReceivedShares(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	shares, err := postgres.SelectReceivedShares(userId)
+	writeShares(w, shares, err)
+}
+
+func apiShareTask(w http.ResponseWriter, r *http.Request) {
+	apiShare(w, r, postgres.ShareTask)
+}
+
+func apiShareProject(w http.ResponseWriter, r *http.Request) {
+	apiShare(w, r, postgres.ShareProject)
+}
+
+// Share item given by id parameter, only its owner may share it
+func apiShare(w http.ResponseWriter, r *http.Request, share func(string, string, string, string) (models.Share, error)) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	request := shareRequest{}
+	if !readJSON(w, r, &request) {
+		return
+	}
+	request.Login = strings.TrimSpace(request.Login)
+	if msg := validateShare(request); msg != "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, msg)
+		return
+	}
+	id, ok := uuidParam(w, r, "id")
+	if !ok {
+		return
+	}
+	res, err := share(userId, id, request.Login, request.Role)
+	if err != nil {
+		writeShareError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, res)
+}
+
+func apiRemoveShare(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	id, ok := uuidParam(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := postgres.DeleteShare(userId, id); err != nil {
+		writeStoreError(w, err, "Failed to delete share")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
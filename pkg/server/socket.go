@@ -0,0 +1,329 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/Kolya59/todo-service/models"
+	"github.com/Kolya59/todo-service/pkg/events"
+	"github.com/Kolya59/todo-service/pkg/postgres"
+)
+
+// Types of socket messages sent by clients
+const (
+	socketSubscribe   = "subscribe"
+	socketUnsubscribe = "unsubscribe"
+	socketCreate      = "create"
+	socketUpdate      = "update"
+	socketResolve     = "resolve"
+)
+
+// Types of socket messages sent by server
+const (
+	socketAck   = "ack"
+	socketError = "error"
+	socketEvent = "event"
+)
+
+const (
+	// Time to write a message before the connection is considered broken
+	socketWriteWait = 10 * time.Second
+	// Client has to answer pings within this time
+	socketPongWait     = 60 * time.Second
+	socketPingInterval = socketPongWait * 9 / 10
+	// Replies waiting for the writer, reader blocks when client does not read them
+	socketSendBuffer = 16
+	// Maximal number of subscribed tasks and projects of one connection
+	maxSocketSubscriptions = 1000
+)
+
+// Browsers are limited to the origin of the UI by default check, desktop clients send no Origin
+var upgrader = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 1024}
+
+// Message of client, id is echoed in the reply. Task holds NewTask for create and TaskPatch for update.
+// Update and resolve with version are rejected when the task has another version.
+type socketRequest struct {
+	ID           string          `json:"id"`
+	Type         string          `json:"type"`
+	TaskUUIDs    []string        `json:"task_uuids,omitempty"`
+	ProjectUUIDs []string        `json:"project_uuids,omitempty"`
+	TaskUUID     string          `json:"task_uuid,omitempty"`
+	Version      *int64          `json:"version,omitempty"`
+	Task         json.RawMessage `json:"task,omitempty"`
+}
+
+// Message of server, ack and error answer request with the same id, event is sent for subscriptions
+type socketReply struct {
+	Type    string        `json:"type"`
+	ID      string        `json:"id,omitempty"`
+	Task    *models.Task  `json:"task,omitempty"`
+	Event   *events.Event `json:"event,omitempty"`
+	Code    string        `json:"code,omitempty"`
+	Message string        `json:"message,omitempty"`
+}
+
+func socketErrorReply(id string, code string, message string) socketReply {
+	return socketReply{Type: socketError, ID: id, Code: code, Message: message}
+}
+
+// Error reply of the store layer, errors are mapped like in JSON API
+func socketStoreError(id string, err error, message string) socketReply {
+	switch err {
+	case postgres.ErrNotFound:
+		return socketErrorReply(id, codeNotFound, "Not found")
+	case postgres.ErrForbidden:
+		return socketErrorReply(id, codeForbidden, "Role does not allow the change")
+	case postgres.ErrProjectNotFound:
+		return socketErrorReply(id, codeInvalidRequest, "Project is not found")
+	}
+	log.Error().Err(err).Msg(message)
+	return socketErrorReply(id, codeInternal, message)
+}
+
+// Connection of one client, reader handles requests and writer sends replies and events
+type socketClient struct {
+	conn   *websocket.Conn
+	userId string
+	send   chan socketReply
+	// Closed when writer stops
+	closed chan struct{}
+
+	mu       sync.Mutex
+	tasks    map[string]bool
+	projects map[string]bool
+}
+
+// Serve WebSocket of collaborative clients until client disconnects or server shuts down
+func serveSocket(w http.ResponseWriter, r *http.Request) {
+	userId, err := auth(r)
+	if err != nil || userId == "" {
+		log.Info().Err(err).Msg("Failed to authorize user")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	// Upgrader writes error response itself
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Info().Err(err).Msg("Failed to upgrade connection")
+		return
+	}
+	stream, cancel := hub.Subscribe(userId)
+	defer cancel()
+
+	c := &socketClient{
+		conn:     conn,
+		userId:   userId,
+		send:     make(chan socketReply, socketSendBuffer),
+		closed:   make(chan struct{}),
+		tasks:    make(map[string]bool),
+		projects: make(map[string]bool),
+	}
+	done := make(chan struct{})
+	go func() {
+		c.write(stream, done)
+		close(c.closed)
+	}()
+	c.read()
+	close(done)
+	<-c.closed
+}
+
+func (c *socketClient) read() {
+	c.conn.SetReadLimit(maxRequestSize)
+	_ = c.conn.SetReadDeadline(time.Now().Add(socketPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(socketPongWait))
+	})
+	for {
+		_, reader, err := c.conn.NextReader()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Info().Err(err).Msg("Socket is closed unexpectedly")
+			}
+			return
+		}
+		request := socketRequest{}
+		decoder := json.NewDecoder(reader)
+		decoder.DisallowUnknownFields()
+		if err = decoder.Decode(&request); err != nil {
+			c.reply(socketErrorReply("", codeInvalidRequest, "Invalid JSON message: "+err.Error()))
+			continue
+		}
+		c.reply(c.handle(request))
+	}
+}
+
+// Queue reply for the writer, it is dropped when the writer has stopped
+func (c *socketClient) reply(reply socketReply) {
+	select {
+	case c.send <- reply:
+	case <-c.closed:
+	}
+}
+
+func (c *socketClient) write(stream <-chan events.Event, done <-chan struct{}) {
+	ping := time.NewTicker(socketPingInterval)
+	defer func() {
+		ping.Stop()
+		if err := c.conn.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close socket")
+		}
+	}()
+	for {
+		var err error
+		select {
+		case <-done:
+			return
+		case e, ok := <-stream:
+			if !ok {
+				// Hub is closed when server shuts down
+				_ = c.conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+				_ = c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server is shutting down"))
+				return
+			}
+			if !c.subscribed(e) {
+				continue
+			}
+			_ = c.conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+			err = c.conn.WriteJSON(socketReply{Type: socketEvent, Event: &e})
+		case reply := <-c.send:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+			err = c.conn.WriteJSON(reply)
+		case <-ping.C:
+			err = c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(socketWriteWait))
+		}
+		if err != nil {
+			log.Info().Err(err).Msg("Failed to write to socket")
+			return
+		}
+	}
+}
+
+func (c *socketClient) subscribed(e events.Event) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tasks[e.TaskUUID] || e.ProjectUUID != nil && c.projects[*e.ProjectUUID]
+}
+
+func (c *socketClient) handle(request socketRequest) socketReply {
+	switch request.Type {
+	case socketSubscribe, socketUnsubscribe:
+		return c.subscribe(request)
+	case socketCreate:
+		return c.create(request)
+	case socketUpdate:
+		patch := taskPatch{}
+		if msg := decodeSocketTask(request.Task, &patch); msg != "" {
+			return socketErrorReply(request.ID, codeInvalidRequest, msg)
+		}
+		return c.update(request, patch)
+	case socketResolve:
+		resolved := true
+		return c.update(request, taskPatch{IsResolved: &resolved})
+	default:
+		return socketErrorReply(request.ID, codeInvalidRequest, fmt.Sprintf("Unknown type %q", request.Type))
+	}
+}
+
+// Decode task of request as strictly as bodies of JSON API, message describes the problem
+func decodeSocketTask(raw json.RawMessage, v interface{}) string {
+	if len(raw) == 0 {
+		return "Task is required"
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return "Invalid task: " + err.Error()
+	}
+	return ""
+}
+
+// Change subscriptions, events are delivered only for tasks the user sees
+func (c *socketClient) subscribe(request socketRequest) socketReply {
+	for _, ids := range [][]string{request.TaskUUIDs, request.ProjectUUIDs} {
+		for _, id := range ids {
+			if _, err := uuid.FromString(id); err != nil {
+				return socketErrorReply(request.ID, codeInvalidRequest, "Subscriptions must be uuids")
+			}
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if request.Type == socketUnsubscribe {
+		for _, id := range request.TaskUUIDs {
+			delete(c.tasks, id)
+		}
+		for _, id := range request.ProjectUUIDs {
+			delete(c.projects, id)
+		}
+		return socketReply{Type: socketAck, ID: request.ID}
+	}
+	if len(c.tasks)+len(c.projects)+len(request.TaskUUIDs)+len(request.ProjectUUIDs) > maxSocketSubscriptions {
+		return socketErrorReply(request.ID, codeInvalidRequest, fmt.Sprintf("At most %d subscriptions are allowed", maxSocketSubscriptions))
+	}
+	for _, id := range request.TaskUUIDs {
+		c.tasks[id] = true
+	}
+	for _, id := range request.ProjectUUIDs {
+		c.projects[id] = true
+	}
+	return socketReply{Type: socketAck, ID: request.ID}
+}
+
+// Create task, the connection is subscribed to it
+func (c *socketClient) create(request socketRequest) socketReply {
+	t := newTask{}
+	if msg := decodeSocketTask(request.Task, &t); msg != "" {
+		return socketErrorReply(request.ID, codeInvalidRequest, msg)
+	}
+	if msg := t.validate(); msg != "" {
+		return socketErrorReply(request.ID, codeInvalidRequest, msg)
+	}
+	task, msg, err := t.insert(c.userId)
+	if err != nil {
+		return socketStoreError(request.ID, err, msg)
+	}
+	c.mu.Lock()
+	c.tasks[task.UUID] = true
+	c.mu.Unlock()
+	task.Version = publishTaskEvent(events.TaskCreated, task.UUID)
+	return socketReply{Type: socketAck, ID: request.ID, Task: &task}
+}
+
+// Apply patch to task, conflicting version is answered with the current task.
+// Version is checked and bumped in the transaction of the change, so only one of concurrent patches wins.
+func (c *socketClient) update(request socketRequest, patch taskPatch) socketReply {
+	if _, err := uuid.FromString(request.TaskUUID); err != nil {
+		return socketErrorReply(request.ID, codeNotFound, "Not found")
+	}
+	if msg := patch.validate(); msg != "" {
+		return socketErrorReply(request.ID, codeInvalidRequest, msg)
+	}
+	msg, err := patch.apply(c.userId, request.TaskUUID, request.Version)
+	if err == postgres.ErrVersionConflict {
+		task, err := postgres.SelectTask(c.userId, request.TaskUUID)
+		if err != nil {
+			return socketStoreError(request.ID, err, "Failed to get task")
+		}
+		reply := socketErrorReply(request.ID, codeConflict, fmt.Sprintf("Task has version %d", task.Version))
+		reply.Task = &task
+		return reply
+	}
+	if err != nil {
+		return socketStoreError(request.ID, err, msg)
+	}
+
+	task, err := postgres.SelectTask(c.userId, request.TaskUUID)
+	if err != nil {
+		return socketStoreError(request.ID, err, "Failed to get task")
+	}
+	return socketReply{Type: socketAck, ID: request.ID, Task: &task}
+}
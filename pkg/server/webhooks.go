@@ -0,0 +1,243 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Kolya59/todo-service/models"
+	"github.com/Kolya59/todo-service/pkg/events"
+	"github.com/Kolya59/todo-service/pkg/postgres"
+	"github.com/Kolya59/todo-service/pkg/scheduler"
+)
+
+// Number of latest deliveries shown in the delivery log
+const deliveryLogSize = 100
+
+// Events webhooks subscribe to
+var webhookEvents = []string{events.TaskCreated, events.TaskUpdated, events.TaskDeleted, events.CommentCreated, events.CommentDeleted}
+
+// Body of webhook delivery, task is set as the owner of webhook sees it unless it is deleted
+type webhookPayload struct {
+	Event     events.Event `json:"event"`
+	Task      *models.Task `json:"task,omitempty"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// Queue deliveries of event to webhooks of users, errors are logged since events are best effort
+func enqueueWebhooks(e events.Event, users []string) {
+	if len(users) == 0 {
+		return
+	}
+	hooks, err := postgres.SelectEventWebhooks(users, e.Type)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to get webhooks of event %v", e.ID)
+		return
+	}
+	// Payload differs by owner of webhook only
+	payloads := map[string]string{}
+	for _, hook := range hooks {
+		payload, ok := payloads[hook.UserUUID]
+		if !ok {
+			p := webhookPayload{Event: e, CreatedAt: time.Now().UTC()}
+			if e.Type != events.TaskDeleted {
+				task, err := postgres.SelectTask(hook.UserUUID, e.TaskUUID)
+				if err != nil {
+					log.Error().Err(err).Msgf("Failed to get task %v for webhook %v", e.TaskUUID, hook.UUID)
+					continue
+				}
+				p.Task = &task
+			}
+			data, err := json.Marshal(p)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to marshal webhook payload")
+				continue
+			}
+			payload = string(data)
+			payloads[hook.UserUUID] = payload
+		}
+		if err = postgres.InsertWebhookDelivery(hook, e.Type, payload); err != nil {
+			log.Error().Err(err).Msgf("Failed to queue event %v for webhook %v", e.ID, hook.UUID)
+		}
+	}
+}
+
+// Check webhook and remove duplicate events, message describes the first problem.
+// Host is resolved, webhooks of internal addresses are refused.
+func validateWebhook(rawURL string, eventTypes []string) ([]string, string) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, "Url must be absolute http or https url"
+	}
+	if len(eventTypes) == 0 {
+		return nil, "At least one event is required"
+	}
+	known := map[string]bool{}
+	for _, eventType := range webhookEvents {
+		known[eventType] = true
+	}
+	seen := map[string]bool{}
+	var result []string
+	for _, eventType := range eventTypes {
+		if !known[eventType] {
+			return nil, fmt.Sprintf("Unknown event %q", eventType)
+		}
+		if !seen[eventType] {
+			seen[eventType] = true
+			result = append(result, eventType)
+		}
+	}
+	// Resolved last, as it is the slowest check
+	if err = scheduler.CheckWebhookURL(rawURL); err != nil {
+		return nil, "Url host must resolve to public address"
+	}
+	return result, ""
+}
+
+// Random hex secret of webhooks and capture tokens
+func newSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+func apiGetWebhooks(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	hooks, err := postgres.SelectWebhooks(userId)
+	if err != nil {
+		writeStoreError(w, err, "Failed to get webhooks")
+		return
+	}
+	if hooks == nil {
+		hooks = make([]models.Webhook, 0)
+	}
+	writeJSON(w, http.StatusOK, hooks)
+}
+
+// Register webhook, its secret is returned only in this response
+func apiInsertWebhook(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	request := struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}{}
+	if !readJSON(w, r, &request) {
+		return
+	}
+	eventTypes, msg := validateWebhook(request.URL, request.Events)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, msg)
+		return
+	}
+	secret, err := newSecret()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to generate webhook secret")
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to generate secret")
+		return
+	}
+	hook, err := postgres.InsertWebhook(userId, request.URL, eventTypes, secret)
+	if err != nil {
+		writeStoreError(w, err, "Failed to insert webhook")
+		return
+	}
+	w.Header().Set("Location", "/api/v1/webhooks/"+hook.UUID)
+	writeJSON(w, http.StatusCreated, hook)
+}
+
+func apiRemoveWebhook(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	id, ok := uuidParam(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := postgres.DeleteWebhook(userId, id); err != nil {
+		writeStoreError(w, err, "Failed to delete webhook")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Latest deliveries of webhook without payloads
+func apiGetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	id, ok := uuidParam(w, r, "id")
+	if !ok {
+		return
+	}
+	// Unknown webhook is 404 rather than an empty log
+	if _, err := postgres.SelectWebhook(userId, id); err != nil {
+		writeStoreError(w, err, "Failed to get webhook")
+		return
+	}
+	deliveries, err := postgres.SelectWebhookDeliveries(userId, id, deliveryLogSize)
+	if err != nil {
+		writeStoreError(w, err, "Failed to get deliveries")
+		return
+	}
+	if deliveries == nil {
+		deliveries = make([]models.WebhookDelivery, 0)
+	}
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+func apiGetWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	id, ok := uuidParam(w, r, "id")
+	if !ok {
+		return
+	}
+	deliveryId, ok := uuidParam(w, r, "deliveryId")
+	if !ok {
+		return
+	}
+	delivery, err := postgres.SelectWebhookDelivery(userId, id, deliveryId)
+	if err != nil {
+		writeStoreError(w, err, "Failed to get delivery")
+		return
+	}
+	writeJSON(w, http.StatusOK, delivery)
+}
+
+// Send delivery again with the payload it was created with
+func apiRedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	id, ok := uuidParam(w, r, "id")
+	if !ok {
+		return
+	}
+	deliveryId, ok := uuidParam(w, r, "deliveryId")
+	if !ok {
+		return
+	}
+	if err := postgres.RedeliverWebhookDelivery(userId, id, deliveryId); err != nil {
+		writeStoreError(w, err, "Failed to redeliver")
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
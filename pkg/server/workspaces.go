@@ -0,0 +1,357 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Kolya59/todo-service/models"
+	"github.com/Kolya59/todo-service/pkg/authz"
+	"github.com/Kolya59/todo-service/pkg/postgres"
+)
+
+// Maximal length of workspace name
+const maxWorkspaceNameLength = 200
+
+func validateWorkspaceName(name string) string {
+	switch {
+	case name == "":
+		return "Name is required"
+	case len([]rune(name)) > maxWorkspaceNameLength:
+		return "Name is too long"
+	default:
+		return ""
+	}
+}
+
+// Authorize action of user in workspace, role of the user is returned. Workspace of other users is not found
+// and role not allowing the action is forbidden, error response is written in both cases.
+func authorizeWorkspace(w http.ResponseWriter, userId string, workspaceId string, action authz.Action) (string, bool) {
+	role, err := postgres.SelectWorkspaceRole(userId, workspaceId)
+	if err != nil {
+		writeStoreError(w, err, "Failed to get workspace")
+		return "", false
+	}
+	if !authz.Can(role, action) {
+		writeError(w, http.StatusForbidden, codeForbidden, "Role does not allow the action")
+		return "", false
+	}
+	return role, true
+}
+
+// Authorize action of user in workspace given by id parameter
+func authorizeWorkspaceParam(w http.ResponseWriter, r *http.Request, userId string, action authz.Action) (string, string, bool) {
+	workspaceId, ok := uuidParam(w, r, "id")
+	if !ok {
+		return "", "", false
+	}
+	role, ok := authorizeWorkspace(w, userId, workspaceId, action)
+	return workspaceId, role, ok
+}
+
+func writeMemberError(w http.ResponseWriter, err error) {
+	switch err {
+	case postgres.ErrLastOwner:
+		writeError(w, http.StatusConflict, codeInvalidRequest, "Workspace must keep an owner")
+	case postgres.ErrUserNotFound:
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "User is not found")
+	case postgres.ErrAlreadyMember:
+		writeError(w, http.StatusConflict, codeInvalidRequest, "User is already a member")
+	default:
+		writeStoreError(w, err, "Failed to change members")
+	}
+}
+
+func apiGetWorkspaces(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	workspaces, err := postgres.SelectWorkspaces(userId)
+	if err != nil {
+		writeStoreError(w, err, "Failed to get workspaces")
+		return
+	}
+	if workspaces == nil {
+		workspaces = make([]models.Workspace, 0)
+	}
+	writeJSON(w, http.StatusOK, workspaces)
+}
+
+func apiInsertWorkspace(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	request := struct {
+		Name string `json:"name"`
+	}{}
+	if !readJSON(w, r, &request) {
+		return
+	}
+	name := strings.TrimSpace(request.Name)
+	if msg := validateWorkspaceName(name); msg != "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, msg)
+		return
+	}
+	workspace, err := postgres.InsertWorkspace(userId, name)
+	if err != nil {
+		writeStoreError(w, err, "Failed to insert workspace")
+		return
+	}
+	writeJSON(w, http.StatusCreated, workspace)
+}
+
+func apiGetWorkspace(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	id, _, ok := authorizeWorkspaceParam(w, r, userId, authz.View)
+	if !ok {
+		return
+	}
+	workspace, err := postgres.SelectWorkspace(userId, id)
+	if err != nil {
+		writeStoreError(w, err, "Failed to get workspace")
+		return
+	}
+	writeJSON(w, http.StatusOK, workspace)
+}
+
+func apiRenameWorkspace(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	request := struct {
+		Name string `json:"name"`
+	}{}
+	if !readJSON(w, r, &request) {
+		return
+	}
+	name := strings.TrimSpace(request.Name)
+	if msg := validateWorkspaceName(name); msg != "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, msg)
+		return
+	}
+	id, _, ok := authorizeWorkspaceParam(w, r, userId, authz.Rename)
+	if !ok {
+		return
+	}
+	if err := postgres.RenameWorkspace(id, name); err != nil {
+		writeStoreError(w, err, "Failed to rename workspace")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiRemoveWorkspace(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	id, _, ok := authorizeWorkspaceParam(w, r, userId, authz.Delete)
+	if !ok {
+		return
+	}
+	if err := postgres.DeleteWorkspace(id); err != nil {
+		writeStoreError(w, err, "Failed to delete workspace")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiGetMembers(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	id, _, ok := authorizeWorkspaceParam(w, r, userId, authz.View)
+	if !ok {
+		return
+	}
+	members, err := postgres.SelectMembers(id)
+	if err != nil {
+		writeStoreError(w, err, "Failed to get members")
+		return
+	}
+	if members == nil {
+		members = make([]models.Member, 0)
+	}
+	writeJSON(w, http.StatusOK, members)
+}
+
+func apiUpdateMember(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	request := struct {
+		Role string `json:"role"`
+	}{}
+	if !readJSON(w, r, &request) {
+		return
+	}
+	if !authz.IsRole(request.Role) {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Role must be owner, admin, member or guest")
+		return
+	}
+	id, role, ok := authorizeWorkspaceParam(w, r, userId, authz.ManageMembers)
+	if !ok {
+		return
+	}
+	memberId, ok := uuidParam(w, r, "userId")
+	if !ok {
+		return
+	}
+	current, err := postgres.SelectWorkspaceRole(memberId, id)
+	if err != nil {
+		writeStoreError(w, err, "Failed to get member")
+		return
+	}
+	if !authz.CanAssign(role, current, request.Role) {
+		writeError(w, http.StatusForbidden, codeForbidden, "Only owners grant ownership and change other owners")
+		return
+	}
+	if err = postgres.UpdateMemberRole(id, memberId, request.Role); err != nil {
+		writeMemberError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Remove member, any member may leave the workspace
+func apiRemoveMember(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	id, role, ok := authorizeWorkspaceParam(w, r, userId, authz.View)
+	if !ok {
+		return
+	}
+	memberId, ok := uuidParam(w, r, "userId")
+	if !ok {
+		return
+	}
+	if memberId != userId {
+		current, err := postgres.SelectWorkspaceRole(memberId, id)
+		if err != nil {
+			writeStoreError(w, err, "Failed to get member")
+			return
+		}
+		if !authz.CanAssign(role, current, "") {
+			writeError(w, http.StatusForbidden, codeForbidden, "Role does not allow the action")
+			return
+		}
+	}
+	if err := postgres.DeleteMember(id, memberId); err != nil {
+		writeMemberError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiGetWorkspaceInvitations(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	id, _, ok := authorizeWorkspaceParam(w, r, userId, authz.ManageMembers)
+	if !ok {
+		return
+	}
+	invitations, err := postgres.SelectWorkspaceInvitations(id)
+	writeInvitations(w, invitations, err)
+}
+
+func apiInvite(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	request := struct {
+		Login string `json:"login"`
+		Role  string `json:"role"`
+	}{}
+	if !readJSON(w, r, &request) {
+		return
+	}
+	request.Login = strings.TrimSpace(request.Login)
+	if request.Login == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Login is required")
+		return
+	}
+	if !authz.IsRole(request.Role) {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Role must be owner, admin, member or guest")
+		return
+	}
+	id, role, ok := authorizeWorkspaceParam(w, r, userId, authz.ManageMembers)
+	if !ok {
+		return
+	}
+	if !authz.CanAssign(role, "", request.Role) {
+		writeError(w, http.StatusForbidden, codeForbidden, "Only owners grant ownership and change other owners")
+		return
+	}
+	invitation, err := postgres.InsertInvitation(id, userId, request.Login, request.Role)
+	if err != nil {
+		writeMemberError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, invitation)
+}
+
+func writeInvitations(w http.ResponseWriter, invitations []models.Invitation, err error) {
+	if err != nil {
+		writeStoreError(w, err, "Failed to get invitations")
+		return
+	}
+	if invitations == nil {
+		invitations = make([]models.Invitation, 0)
+	}
+	writeJSON(w, http.StatusOK, invitations)
+}
+
+func apiGetInvitations(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	invitations, err := postgres.SelectReceivedInvitations(userId)
+	writeInvitations(w, invitations, err)
+}
+
+func apiAcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	id, ok := uuidParam(w, r, "id")
+	if !ok {
+		return
+	}
+	workspace, err := postgres.AcceptInvitation(userId, id)
+	if err != nil {
+		writeStoreError(w, err, "Failed to accept invitation")
+		return
+	}
+	writeJSON(w, http.StatusOK, workspace)
+}
+
+// Decline invitation as invited user or revoke it as member managing members
+func apiRemoveInvitation(w http.ResponseWriter, r *http.Request) {
+	userId, ok := apiAuth(w, r)
+	if !ok {
+		return
+	}
+	id, ok := uuidParam(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := postgres.DeleteInvitation(userId, id); err != nil {
+		writeStoreError(w, err, "Failed to delete invitation")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -33,3 +33,442 @@ alter table tasks
 create unique index tasks_uuid_uindex
     on tasks (uuid);
 
+
+alter table tasks
+    add due_date timestamptz;
+
+create table reminders
+(
+    uuid           uuid    not null
+        constraint reminders_pk
+            primary key,
+    task_uuid      uuid    not null
+        constraint reminders_tasks_uuid_fk
+            references tasks
+            on delete cascade,
+    user_uuid      uuid    not null,
+    remind_at      timestamptz,
+    offset_seconds bigint,
+    claimed_until  timestamptz,
+    sent_at        timestamptz,
+    failed_at      timestamptz,
+    attempts       integer not null default 0,
+    constraint reminders_time_check
+        check ((remind_at is null) <> (offset_seconds is null))
+);
+
+alter table reminders
+    owner to kolya59;
+
+create index reminders_pending_index
+    on reminders (task_uuid)
+    where sent_at is null and failed_at is null;
+
+create table attachments
+(
+    uuid         uuid        not null
+        constraint attachments_pk
+            primary key,
+    task_uuid    uuid        not null
+        constraint attachments_tasks_uuid_fk
+            references tasks
+            on delete cascade,
+    name         text        not null,
+    content_type text        not null,
+    size         bigint      not null,
+    created_at   timestamptz not null
+);
+
+alter table attachments
+    owner to kolya59;
+
+create index attachments_task_uuid_index
+    on attachments (task_uuid);
+
+alter table users
+    add data_key bytea;
+
+alter table attachments
+    add encrypted boolean not null default false;
+
+alter table users
+    add data_key_id text;
+
+create table comments
+(
+    uuid        uuid        not null
+        constraint comments_pk
+            primary key,
+    task_uuid   uuid        not null
+        constraint comments_tasks_uuid_fk
+            references tasks
+            on delete cascade,
+    author_uuid uuid        not null,
+    value       text        not null,
+    created_at  timestamptz not null
+);
+
+alter table comments
+    owner to kolya59;
+
+create index comments_task_uuid_index
+    on comments (task_uuid);
+
+alter table tasks
+    add created_at timestamptz not null default now();
+
+create index tasks_author_uuid_created_at_index
+    on tasks (author_uuid, created_at desc, uuid desc);
+
+create table task_labels
+(
+    task_uuid uuid not null
+        constraint task_labels_tasks_uuid_fk
+            references tasks
+            on delete cascade,
+    label     text not null,
+    constraint task_labels_pk
+        primary key (task_uuid, label)
+);
+
+alter table task_labels
+    owner to kolya59;
+
+create index task_labels_label_index
+    on task_labels (label);
+
+alter table tasks
+    add search_vector tsvector;
+
+alter table comments
+    add search_vector tsvector;
+
+create index tasks_search_vector_index
+    on tasks using gin (search_vector);
+
+create index comments_search_vector_index
+    on comments using gin (search_vector);
+
+update tasks
+set search_vector = to_tsvector('english', coalesce(value, ''))
+where value not like 'enc:v1:%';
+
+update comments
+set search_vector = to_tsvector('english', value)
+where value not like 'enc:v1:%';
+
+create table smart_lists
+(
+    uuid       uuid        not null
+        constraint smart_lists_pk
+            primary key,
+    user_uuid  uuid        not null,
+    name       text        not null,
+    query      text        not null,
+    pinned     boolean     not null default false,
+    created_at timestamptz not null default now()
+);
+
+alter table smart_lists
+    owner to kolya59;
+
+create index smart_lists_user_index
+    on smart_lists (user_uuid, pinned desc, created_at);
+
+create table projects
+(
+    uuid       uuid        not null
+        constraint projects_pk
+            primary key,
+    owner_uuid uuid        not null,
+    name       text        not null,
+    created_at timestamptz not null default now()
+);
+
+alter table projects
+    owner to kolya59;
+
+create index projects_owner_index
+    on projects (owner_uuid, created_at);
+
+alter table tasks
+    add project_uuid uuid
+        constraint tasks_projects_uuid_fk
+            references projects
+            on delete set null;
+
+create index tasks_project_index
+    on tasks (project_uuid);
+
+create table shares
+(
+    uuid         uuid        not null
+        constraint shares_pk
+            primary key,
+    task_uuid    uuid
+        constraint shares_tasks_uuid_fk
+            references tasks
+            on delete cascade,
+    project_uuid uuid
+        constraint shares_projects_uuid_fk
+            references projects
+            on delete cascade,
+    user_uuid    uuid        not null,
+    role         text        not null
+        constraint shares_role_check
+            check (role in ('viewer', 'editor')),
+    created_at   timestamptz not null default now(),
+    constraint shares_item_check
+        check ((task_uuid is null) <> (project_uuid is null))
+);
+
+alter table shares
+    owner to kolya59;
+
+create unique index shares_task_user_uindex
+    on shares (task_uuid, user_uuid)
+    where task_uuid is not null;
+
+create unique index shares_project_user_uindex
+    on shares (project_uuid, user_uuid)
+    where project_uuid is not null;
+
+create index shares_user_index
+    on shares (user_uuid);
+
+create table workspaces
+(
+    uuid       uuid        not null
+        constraint workspaces_pk
+            primary key,
+    name       text        not null,
+    created_at timestamptz not null default now()
+);
+
+alter table workspaces
+    owner to kolya59;
+
+create table workspace_members
+(
+    workspace_uuid uuid        not null
+        constraint workspace_members_workspaces_uuid_fk
+            references workspaces
+            on delete cascade,
+    user_uuid      uuid        not null,
+    role           text        not null
+        constraint workspace_members_role_check
+            check (role in ('owner', 'admin', 'member', 'guest')),
+    created_at     timestamptz not null default now(),
+    constraint workspace_members_pk
+        primary key (workspace_uuid, user_uuid)
+);
+
+alter table workspace_members
+    owner to kolya59;
+
+create index workspace_members_user_index
+    on workspace_members (user_uuid);
+
+create table workspace_invitations
+(
+    uuid           uuid        not null
+        constraint workspace_invitations_pk
+            primary key,
+    workspace_uuid uuid        not null
+        constraint workspace_invitations_workspaces_uuid_fk
+            references workspaces
+            on delete cascade,
+    user_uuid      uuid        not null,
+    inviter_uuid   uuid        not null,
+    role           text        not null
+        constraint workspace_invitations_role_check
+            check (role in ('owner', 'admin', 'member', 'guest')),
+    created_at     timestamptz not null default now(),
+    constraint workspace_invitations_user_uindex
+        unique (workspace_uuid, user_uuid)
+);
+
+alter table workspace_invitations
+    owner to kolya59;
+
+create index workspace_invitations_user_index
+    on workspace_invitations (user_uuid);
+
+alter table projects
+    add workspace_uuid uuid
+        constraint projects_workspaces_uuid_fk
+            references workspaces
+            on delete cascade;
+
+create index projects_workspace_index
+    on projects (workspace_uuid);
+
+create table task_assignees
+(
+    task_uuid   uuid        not null
+        constraint task_assignees_tasks_uuid_fk
+            references tasks
+            on delete cascade,
+    user_uuid   uuid        not null,
+    assigned_at timestamptz not null default now(),
+    constraint task_assignees_pk
+        primary key (task_uuid, user_uuid)
+);
+
+alter table task_assignees
+    owner to kolya59;
+
+create index task_assignees_user_index
+    on task_assignees (user_uuid);
+
+create table comment_mentions
+(
+    comment_uuid uuid not null
+        constraint comment_mentions_comments_uuid_fk
+            references comments
+            on delete cascade,
+    user_uuid    uuid not null,
+    constraint comment_mentions_pk
+        primary key (comment_uuid, user_uuid)
+);
+
+alter table comment_mentions
+    owner to kolya59;
+
+create table notifications
+(
+    uuid          uuid        not null
+        constraint notifications_pk
+            primary key,
+    user_uuid     uuid        not null,
+    kind          text        not null,
+    actor_uuid    uuid,
+    task_uuid     uuid
+        constraint notifications_tasks_uuid_fk
+            references tasks
+            on delete cascade,
+    comment_uuid  uuid
+        constraint notifications_comments_uuid_fk
+            references comments
+            on delete cascade,
+    created_at    timestamptz not null default now(),
+    claimed_until timestamptz,
+    delivered_at  timestamptz,
+    attempts      integer     not null default 0
+);
+
+alter table notifications
+    owner to kolya59;
+
+create index notifications_user_index
+    on notifications (user_uuid, created_at);
+
+create index notifications_pending_index
+    on notifications (created_at)
+    where delivered_at is null;
+
+alter table notifications
+    add read_at timestamptz;
+
+alter table notifications
+    add project_uuid uuid
+        constraint notifications_projects_uuid_fk
+            references projects
+            on delete cascade;
+
+create index notifications_unread_index
+    on notifications (user_uuid)
+    where read_at is null;
+
+alter table tasks
+    add version bigint not null default 0;
+
+create table webhooks
+(
+    uuid       uuid        not null
+        constraint webhooks_pk
+            primary key,
+    user_uuid  uuid        not null,
+    url        text        not null,
+    secret     text        not null,
+    events     text[]      not null,
+    created_at timestamptz not null default now()
+);
+
+alter table webhooks
+    owner to kolya59;
+
+create index webhooks_user_index
+    on webhooks (user_uuid, created_at);
+
+create table webhook_deliveries
+(
+    uuid            uuid        not null
+        constraint webhook_deliveries_pk
+            primary key,
+    webhook_uuid    uuid        not null
+        constraint webhook_deliveries_webhooks_uuid_fk
+            references webhooks
+            on delete cascade,
+    event_type      text        not null,
+    payload         text        not null,
+    created_at      timestamptz not null default now(),
+    next_attempt_at timestamptz,
+    claimed_until   timestamptz,
+    delivered_at    timestamptz,
+    attempts        integer     not null default 0
+);
+
+alter table webhook_deliveries
+    owner to kolya59;
+
+create index webhook_deliveries_webhook_index
+    on webhook_deliveries (webhook_uuid, created_at);
+
+create index webhook_deliveries_pending_index
+    on webhook_deliveries (next_attempt_at)
+    where next_attempt_at is not null;
+
+create table webhook_attempts
+(
+    delivery_uuid uuid        not null
+        constraint webhook_attempts_webhook_deliveries_uuid_fk
+            references webhook_deliveries
+            on delete cascade,
+    attempted_at  timestamptz not null default now(),
+    status_code   integer,
+    error         text,
+    duration_ms   bigint      not null
+);
+
+alter table webhook_attempts
+    owner to kolya59;
+
+create index webhook_attempts_delivery_index
+    on webhook_attempts (delivery_uuid, attempted_at);
+
+alter table tasks
+    add priority text
+        constraint tasks_priority_check
+            check (priority in ('low', 'medium', 'high'));
+
+create table capture_tokens
+(
+    uuid         uuid        not null
+        constraint capture_tokens_pk
+            primary key,
+    user_uuid    uuid        not null,
+    name         text        not null,
+    token_hash   bytea       not null,
+    created_at   timestamptz not null default now(),
+    last_used_at timestamptz
+);
+
+alter table capture_tokens
+    owner to kolya59;
+
+create unique index capture_tokens_token_hash_uindex
+    on capture_tokens (token_hash);
+
+create index capture_tokens_user_index
+    on capture_tokens (user_uuid, created_at);